@@ -0,0 +1,752 @@
+package commands
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultUser = "default"
+
+// aclLogMaxLen bounds the entries of ACL LOG, the oldest are dropped.
+const aclLogMaxLen = 128
+
+// aclLogGrouping is the time within which a denial identical to a logged
+// one only increments its count.
+const aclLogGrouping = 60 * time.Second
+
+var (
+	errAclSyntax         = errors.New("Syntax error")
+	errAclUnknownCommand = errors.New("Unknown command or category name in ACL")
+	errAclPasswordHash   = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errAclNoPassword     = errors.New("The password you are trying to remove from the user does not exist")
+	errAclUsername       = errors.New("Usernames can't contain spaces or null characters")
+)
+
+// ACLService holds the users connections authenticate as, and what each of
+// them may run. Users are never changed in place: a changed user replaces
+// the previous one, which the connections checking it may keep using.
+type ACLService struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+
+	// requirePass is the password of the default user set by requirepass
+	requirePass string
+
+	// file is where ACL SAVE and ACL LOAD store the users, none when empty
+	file string
+
+	logMu     sync.Mutex
+	log       []*aclLogEntry
+	nextLogID int64
+}
+
+// aclUser is a user and its rules, as set by ACL SETUSER.
+type aclUser struct {
+	name    string
+	enabled bool
+	nopass  bool
+
+	// passwords are the SHA-256 hashes of the passwords, in hex
+	passwords []string
+
+	// commands are the command rules, e.g. "+@all" or "-config|set", applied
+	// in order: the last one matching a command decides
+	commands []string
+
+	keys     []keyPattern
+	channels []string
+}
+
+// keyPattern gives access to the keys matching pattern, for reading and/or
+// writing.
+type keyPattern struct {
+	pattern     string
+	read, write bool
+}
+
+func (k keyPattern) String() string {
+	switch {
+	case k.read && !k.write:
+		return "%R~" + k.pattern
+	case k.write && !k.read:
+		return "%W~" + k.pattern
+	default:
+		return "~" + k.pattern
+	}
+}
+
+// aclLogEntry is a denied command or authentication, as listed by ACL LOG.
+type aclLogEntry struct {
+	id       int64
+	count    int
+	reason   string
+	context  string
+	object   string
+	username string
+	client   string
+	created  time.Time
+	updated  time.Time
+}
+
+// NewACLService creates the users, the default one only: it can run
+// anything, with any password. Users are saved to and loaded from file.
+func NewACLService(file string) *ACLService {
+	a := &ACLService{
+		users: make(map[string]*aclUser),
+		file:  file,
+	}
+
+	a.users[defaultUser] = a.newDefaultUser()
+
+	return a
+}
+
+func (a *ACLService) newDefaultUser() *aclUser {
+	u := &aclUser{name: defaultUser}
+
+	rules := []string{"on", "nopass", "~*", "&*", "+@all"}
+	if a.requirePass != "" {
+		rules[1] = ">" + a.requirePass
+	}
+
+	for _, rule := range rules {
+		u.apply(rule)
+	}
+
+	return u
+}
+
+// SetRequirePass makes password the only password of the default user, or
+// lets anyone authenticate as the default user when empty.
+func (a *ACLService) SetRequirePass(password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.requirePass = password
+
+	u := a.users[defaultUser].clone()
+	u.apply("resetpass")
+
+	if password == "" {
+		u.apply("nopass")
+	} else {
+		u.apply(">" + password)
+	}
+
+	a.users[defaultUser] = u
+}
+
+// RequirePass returns the password set by requirepass.
+func (a *ACLService) RequirePass() string {
+	if a == nil {
+		return ""
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.requirePass
+}
+
+func (a *ACLService) user(name string) *aclUser {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.users[name]
+}
+
+// Authenticate tells whether password is one of the passwords of the user
+// name, which must be enabled. Without ACL, only the default user exists.
+func (a *ACLService) Authenticate(name, password string) bool {
+	if a == nil {
+		return name == defaultUser
+	}
+
+	u := a.user(name)
+
+	return u != nil && u.enabled && (u.nopass || slices.Contains(u.passwords, hashPassword(password)))
+}
+
+// defaultAuthenticated tells whether new connections are authenticated as
+// the default user without AUTH, as when it has no password.
+func (a *ACLService) defaultAuthenticated() bool {
+	u := a.user(defaultUser)
+
+	return u != nil && u.enabled && u.nopass
+}
+
+// SetUser creates the user name or changes its rules. Either every rule
+// applies, or none does.
+func (a *ACLService) SetUser(name string, rules ...string) error {
+	if name == "" || strings.ContainsAny(name, " \x00") {
+		return errAclUsername
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u := &aclUser{name: name}
+	if current, ok := a.users[name]; ok {
+		u = current.clone()
+	}
+
+	for _, rule := range rules {
+		if err := u.apply(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %w", rule, err)
+		}
+	}
+
+	a.users[name] = u
+
+	return nil
+}
+
+// DelUsers removes the users named and returns how many existed. The
+// default user cannot be removed.
+func (a *ACLService) DelUsers(names ...string) (int, error) {
+	if slices.Contains(names, defaultUser) {
+		return 0, errors.New("The 'default' user cannot be removed")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	deleted := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// Users returns the names of the users, sorted.
+func (a *ACLService) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// List describes every user with the rules recreating it, as written to
+// the ACL file.
+func (a *ACLService) List() []string {
+	var lines []string
+
+	for _, name := range a.Users() {
+		if u := a.user(name); u != nil {
+			lines = append(lines, u.describe())
+		}
+	}
+
+	return lines
+}
+
+// Save writes the users to the ACL file.
+func (a *ACLService) Save() error {
+	if a.file == "" {
+		return errNoAclFile
+	}
+
+	content := strings.Join(a.List(), "\n") + "\n"
+
+	// written aside first, the file is never left half written
+	tmp, err := os.CreateTemp(filepath.Dir(a.file), filepath.Base(a.file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), a.file)
+}
+
+var errNoAclFile = errors.New("This Redis instance is not configured to use an ACL file. You may want to specify users via the " +
+	"ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+
+// Load replaces the users with the ones of the ACL file, one
+// "user <name> <rules>..." per line. A missing file has no users. The
+// default user keeps its initial rules unless the file has it. Nothing
+// changes if any line is invalid.
+func (a *ACLService) Load() error {
+	if a.file == "" {
+		return errNoAclFile
+	}
+
+	f, err := os.Open(a.file)
+	if errors.Is(err, os.ErrNotExist) {
+		f = nil
+	} else if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	users := map[string]*aclUser{defaultUser: a.newDefaultUser()}
+
+	if f != nil {
+		defer f.Close()
+
+		if err := a.parseFile(f.Name(), bufio.NewScanner(f), users); err != nil {
+			return err
+		}
+	}
+
+	a.users = users
+
+	return nil
+}
+
+func (a *ACLService) parseFile(path string, scanner *bufio.Scanner, users map[string]*aclUser) error {
+	seen := make(map[string]bool)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		fail := func(reason string) error {
+			return fmt.Errorf("%s:%d: %s. WARNING: ACL errors detected, no change to the previously active ACL rules was performed", path, line, reason)
+		}
+
+		if fields[0] != "user" || len(fields) < 2 {
+			return fail("should start with user keyword followed by the username")
+		}
+
+		name := fields[1]
+		if seen[name] {
+			return fail(fmt.Sprintf("Duplicate user '%s' found", name))
+		}
+
+		seen[name] = true
+
+		u := &aclUser{name: name}
+
+		for _, rule := range fields[2:] {
+			if err := u.apply(rule); err != nil {
+				return fail(fmt.Sprintf("Error in applying operation '%s': %s", rule, err))
+			}
+		}
+
+		users[name] = u
+	}
+
+	return scanner.Err()
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commands = slices.Clone(u.commands)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+
+	return &c
+}
+
+// apply changes the user as rule says, e.g. "on", ">password", "~cache:*",
+// "&news.*" or "+@read".
+func (u *aclUser) apply(rule string) error {
+	switch lower := strings.ToLower(rule); lower {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass, u.passwords = true, nil
+	case "resetpass":
+		u.nopass, u.passwords = false, nil
+	case "allkeys":
+		u.keys = []keyPattern{{pattern: "*", read: true, write: true}}
+	case "resetkeys":
+		u.keys = nil
+	case "allchannels":
+		u.channels = []string{"*"}
+	case "resetchannels":
+		u.channels = nil
+	case "allcommands":
+		u.commands = []string{"+@all"}
+	case "nocommands":
+		u.commands = nil
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "nocommands"} {
+			u.apply(r)
+		}
+	default:
+		return u.applyPattern(rule)
+	}
+
+	return nil
+}
+
+func (u *aclUser) applyPattern(rule string) error {
+	switch {
+	case rule == "":
+		return errAclSyntax
+	case rule[0] == '>':
+		u.addPassword(hashPassword(rule[1:]))
+	case rule[0] == '#':
+		if !isPasswordHash(rule[1:]) {
+			return errAclPasswordHash
+		}
+
+		u.addPassword(rule[1:])
+	case rule[0] == '<' || rule[0] == '!':
+		hash := rule[1:]
+		if rule[0] == '<' {
+			hash = hashPassword(hash)
+		} else if !isPasswordHash(hash) {
+			return errAclPasswordHash
+		}
+
+		i := slices.Index(u.passwords, hash)
+		if i < 0 {
+			return errAclNoPassword
+		}
+
+		u.passwords = slices.Delete(u.passwords, i, i+1)
+	case rule[0] == '~':
+		u.addKeyPattern(keyPattern{pattern: rule[1:], read: true, write: true})
+	case rule[0] == '%':
+		flags, pattern, ok := strings.Cut(rule[1:], "~")
+		if !ok || flags == "" {
+			return errAclSyntax
+		}
+
+		k := keyPattern{pattern: pattern}
+		for _, flag := range strings.ToUpper(flags) {
+			switch flag {
+			case 'R':
+				k.read = true
+			case 'W':
+				k.write = true
+			default:
+				return errAclSyntax
+			}
+		}
+
+		u.addKeyPattern(k)
+	case rule[0] == '&':
+		if !slices.Contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+	case rule[0] == '+' || rule[0] == '-':
+		return u.addCommandRule(rule)
+	default:
+		return errAclSyntax
+	}
+
+	return nil
+}
+
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+
+	if !slices.Contains(u.passwords, hash) {
+		u.passwords = append(u.passwords, hash)
+	}
+}
+
+func (u *aclUser) addKeyPattern(k keyPattern) {
+	for i, existing := range u.keys {
+		if existing.pattern == k.pattern {
+			u.keys[i].read = existing.read || k.read
+			u.keys[i].write = existing.write || k.write
+
+			return
+		}
+	}
+
+	u.keys = append(u.keys, k)
+}
+
+// addCommandRule adds a rule allowing or denying a command, a subcommand
+// or a category. Allowing or denying @all replaces the previous rules, a
+// rule given again only counts where it was given last.
+func (u *aclUser) addCommandRule(rule string) error {
+	target := strings.ToLower(rule[1:])
+
+	if category, ok := strings.CutPrefix(target, "@"); ok {
+		if category != "all" && !slices.Contains(aclCategories, category) {
+			return errAclUnknownCommand
+		}
+	} else {
+		command, sub, hasSub := strings.Cut(target, "|")
+
+		if _, ok := commandArity[strings.ToUpper(command)]; !ok {
+			return errAclUnknownCommand
+		}
+
+		if hasSub && (sub == "" || !slices.Contains(subcommandCommands, strings.ToUpper(command))) {
+			return errAclUnknownCommand
+		}
+	}
+
+	rule = rule[:1] + target
+
+	if target == "@all" {
+		u.commands = nil
+	}
+
+	u.commands = slices.DeleteFunc(u.commands, func(r string) bool {
+		return r[1:] == target
+	})
+
+	u.commands = append(u.commands, rule)
+
+	return nil
+}
+
+// canRun tells whether the rules of the user allow the command named as by
+// commandName.
+func (u *aclUser) canRun(name string) bool {
+	command, _, _ := strings.Cut(name, "|")
+	categories := categoriesOf(name)
+
+	allowed := false
+
+	for _, rule := range u.commands {
+		target := rule[1:]
+
+		var matches bool
+		if category, ok := strings.CutPrefix(target, "@"); ok {
+			matches = category == "all" || slices.Contains(categories, category)
+		} else {
+			matches = target == name || target == command
+		}
+
+		if matches {
+			allowed = rule[0] == '+'
+		}
+	}
+
+	return allowed
+}
+
+func (u *aclUser) canAccessKey(key string, access keyAccess) bool {
+	for _, k := range u.keys {
+		if (k.read || !access.read) && (k.write || !access.write) && utils.Match(k.pattern, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canAccessChannel tells whether the user may subscribe or publish to
+// channel, or subscribe to the pattern when literal.
+func (u *aclUser) canAccessChannel(channel string, literal bool) bool {
+	for _, pattern := range u.channels {
+		if pattern == "*" || pattern == channel || !literal && utils.Match(pattern, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aclDenial tells why a user may not run a command: because of the command,
+// a key or a channel, named by object.
+type aclDenial struct {
+	reason string
+	object string
+}
+
+// check returns why the user may not run c, if it may not.
+func (u *aclUser) check(c Command) (aclDenial, bool) {
+	name := commandName(c)
+
+	if !u.canRun(name) {
+		return aclDenial{reason: "command", object: name}, false
+	}
+
+	keys, access := commandKeys(c)
+	for _, key := range keys {
+		if !u.canAccessKey(key, access) {
+			return aclDenial{reason: "key", object: key}, false
+		}
+	}
+
+	channels, literal := commandChannels(c)
+	for _, channel := range channels {
+		if !u.canAccessChannel(channel, literal) {
+			return aclDenial{reason: "channel", object: channel}, false
+		}
+	}
+
+	return aclDenial{}, true
+}
+
+// commandRules describes the command rules, which start from -@all unless
+// they start from @all.
+func (u *aclUser) commandRules() string {
+	if len(u.commands) == 0 || u.commands[0][1:] != "@all" {
+		return strings.Join(append([]string{"-@all"}, u.commands...), " ")
+	}
+
+	return strings.Join(u.commands, " ")
+}
+
+func (u *aclUser) keyRules() string {
+	rules := make([]string, 0, len(u.keys))
+	for _, k := range u.keys {
+		rules = append(rules, k.String())
+	}
+
+	return strings.Join(rules, " ")
+}
+
+func (u *aclUser) channelRules() string {
+	rules := make([]string, 0, len(u.channels))
+	for _, ch := range u.channels {
+		rules = append(rules, "&"+ch)
+	}
+
+	return strings.Join(rules, " ")
+}
+
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+
+	return flags
+}
+
+// describe returns the rules recreating the user, as listed by ACL LIST.
+func (u *aclUser) describe() string {
+	parts := append([]string{"user", u.name}, u.flags()...)
+
+	for _, hash := range u.passwords {
+		parts = append(parts, "#"+hash)
+	}
+
+	if keys := u.keyRules(); keys != "" {
+		parts = append(parts, keys)
+	}
+
+	if channels := u.channelRules(); channels != "" {
+		parts = append(parts, channels)
+	} else {
+		parts = append(parts, "resetchannels")
+	}
+
+	parts = append(parts, u.commandRules())
+
+	return strings.Join(parts, " ")
+}
+
+// logDenial records a denied command or authentication. A denial identical
+// to a recent one is counted with it.
+func (a *ACLService) logDenial(reason, context, object, username, client string) {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+
+	now := time.Now()
+
+	for _, e := range a.log {
+		if e.reason == reason && e.context == context && e.object == object && e.username == username &&
+			now.Sub(e.updated) < aclLogGrouping {
+			e.count++
+			e.updated = now
+			e.client = client
+
+			return
+		}
+	}
+
+	entry := &aclLogEntry{
+		id:       a.nextLogID,
+		count:    1,
+		reason:   reason,
+		context:  context,
+		object:   object,
+		username: username,
+		client:   client,
+		created:  now,
+		updated:  now,
+	}
+	a.nextLogID++
+
+	a.log = append([]*aclLogEntry{entry}, a.log...)
+	if len(a.log) > aclLogMaxLen {
+		a.log = a.log[:aclLogMaxLen]
+	}
+}
+
+// Log returns up to count of the latest denials, the latest first.
+func (a *ACLService) Log(count int) []aclLogEntry {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+
+	entries := make([]aclLogEntry, 0, min(count, len(a.log)))
+	for _, e := range a.log[:min(count, len(a.log))] {
+		entries = append(entries, *e)
+	}
+
+	return entries
+}
+
+func (a *ACLService) ResetLog() {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+
+	a.log = nil
+}
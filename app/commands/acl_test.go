@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"bytes"
+	"github.com/codecrafters-io/redis-starter-go/app/services"
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestACLService_Rules(t *testing.T) {
+	acl := NewACLService("")
+
+	assert.NoError(t, acl.SetUser("alice", "on", ">secret", "~cached:*", "%R~shared:*", "&news", "+@read", "-xrange", "+config|get"))
+	assert.Equal(t, []string{"user alice on #2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b " +
+		"~cached:* %R~shared:* &news -@all +@read -xrange +config|get",
+		"user default on nopass ~* &* +@all"}, acl.List())
+
+	alice := acl.user("alice")
+	assert.True(t, alice.canRun("get"))
+	assert.False(t, alice.canRun("xrange"), "the last matching rule wins")
+	assert.True(t, alice.canRun("config|get"))
+	assert.False(t, alice.canRun("config|set"))
+	assert.False(t, alice.canRun("set"))
+
+	assert.True(t, alice.canAccessKey("shared:1", readAccess))
+	assert.False(t, alice.canAccessKey("shared:1", writeAccess))
+	assert.True(t, alice.canAccessKey("cached:1", readWriteAccess))
+	assert.False(t, alice.canAccessKey("other", readAccess))
+
+	assert.True(t, alice.canAccessChannel("news", false))
+	assert.False(t, alice.canAccessChannel("new*", true), "patterns are matched as they are")
+
+	assert.True(t, acl.Authenticate("alice", "secret"))
+	assert.False(t, acl.Authenticate("alice", "wrong"))
+
+	assert.NoError(t, acl.SetUser("alice", "off"))
+	assert.False(t, acl.Authenticate("alice", "secret"), "disabled users cannot authenticate")
+
+	for _, invalid := range [][]string{{"+nosuchcommand"}, {"+@nosuchcategory"}, {"#nothex"}, {"<missing"}, {"maybe"}} {
+		err := acl.SetUser("alice", invalid...)
+		assert.ErrorContains(t, err, "Error in ACL SETUSER modifier '"+invalid[0]+"'")
+	}
+
+	assert.False(t, acl.user("alice").enabled, "failed changes leave the user as it was")
+
+	_, err := acl.DelUsers("default")
+	assert.Error(t, err)
+
+	deleted, err := acl.DelUsers("alice", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
+
+func TestACLService_RequirePass(t *testing.T) {
+	acl := NewACLService("")
+	assert.True(t, acl.defaultAuthenticated())
+
+	acl.SetRequirePass("secret")
+	assert.False(t, acl.defaultAuthenticated())
+	assert.True(t, acl.Authenticate("default", "secret"))
+	assert.Equal(t, "secret", acl.RequirePass())
+
+	acl.SetRequirePass("")
+	assert.True(t, acl.defaultAuthenticated())
+}
+
+func TestACLService_SaveLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.acl")
+
+	acl := NewACLService(file)
+	assert.NoError(t, acl.Load(), "a missing file defines no users")
+	assert.Equal(t, []string{"default"}, acl.Users())
+
+	assert.NoError(t, acl.SetUser("alice", "on", ">secret", "~*", "+@all"))
+	assert.NoError(t, acl.Save())
+
+	loaded := NewACLService(file)
+	assert.NoError(t, loaded.Load())
+	assert.Equal(t, acl.List(), loaded.List())
+	assert.True(t, loaded.Authenticate("alice", "secret"))
+
+	assert.NoError(t, os.WriteFile(file, []byte("user alice on nopass +get\nuser bob maybe\n"), 0o644))
+	err := loaded.Load()
+	assert.ErrorContains(t, err, file+":2: ")
+	assert.True(t, loaded.Authenticate("alice", "secret"), "nothing changes when the file has errors")
+
+	assert.ErrorIs(t, NewACLService("").Save(), errNoAclFile)
+}
+
+func TestACL(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	clients := services.NewClients()
+	acl := NewACLService("")
+	acl.SetRequirePass("secret")
+
+	ctx := RequestContext{
+		Store:       store.NewMemory(),
+		Replication: services.NewReplicationService(services.Config),
+		Transaction: NewTransactionService(),
+		PubSub:      services.NewPubSub(clients),
+		Clients:     clients,
+		Conn:        server,
+		ACL:         acl,
+	}
+
+	router := NewCommandRouter()
+
+	run := func(args ...string) string {
+		c := Command{Type: args[0], Args: args[1:]}
+
+		out, err := c.Execute(router, ctx)
+		assert.NoError(t, err)
+
+		return string(bytes.Join(out, nil))
+	}
+
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", run("GET", "k"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", run("AUTH", "wrong"))
+	assert.Equal(t, "+OK\r\n", run("AUTH", "secret"))
+	assert.Equal(t, "$7\r\ndefault\r\n", run("ACL", "WHOAMI"))
+
+	assert.Equal(t, "+OK\r\n", run("ACL", "SETUSER", "alice", "on", ">pw", "~app:*", "&news", "+@read", "+set", "+subscribe", "+@transaction"))
+	getUser := run("ACL", "GETUSER", "alice")
+	assert.Contains(t, getUser, "$8\r\ncommands\r\n$42\r\n-@all +@read +set +subscribe +@transaction\r\n")
+	assert.Contains(t, getUser, "$4\r\nkeys\r\n$6\r\n~app:*\r\n")
+	assert.Contains(t, getUser, "$8\r\nchannels\r\n$5\r\n&news\r\n")
+	assert.Equal(t, "$-1\r\n", run("ACL", "GETUSER", "bob"))
+
+	assert.Equal(t, "+OK\r\n", run("ACL", "DRYRUN", "alice", "GET", "app:1"))
+	assert.Equal(t, "$55\r\nUser alice has no permissions to access the 'other' key\r\n", run("ACL", "DRYRUN", "alice", "GET", "other"))
+	assert.Equal(t, "$55\r\nUser alice has no permissions to run the 'incr' command\r\n", run("ACL", "DRYRUN", "alice", "INCR", "app:1"))
+	assert.Equal(t, "-ERR User 'bob' not found\r\n", run("ACL", "DRYRUN", "bob", "GET", "k"))
+
+	assert.Equal(t, "+OK\r\n", run("AUTH", "alice", "pw"))
+	assert.Equal(t, "+OK\r\n", run("SET", "app:1", "v"))
+	assert.Equal(t, "$1\r\nv\r\n", run("GET", "app:1"))
+	assert.Equal(t, "-NOPERM No permissions to access a key\r\n", run("SET", "other", "v"))
+	assert.Equal(t, "-NOPERM User alice has no permissions to run the 'incr' command\r\n", run("INCR", "app:1"))
+	assert.Equal(t, "-NOPERM No permissions to access a channel\r\n", run("SUBSCRIBE", "sports"))
+	assert.Equal(t, "-NOPERM User alice has no permissions to run the 'acl|log' command\r\n", run("ACL", "LOG"))
+
+	assert.Equal(t, "+OK\r\n", run("MULTI"))
+	assert.Equal(t, "+QUEUED\r\n", run("SET", "app:2", "v"))
+	assert.NoError(t, acl.SetUser("alice", "resetkeys", "~other"))
+	assert.Equal(t, "*1\r\n-NOPERM No permissions to access a key\r\n", run("EXEC"), "checked again when the transaction runs")
+
+	assert.Equal(t, "+OK\r\n", run("AUTH", "secret"))
+
+	log := run("ACL", "LOG", "1")
+	assert.True(t, strings.HasPrefix(log, "*1\r\n*20\r\n$5\r\ncount\r\n:1\r\n$6\r\nreason\r\n$3\r\nkey\r\n$7\r\ncontext\r\n$5\r\nmulti\r\n"+
+		"$6\r\nobject\r\n$5\r\napp:2\r\n$8\r\nusername\r\n$5\r\nalice\r\n"), log)
+	assert.Equal(t, "+OK\r\n", run("ACL", "LOG", "RESET"))
+	assert.Equal(t, "*0\r\n", run("ACL", "LOG"))
+
+	assert.Contains(t, run("ACL", "CAT"), "$6\r\nstream\r\n")
+	assert.Contains(t, run("ACL", "CAT", "stream"), "$4\r\nxadd\r\n")
+	assert.Equal(t, "-ERR Unknown category 'nosuch'\r\n", run("ACL", "CAT", "nosuch"))
+
+	assert.Equal(t, "*2\r\n$5\r\nalice\r\n$7\r\ndefault\r\n", run("ACL", "USERS"))
+	assert.Equal(t, ":1\r\n", run("ACL", "DELUSER", "alice"))
+	assert.Equal(t, "-ERR unknown subcommand 'NOPE'. Try ACL HELP.\r\n", run("ACL", "NOPE"))
+}
@@ -0,0 +1,344 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAclLogCount is the number of entries ACL LOG replies with when not
+// told.
+const defaultAclLogCount = 10
+
+// user returns the user the connection authenticated as. Until it
+// authenticates, it is the default user if that one needs no password.
+func (s RequestContext) user() (string, bool) {
+	if s.ACL == nil || s.Conn == nil {
+		return defaultUser, true
+	}
+
+	if name := s.Clients.Get(s.Conn).User; name != "" {
+		return name, true
+	}
+
+	if s.ACL.defaultAuthenticated() {
+		s.Clients.SetUser(s.Conn, defaultUser)
+		return defaultUser, true
+	}
+
+	return "", false
+}
+
+// authenticate makes the connection run its commands as the user name, if
+// password is one of its passwords.
+func (s RequestContext) authenticate(name, password string) (resp.Value, bool) {
+	if !s.ACL.Authenticate(name, password) {
+		if s.ACL != nil {
+			s.ACL.logDenial("auth", "toplevel", "AUTH", name, s.clientInfo())
+		}
+
+		return resp.ErrorValue("WRONGPASS invalid username-password pair or user is disabled."), false
+	}
+
+	if s.Conn != nil && s.Clients != nil {
+		s.Clients.SetUser(s.Conn, name)
+	}
+
+	return resp.StringValue("OK"), true
+}
+
+// authorize checks, before c runs, that the connection authenticated and
+// that its user may run c on its keys and channels. Denials are logged for
+// ACL LOG, context telling whether c was sent on its own or run by EXEC.
+// Our master runs anything.
+func (s RequestContext) authorize(c Command, context string) (resp.Value, bool) {
+	if s.ACL == nil || s.Conn == nil || s.Replication.IsMasterLink(s.Conn) {
+		return resp.Value{}, true
+	}
+
+	name, authenticated := s.user()
+
+	// how a connection authenticates
+	if typ := strings.ToUpper(c.Type); typ == "AUTH" || typ == "HELLO" {
+		return resp.Value{}, true
+	}
+
+	if !authenticated {
+		return resp.ErrorValue("NOAUTH Authentication required."), false
+	}
+
+	u := s.ACL.user(name)
+	if u == nil {
+		// removed meanwhile, it may run nothing
+		u = &aclUser{name: name}
+	}
+
+	denial, ok := u.check(c)
+	if ok {
+		return resp.Value{}, true
+	}
+
+	s.ACL.logDenial(denial.reason, context, denial.object, name, s.clientInfo())
+
+	switch denial.reason {
+	case "key":
+		return resp.ErrorValue("NOPERM No permissions to access a key"), false
+	case "channel":
+		return resp.ErrorValue("NOPERM No permissions to access a channel"), false
+	default:
+		return resp.ErrorValue(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", name, denial.object)), false
+	}
+}
+
+// clientInfo describes the connection in ACL LOG.
+func (s RequestContext) clientInfo() string {
+	if s.Conn == nil || s.Clients == nil {
+		return ""
+	}
+
+	client := s.Clients.Get(s.Conn)
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s user=%s resp=%d",
+		client.ID, s.Conn.RemoteAddr(), s.Conn.LocalAddr(), client.Name, client.User, client.Protocol)
+}
+
+// authHandler authenticates the connection: AUTH [username] password. The
+// username defaults to the default user.
+func authHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 || len(c.Args) > 2 {
+		return resp.ErrorValue("ERR syntax error"), nil
+	}
+
+	if len(c.Args) == 1 {
+		if s.ACL == nil || s.ACL.defaultAuthenticated() {
+			return resp.ErrorValue("ERR AUTH <password> called without any password configured for the default user. " +
+				"Are you sure your configuration is correct?"), nil
+		}
+
+		reply, _ := s.authenticate(defaultUser, c.Args[0])
+		return reply, nil
+	}
+
+	reply, _ := s.authenticate(c.Args[0], c.Args[1])
+
+	return reply, nil
+}
+
+// aclHandler manages the users: ACL SETUSER, GETUSER, DELUSER, LIST, USERS,
+// WHOAMI, CAT, DRYRUN, LOG, SAVE and LOAD.
+func aclHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'acl' command"), nil
+	}
+
+	if s.ACL == nil {
+		return resp.ErrorValue("ERR ACL is not available"), nil
+	}
+
+	sub := strings.ToUpper(c.Args[0])
+	args := c.Args[1:]
+
+	arity := map[string][2]int{
+		"SETUSER": {1, -1},
+		"GETUSER": {1, 1},
+		"DELUSER": {1, -1},
+		"LIST":    {0, 0},
+		"USERS":   {0, 0},
+		"WHOAMI":  {0, 0},
+		"CAT":     {0, 1},
+		"DRYRUN":  {2, -1},
+		"LOG":     {0, 1},
+		"SAVE":    {0, 0},
+		"LOAD":    {0, 0},
+	}
+
+	bounds, ok := arity[sub]
+	if !ok {
+		return resp.ErrorValue(fmt.Sprintf("ERR unknown subcommand '%s'. Try ACL HELP.", c.Args[0])), nil
+	}
+
+	if len(args) < bounds[0] || bounds[1] >= 0 && len(args) > bounds[1] {
+		return resp.ErrorValue(fmt.Sprintf("ERR wrong number of arguments for 'acl|%s' command", strings.ToLower(sub))), nil
+	}
+
+	switch sub {
+	case "SETUSER":
+		if err := s.ACL.SetUser(args[0], args[1:]...); err != nil {
+			return resp.ErrorValue("ERR " + err.Error()), nil
+		}
+
+		return resp.StringValue("OK"), nil
+	case "GETUSER":
+		return aclGetUser(s.ACL, args[0]), nil
+	case "DELUSER":
+		deleted, err := s.ACL.DelUsers(args...)
+		if err != nil {
+			return resp.ErrorValue("ERR " + err.Error()), nil
+		}
+
+		s.Clients.DisconnectUsers(args...)
+
+		return resp.IntegerValue(int64(deleted)), nil
+	case "LIST":
+		return bulkStrings(s.ACL.List()), nil
+	case "USERS":
+		return bulkStrings(s.ACL.Users()), nil
+	case "WHOAMI":
+		name, _ := s.user()
+		return resp.BulkStringValue(name), nil
+	case "CAT":
+		return aclCat(args), nil
+	case "DRYRUN":
+		return aclDryRun(s.ACL, args[0], args[1], args[2:]), nil
+	case "LOG":
+		return aclLog(s.ACL, args), nil
+	case "SAVE":
+		if err := s.ACL.Save(); err != nil {
+			if errors.Is(err, errNoAclFile) {
+				return resp.ErrorValue("ERR " + err.Error()), nil
+			}
+
+			fmt.Println("Error saving ACL file: ", err)
+
+			return resp.ErrorValue("ERR There was an error trying to save the ACLs. Please check the server logs for more information"), nil
+		}
+
+		return resp.StringValue("OK"), nil
+	default:
+		before := s.ACL.Users()
+
+		if err := s.ACL.Load(); err != nil {
+			return resp.ErrorValue("ERR " + err.Error()), nil
+		}
+
+		// the connections of the users gone are closed
+		after := s.ACL.Users()
+		s.Clients.DisconnectUsers(slices.DeleteFunc(before, func(name string) bool {
+			return slices.Contains(after, name)
+		})...)
+
+		return resp.StringValue("OK"), nil
+	}
+}
+
+func bulkStrings(values []string) resp.Value {
+	items := make([]resp.Value, 0, len(values))
+	for _, v := range values {
+		items = append(items, resp.BulkStringValue(v))
+	}
+
+	return resp.ArrayValue(items...)
+}
+
+func aclGetUser(acl *ACLService, name string) resp.Value {
+	u := acl.user(name)
+	if u == nil {
+		return resp.NullValue()
+	}
+
+	return resp.MapValue(
+		resp.BulkStringValue("flags"), bulkStrings(u.flags()),
+		resp.BulkStringValue("passwords"), bulkStrings(u.passwords),
+		resp.BulkStringValue("commands"), resp.BulkStringValue(u.commandRules()),
+		resp.BulkStringValue("keys"), resp.BulkStringValue(u.keyRules()),
+		resp.BulkStringValue("channels"), resp.BulkStringValue(u.channelRules()),
+		resp.BulkStringValue("selectors"), resp.ArrayValue(),
+	)
+}
+
+// aclCat lists the categories, or the commands of a category.
+func aclCat(args []string) resp.Value {
+	if len(args) == 0 {
+		return bulkStrings(aclCategories)
+	}
+
+	category := strings.ToLower(args[0])
+	if !slices.Contains(aclCategories, category) {
+		return resp.ErrorValue(fmt.Sprintf("ERR Unknown category '%s'", args[0]))
+	}
+
+	var names []string
+	for name, categories := range commandCategories {
+		if slices.Contains(categories, category) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+
+	slices.Sort(names)
+
+	return bulkStrings(names)
+}
+
+// aclDryRun tells whether the user name may run the command, without
+// running it.
+func aclDryRun(acl *ACLService, name, command string, args []string) resp.Value {
+	u := acl.user(name)
+	if u == nil {
+		return resp.ErrorValue(fmt.Sprintf("ERR User '%s' not found", name))
+	}
+
+	if _, ok := commandArity[strings.ToUpper(command)]; !ok {
+		return resp.ErrorValue(fmt.Sprintf("ERR Command '%s' not found", command))
+	}
+
+	denial, ok := u.check(Command{Type: command, Args: args})
+	if ok {
+		return resp.StringValue("OK")
+	}
+
+	switch denial.reason {
+	case "key":
+		return resp.BulkStringValue(fmt.Sprintf("User %s has no permissions to access the '%s' key", name, denial.object))
+	case "channel":
+		return resp.BulkStringValue(fmt.Sprintf("User %s has no permissions to access the '%s' channel", name, denial.object))
+	default:
+		return resp.BulkStringValue(fmt.Sprintf("User %s has no permissions to run the '%s' command", name, denial.object))
+	}
+}
+
+// aclLog lists the latest denials: ACL LOG [count | RESET].
+func aclLog(acl *ACLService, args []string) resp.Value {
+	count := defaultAclLogCount
+
+	if len(args) == 1 {
+		if strings.EqualFold(args[0], "RESET") {
+			acl.ResetLog()
+			return resp.StringValue("OK")
+		}
+
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range")
+		}
+
+		if n < 0 {
+			return resp.ErrorValue("ERR value is out of range, must be positive")
+		}
+
+		count = n
+	}
+
+	now := time.Now()
+
+	entries := make([]resp.Value, 0)
+	for _, e := range acl.Log(count) {
+		entries = append(entries, resp.MapValue(
+			resp.BulkStringValue("count"), resp.IntegerValue(int64(e.count)),
+			resp.BulkStringValue("reason"), resp.BulkStringValue(e.reason),
+			resp.BulkStringValue("context"), resp.BulkStringValue(e.context),
+			resp.BulkStringValue("object"), resp.BulkStringValue(e.object),
+			resp.BulkStringValue("username"), resp.BulkStringValue(e.username),
+			resp.BulkStringValue("age-seconds"), resp.DoubleValue(now.Sub(e.created).Seconds()),
+			resp.BulkStringValue("client-info"), resp.BulkStringValue(e.client),
+			resp.BulkStringValue("entry-id"), resp.IntegerValue(e.id),
+			resp.BulkStringValue("timestamp-created"), resp.IntegerValue(e.created.UnixMilli()),
+			resp.BulkStringValue("timestamp-last-updated"), resp.IntegerValue(e.updated.UnixMilli()),
+		))
+	}
+
+	return resp.ArrayValue(entries...)
+}
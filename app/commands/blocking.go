@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"time"
+)
+
+// signalKeyAsReady wakes the clients blocked on key.
+func (s RequestContext) signalKeyAsReady(key string) {
+	if s.Blocking != nil {
+		s.Blocking.SignalKeyAsReady(key)
+	}
+}
+
+// gone returns a channel closed once the client closed the connection, nil
+// when there is no connection.
+func (s RequestContext) gone() <-chan struct{} {
+	if s.Conn == nil || s.Clients == nil {
+		return nil
+	}
+
+	return s.Clients.Gone(s.Conn)
+}
+
+// blockOn replies with what read returns once it served something. Until
+// then, read is called again every time one of keys is signaled as ready, for
+// up to timeout milliseconds (0 waits forever, a negative timeout does not
+// wait). On timeout, or once the client is gone, the last reply of read is
+// returned. read runs under the shared execution lock, which is not held
+// while waiting.
+func (s RequestContext) blockOn(keys []string, timeout int64, read func() (resp.Value, bool)) resp.Value {
+	// EXEC already holds the execution lock exclusively
+	if s.denyBlocking {
+		reply, _ := read()
+		return reply
+	}
+
+	var ready <-chan struct{}
+
+	// registered before the first read, a change made meanwhile still wakes
+	// the client
+	if timeout >= 0 && s.Blocking != nil {
+		w := s.Blocking.Block(keys)
+		defer s.Blocking.Unblock(w)
+
+		ready = w.Ready()
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	for {
+		unlock := s.Transaction.Shared()
+		reply, served := read()
+		unlock()
+
+		if served || timeout < 0 {
+			return reply
+		}
+
+		s.flush()
+
+		select {
+		case <-ready:
+		case <-deadline:
+			return reply
+		case <-s.gone():
+			// nobody is left to reply to
+			return reply
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package commands
+
+import (
+	"slices"
+	"strings"
+)
+
+// aclCategories are the command categories ACL rules may refer to as
+// @<category>, as listed by ACL CAT.
+var aclCategories = []string{
+	"keyspace",
+	"read",
+	"write",
+	"set",
+	"sortedset",
+	"list",
+	"hash",
+	"string",
+	"bitmap",
+	"hyperloglog",
+	"geo",
+	"stream",
+	"pubsub",
+	"admin",
+	"fast",
+	"slow",
+	"blocking",
+	"dangerous",
+	"connection",
+	"transaction",
+	"scripting",
+}
+
+// commandCategories are the categories of every command. A subcommand may
+// have categories of its own, e.g. "ACL|WHOAMI".
+var commandCategories = map[string][]string{
+	"AUTH":         {"fast", "connection"},
+	"HELLO":        {"fast", "connection"},
+	"PING":         {"fast", "connection"},
+	"ECHO":         {"fast", "connection"},
+	"SET":          {"write", "string", "slow"},
+	"GET":          {"read", "string", "fast"},
+	"CONFIG":       {"admin", "slow", "dangerous"},
+	"KEYS":         {"keyspace", "read", "slow", "dangerous"},
+	"INFO":         {"slow", "dangerous"},
+	"REPLCONF":     {"admin", "slow", "dangerous"},
+	"PSYNC":        {"admin", "slow", "dangerous"},
+	"COMMAND":      {"slow", "connection"},
+	"WAIT":         {"slow", "connection"},
+	"WAITAOF":      {"slow", "connection"},
+	"FAILOVER":     {"admin", "slow", "dangerous"},
+	"REPLICAOF":    {"admin", "slow", "dangerous"},
+	"SLAVEOF":      {"admin", "slow", "dangerous"},
+	"TYPE":         {"keyspace", "read", "fast"},
+	"INCR":         {"write", "string", "fast"},
+	"DEL":          {"keyspace", "write", "slow"},
+	"MULTI":        {"fast", "transaction"},
+	"EXEC":         {"slow", "transaction"},
+	"DISCARD":      {"fast", "transaction"},
+	"WATCH":        {"fast", "transaction"},
+	"UNWATCH":      {"fast", "transaction"},
+	"XADD":         {"write", "stream", "fast"},
+	"XRANGE":       {"read", "stream", "slow"},
+	"XREVRANGE":    {"read", "stream", "slow"},
+	"XREAD":        {"read", "stream", "slow", "blocking"},
+	"XGROUP":       {"write", "stream", "slow"},
+	"XREADGROUP":   {"write", "stream", "slow", "blocking"},
+	"XACK":         {"write", "stream", "fast"},
+	"XPENDING":     {"read", "stream", "slow"},
+	"XCLAIM":       {"write", "stream", "fast"},
+	"XAUTOCLAIM":   {"write", "stream", "fast"},
+	"XLEN":         {"read", "stream", "fast"},
+	"XDEL":         {"write", "stream", "fast"},
+	"XTRIM":        {"write", "stream", "slow"},
+	"XINFO":        {"read", "stream", "slow"},
+	"SUBSCRIBE":    {"pubsub", "slow"},
+	"UNSUBSCRIBE":  {"pubsub", "slow"},
+	"PSUBSCRIBE":   {"pubsub", "slow"},
+	"PUNSUBSCRIBE": {"pubsub", "slow"},
+	"SSUBSCRIBE":   {"pubsub", "slow"},
+	"SUNSUBSCRIBE": {"pubsub", "slow"},
+	"PUBLISH":      {"pubsub", "fast"},
+	"SPUBLISH":     {"pubsub", "fast"},
+	"PUBSUB":       {"pubsub", "slow"},
+	"SENTINEL":     {"admin", "slow", "dangerous"},
+	"ACL":          {"admin", "slow", "dangerous"},
+	"ACL|WHOAMI":   {"slow"},
+	"ACL|CAT":      {"slow"},
+}
+
+// subcommandCommands are the commands whose first argument is a subcommand,
+// which ACL rules may allow or deny on its own, e.g. +config|get.
+var subcommandCommands = []string{
+	"CONFIG",
+	"COMMAND",
+	"XGROUP",
+	"XINFO",
+	"PUBSUB",
+	"SENTINEL",
+	"ACL",
+}
+
+// commandName returns the name of c as ACL rules and logs refer to it, e.g.
+// "get" or "config|get".
+func commandName(c Command) string {
+	name := strings.ToLower(c.Type)
+
+	if slices.Contains(subcommandCommands, strings.ToUpper(c.Type)) && len(c.Args) > 0 {
+		name += "|" + strings.ToLower(c.Args[0])
+	}
+
+	return name
+}
+
+// categoriesOf returns the categories of the command named as by
+// commandName.
+func categoriesOf(name string) []string {
+	if categories, ok := commandCategories[strings.ToUpper(name)]; ok {
+		return categories
+	}
+
+	command, _, _ := strings.Cut(name, "|")
+
+	return commandCategories[strings.ToUpper(command)]
+}
+
+// keyAccess tells how a command uses its keys: a key read needs a pattern
+// allowing reads, a key written one allowing writes. Neither is needed
+// by commands that only look at the existence of the key, any pattern will do.
+type keyAccess struct {
+	read, write bool
+}
+
+var (
+	readAccess      = keyAccess{read: true}
+	writeAccess     = keyAccess{write: true}
+	readWriteAccess = keyAccess{read: true, write: true}
+)
+
+// commandKeys returns the keys c accesses, and how.
+func commandKeys(c Command) ([]string, keyAccess) {
+	args := c.Args
+
+	first := func(access keyAccess) ([]string, keyAccess) {
+		if len(args) == 0 {
+			return nil, access
+		}
+
+		return args[:1], access
+	}
+
+	switch strings.ToUpper(c.Type) {
+	case "GET", "TYPE", "XRANGE", "XREVRANGE", "XLEN", "XPENDING":
+		return first(readAccess)
+	case "SET", "XADD", "XDEL", "XTRIM", "XACK":
+		return first(writeAccess)
+	case "INCR", "XCLAIM", "XAUTOCLAIM":
+		return first(readWriteAccess)
+	case "DEL":
+		return args, writeAccess
+	case "WATCH":
+		return args, keyAccess{}
+	case "XGROUP":
+		if len(args) > 1 {
+			return args[1:2], writeAccess
+		}
+	case "XINFO":
+		if len(args) > 1 {
+			return args[1:2], readAccess
+		}
+	case "XREAD":
+		return streamKeys(args), readAccess
+	case "XREADGROUP":
+		return streamKeys(args), readWriteAccess
+	}
+
+	return nil, keyAccess{}
+}
+
+// streamKeys returns the keys following STREAMS, which are followed by as
+// many ids.
+func streamKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.EqualFold(arg, "STREAMS") {
+			rest := args[i+1:]
+
+			return rest[:len(rest)/2]
+		}
+	}
+
+	return nil
+}
+
+// commandChannels returns the channels c subscribes or publishes to. Patterns
+// subscribed to are only allowed by the very same channel pattern of the
+// user, they are returned with literal set.
+func commandChannels(c Command) (channels []string, literal bool) {
+	switch strings.ToUpper(c.Type) {
+	case "SUBSCRIBE", "SSUBSCRIBE":
+		return c.Args, false
+	case "PSUBSCRIBE":
+		return c.Args, true
+	case "PUBLISH", "SPUBLISH":
+		if len(c.Args) > 0 {
+			return c.Args[:1], false
+		}
+	}
+
+	return nil, false
+}
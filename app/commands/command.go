@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/sentinel"
 	"github.com/codecrafters-io/redis-starter-go/app/services"
 	"github.com/codecrafters-io/redis-starter-go/app/store"
 	"net"
@@ -22,18 +23,108 @@ type RequestContext struct {
 	Store       store.DataStore
 	Replication *services.ReplicationService
 	Conn        net.Conn
+	PubSub      *services.PubSub
+	Blocking    *services.Blocking
+	Keyspace    *services.KeyspaceEvents
+	Clients     *services.Clients
 
 	Transaction *TransactionService
+
+	// ACL restricts what the connections may run, anything when nil
+	ACL *ACLService
+
+	// Sentinel is only set when running in sentinel mode
+	Sentinel *sentinel.Sentinel
+
+	propagation *propagation
+
+	// denyBlocking makes blocking commands reply right away, as when run by
+	// EXEC
+	denyBlocking bool
+}
+
+// protocol is the protocol version negotiated by the client with HELLO.
+func (s RequestContext) protocol() int {
+	return s.Clients.Protocol(s.Conn)
+}
+
+// marshal encodes v for the protocol of the client.
+func (s RequestContext) marshal(v resp.Value) ([]byte, error) {
+	v = v.ForProtocol(s.protocol())
+
+	return v.Marshal()
+}
+
+// flush sends the replies of the commands the client sent before this one,
+// which would otherwise wait for this one to stop blocking.
+func (s RequestContext) flush() {
+	if s.Conn != nil && s.Clients != nil {
+		s.Clients.Output(s.Conn).Flush()
+	}
+}
+
+// propagation lets a handler change what is sent to the replicas, so that
+// commands depending on the time or on the state of the master are
+// replicated by their effects.
+type propagation struct {
+	commands [][]string
+	skip     bool
+}
+
+// propagateAs replicates the command as args instead of as received.
+func (s RequestContext) propagateAs(args ...string) {
+	s.propagateEach([][]string{args})
+}
+
+// propagateEach replicates the command as a sequence of commands, for
+// commands with a distinct effect per argument.
+func (s RequestContext) propagateEach(commands [][]string) {
+	if s.propagation != nil {
+		s.propagation.commands = commands
+		s.propagation.skip = false
+	}
+}
+
+// skipPropagation keeps a command that changed nothing from the replicas.
+func (s RequestContext) skipPropagation() {
+	if s.propagation != nil {
+		s.propagation.skip = true
+	}
+}
+
+// propagated returns the commands replicating c given its reply, none when
+// it is not replicated.
+func (p *propagation) propagated(c Command, res resp.Value) [][]string {
+	if !isPropagatedCommand(c.Type) || p.skip || res.Type == resp.SimpleError {
+		return nil
+	}
+
+	if p.commands == nil {
+		return [][]string{append([]string{c.Type}, c.Args...)}
+	}
+
+	return p.commands
 }
 
 var transactionCommands = []string{
 	"MULTI",
 	"EXEC",
 	"DISCARD",
+	"WATCH",
+}
+
+// unlockedCommands run without the shared execution lock: EXEC takes it
+// exclusively, and blocking commands only hold it while looking for data.
+var unlockedCommands = []string{
+	"EXEC",
+	"WAIT",
+	"WAITAOF",
+	"XREAD",
+	"XREADGROUP",
 }
 
 func NewCommand(value resp.Value) (Command, error) {
-	if value.Type == resp.Null {
+	if value.Type == resp.Null || value.Type == resp.Array && len(value.Values) == 0 {
 		return Command{}, errors.New("invalid command")
 	}
 
@@ -48,6 +139,10 @@ func NewCommand(value resp.Value) (Command, error) {
 			}
 		}
 
+		if len(arr) == 0 {
+			return Command{}, errors.New("invalid command")
+		}
+
 		return Command{
 			Type:      arr[0],
 			Args:      arr[1:],
@@ -65,35 +160,220 @@ func NewCommand(value resp.Value) (Command, error) {
 	}, nil
 }
 
+// commandArity is the number of arguments of a command, its name included,
+// as reported by COMMAND INFO: an exact count when positive, a minimum when
+// negative. Queued commands are checked against it.
+var commandArity = map[string]int{
+	"PING":         -1,
+	"HELLO":        -1,
+	"AUTH":         -2,
+	"ACL":          -2,
+	"ECHO":         2,
+	"SET":          -3,
+	"GET":          2,
+	"CONFIG":       -2,
+	"KEYS":         2,
+	"INFO":         -1,
+	"REPLCONF":     -1,
+	"PSYNC":        -3,
+	"COMMAND":      -1,
+	"WAIT":         3,
+	"WAITAOF":      4,
+	"FAILOVER":     -1,
+	"REPLICAOF":    3,
+	"SLAVEOF":      3,
+	"TYPE":         2,
+	"INCR":         2,
+	"DEL":          -2,
+	"MULTI":        1,
+	"EXEC":         1,
+	"DISCARD":      1,
+	"WATCH":        -2,
+	"UNWATCH":      1,
+	"XADD":         -5,
+	"XRANGE":       -4,
+	"XREVRANGE":    -4,
+	"XREAD":        -4,
+	"XGROUP":       -2,
+	"XREADGROUP":   -7,
+	"XACK":         -4,
+	"XPENDING":     -3,
+	"XCLAIM":       -6,
+	"XAUTOCLAIM":   -6,
+	"XLEN":         2,
+	"XDEL":         -3,
+	"XTRIM":        -4,
+	"XINFO":        -2,
+	"SUBSCRIBE":    -2,
+	"UNSUBSCRIBE":  -1,
+	"PSUBSCRIBE":   -2,
+	"PUNSUBSCRIBE": -1,
+	"SSUBSCRIBE":   -2,
+	"SUNSUBSCRIBE": -1,
+	"PUBLISH":      3,
+	"SPUBLISH":     3,
+	"PUBSUB":       -2,
+	"SENTINEL":     -2,
+}
+
+// validate rejects a command the router does not know, or called with the
+// wrong number of arguments.
+func (c *commandRouter) validate(cmd Command) (resp.Value, bool) {
+	typ := strings.ToUpper(cmd.Type)
+
+	if !c.canHandle(typ) {
+		var args strings.Builder
+		for _, arg := range cmd.Args {
+			fmt.Fprintf(&args, "'%s' ", arg)
+		}
+
+		return resp.ErrorValue(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", cmd.Type, args.String())), false
+	}
+
+	arity, ok := commandArity[typ]
+	n := len(cmd.Args) + 1
+
+	if ok && (arity > 0 && n != arity || arity < 0 && n < -arity) {
+		return resp.ErrorValue(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Type))), false
+	}
+
+	return resp.Value{}, true
+}
+
+var writeCommands = []string{
+	"SET",
+	"DEL",
+	"INCR",
+	"XADD",
+	"XGROUP",
+	"XREADGROUP",
+	"XACK",
+	"XCLAIM",
+	"XAUTOCLAIM",
+	"XDEL",
+	"XTRIM",
+}
+
+var propagatedCommands = []string{
+	"SET",
+	"DEL",
+	"INCR",
+	"XADD",
+	"XGROUP",
+	"XREADGROUP",
+	"XACK",
+	"XCLAIM",
+	"XAUTOCLAIM",
+	"XDEL",
+	"XTRIM",
+	// the writes of the transaction, wrapped in MULTI and EXEC
+	"EXEC",
+	// delivered to the subscribers of the replicas as well
+	"SPUBLISH",
+}
+
 func isPropagatedCommand(c string) bool {
-	s := strings.ToUpper(c)
-	return s == "SET" || s == "DEL"
+	return slices.Contains(propagatedCommands, strings.ToUpper(c))
+}
+
+func isWriteCommand(c string) bool {
+	return slices.Contains(writeCommands, strings.ToUpper(c))
 }
 
 func (c *Command) Execute(handler commandRouter, s RequestContext) ([][]byte, error) {
 	var responses [][]byte
 
-	if s.Transaction.IsTransaction(s.Conn) && !slices.Contains(transactionCommands, c.Type) {
+	// RESP3 clients get their messages apart from the replies, they may
+	// send any command while subscribed
+	if s.protocol() == resp.RESP2 && s.PubSub.Subscribed(s.Conn) && !slices.Contains(subscribedCommands, strings.ToUpper(c.Type)) {
+		c.Propagate = false
+
+		value := resp.ErrorValue(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / "+
+			"(P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(c.Type)))
+		v, _ := s.marshal(value)
+
+		return append(responses, v), nil
+	}
+
+	inTransaction := s.Transaction.IsTransaction(s.Conn) && !slices.Contains(transactionCommands, strings.ToUpper(c.Type))
+
+	// a command rejected before running is neither propagated nor queued,
+	// and makes the transaction it was sent in fail on EXEC
+	reject := func(value resp.Value) ([][]byte, error) {
+		c.Propagate = false
+
+		if inTransaction {
+			s.Transaction.Fail(s.Conn)
+		}
+
+		v, _ := s.marshal(value)
+
+		return append(responses, v), nil
+	}
+
+	if value, ok := handler.validate(*c); !ok {
+		return reject(value)
+	}
+
+	if value, ok := s.authorize(*c, "toplevel"); !ok {
+		return reject(value)
+	}
+
+	if isWriteCommand(c.Type) && !s.Replication.IsMasterLink(s.Conn) {
+		// writes wait while a FAILOVER is handing over to a replica
+		s.Replication.WaitWritesAllowed()
+
+		if s.Replication.IsSlave() {
+			return reject(resp.ErrorValue("READONLY You can't write against a read only replica."))
+		}
+
+		if !s.Replication.CanWrite() {
+			return reject(resp.ErrorValue("NOREPLICAS Not enough good replicas to write."))
+		}
+	}
+
+	if inTransaction {
 		if err := s.Transaction.AddCommand(s.Conn, c); err != nil {
 			return nil, err
 		}
 
-		value := resp.BulkStringValue("QUEUED")
-		v, _ := value.Marshal()
+		// replicated by EXEC
+		c.Propagate = false
+
+		value := resp.StringValue("QUEUED")
+		v, _ := s.marshal(value)
 
 		responses = append(responses, v)
 		return responses, nil
 	}
 
+	if !slices.Contains(unlockedCommands, strings.ToUpper(c.Type)) {
+		unlock := s.Transaction.Shared()
+		defer unlock()
+	}
+
+	p := &propagation{}
+	s.propagation = p
+
 	res, err := handler.Handle(*c, s)
 
+	// every request gets a reply, a failure is replied to as an error
 	if err != nil {
-		return nil, err
+		fmt.Println("Failed to execute command: ", err)
+
+		if res.Type != resp.SimpleError {
+			res = resp.ErrorValue("ERR " + err.Error())
+		}
+	}
+
+	// what comes from the master link is forwarded as received
+	if !s.Replication.IsMasterLink(s.Conn) {
+		c.applyPropagation(p, res)
 	}
 
 	if res.Type == resp.Array && res.Flatten {
 		for _, v := range res.Values {
-			r, err := v.Marshal()
+			r, err := s.marshal(v)
 
 			if err != nil {
 				return nil, err
@@ -104,7 +384,7 @@ func (c *Command) Execute(handler commandRouter, s RequestContext) ([][]byte, er
 		return responses, nil
 	}
 
-	r, err := res.Marshal()
+	r, err := s.marshal(res)
 
 	if err != nil {
 		return nil, err
@@ -113,6 +393,42 @@ func (c *Command) Execute(handler commandRouter, s RequestContext) ([][]byte, er
 	return append(responses, r), nil
 }
 
+// applyPropagation keeps failed commands from the replicas and replaces the
+// propagated command when the handler asked for it.
+func (c *Command) applyPropagation(p *propagation, res resp.Value) {
+	if !c.Propagate {
+		return
+	}
+
+	if p.skip || res.Type == resp.SimpleError {
+		c.Propagate = false
+		return
+	}
+
+	if p.commands == nil {
+		return
+	}
+
+	var raw []byte
+	for _, args := range p.commands {
+		raw = append(raw, marshalCommand(args)...)
+	}
+
+	c.Raw = raw
+}
+
+func marshalCommand(args []string) []byte {
+	values := make([]resp.Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, resp.BulkStringValue(arg))
+	}
+
+	value := resp.ArrayValue(values...)
+	raw, _ := value.Marshal()
+
+	return raw
+}
+
 func (c *Command) String() string {
 	return fmt.Sprintf("Command: [%s %s]", c.Type, strings.Join(c.Args, " "))
 }
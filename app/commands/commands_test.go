@@ -2,9 +2,17 @@ package commands
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/services"
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
 	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewCommand(t *testing.T) {
@@ -39,4 +47,766 @@ func TestNewCommand(t *testing.T) {
 		_, err := NewCommand(value)
 		assert.Error(t, err)
 	})
+
+	t.Run("should return error when no element is a string", func(t *testing.T) {
+		_, err := NewCommand(resp.ArrayValue(resp.ArrayValue()))
+		assert.Error(t, err)
+	})
+}
+
+func TestXRange_KeepsFieldOrder(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	add := Command{Type: "XADD", Args: []string{"s", "1-1", "b", "1", "a", "2", "b", "3"}}
+	_, err := xAddHandler(add, ctx)
+	assert.NoError(t, err)
+
+	v, err := xRangeHandler(Command{Type: "XRANGE", Args: []string{"s", "-", "+"}}, ctx)
+	assert.NoError(t, err)
+
+	raw, _ := v.Marshal()
+	assert.Equal(t, "*1\r\n*2\r\n$3\r\n1-1\r\n*6\r\n$1\r\nb\r\n$1\r\n1\r\n$1\r\na\r\n$1\r\n2\r\n$1\r\nb\r\n$1\r\n3\r\n", string(raw))
+}
+
+func marshalled(t *testing.T, v resp.Value) string {
+	raw, err := v.Marshal()
+	assert.NoError(t, err)
+
+	return string(raw)
+}
+
+func TestXRange_Options(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	for _, id := range []string{"1-1", "1-2", "2-0", "3-0"} {
+		_, err := xAddHandler(Command{Type: "XADD", Args: []string{"s", id, "f", "v"}}, ctx)
+		assert.NoError(t, err)
+	}
+
+	ids := func(handler commandHandler, typ string, args ...string) []string {
+		v, err := handler(Command{Type: typ, Args: args}, ctx)
+		assert.NoError(t, err)
+
+		if v.Type != resp.Array {
+			return []string{marshalled(t, v)}
+		}
+
+		result := make([]string, 0, len(v.Values))
+		for _, entry := range v.Values {
+			id, _ := entry.Values[0].AsString()
+			result = append(result, id)
+		}
+
+		return result
+	}
+
+	assert.Equal(t, []string{"1-1", "1-2"}, ids(xRangeHandler, "XRANGE", "s", "1", "1"), "an incomplete ID covers the millisecond")
+	assert.Equal(t, []string{"1-2", "2-0"}, ids(xRangeHandler, "XRANGE", "s", "(1-1", "(3-0"))
+	assert.Equal(t, []string{"1-1", "1-2"}, ids(xRangeHandler, "XRANGE", "s", "-", "+", "COUNT", "2"))
+	assert.Empty(t, ids(xRangeHandler, "XRANGE", "s", "-", "+", "COUNT", "0"))
+	assert.Empty(t, ids(xRangeHandler, "XRANGE", "missing", "-", "+"))
+
+	assert.Equal(t, []string{"3-0", "2-0", "1-2", "1-1"}, ids(xRevRangeHandler, "XREVRANGE", "s", "+", "-"))
+	assert.Equal(t, []string{"2-0"}, ids(xRevRangeHandler, "XREVRANGE", "s", "(3-0", "(1-2", "COUNT", "5"))
+	assert.Equal(t, []string{"3-0"}, ids(xRevRangeHandler, "XREVRANGE", "s", "+", "-", "COUNT", "1"))
+
+	assert.Equal(t, []string{"-ERR invalid start ID for the interval\r\n"}, ids(xRangeHandler, "XRANGE", "s", "(+", "+"))
+	assert.Equal(t, []string{"-ERR invalid end ID for the interval\r\n"}, ids(xRangeHandler, "XRANGE", "s", "-", "(0-0"))
+	assert.Equal(t, []string{"-ERR syntax error\r\n"}, ids(xRangeHandler, "XRANGE", "s", "-", "+", "LIMIT", "2"))
+	assert.Equal(t, []string{"-ERR wrong number of arguments for 'xrange' command\r\n"}, ids(xRangeHandler, "XRANGE", "s", "-"))
+
+	assert.NoError(t, ctx.Store.Write("str", "v"))
+	assert.Contains(t, ids(xRangeHandler, "XRANGE", "str", "-", "+")[0], "WRONGTYPE")
+}
+
+func TestConsumerGroups(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	run := func(handler commandHandler, args ...string) string {
+		v, err := handler(Command{Args: args}, ctx)
+		assert.NoError(t, err)
+
+		return marshalled(t, v)
+	}
+
+	assert.Contains(t, run(xGroupHandler, "CREATE", "s", "g", "$"), "requires the key to exist")
+	assert.Equal(t, "+OK\r\n", run(xGroupHandler, "CREATE", "s", "g", "$", "MKSTREAM"))
+	assert.Equal(t, "-BUSYGROUP Consumer Group name already exists\r\n", run(xGroupHandler, "CREATE", "s", "g", "0"))
+
+	run(xAddHandler, "s", "1-1", "f", "a")
+	run(xAddHandler, "s", "1-2", "f", "b")
+
+	assert.Equal(t,
+		"*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\na\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nf\r\n$1\r\nb\r\n",
+		run(xReadGroupHandler, "GROUP", "g", "alice", "STREAMS", "s", ">"))
+	assert.Equal(t, "*-1\r\n", run(xReadGroupHandler, "GROUP", "g", "bob", "STREAMS", "s", ">"), "everything was delivered")
+	assert.Contains(t, run(xReadGroupHandler, "GROUP", "nope", "alice", "STREAMS", "s", ">"), "-NOGROUP")
+
+	// the history of alice, after 1-1
+	assert.Equal(t,
+		"*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nf\r\n$1\r\nb\r\n",
+		run(xReadGroupHandler, "GROUP", "g", "alice", "STREAMS", "s", "1-1"))
+
+	assert.Equal(t,
+		"*4\r\n:2\r\n$3\r\n1-1\r\n$3\r\n1-2\r\n*1\r\n*2\r\n$5\r\nalice\r\n$1\r\n2\r\n",
+		run(xPendingHandler, "s", "g"))
+	assert.Contains(t, run(xPendingHandler, "s", "g", "-", "+", "10"), "$3\r\n1-2\r\n$5\r\nalice\r\n")
+	assert.Equal(t, "*0\r\n", run(xPendingHandler, "s", "g", "-", "+", "10", "bob"))
+
+	assert.Equal(t, ":1\r\n", run(xAckHandler, "s", "g", "1-1", "9-9"))
+	assert.Equal(t, ":1\r\n", run(xGroupHandler, "DELCONSUMER", "s", "g", "alice"), "1-2 was still pending")
+	assert.Equal(t, "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n", run(xPendingHandler, "s", "g"))
+
+	assert.Equal(t, ":1\r\n", run(xGroupHandler, "CREATECONSUMER", "s", "g", "carol"))
+	assert.Equal(t, ":0\r\n", run(xGroupHandler, "CREATECONSUMER", "s", "g", "carol"))
+	assert.Equal(t, "+OK\r\n", run(xGroupHandler, "SETID", "s", "g", "0", "ENTRIESREAD", "0"))
+	assert.Contains(t, run(xReadGroupHandler, "GROUP", "g", "carol", "COUNT", "1", "STREAMS", "s", ">"), "1-1")
+
+	assert.Equal(t, ":1\r\n", run(xGroupHandler, "DESTROY", "s", "g"))
+	assert.Equal(t, ":0\r\n", run(xGroupHandler, "DESTROY", "s", "g"))
+}
+
+func TestConsumerGroups_Propagation(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	propagated := func(handler commandHandler, typ string, args ...string) *propagation {
+		p := &propagation{}
+		ctx.propagation = p
+
+		_, err := handler(Command{Type: typ, Args: args}, ctx)
+		assert.NoError(t, err)
+
+		return p
+	}
+
+	p := propagated(xAddHandler, "XADD", "s", "*", "f", "v")
+	id := p.commands[0][2]
+	assert.NotEqual(t, "*", id, "replicas get the generated ID")
+	assert.Equal(t, []string{"XADD", "s", id, "f", "v"}, p.commands[0])
+
+	p = propagated(xGroupHandler, "XGROUP", "CREATE", "s", "g", "$")
+	assert.Equal(t, []string{"XGROUP", "CREATE", "s", "g", id}, p.commands[0])
+
+	p = propagated(xReadGroupHandler, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "10", "STREAMS", "s", ">")
+	assert.False(t, p.skip, "the consumer was created")
+	assert.Equal(t, []string{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "s", ">"}, p.commands[0], "replicas never block")
+
+	p = propagated(xReadGroupHandler, "XREADGROUP", "GROUP", "g", "c", "STREAMS", "s", ">")
+	assert.True(t, p.skip, "nothing changed")
+
+	propagated(xAddHandler, "XADD", "s", "*", "f", "v")
+
+	p = propagated(xReadGroupHandler, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "COUNT", "5", "NOACK", "STREAMS", "s", ">")
+	assert.False(t, p.skip)
+	assert.Equal(t, []string{"XREADGROUP", "GROUP", "g", "c", "COUNT", "5", "NOACK", "STREAMS", "s", ">"}, p.commands[0])
+
+	p = propagated(xAckHandler, "XACK", "s", "g", "0-1")
+	assert.True(t, p.skip, "nothing was acknowledged")
+}
+
+func TestClaim(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	run := func(handler commandHandler, typ string, args ...string) (string, *propagation) {
+		p := &propagation{}
+		ctx.propagation = p
+
+		v, err := handler(Command{Type: typ, Args: args}, ctx)
+		assert.NoError(t, err)
+
+		return marshalled(t, v), p
+	}
+
+	run(xAddHandler, "XADD", "s", "1-1", "f", "a")
+	run(xAddHandler, "XADD", "s", "1-2", "f", "b")
+	run(xGroupHandler, "XGROUP", "CREATE", "s", "g", "0")
+	run(xReadGroupHandler, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+
+	out, p := run(xClaimHandler, "XCLAIM", "s", "g", "bob", "3600000", "1-1")
+	assert.Equal(t, "*0\r\n", out, "the entry was just delivered")
+	assert.Equal(t, [][]string{{"XGROUP", "CREATECONSUMER", "s", "g", "bob"}}, p.commands)
+
+	out, p = run(xClaimHandler, "XCLAIM", "s", "g", "bob", "0", "1-1", "9-9", "TIME", "1000", "RETRYCOUNT", "5")
+	assert.Equal(t, "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\na\r\n", out)
+	assert.Equal(t, [][]string{{"XCLAIM", "s", "g", "bob", "0", "1-1", "TIME", "1000", "RETRYCOUNT", "5", "FORCE", "JUSTID"}},
+		p.commands)
+	out, _ = run(xPendingHandler, "XPENDING", "s", "g", "-", "+", "10", "bob")
+	assert.Contains(t, out, "$3\r\n1-1\r\n$3\r\nbob\r\n")
+
+	out, p = run(xClaimHandler, "XCLAIM", "s", "g", "bob", "0", "1-2", "JUSTID", "LASTID", "5-0")
+	assert.Equal(t, "*1\r\n$3\r\n1-2\r\n", out)
+	assert.Equal(t, []string{"XGROUP", "SETID", "s", "g", "5-0", "ENTRIESREAD", "2"}, p.commands[0])
+
+	out, _ = run(xClaimHandler, "XCLAIM", "s", "g", "bob", "0", "1-1", "BOGUS")
+	assert.Equal(t, "-ERR Unrecognized XCLAIM option 'BOGUS'\r\n", out)
+
+	out, _ = run(xClaimHandler, "XCLAIM", "s", "nope", "bob", "0", "1-1")
+	assert.Equal(t, "-NOGROUP No such key 's' or consumer group 'nope'\r\n", out)
+
+	out, p = run(xAutoClaimHandler, "XAUTOCLAIM", "s", "g", "carol", "0", "-", "COUNT", "1", "JUSTID")
+	assert.Equal(t, "*3\r\n$3\r\n1-2\r\n*1\r\n$3\r\n1-1\r\n*0\r\n", out)
+	assert.Len(t, p.commands, 2, "the consumer and the claimed entry")
+
+	out, _ = run(xAutoClaimHandler, "XAUTOCLAIM", "s", "g", "carol", "0", "1-2")
+	assert.Equal(t, "*3\r\n$3\r\n0-0\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nf\r\n$1\r\nb\r\n*0\r\n", out)
+
+	out, _ = run(xAutoClaimHandler, "XAUTOCLAIM", "s", "g", "carol", "0", "-", "COUNT", "0")
+	assert.Equal(t, "-ERR COUNT must be > 0\r\n", out)
+}
+
+func TestTrimming(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	var p *propagation
+
+	run := func(handler commandHandler, typ string, args ...string) string {
+		p = &propagation{}
+		ctx.propagation = p
+
+		v, err := handler(Command{Type: typ, Args: args}, ctx)
+		assert.NoError(t, err)
+
+		return marshalled(t, v)
+	}
+
+	out := run(xAddHandler, "XADD", "s", "NOMKSTREAM", "*", "f", "v")
+	assert.Equal(t, "$-1\r\n", out)
+	assert.True(t, p.skip)
+	assert.Equal(t, ":0\r\n", run(xLenHandler, "XLEN", "s"))
+
+	for i := 1; i <= 5; i++ {
+		run(xAddHandler, "XADD", "s", fmt.Sprintf("%d-0", i), "f", "v")
+	}
+
+	out = run(xAddHandler, "XADD", "s", "MAXLEN", "=", "3", "6-0", "f", "v")
+	assert.Equal(t, "$3\r\n6-0\r\n", out)
+	assert.Equal(t, []string{"XADD", "s", "MAXLEN", "=", "3", "6-0", "f", "v"}, p.commands[0])
+	assert.Equal(t, ":3\r\n", run(xLenHandler, "XLEN", "s"))
+
+	out = run(xTrimHandler, "XTRIM", "s", "MAXLEN", "~", "0")
+	assert.Equal(t, ":3\r\n", out)
+	assert.Equal(t, []string{"XTRIM", "s", "MAXLEN", "=", "0"}, p.commands[0], "replicas get the resulting length")
+
+	run(xAddHandler, "XADD", "s", "7-0", "f", "v")
+	run(xAddHandler, "XADD", "s", "8-0", "f", "v")
+
+	out = run(xDelHandler, "XDEL", "s", "7-0", "9-0")
+	assert.Equal(t, ":1\r\n", out)
+	assert.False(t, p.skip)
+
+	out = run(xTrimHandler, "XTRIM", "s", "MINID", "0")
+	assert.Equal(t, ":0\r\n", out)
+	assert.True(t, p.skip)
+
+	errors := map[string][]string{
+		"-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n":            {"s", "MAXLEN", "1", "LIMIT", "10"},
+		"-ERR syntax error, MAXLEN and MINID options at the same time are not compatible\r\n": {"s", "MAXLEN", "1", "MINID", "1"},
+		"-ERR The MAXLEN argument must be >= 0.\r\n":                                          {"s", "MAXLEN", "-1"},
+		"-ERR syntax error, XTRIM must be called with a trimming strategy\r\n":                {"s", "LIMIT", "1"},
+	}
+
+	for expected, args := range errors {
+		out = run(xTrimHandler, "XTRIM", args...)
+		assert.Equal(t, expected, out, args)
+	}
+
+	assert.NoError(t, ctx.Store.Write("str", "v"))
+	assert.Contains(t, run(xAddHandler, "XADD", "str", "*", "f", "v"), "WRONGTYPE")
+	assert.Contains(t, run(xLenHandler, "XLEN", "str"), "WRONGTYPE")
+}
+
+// concurrent runs every command of commands on its own client at the same
+// time, times times each, as clients do under the shared execution lock.
+func TestDel(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	var p *propagation
+
+	run := func(args ...string) string {
+		p = &propagation{}
+		ctx.propagation = p
+
+		v, err := delHandler(Command{Type: "DEL", Args: args}, ctx)
+		assert.NoError(t, err)
+
+		return marshalled(t, v)
+	}
+
+	assert.NoError(t, ctx.Store.Write("a", "1"))
+	_, err := ctx.Store.XAdd("b", "1-1", [][]string{{"f", "v"}})
+	assert.NoError(t, err)
+
+	c := Command{Type: "DEL", Args: []string{"a", "b", "c"}}
+	assert.Equal(t, ":2\r\n", run(c.Args...))
+	assert.Equal(t, [][]string{{"DEL", "a", "b", "c"}}, p.propagated(c, resp.IntegerValue(2)))
+	assert.Nil(t, ctx.Store.Read("a"))
+	assert.Equal(t, ":0\r\n", run("b"))
+	assert.True(t, p.skip, "deleting nothing is not replicated")
+
+	assert.True(t, isWriteCommand("del"))
+	assert.True(t, isPropagatedCommand("del"))
+}
+
+func concurrent(t *testing.T, ctx RequestContext, times int, commands ...[]string) {
+	t.Helper()
+
+	router := NewCommandRouter()
+
+	var wg sync.WaitGroup
+
+	for _, args := range commands {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < times; i++ {
+				v, err := router.Handle(Command{Type: args[0], Args: args[1:]}, ctx)
+				assert.NoError(t, err)
+				assert.NotEqual(t, resp.SimpleError, v.Type, args)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestStreams_ConcurrentClients(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	concurrent(t, ctx, 200,
+		[]string{"XADD", "s", "*", "f", "v"},
+		[]string{"XADD", "s", "MAXLEN", "50", "*", "f", "v"},
+		[]string{"XTRIM", "s", "MAXLEN", "10"},
+		[]string{"XDEL", "s", "1-0"},
+		[]string{"XRANGE", "s", "-", "+"},
+		[]string{"XREVRANGE", "s", "+", "-", "COUNT", "5"},
+		[]string{"XLEN", "s"},
+		[]string{"XREAD", "STREAMS", "s", "0"},
+	)
+}
+
+func TestConsumerGroups_ConcurrentClients(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	_, err := xGroupHandler(Command{Type: "XGROUP", Args: []string{"CREATE", "s", "g", "$", "MKSTREAM"}}, ctx)
+	assert.NoError(t, err)
+
+	concurrent(t, ctx, 200,
+		[]string{"XADD", "s", "*", "f", "v"},
+		[]string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"},
+		[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", "0"},
+		[]string{"XACK", "s", "g", "1-0"},
+		[]string{"XPENDING", "s", "g", "-", "+", "10"},
+		[]string{"XCLAIM", "s", "g", "bob", "0", "1-0"},
+		[]string{"XAUTOCLAIM", "s", "g", "carol", "0", "0", "COUNT", "5"},
+		[]string{"XGROUP", "CREATECONSUMER", "s", "g", "dave"},
+		[]string{"XGROUP", "SETID", "s", "g", "$"},
+		[]string{"XINFO", "STREAM", "s", "FULL"},
+		[]string{"XTRIM", "s", "MAXLEN", "10"},
+	)
+}
+
+func TestXRead(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory(), Blocking: services.NewBlocking()}
+
+	run := func(handler commandHandler, typ string, args ...string) string {
+		v, err := handler(Command{Type: typ, Args: args}, ctx)
+		assert.NoError(t, err)
+
+		return marshalled(t, v)
+	}
+
+	run(xAddHandler, "XADD", "a", "1-1", "f", "v")
+	run(xAddHandler, "XADD", "a", "1-2", "f", "w")
+	run(xAddHandler, "XADD", "empty", "1-1", "f", "v")
+
+	assert.Equal(t,
+		"*1\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n",
+		run(xReadHandler, "XREAD", "COUNT", "1", "STREAMS", "a", "empty", "missing", "0", "1-1", "0"),
+		"only the streams with entries are returned")
+
+	assert.Equal(t,
+		"*1\r\n*2\r\n$1\r\na\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nf\r\n$1\r\nw\r\n",
+		run(xReadHandler, "XREAD", "STREAMS", "a", "+"),
+		"+ reads the last entry")
+
+	assert.Equal(t, "*-1\r\n", run(xReadHandler, "XREAD", "STREAMS", "a", "empty", "$", "$"))
+	assert.Equal(t, "*-1\r\n", run(xReadHandler, "XREAD", "STREAMS", "a", "1-2"))
+
+	assert.Contains(t, run(xReadHandler, "XREAD", "STREAMS", "a", "empty", "0"), "Unbalanced 'xread'")
+	assert.Equal(t, "-ERR timeout is negative\r\n", run(xReadHandler, "XREAD", "BLOCK", "-1", "STREAMS", "a", "0"))
+
+	start := time.Now()
+	assert.Equal(t, "*-1\r\n", run(xReadHandler, "XREAD", "BLOCK", "50", "STREAMS", "a", "$"))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second, "the timeout does not wait for a change")
+
+	replies := make(chan string)
+
+	go func() {
+		replies <- run(xReadHandler, "XREAD", "BLOCK", "0", "STREAMS", "a", "later", "$", "$")
+	}()
+
+	// the stream is created after the client blocked
+	assert.Eventually(t, func() bool { return ctx.Blocking.Blocked("later") == 1 }, time.Second, time.Millisecond)
+	run(xAddHandler, "XADD", "later", "5-0", "f", "v")
+
+	select {
+	case reply := <-replies:
+		assert.Equal(t, "*1\r\n*2\r\n$5\r\nlater\r\n*1\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n", reply)
+	case <-time.After(time.Second):
+		t.Fatal("the blocked client was not woken up")
+	}
+
+	assert.Equal(t, 0, ctx.Blocking.Blocked("a"), "the client is unblocked")
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	ctx.Conn, ctx.Clients = server, services.NewClients()
+
+	go func() {
+		replies <- run(xReadHandler, "XREAD", "BLOCK", "0", "STREAMS", "a", "$")
+	}()
+
+	assert.Eventually(t, func() bool { return ctx.Blocking.Blocked("a") == 1 }, time.Second, time.Millisecond)
+	ctx.Clients.Hangup(server)
+
+	select {
+	case <-replies:
+	case <-time.After(time.Second):
+		t.Fatal("the client that disconnected is still blocked")
+	}
+
+	assert.Equal(t, 0, ctx.Blocking.Blocked("a"), "the client that disconnected is unblocked")
+}
+
+func TestXInfo(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory()}
+
+	run := func(handler commandHandler, args ...string) resp.Value {
+		v, err := handler(Command{Args: args}, ctx)
+		assert.NoError(t, err)
+
+		return v
+	}
+
+	// field returns the value following name in a flat list of pairs
+	field := func(v resp.Value, name string) resp.Value {
+		for i := 0; i+1 < len(v.Values); i += 2 {
+			if s, _ := v.Values[i].AsString(); s == name {
+				return v.Values[i+1]
+			}
+		}
+
+		t.Fatalf("no field %s", name)
+
+		return resp.Value{}
+	}
+
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		run(xAddHandler, "s", id, "f", "v")
+	}
+
+	run(xGroupHandler, "CREATE", "s", "g", "0")
+	run(xReadGroupHandler, "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">")
+	run(xDelHandler, "s", "3-0")
+
+	info := run(xInfoHandler, "STREAM", "s")
+	assert.Equal(t, ":2\r\n", marshalled(t, field(info, "length")))
+	assert.Equal(t, "$3\r\n3-0\r\n", marshalled(t, field(info, "max-deleted-entry-id")))
+	assert.Equal(t, ":3\r\n", marshalled(t, field(info, "entries-added")))
+	assert.Equal(t, ":1\r\n", marshalled(t, field(info, "radix-tree-keys")))
+	assert.Equal(t, "*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n", marshalled(t, field(info, "last-entry")))
+
+	groups := run(xInfoHandler, "GROUPS", "s")
+	assert.Len(t, groups.Values, 1)
+	assert.Equal(t, ":1\r\n", marshalled(t, field(groups.Values[0], "entries-read")))
+	assert.Equal(t, "$-1\r\n", marshalled(t, field(groups.Values[0], "lag")), "3-0 was deleted ahead of the group")
+
+	consumers := run(xInfoHandler, "CONSUMERS", "s", "g")
+	assert.Equal(t, ":1\r\n", marshalled(t, field(consumers.Values[0], "pending")))
+
+	full := run(xInfoHandler, "STREAM", "s", "FULL", "COUNT", "1")
+	assert.Len(t, field(full, "entries").Values, 1)
+	group := field(full, "groups").Values[0]
+	assert.Equal(t, ":1\r\n", marshalled(t, field(group, "pel-count")))
+	assert.Contains(t, marshalled(t, field(group, "consumers")), "$5\r\nalice\r\n")
+
+	assert.Equal(t, "-ERR no such key\r\n", marshalled(t, run(xInfoHandler, "STREAM", "missing")))
+	assert.Contains(t, marshalled(t, run(xInfoHandler, "CONSUMERS", "s", "nope")), "-NOGROUP")
+	assert.Contains(t, marshalled(t, run(xInfoHandler, "BOGUS", "s")), "Try XINFO HELP")
+}
+
+func TestWatch(t *testing.T) {
+	ctx := RequestContext{Store: store.NewMemory(), Transaction: NewTransactionService()}
+	router := NewCommandRouter()
+
+	run := func(args ...string) resp.Value {
+		c := Command{Type: args[0], Args: args[1:]}
+
+		// queued the way Execute does during MULTI
+		if ctx.Transaction.IsTransaction(ctx.Conn) && args[0] != "EXEC" && args[0] != "WATCH" {
+			assert.NoError(t, ctx.Transaction.AddCommand(ctx.Conn, &c))
+			return resp.StringValue("QUEUED")
+		}
+
+		v, err := router.Handle(c, ctx)
+		assert.NoError(t, err)
+
+		return v
+	}
+
+	assert.Equal(t, resp.StringValue("OK"), run("WATCH", "k", "other"))
+	run("MULTI")
+	run("SET", "k", "from the transaction")
+	assert.Equal(t, resp.ErrorValue("ERR WATCH inside MULTI is not allowed"), run("WATCH", "k"))
+	assert.Equal(t, resp.ArrayValue(resp.StringValue("OK")), run("EXEC"), "nothing changed")
+
+	run("WATCH", "k")
+	assert.NoError(t, ctx.Store.Write("k", "from another client"))
+	run("MULTI")
+	run("SET", "k", "from the transaction")
+	assert.Equal(t, resp.NullArrayValue(), run("EXEC"))
+	assert.Equal(t, "from another client", ctx.Store.Read("k").GetValue())
+
+	run("MULTI")
+	run("INCR", "n")
+	assert.Equal(t, resp.ArrayValue(resp.IntegerValue(1)), run("EXEC"), "EXEC forgets the watched keys")
+
+	run("WATCH", "k")
+	assert.NoError(t, ctx.Store.Write("k", "again"))
+	run("UNWATCH")
+	run("MULTI")
+	run("INCR", "n")
+	assert.Equal(t, resp.ArrayValue(resp.IntegerValue(2)), run("EXEC"))
+
+	run("XADD", "s", "1-1", "f", "v")
+	run("XGROUP", "CREATE", "s", "g", "0")
+	run("WATCH", "s")
+	run("XREADGROUP", "GROUP", "g", "c", "STREAMS", "s", ">")
+	run("MULTI")
+	run("XLEN", "s")
+	assert.Equal(t, resp.NullArrayValue(), run("EXEC"), "a stream changed in place")
+}
+
+func TestTransactionErrors(t *testing.T) {
+	ctx := RequestContext{
+		Store:       store.NewMemory(),
+		Replication: services.NewReplicationService(services.Config),
+		Transaction: NewTransactionService(),
+	}
+
+	router := NewCommandRouter()
+
+	run := func(args ...string) string {
+		c := Command{Type: args[0], Args: args[1:]}
+
+		out, err := c.Execute(router, ctx)
+		assert.NoError(t, err)
+
+		return string(bytes.Join(out, nil))
+	}
+
+	run("MULTI")
+	assert.Equal(t, "+QUEUED\r\n", run("SET", "k", "v"))
+	assert.Equal(t, "-ERR unknown command 'NOPE', with args beginning with: 'a' \r\n", run("NOPE", "a"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'get' command\r\n", run("GET"))
+	assert.Equal(t, "-EXECABORT Transaction discarded because of previous errors.\r\n", run("EXEC"))
+	assert.Nil(t, ctx.Store.Read("k"), "nothing ran")
+	assert.Equal(t, "-ERR EXEC without MULTI\r\n", run("EXEC"))
+
+	run("multi")
+	run("SET", "k", "v")
+	run("XADD", "k", "1-1", "f", "v")
+	run("INCR", "n")
+	assert.Equal(t, "*3\r\n+OK\r\n"+
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"+
+		":1\r\n", run("exec"), "errors while running do not stop the others")
+}
+
+func TestTransactionPropagation(t *testing.T) {
+	ctx := RequestContext{
+		Store:       store.NewMemory(),
+		Replication: services.NewReplicationService(services.Config),
+		Transaction: NewTransactionService(),
+		Blocking:    services.NewBlocking(),
+	}
+
+	router := NewCommandRouter()
+
+	run := func(args ...string) (Command, string) {
+		value, _, err := resp.NewReader(bytes.NewReader(marshalCommand(args))).ReadValue()
+		assert.NoError(t, err)
+
+		c, err := NewCommand(value)
+		assert.NoError(t, err)
+
+		out, err := c.Execute(router, ctx)
+		assert.NoError(t, err)
+
+		return c, string(bytes.Join(out, nil))
+	}
+
+	run("MULTI")
+	set, _ := run("SET", "k", "v")
+	assert.False(t, set.Propagate, "replicated with EXEC")
+
+	run("GET", "k")
+	run("XADD", "s", "*", "f", "v")
+	run("XREAD", "BLOCK", "0", "STREAMS", "other", "$")
+
+	exec, reply := run("EXEC")
+	assert.Contains(t, reply, "*-1\r\n", "nothing to read, BLOCK does not wait in a transaction")
+
+	var lastID stream.ID
+	assert.NoError(t, ctx.Store.WithStream("s", false, func(st *stream.Stream) { lastID = st.LastID }))
+
+	var expected []byte
+	for _, args := range [][]string{
+		{"MULTI"},
+		{"SET", "k", "v"},
+		{"XADD", "s", lastID.String(), "f", "v"},
+		{"EXEC"},
+	} {
+		expected = append(expected, marshalCommand(args)...)
+	}
+
+	assert.True(t, exec.Propagate)
+	assert.Equal(t, string(expected), string(exec.Raw), "only the writes, as executed")
+
+	run("MULTI")
+	run("GET", "k")
+	exec, _ = run("EXEC")
+	assert.False(t, exec.Propagate, "nothing written")
+}
+
+func TestSubscribedMode(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	clients := services.NewClients()
+
+	ctx := RequestContext{
+		Store:       store.NewMemory(),
+		Replication: services.NewReplicationService(services.Config),
+		Transaction: NewTransactionService(),
+		PubSub:      services.NewPubSub(clients),
+		Clients:     clients,
+		Conn:        server,
+	}
+
+	router := NewCommandRouter()
+
+	run := func(args ...string) string {
+		c := Command{Type: args[0], Args: args[1:]}
+
+		out, err := c.Execute(router, ctx)
+		assert.NoError(t, err)
+
+		return string(bytes.Join(out, nil))
+	}
+
+	assert.Equal(t, "+PONG\r\n", run("PING"))
+	assert.Equal(t, "", run("SUBSCRIBE", "news"), "confirmed by the hub")
+	assert.Equal(t, "*2\r\n$4\r\npong\r\n$0\r\n\r\n", run("ping"))
+	assert.Equal(t, "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET "+
+		"are allowed in this context\r\n", run("get", "k"))
+
+	assert.Equal(t, "", run("UNSUBSCRIBE"))
+	assert.Equal(t, "$-1\r\n", run("GET", "k"), "no subscriptions left")
+	assert.Equal(t, "*2\r\n$4\r\nnews\r\n:0\r\n", run("PUBSUB", "NUMSUB", "news"))
+
+	spublish := Command{Type: "SPUBLISH", Args: []string{"orders", "created"}, Propagate: isPropagatedCommand("SPUBLISH")}
+	_, err := spublish.Execute(router, ctx)
+	assert.NoError(t, err)
+	assert.True(t, spublish.Propagate, "shard messages reach the subscribers of the replicas")
+}
+
+func TestHello(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	clients := services.NewClients()
+
+	ctx := RequestContext{
+		Store:       store.NewMemory(),
+		Replication: services.NewReplicationService(services.Config),
+		Transaction: NewTransactionService(),
+		PubSub:      services.NewPubSub(clients),
+		Clients:     clients,
+		Conn:        server,
+	}
+
+	router := NewCommandRouter()
+
+	run := func(args ...string) string {
+		c := Command{Type: args[0], Args: args[1:]}
+
+		out, err := c.Execute(router, ctx)
+		assert.NoError(t, err)
+
+		return string(bytes.Join(out, nil))
+	}
+
+	assert.True(t, strings.HasPrefix(run("HELLO"), "*14\r\n$6\r\nserver\r\n$5\r\nredis\r\n"), "a flat array in RESP2")
+	assert.Equal(t, "-NOPROTO unsupported protocol version\r\n", run("HELLO", "4"))
+	assert.Equal(t, "-ERR Protocol version is not an integer or out of range\r\n", run("HELLO", "three"))
+	assert.Equal(t, "-ERR Syntax error in HELLO option 'AUTH'\r\n", run("HELLO", "3", "AUTH", "default"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", run("HELLO", "3", "AUTH", "alice", "secret"))
+	assert.Equal(t, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n", run("HELLO", "3", "SETNAME", "my app"))
+	assert.Equal(t, resp.RESP2, clients.Protocol(server), "failed negotiations change nothing")
+
+	hello := run("HELLO", "3", "AUTH", "default", "secret", "SETNAME", "app")
+	assert.True(t, strings.HasPrefix(hello, "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n"), hello)
+	assert.Contains(t, hello, "$5\r\nproto\r\n:3\r\n")
+	assert.Equal(t, "app", clients.Get(server).Name)
+
+	assert.Equal(t, "_\r\n", run("GET", "k"))
+	assert.Equal(t, "%1\r\n$3\r\ndir\r\n$0\r\n\r\n", run("CONFIG", "GET", "dir"))
+
+	assert.Equal(t, "", run("SUBSCRIBE", "news"))
+	confirmation, _, err := resp.NewReader(client).ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Push, confirmation.Type)
+	assert.Equal(t, "_\r\n", run("GET", "k"), "RESP3 clients may send any command while subscribed")
+	assert.Equal(t, "+PONG\r\n", run("PING"))
+
+	assert.True(t, strings.HasPrefix(run("HELLO", "2"), "*14\r\n"))
+	assert.Contains(t, run("GET", "k"), "-ERR Can't execute 'get'")
+}
+
+func TestConfigClientOutputBufferLimit(t *testing.T) {
+	clients := services.NewClients()
+
+	ctx := RequestContext{
+		Store:       store.NewMemory(),
+		Replication: services.NewReplicationService(services.Config),
+		Transaction: NewTransactionService(),
+		Clients:     clients,
+	}
+
+	router := NewCommandRouter()
+
+	run := func(args ...string) string {
+		c := Command{Type: args[0], Args: args[1:]}
+
+		out, err := c.Execute(router, ctx)
+		assert.NoError(t, err)
+
+		return string(bytes.Join(out, nil))
+	}
+
+	assert.Equal(t, "+OK\r\n", run("CONFIG", "SET", "client-output-buffer-limit", "pubsub 1mb 512kb 10"))
+	assert.Equal(t, services.OutputLimit{Hard: 1 << 20, Soft: 512 << 10, SoftSeconds: 10}, clients.OutputLimits()[services.PubSubClient])
+
+	limits := "normal 0 0 0 slave 268435456 67108864 60 pubsub 1048576 524288 10"
+	assert.Equal(t, fmt.Sprintf("*2\r\n$26\r\nclient-output-buffer-limit\r\n$%d\r\n%s\r\n", len(limits), limits), run("CONFIG", "GET", "client-output-buffer-limit"))
+
+	assert.Equal(t, "-ERR CONFIG SET failed (possibly related to argument 'client-output-buffer-limit') - "+
+		"Invalid client class specified in buffer limit configuration.\r\n", run("CONFIG", "SET", "client-output-buffer-limit", "master 0 0 0"))
 }
@@ -0,0 +1,792 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const newEntriesID = ">"
+
+func noGroupError(key, group string) resp.Value {
+	return resp.ErrorValue(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", group, key))
+}
+
+func xGroupHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xgroup' command"), nil
+	}
+
+	sub := strings.ToUpper(c.Args[0])
+	args := c.Args[1:]
+
+	arity := map[string][2]int{
+		"CREATE":         {3, 6},
+		"SETID":          {3, 5},
+		"DESTROY":        {2, 2},
+		"CREATECONSUMER": {3, 3},
+		"DELCONSUMER":    {3, 3},
+	}
+
+	bounds, ok := arity[sub]
+	if !ok {
+		return resp.ErrorValue(fmt.Sprintf("ERR unknown subcommand '%s'. Try XGROUP HELP.", c.Args[0])), nil
+	}
+
+	if len(args) < bounds[0] || len(args) > bounds[1] {
+		return resp.ErrorValue(fmt.Sprintf("ERR wrong number of arguments for 'xgroup|%s' command", strings.ToLower(sub))), nil
+	}
+
+	key, group := args[0], args[1]
+
+	mkStream := false
+	entriesRead := stream.InvalidEntriesRead
+
+	if sub == "CREATE" || sub == "SETID" {
+		for i := 3; i < len(args); i++ {
+			switch {
+			case sub == "CREATE" && strings.EqualFold(args[i], "MKSTREAM"):
+				mkStream = true
+			case strings.EqualFold(args[i], "ENTRIESREAD") && i+1 < len(args):
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil {
+					return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+				}
+
+				if n < stream.InvalidEntriesRead {
+					return resp.ErrorValue("ERR value for ENTRIESREAD must be positive or -1"), nil
+				}
+
+				entriesRead = n
+				i++
+			default:
+				return resp.ErrorValue("ERR syntax error"), nil
+			}
+		}
+	}
+
+	var id stream.ID
+
+	if (sub == "CREATE" || sub == "SETID") && args[2] != "$" {
+		parsed, err := stream.ParseID(args[2])
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		id = parsed
+	}
+
+	// event is the keyspace event of the change made, none when nothing
+	// changed
+	var (
+		reply resp.Value
+		event string
+	)
+
+	err := s.Store.WithStream(key, mkStream, func(st *stream.Stream) {
+		if st == nil {
+			reply = resp.ErrorValue("ERR The XGROUP subcommand requires the key to exist. " +
+				"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+			return
+		}
+
+		reply, event = changeGroup(st, sub, args, &id, entriesRead)
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	if event == "" {
+		s.skipPropagation()
+		return reply, nil
+	}
+
+	s.Store.Touch(key, event)
+
+	if sub == "CREATE" || sub == "SETID" {
+		// "$" depends on the stream of the master at the time of the call
+		propagated := append([]string{c.Type, c.Args[0], key, group, id.String()}, args[3:]...)
+		s.propagateAs(propagated...)
+	}
+
+	return reply, nil
+}
+
+// changeGroup runs the XGROUP subcommand sub on st, and returns its reply
+// and the keyspace event of the change, empty when nothing changed. id is
+// resolved when "$".
+func changeGroup(st *stream.Stream, sub string, args []string, id *stream.ID, entriesRead int64) (resp.Value, string) {
+	key, group := args[0], args[1]
+	now := time.Now().UnixMilli()
+
+	switch sub {
+	case "CREATE", "SETID":
+		if args[2] == "$" {
+			*id = st.LastID
+		}
+
+		if sub == "CREATE" {
+			if _, created := st.CreateGroup(group, *id, entriesRead); !created {
+				return resp.ErrorValue("BUSYGROUP Consumer Group name already exists"), ""
+			}
+		} else {
+			g := st.Group(group)
+			if g == nil {
+				return noGroupError(key, group), ""
+			}
+
+			g.SetID(*id, entriesRead)
+		}
+
+		return resp.StringValue("OK"), "xgroup-" + strings.ToLower(sub)
+	case "DESTROY":
+		if !st.DestroyGroup(group) {
+			return resp.IntegerValue(0), ""
+		}
+
+		return resp.IntegerValue(1), "xgroup-destroy"
+	}
+
+	g := st.Group(group)
+	if g == nil {
+		return noGroupError(key, group), ""
+	}
+
+	if sub == "CREATECONSUMER" {
+		if _, created := g.CreateConsumer(args[2], now); !created {
+			return resp.IntegerValue(0), ""
+		}
+
+		return resp.IntegerValue(1), "xgroup-createconsumer"
+	}
+
+	pending, deleted := g.DeleteConsumer(args[2])
+	if !deleted {
+		return resp.IntegerValue(int64(pending)), ""
+	}
+
+	return resp.IntegerValue(int64(pending)), "xgroup-delconsumer"
+}
+
+// xReadGroupHandler delivers new entries (">") or the pending entries of the
+// consumer (any other ID). It is replicated without BLOCK once it served
+// something, replaying it on the same state delivers the same entries.
+func xReadGroupHandler(c Command, s RequestContext) (resp.Value, error) {
+	opts, errValue, ok := parseXReadArgs(c.Args, true)
+	if !ok {
+		return errValue, nil
+	}
+
+	after := make([]stream.ID, len(opts.ids))
+	onlyNew := true
+
+	for i, id := range opts.ids {
+		switch id {
+		case newEntriesID:
+			continue
+		case "$":
+			return resp.ErrorValue("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, " +
+				"or use the > ID to get new messages. The $ ID would just return an empty result set."), nil
+		}
+
+		parsed, err := stream.ParseID(id)
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		after[i] = parsed
+		onlyNew = false
+	}
+
+	// the history of a consumer is served right away
+	timeout := opts.block
+	if !onlyNew {
+		timeout = -1
+	}
+
+	// creating the consumer is a change even when nothing is delivered
+	changed := false
+
+	noGroup := func(key string) resp.Value {
+		return resp.ErrorValue(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' "+
+			"in XREADGROUP with GROUP option", key, opts.group))
+	}
+
+	reply := s.blockOn(opts.keys, timeout, func() (resp.Value, bool) {
+		// nothing is delivered unless every group exists
+		for _, key := range opts.keys {
+			exists := false
+
+			err := s.Store.WithStream(key, false, func(st *stream.Stream) {
+				exists = st != nil && st.Group(opts.group) != nil
+			})
+			if err != nil {
+				return resp.ErrorValue(err.Error()), true
+			}
+
+			if !exists {
+				return noGroup(key), true
+			}
+		}
+
+		now := time.Now().UnixMilli()
+		result := make([]resp.Value, 0, len(opts.keys))
+
+		for i, key := range opts.keys {
+			var (
+				entries        []*stream.Entry
+				found, created bool
+			)
+
+			err := s.Store.WithStream(key, false, func(st *stream.Stream) {
+				var g *stream.Group
+				if st != nil {
+					g = st.Group(opts.group)
+				}
+
+				if found = g != nil; !found {
+					return
+				}
+
+				_, exists := g.Consumers[opts.consumer]
+				created = !exists
+
+				consumer := g.Consumer(opts.consumer, now)
+
+				if opts.ids[i] == newEntriesID {
+					entries = st.ReadGroup(g, consumer, opts.count, opts.noAck, now)
+				} else {
+					entries = st.ReadHistory(consumer, after[i], opts.count, now)
+				}
+			})
+			if err != nil {
+				return resp.ErrorValue(err.Error()), true
+			}
+
+			if !found {
+				return noGroup(key), true
+			}
+
+			if created {
+				changed = true
+				s.Store.Touch(key, "xgroup-createconsumer")
+			}
+
+			if len(entries) > 0 {
+				changed = true
+				s.Store.Touch(key, "")
+			} else if opts.ids[i] == newEntriesID {
+				continue
+			}
+
+			result = append(result, resp.ArrayValue(
+				resp.BulkStringValue(key),
+				resp.ArrayValue(formatStreamEntries(entries)...),
+			))
+		}
+
+		if len(result) == 0 {
+			return resp.NullArrayValue(), false
+		}
+
+		return resp.ArrayValue(result...), true
+	})
+
+	if changed {
+		s.propagateAs(opts.propagated(c.Type)...)
+	} else {
+		s.skipPropagation()
+	}
+
+	return reply, nil
+}
+
+// propagated rebuilds the command without BLOCK, so a replica never waits.
+func (opts xReadArgs) propagated(name string) []string {
+	args := []string{name, "GROUP", opts.group, opts.consumer}
+
+	if opts.count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(opts.count))
+	}
+
+	if opts.noAck {
+		args = append(args, "NOACK")
+	}
+
+	args = append(args, "STREAMS")
+	args = append(args, opts.keys...)
+
+	return append(args, opts.ids...)
+}
+
+func xAckHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) < 3 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xack' command"), nil
+	}
+
+	ids := make([]stream.ID, 0, len(c.Args)-2)
+
+	for _, arg := range c.Args[2:] {
+		id, err := stream.ParseID(arg)
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		ids = append(ids, id)
+	}
+
+	var acked int64
+
+	err := s.Store.WithStream(c.Args[0], false, func(st *stream.Stream) {
+		if st == nil {
+			return
+		}
+
+		if g := st.Group(c.Args[1]); g != nil {
+			for _, id := range ids {
+				if g.Ack(id) {
+					acked++
+				}
+			}
+		}
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	if acked == 0 {
+		s.skipPropagation()
+	} else {
+		s.Store.Touch(c.Args[0], "")
+	}
+
+	return resp.IntegerValue(acked), nil
+}
+
+func xPendingHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) < 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xpending' command"), nil
+	}
+
+	key, group := c.Args[0], c.Args[1]
+	args := c.Args[2:]
+
+	var minIdle int64
+
+	if len(args) > 0 && strings.EqualFold(args[0], "IDLE") {
+		if len(args) < 2 {
+			return resp.ErrorValue("ERR syntax error"), nil
+		}
+
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+		}
+
+		minIdle = n
+		args = args[2:]
+
+		if len(args) == 0 {
+			return resp.ErrorValue("ERR syntax error"), nil
+		}
+	}
+
+	if len(args) != 0 && len(args) != 3 && len(args) != 4 {
+		return resp.ErrorValue("ERR syntax error"), nil
+	}
+
+	var start, end stream.ID
+	count := 0
+
+	if len(args) > 0 {
+		var err error
+
+		if start, err = stream.ParseRangeID(args[0], false); err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		if end, err = stream.ParseRangeID(args[1], true); err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		if count, err = strconv.Atoi(args[2]); err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+		}
+	}
+
+	var reply resp.Value
+
+	err := s.Store.WithStream(key, false, func(st *stream.Stream) {
+		var g *stream.Group
+		if st != nil {
+			g = st.Group(group)
+		}
+
+		switch {
+		case g == nil:
+			reply = resp.ErrorValue(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+		case len(args) == 0:
+			reply = pendingSummary(g)
+		default:
+			reply = pendingEntries(g, start, end, count, args[3:], minIdle)
+		}
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	return reply, nil
+}
+
+// pendingEntries replies with up to count entries pending between start and
+// end, only those of the consumer when one is given.
+func pendingEntries(g *stream.Group, start, end stream.ID, count int, consumerName []string, minIdle int64) resp.Value {
+	var consumer *stream.Consumer
+
+	if len(consumerName) == 1 {
+		if consumer = g.Consumers[consumerName[0]]; consumer == nil {
+			return resp.ArrayValue()
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	pending := g.PendingRange(start, end, count, consumer, minIdle, now)
+	values := make([]resp.Value, 0, len(pending))
+
+	for _, p := range pending {
+		values = append(values, resp.ArrayValue(
+			resp.BulkStringValue(p.ID.String()),
+			resp.BulkStringValue(p.Consumer.Name),
+			resp.IntegerValue(now-p.DeliveryTime),
+			resp.IntegerValue(p.DeliveryCount),
+		))
+	}
+
+	return resp.ArrayValue(values...)
+}
+
+// pendingSummary replies with the number of pending entries, the smallest
+// and greatest pending IDs and the number of entries pending per consumer.
+func pendingSummary(g *stream.Group) resp.Value {
+	if g.Pending.Len() == 0 {
+		return resp.ArrayValue(
+			resp.IntegerValue(0),
+			resp.BulkNullStringValue(),
+			resp.BulkNullStringValue(),
+			resp.NullArrayValue(),
+		)
+	}
+
+	first, last := g.PendingBounds()
+
+	consumers := make([]resp.Value, 0, len(g.Consumers))
+	for _, name := range g.ConsumerNames() {
+		n := g.Consumers[name].Pending.Len()
+		if n == 0 {
+			continue
+		}
+
+		consumers = append(consumers, resp.ArrayValue(
+			resp.BulkStringValue(name),
+			resp.BulkStringValue(strconv.Itoa(n)),
+		))
+	}
+
+	return resp.ArrayValue(
+		resp.IntegerValue(int64(g.Pending.Len())),
+		resp.BulkStringValue(first.ID.String()),
+		resp.BulkStringValue(last.ID.String()),
+		resp.ArrayValue(consumers...),
+	)
+}
+
+// claimGroup looks up the group of a claim command in st, creating the
+// consumer.
+func claimGroup(st *stream.Stream, key, group, consumer string, now int64) (*stream.Group, *stream.Consumer, bool, resp.Value) {
+	var g *stream.Group
+	if st != nil {
+		g = st.Group(group)
+	}
+
+	if g == nil {
+		return nil, nil, false, resp.ErrorValue(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+	}
+
+	_, exists := g.Consumers[consumer]
+
+	return g, g.Consumer(consumer, now), !exists, resp.Value{}
+}
+
+// consumerEvent is the keyspace event of a claim, which only notifies the
+// creation of the consumer.
+func consumerEvent(created bool) string {
+	if created {
+		return "xgroup-createconsumer"
+	}
+
+	return ""
+}
+
+// claimReply replies with the claimed entries, or only their IDs with
+// JUSTID.
+func claimReply(st *stream.Stream, claimed []*stream.PendingEntry, justID bool) resp.Value {
+	values := make([]resp.Value, 0, len(claimed))
+
+	if justID {
+		for _, p := range claimed {
+			values = append(values, resp.BulkStringValue(p.ID.String()))
+		}
+
+		return resp.ArrayValue(values...)
+	}
+
+	entries := make([]*stream.Entry, 0, len(claimed))
+	for _, p := range claimed {
+		entries = append(entries, st.Get(p.ID))
+	}
+
+	return resp.ArrayValue(formatStreamEntries(entries)...)
+}
+
+// claimPropagation replicates a claim by its effects: every claimed entry
+// with its delivery time and count, every entry dropped because it was
+// deleted, and the consumer when it was created.
+func claimPropagation(key, group, consumer string, created bool, claimed []*stream.PendingEntry, deleted []stream.ID) [][]string {
+	var commands [][]string
+
+	if created {
+		commands = append(commands, []string{"XGROUP", "CREATECONSUMER", key, group, consumer})
+	}
+
+	for _, p := range claimed {
+		commands = append(commands, []string{
+			"XCLAIM", key, group, consumer, "0", p.ID.String(),
+			"TIME", strconv.FormatInt(p.DeliveryTime, 10),
+			"RETRYCOUNT", strconv.FormatInt(p.DeliveryCount, 10),
+			"FORCE", "JUSTID",
+		})
+	}
+
+	for _, id := range deleted {
+		commands = append(commands, []string{"XACK", key, group, id.String()})
+	}
+
+	return commands
+}
+
+func xClaimHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) < 5 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xclaim' command"), nil
+	}
+
+	key, group, consumer := c.Args[0], c.Args[1], c.Args[2]
+
+	minIdle, err := strconv.ParseInt(c.Args[3], 10, 64)
+	if err != nil || minIdle < 0 {
+		return resp.ErrorValue("ERR Invalid min-idle-time argument for XCLAIM"), nil
+	}
+
+	now := time.Now().UnixMilli()
+	opts := stream.ClaimOptions{MinIdle: minIdle, DeliveryTime: now, RetryCount: -1}
+
+	// the IDs come first, the options start at the first argument that is
+	// not an ID
+	args := c.Args[4:]
+	var ids []stream.ID
+
+	for len(args) > 0 {
+		id, err := stream.ParseID(args[0])
+		if err != nil {
+			break
+		}
+
+		ids = append(ids, id)
+		args = args[1:]
+	}
+
+	var lastID *stream.ID
+
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		hasValue := i+1 < len(args)
+
+		switch {
+		case opt == "FORCE":
+			opts.Force = true
+		case opt == "JUSTID":
+			opts.JustID = true
+		case opt == "IDLE" && hasValue:
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return resp.ErrorValue("ERR Invalid IDLE option argument for XCLAIM"), nil
+			}
+
+			opts.DeliveryTime = now - ms
+			i++
+		case opt == "TIME" && hasValue:
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return resp.ErrorValue("ERR Invalid TIME option argument for XCLAIM"), nil
+			}
+
+			opts.DeliveryTime = ms
+			i++
+		case opt == "RETRYCOUNT" && hasValue:
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return resp.ErrorValue("ERR Invalid RETRYCOUNT option argument for XCLAIM"), nil
+			}
+
+			opts.RetryCount = n
+			i++
+		case opt == "LASTID" && hasValue:
+			id, err := stream.ParseID(args[i+1])
+			if err != nil {
+				return resp.ErrorValue(err.Error()), nil
+			}
+
+			lastID = &id
+			i++
+		default:
+			return resp.ErrorValue(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i])), nil
+		}
+	}
+
+	// a delivery time in the future is as good as now
+	if opts.DeliveryTime < 0 || opts.DeliveryTime > now {
+		opts.DeliveryTime = now
+	}
+
+	var (
+		reply    resp.Value
+		commands [][]string
+		created  bool
+	)
+
+	err = s.Store.WithStream(key, false, func(st *stream.Stream) {
+		g, cons, isNew, errValue := claimGroup(st, key, group, consumer, now)
+		if g == nil {
+			reply = errValue
+			return
+		}
+
+		created = isNew
+
+		if lastID != nil && g.LastID.Less(*lastID) {
+			g.SetID(*lastID, g.EntriesRead)
+
+			commands = append(commands, []string{"XGROUP", "SETID", key, group, lastID.String(),
+				"ENTRIESREAD", strconv.FormatInt(g.EntriesRead, 10)})
+		}
+
+		claimed, deleted := st.Claim(g, cons, ids, opts, now)
+		commands = append(commands, claimPropagation(key, group, consumer, created, claimed, deleted)...)
+
+		reply = claimReply(st, claimed, opts.JustID)
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	if len(commands) > 0 {
+		s.Store.Touch(key, consumerEvent(created))
+		s.propagateEach(commands)
+	} else {
+		s.skipPropagation()
+	}
+
+	return reply, nil
+}
+
+func xAutoClaimHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) < 5 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xautoclaim' command"), nil
+	}
+
+	key, group, consumer := c.Args[0], c.Args[1], c.Args[2]
+
+	minIdle, err := strconv.ParseInt(c.Args[3], 10, 64)
+	if err != nil || minIdle < 0 {
+		return resp.ErrorValue("ERR Invalid min-idle-time argument for XAUTOCLAIM"), nil
+	}
+
+	start, err := stream.ParseRangeID(c.Args[4], false)
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	now := time.Now().UnixMilli()
+	opts := stream.ClaimOptions{MinIdle: minIdle, DeliveryTime: now, RetryCount: -1}
+	count := 100
+
+	args := c.Args[5:]
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "JUSTID"):
+			opts.JustID = true
+		case strings.EqualFold(args[i], "COUNT") && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+			}
+
+			// the scan looks at up to 10 times count entries
+			if n < 1 || n > math.MaxInt/10 {
+				return resp.ErrorValue("ERR COUNT must be > 0"), nil
+			}
+
+			count = n
+			i++
+		default:
+			return resp.ErrorValue("ERR syntax error"), nil
+		}
+	}
+
+	var (
+		reply    resp.Value
+		commands [][]string
+		created  bool
+	)
+
+	err = s.Store.WithStream(key, false, func(st *stream.Stream) {
+		g, cons, isNew, errValue := claimGroup(st, key, group, consumer, now)
+		if g == nil {
+			reply = errValue
+			return
+		}
+
+		created = isNew
+
+		claimed, deleted, next := st.AutoClaim(g, cons, start, count, opts, now)
+		commands = claimPropagation(key, group, consumer, created, claimed, deleted)
+
+		deletedIDs := make([]resp.Value, 0, len(deleted))
+		for _, id := range deleted {
+			deletedIDs = append(deletedIDs, resp.BulkStringValue(id.String()))
+		}
+
+		reply = resp.ArrayValue(
+			resp.BulkStringValue(next.String()),
+			claimReply(st, claimed, opts.JustID),
+			resp.ArrayValue(deletedIDs...),
+		)
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	if len(commands) > 0 {
+		s.Store.Touch(key, consumerEvent(created))
+		s.propagateEach(commands)
+	} else {
+		s.skipPropagation()
+	}
+
+	return reply, nil
+}
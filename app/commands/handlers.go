@@ -8,10 +8,9 @@ import (
 	"github.com/codecrafters-io/redis-starter-go/app/services"
 	"github.com/codecrafters-io/redis-starter-go/app/store"
 	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
-	"slices"
+	"net"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"time"
 )
 
@@ -24,25 +23,57 @@ type commandRouter struct {
 func NewCommandRouter() commandRouter {
 	return commandRouter{
 		handlers: map[string]commandHandler{
-			"PING":     pingHandler,
-			"ECHO":     echoHandler,
-			"SET":      setHandler,
-			"GET":      getHandler,
-			"CONFIG":   configHandler,
-			"KEYS":     keysHandler,
-			"INFO":     infoHandler,
-			"REPLCONF": replConfigHandler,
-			"PSYNC":    pSyncHandler,
-			"COMMAND":  docHandler,
-			"WAIT":     waitHandler,
-			"TYPE":     typeHandler,
-			"XADD":     xAddHandler,
-			"XRANGE":   xRangeHandler,
-			"XREAD":    xReadHandler,
-			"INCR":     incrHandler,
-			"MULTI":    multiHandler,
-			"EXEC":     execHandler,
-			"DISCARD":  discardHandler,
+			"HELLO":     helloHandler,
+			"AUTH":      authHandler,
+			"ACL":       aclHandler,
+			"PING":      pingHandler,
+			"ECHO":      echoHandler,
+			"SET":       setHandler,
+			"GET":       getHandler,
+			"CONFIG":    configHandler,
+			"KEYS":      keysHandler,
+			"INFO":      infoHandler,
+			"REPLCONF":  replConfigHandler,
+			"PSYNC":     pSyncHandler,
+			"COMMAND":   docHandler,
+			"WAIT":      waitHandler,
+			"WAITAOF":   waitAofHandler,
+			"FAILOVER":  failoverHandler,
+			"REPLICAOF": replicaOfHandler,
+			"SLAVEOF":   replicaOfHandler,
+			"TYPE":      typeHandler,
+			"XADD":      xAddHandler,
+			"XRANGE":    xRangeHandler,
+			"XREVRANGE": xRevRangeHandler,
+			"XREAD":     xReadHandler,
+			"INCR":      incrHandler,
+			"DEL":       delHandler,
+			"MULTI":     multiHandler,
+			"EXEC":      execHandler,
+			"DISCARD":   discardHandler,
+			"WATCH":     watchHandler,
+			"UNWATCH":   unwatchHandler,
+
+			"XGROUP":     xGroupHandler,
+			"XREADGROUP": xReadGroupHandler,
+			"XACK":       xAckHandler,
+			"XPENDING":   xPendingHandler,
+			"XCLAIM":     xClaimHandler,
+			"XAUTOCLAIM": xAutoClaimHandler,
+			"XLEN":       xLenHandler,
+			"XDEL":       xDelHandler,
+			"XTRIM":      xTrimHandler,
+			"XINFO":      xInfoHandler,
+
+			"SUBSCRIBE":    subscribeHandler,
+			"UNSUBSCRIBE":  unsubscribeHandler,
+			"PSUBSCRIBE":   pSubscribeHandler,
+			"PUNSUBSCRIBE": pUnsubscribeHandler,
+			"SSUBSCRIBE":   sSubscribeHandler,
+			"SUNSUBSCRIBE": sUnsubscribeHandler,
+			"PUBLISH":      publishHandler,
+			"SPUBLISH":     sPublishHandler,
+			"PUBSUB":       pubSubHandler,
 		},
 	}
 }
@@ -85,6 +116,14 @@ func execHandler(c Command, s RequestContext) (resp.Value, error) {
 
 	response, err := s.Transaction.Commit(s.Conn, s)
 
+	if errors.Is(err, ErrExecAbort) {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	if errors.Is(err, ErrWatchedKeyChanged) {
+		return resp.NullArrayValue(), nil
+	}
+
 	if response == nil {
 		return resp.ArrayValue(), err
 	}
@@ -93,6 +132,26 @@ func execHandler(c Command, s RequestContext) (resp.Value, error) {
 
 }
 
+func watchHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'watch' command"), nil
+	}
+
+	if s.Transaction.IsTransaction(s.Conn) {
+		return resp.ErrorValue("ERR WATCH inside MULTI is not allowed"), nil
+	}
+
+	s.Transaction.Watch(s.Conn, s.Store, c.Args)
+
+	return resp.StringValue("OK"), nil
+}
+
+func unwatchHandler(c Command, s RequestContext) (resp.Value, error) {
+	s.Transaction.Unwatch(s.Conn)
+
+	return resp.StringValue("OK"), nil
+}
+
 func multiHandler(c Command, s RequestContext) (resp.Value, error) {
 	if s.Transaction.IsTransaction(s.Conn) {
 		return resp.ErrorValue("ERR MULTI calls can not be nested"), nil
@@ -119,87 +178,74 @@ func incrHandler(c Command, s RequestContext) (resp.Value, error) {
 	return resp.IntegerValue(v), nil
 }
 
-const (
-	blocking int64 = 0
-)
+func delHandler(c Command, s RequestContext) (resp.Value, error) {
+	deleted := s.Store.Delete(c.Args...)
 
-func xReadHandler(c Command, s RequestContext) (resp.Value, error) {
-	keys, ids, blockMillis := parseXReadArgs(c.Args)
-
-	if blockMillis == blocking {
-		return handleBlockingRead(keys, ids, s.Store)
+	if deleted == 0 {
+		s.skipPropagation()
 	}
 
-	if blockMillis > 0 {
-		if slices.Contains(ids, "$") {
-			sto := s.Store.Read(keys[0])
-			stream, ok := sto.(*stream.Stream)
-
-			if ok {
-				ids[0] = stream.TailPrefix
-			}
-		}
-
-		time.Sleep(time.Duration(blockMillis) * time.Millisecond)
-	}
-
-	return readStreams(keys, ids, s.Store)
+	return resp.IntegerValue(int64(deleted)), nil
 }
 
-func xRangeHandler(c Command, s RequestContext) (resp.Value, error) {
-	key := c.Args[0]
-	start := c.Args[1]
-	end := c.Args[2]
-
-	fmt.Println("XRANGE: ", key, start, end)
-
-	trie := s.Store.Read(key).(*stream.Stream)
-
-	if trie == nil {
-		fmt.Println("Stream not found")
-		return resp.NullValue(), nil
+func xAddHandler(c Command, s RequestContext) (resp.Value, error) {
+	opts, errValue, ok := parseAddOrTrimArgs(c.Args, true)
+	if !ok {
+		return errValue, nil
 	}
 
-	result := trie.Range(start, end)
-	r := make([]resp.Value, 0, len(result))
-
-	for _, entry := range result {
-		id := resp.BulkStringValue(entry.Id)
-		values := make([]resp.Value, 0, 2*len(entry.Elements))
+	key := c.Args[0]
 
-		for k, v := range entry.Elements {
-			values = append(values, resp.BulkStringValue(k), resp.BulkStringValue(fmt.Sprintf("%v", v)))
+	if opts.noMkStream {
+		exists := false
+		err := s.Store.WithStream(key, false, func(st *stream.Stream) { exists = st != nil })
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
 		}
 
-		r = append(r, resp.ArrayValue(id, resp.ArrayValue(values...)))
+		if !exists {
+			s.skipPropagation()
+			return resp.BulkNullStringValue(), nil
+		}
 	}
 
-	return resp.ArrayValue(r...), nil
-}
-
-func xAddHandler(c Command, s RequestContext) (resp.Value, error) {
-	args := Chunk(c.Args, 2)
-
-	key := args[0]
-	entries := args[1:]
+	k, err := s.Store.XAdd(key, c.Args[opts.idAt], Chunk(c.Args[opts.idAt+1:], 2))
 
-	if len(key) == 0 {
-		panic("stream has no name arguments")
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
 	}
 
-	fmt.Println("XADD: ", key[0], key[1], entries)
-
-	k, err := s.Store.XAdd(key[0], key[1], entries)
+	var (
+		trimmed int64
+		trim    []string
+	)
 
+	err = s.Store.WithStream(key, false, func(st *stream.Stream) {
+		trimmed = st.Trim(opts.trim)
+		trim = opts.propagatedTrim(st)
+	})
 	if err != nil {
 		return resp.ErrorValue(err.Error()), nil
 	}
 
-	if k != key[0] {
-		return resp.BulkStringValue(k), nil
+	if trimmed > 0 {
+		s.Store.Touch(key, "xtrim")
+	}
+
+	s.signalKeyAsReady(key)
+
+	// replicas store the entry under the ID generated here
+	propagated := []string{c.Type, key}
+	if opts.noMkStream {
+		propagated = append(propagated, "NOMKSTREAM")
 	}
 
-	return resp.StringValue(key[0]), nil
+	propagated = append(propagated, trim...)
+	propagated = append(propagated, k)
+	propagated = append(propagated, c.Args[opts.idAt+1:]...)
+	s.propagateAs(propagated...)
+
+	return resp.BulkStringValue(k), nil
 }
 
 func typeHandler(c Command, s RequestContext) (resp.Value, error) {
@@ -213,64 +259,120 @@ func typeHandler(c Command, s RequestContext) (resp.Value, error) {
 }
 
 func waitHandler(c Command, s RequestContext) (result resp.Value, err error) {
-	if len(c.Args) < 2 {
-		return resp.ErrorValue("ERR: not enough arguments"), nil
+	if len(c.Args) != 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'wait' command"), nil
 	}
 
-	var (
-		acked           atomic.Int32
-		replicaCount, _ = strconv.Atoi(c.Args[0])
-		timeout, _      = strconv.Atoi(c.Args[1])
-	)
+	numReplicas, err := strconv.Atoi(c.Args[0])
+	if err != nil || numReplicas < 0 {
+		return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+	}
 
-	if replicaCount == 0 {
-		return resp.IntegerValue(0), nil
+	timeout, err := strconv.ParseInt(c.Args[1], 10, 64)
+	if err != nil || timeout < 0 {
+		return resp.ErrorValue("ERR timeout is negative"), nil
 	}
 
-	// check documentation
-	if len(s.Store.Keys()) == 0 {
-		return resp.IntegerValue(int64(len(s.Replication.Replicas))), nil
+	if !s.Replication.IsMaster() {
+		return resp.ErrorValue("ERR WAIT cannot be used with replica instances."), nil
 	}
 
-	replicas := s.Replication.Replicas
-	results := make(chan struct{}, len(replicas))
+	acked := waitForReplicas(s, numReplicas, timeout, false)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
-	defer cancel()
+	return resp.IntegerValue(int64(acked)), nil
+}
 
-	for _, replica := range replicas {
-		tcpConn := replica.Conn
+// waitAofHandler answers WAITAOF numlocal numreplicas timeout. There is no
+// append only file, so only replica fsync acknowledgements can be awaited.
+func waitAofHandler(c Command, s RequestContext) (result resp.Value, err error) {
+	if len(c.Args) != 3 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'waitaof' command"), nil
+	}
 
-		if err := s.Replication.GetAck(tcpConn); err != nil {
-			fmt.Println("Failed to send GetAck request to replica:", tcpConn.RemoteAddr())
-			continue
-		}
+	numLocal, err := strconv.Atoi(c.Args[0])
+	if err != nil || numLocal < 0 {
+		return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+	}
 
-		go func(r *services.Replica) {
-			select {
-			case <-r.Ack:
-				results <- struct{}{}
-			case <-ctx.Done():
-				fmt.Println("Timeout or cancel signal received for replica:", r.Conn.RemoteAddr())
-			}
-		}(replica)
+	numReplicas, err := strconv.Atoi(c.Args[1])
+	if err != nil || numReplicas < 0 {
+		return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+	}
+
+	timeout, err := strconv.ParseInt(c.Args[2], 10, 64)
+	if err != nil || timeout < 0 {
+		return resp.ErrorValue("ERR timeout is negative"), nil
+	}
+
+	if !s.Replication.IsMaster() {
+		return resp.ErrorValue("ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated."), nil
+	}
+
+	if numLocal > 0 {
+		return resp.ErrorValue("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled."), nil
 	}
 
+	acked := waitForReplicas(s, numReplicas, timeout, true)
+
+	return resp.ArrayValue(resp.IntegerValue(0), resp.IntegerValue(int64(acked))), nil
+}
+
+// waitForReplicas blocks until numReplicas replicas acknowledged the last
+// write of the client, or timeout milliseconds elapsed. A zero timeout blocks forever.
+func waitForReplicas(s RequestContext, numReplicas int, timeout int64, aof bool) int {
+	offset := s.Replication.GetClientOffset(s.Conn)
+
+	if acked := s.Replication.CountAcked(offset, aof); acked >= numReplicas || s.denyBlocking {
+		return acked
+	}
+
+	ctx := context.Background()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the wait ends with the client
 	go func() {
-		for range results {
-			acked.Add(1)
+		select {
+		case <-s.gone():
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
 
-	<-ctx.Done()
+	s.Replication.RequestAcks()
+	s.flush()
 
-	finalAcked := acked.Load()
-	fmt.Println("Final acked count:", finalAcked)
-
-	return resp.IntegerValue(int64(finalAcked)), nil
+	return s.Replication.WaitForAcks(ctx, offset, numReplicas, aof)
 }
 
-func pingHandler(_ Command, _ RequestContext) (resp.Value, error) {
+// pingHandler replies PONG, or echoes its argument. A connection with
+// subscriptions gets a pong message instead.
+func pingHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) > 1 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'ping' command"), nil
+	}
+
+	// RESP2 subscribers tell the reply apart from the messages by its shape
+	if s.protocol() == resp.RESP2 && s.PubSub.Subscribed(s.Conn) {
+		message := ""
+		if len(c.Args) == 1 {
+			message = c.Args[0]
+		}
+
+		return resp.ArrayValue(resp.BulkStringValue("pong"), resp.BulkStringValue(message)), nil
+	}
+
+	if len(c.Args) == 1 {
+		return resp.BulkStringValue(c.Args[0]), nil
+	}
+
 	return resp.StringValue("PONG"), nil
 }
 
@@ -320,24 +422,85 @@ func setHandler(c Command, context RequestContext) (resp.Value, error) {
 	return resp.StringValue("OK"), nil
 }
 
-func configHandler(c Command, _ RequestContext) (result resp.Value, err error) {
+func configHandler(c Command, s RequestContext) (result resp.Value, err error) {
+	if len(c.Args) < 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'config' command"), nil
+	}
+
 	cmd := c.Args[0]
 	arg := c.Args[1]
 
+	if strings.EqualFold(cmd, "set") {
+		return configSetHandler(c.Args[1:], s), nil
+	}
+
 	if !strings.EqualFold(cmd, "get") {
 		return resp.ErrorValue("unknown command"), nil
 	}
 
 	switch arg {
 	case "dbfilename":
-		return resp.ArrayValue(resp.BulkStringValue(arg), resp.BulkStringValue(*services.Config.DbFilename)), nil
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(*services.Config.DbFilename)), nil
 	case "dir":
-		return resp.ArrayValue(resp.BulkStringValue(arg), resp.BulkStringValue(*services.Config.Dir)), nil
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(*services.Config.Dir)), nil
+	case "min-replicas-to-write":
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(strconv.Itoa(*services.Config.MinReplicasToWrite))), nil
+	case "min-replicas-max-lag":
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(strconv.Itoa(*services.Config.MinReplicasMaxLag))), nil
+	case "notify-keyspace-events":
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(s.Keyspace.Flags())), nil
+	case "proto-max-bulk-len":
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(strconv.Itoa(*services.Config.ProtoMaxBulkLen))), nil
+	case "client-output-buffer-limit":
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(s.Clients.OutputLimits().String())), nil
+	case "requirepass":
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(s.ACL.RequirePass())), nil
+	case "aclfile":
+		return resp.MapValue(resp.BulkStringValue(arg), resp.BulkStringValue(*services.Config.AclFile)), nil
 	default:
 		return resp.ErrorValue("unknown argument"), nil
 	}
 }
 
+// configSetHandler changes parameters at runtime, only the keyspace events,
+// the output buffer limits and requirepass can be changed for now.
+func configSetHandler(args []string, s RequestContext) resp.Value {
+	if len(args)%2 != 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'config|set' command")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "notify-keyspace-events", "client-output-buffer-limit", "requirepass":
+		default:
+			return resp.ErrorValue(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i]))
+		}
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		var err error
+
+		switch strings.ToLower(args[i]) {
+		case "notify-keyspace-events":
+			err = s.Keyspace.SetFlags(args[i+1])
+		case "client-output-buffer-limit":
+			var limits services.OutputLimits
+
+			if limits, err = services.ParseOutputLimits(args[i+1], s.Clients.OutputLimits()); err == nil {
+				s.Clients.SetOutputLimits(limits)
+			}
+		case "requirepass":
+			s.ACL.SetRequirePass(args[i+1])
+		}
+
+		if err != nil {
+			return resp.ErrorValue(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", args[i], err))
+		}
+	}
+
+	return resp.StringValue("OK")
+}
+
 func keysHandler(c Command, context RequestContext) (resp.Value, error) {
 	if len(c.Args) == 0 {
 		err := errors.New("no pattern provided")
@@ -367,7 +530,7 @@ func infoHandler(c Command, context RequestContext) (resp.Value, error) {
 
 	switch arg {
 	case "replication":
-		return resp.BulkStringValue(context.Replication.String()), nil
+		return resp.VerbatimStringValue("txt", context.Replication.String()), nil
 	default:
 		return resp.BulkStringValue("ERR: unknown argument"), nil
 	}
@@ -376,38 +539,161 @@ func infoHandler(c Command, context RequestContext) (resp.Value, error) {
 func replConfigHandler(c Command, s RequestContext) (resp.Value, error) {
 	switch strings.ToUpper(c.Args[0]) {
 	case "GETACK":
-		return resp.ArrayValue(
-			resp.BulkStringValue("REPLCONF"),
-			resp.BulkStringValue("ACK"),
-			resp.BulkStringValue(strconv.FormatInt(s.Replication.GetReplOffset(), 10)),
-		), nil
+		return s.Replication.AckValue(), nil
 	case "ACK":
-		if s.Conn != nil {
-			replica := s.Replication.GetReplica(s.Conn)
-			if replica == nil {
-				return resp.ErrorValue("ERR: no replica connection"), nil
-			}
+		if s.Conn == nil || len(c.Args) < 2 {
+			return resp.FlatArrayValue(), nil
+		}
 
-			fmt.Println("Replica ack received: ", replica.Conn.RemoteAddr().String())
-			replica.Ack <- true
+		replica := s.Replication.GetReplica(s.Conn)
+		if replica == nil {
+			return resp.ErrorValue("ERR: no replica connection"), nil
 		}
+
+		offset, err := strconv.ParseInt(c.Args[1], 10, 64)
+		if err != nil {
+			return resp.FlatArrayValue(), nil
+		}
+
+		var aofOffset int64 = -1
+		if len(c.Args) >= 4 && strings.EqualFold(c.Args[2], "FACK") {
+			if v, err := strconv.ParseInt(c.Args[3], 10, 64); err == nil {
+				aofOffset = v
+			}
+		}
+
+		s.Replication.Ack(replica, offset, aofOffset)
+
 		return resp.FlatArrayValue(), nil
+	case "LISTENING-PORT":
+		if s.Conn != nil && len(c.Args) > 1 {
+			port, err := strconv.Atoi(c.Args[1])
+			if err != nil {
+				return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+			}
+
+			s.Replication.SetListeningPort(s.Conn, port)
+		}
+		return resp.StringValue("OK"), nil
 	default:
 		return resp.StringValue("OK"), nil
 	}
 }
 
+func failoverHandler(c Command, s RequestContext) (resp.Value, error) {
+	var (
+		opts  services.FailoverOptions
+		abort bool
+	)
+
+	for i := 0; i < len(c.Args); i++ {
+		switch strings.ToUpper(c.Args[i]) {
+		case "TO":
+			if i+2 >= len(c.Args) {
+				return resp.ErrorValue("ERR syntax error"), nil
+			}
+			opts.Host, opts.Port = c.Args[i+1], c.Args[i+2]
+			i += 2
+		case "FORCE":
+			opts.Force = true
+		case "ABORT":
+			abort = true
+		case "TIMEOUT":
+			if i+1 >= len(c.Args) {
+				return resp.ErrorValue("ERR syntax error"), nil
+			}
+
+			ms, err := strconv.ParseInt(c.Args[i+1], 10, 64)
+			if err != nil || ms <= 0 {
+				return resp.ErrorValue("ERR FAILOVER timeout must be greater than 0"), nil
+			}
+			opts.Timeout = time.Duration(ms) * time.Millisecond
+			i++
+		default:
+			return resp.ErrorValue("ERR syntax error"), nil
+		}
+	}
+
+	if abort {
+		if opts.Host != "" || opts.Force || opts.Timeout > 0 {
+			return resp.ErrorValue("ERR FAILOVER ABORT cannot be used with other arguments"), nil
+		}
+
+		if err := s.Replication.AbortFailover(); err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		return resp.StringValue("OK"), nil
+	}
+
+	if opts.Force && (opts.Host == "" || opts.Timeout == 0) {
+		return resp.ErrorValue("ERR FAILOVER with force option requires both a timeout and target HOST and IP."), nil
+	}
+
+	if err := s.Replication.Failover(opts); err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	return resp.StringValue("OK"), nil
+}
+
+// replicaOfHandler follows a new master, or promotes us with REPLICAOF NO ONE.
+func replicaOfHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) != 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'replicaof' command"), nil
+	}
+
+	if strings.EqualFold(c.Args[0], "NO") && strings.EqualFold(c.Args[1], "ONE") {
+		s.Replication.BecomeMaster()
+		return resp.StringValue("OK"), nil
+	}
+
+	port, err := strconv.Atoi(c.Args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return resp.ErrorValue("ERR Invalid master port"), nil
+	}
+
+	addr := net.JoinHostPort(c.Args[0], strconv.Itoa(port))
+
+	if s.Replication.IsSlave() && s.Replication.GetMasterAddr() == addr {
+		return resp.StringValue("OK Already connected to specified master"), nil
+	}
+
+	s.Replication.BecomeReplicaOf(addr)
+
+	return resp.StringValue("OK"), nil
+}
+
+// pSyncHandler turns the connection into a replica link. The replication
+// service writes the resync payload itself, so there is no direct reply.
 func pSyncHandler(c Command, s RequestContext) (resp.Value, error) {
-	return resp.FlatArrayValue(
-		resp.StringValue(
-			fmt.Sprintf(
-				"FULLRESYNC %s %v",
-				s.Replication.MasterReplid,
-				"0",
-			),
-		),
-		resp.BulkLikeStringValue(s.Store.Dump()),
-	), nil
+	if len(c.Args) < 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'psync' command"), nil
+	}
+
+	if s.Conn == nil {
+		return resp.ErrorValue("ERR PSYNC requires a network connection"), nil
+	}
+
+	offset, err := strconv.ParseInt(c.Args[1], 10, 64)
+	if err != nil {
+		offset = -1
+	}
+
+	// our master hands over to us at the end of a coordinated FAILOVER
+	if len(c.Args) > 2 && strings.EqualFold(c.Args[2], "FAILOVER") {
+		if !s.Replication.IsSlave() {
+			return resp.ErrorValue("ERR PSYNC FAILOVER can't be sent to a master."), nil
+		}
+
+		s.Replication.BecomeMaster()
+	}
+
+	if err := s.Replication.Sync(s.Conn, s.Clients.Output(s.Conn), c.Args[0], offset, s.Store.Dump); err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	return resp.FlatArrayValue(), nil
 }
 
 func docHandler(c Command, s RequestContext) (resp.Value, error) {
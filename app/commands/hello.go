@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"strconv"
+	"strings"
+)
+
+// serverVersion is the Redis version this server is compatible with, as
+// replied to HELLO.
+const serverVersion = "7.4.0"
+
+// helloHandler negotiates the protocol of the connection:
+// HELLO [protover [AUTH username password] [SETNAME clientname]]. Replies
+// are encoded with the negotiated protocol from this reply on. A connection
+// that did not authenticate yet may authenticate with AUTH.
+func helloHandler(c Command, s RequestContext) (resp.Value, error) {
+	if s.Conn == nil {
+		return resp.ErrorValue("ERR HELLO requires a network connection"), nil
+	}
+
+	protocol := s.protocol()
+	name, setName := "", false
+	user, password, auth := "", "", false
+
+	if len(c.Args) > 0 {
+		version, err := strconv.Atoi(c.Args[0])
+		if err != nil {
+			return resp.ErrorValue("ERR Protocol version is not an integer or out of range"), nil
+		}
+
+		for i := 1; i < len(c.Args); i++ {
+			switch {
+			case strings.EqualFold(c.Args[i], "AUTH") && i+2 < len(c.Args):
+				user, password, auth = c.Args[i+1], c.Args[i+2], true
+				i += 2
+			case strings.EqualFold(c.Args[i], "SETNAME") && i+1 < len(c.Args):
+				name, setName = c.Args[i+1], true
+				i++
+			default:
+				return resp.ErrorValue(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", c.Args[i])), nil
+			}
+		}
+
+		if version != resp.RESP2 && version != resp.RESP3 {
+			return resp.ErrorValue("NOPROTO unsupported protocol version"), nil
+		}
+
+		if setName && !validClientName(name) {
+			return resp.ErrorValue("ERR Client names cannot contain spaces, newlines or special characters."), nil
+		}
+
+		protocol = version
+	}
+
+	if auth {
+		if reply, ok := s.authenticate(user, password); !ok {
+			return reply, nil
+		}
+	} else if _, ok := s.user(); !ok {
+		return resp.ErrorValue("NOAUTH HELLO must be called with the client already authenticated, otherwise the " +
+			"HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"), nil
+	}
+
+	s.Clients.SetProtocol(s.Conn, protocol)
+	if setName {
+		s.Clients.SetName(s.Conn, name)
+	}
+
+	mode, role := "standalone", "master"
+	if s.Sentinel != nil {
+		mode = "sentinel"
+	} else if s.Replication.IsSlave() {
+		role = "replica"
+	}
+
+	return resp.MapValue(
+		resp.BulkStringValue("server"), resp.BulkStringValue("redis"),
+		resp.BulkStringValue("version"), resp.BulkStringValue(serverVersion),
+		resp.BulkStringValue("proto"), resp.IntegerValue(int64(protocol)),
+		resp.BulkStringValue("id"), resp.IntegerValue(s.Clients.Get(s.Conn).ID),
+		resp.BulkStringValue("mode"), resp.BulkStringValue(mode),
+		resp.BulkStringValue("role"), resp.BulkStringValue(role),
+		resp.BulkStringValue("modules"), resp.ArrayValue(),
+	), nil
+}
+
+// validClientName rejects the names that would break CLIENT LIST: only
+// printable characters other than the space are allowed.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"strings"
+)
+
+// subscribedCommands are the only commands a connection with subscriptions
+// may send.
+var subscribedCommands = []string{
+	"SUBSCRIBE",
+	"PSUBSCRIBE",
+	"SSUBSCRIBE",
+	"UNSUBSCRIBE",
+	"PUNSUBSCRIBE",
+	"SUNSUBSCRIBE",
+	"PING",
+	"QUIT",
+	"RESET",
+}
+
+// subscriptionRequest checks that c can change the subscriptions of the
+// connection. The hub pushes the confirmations itself, ordered with the
+// messages, so there is no direct reply.
+func subscriptionRequest(c Command, s RequestContext) (resp.Value, bool) {
+	if s.Conn == nil {
+		return resp.ErrorValue(fmt.Sprintf("ERR %s requires a network connection", strings.ToUpper(c.Type))), false
+	}
+
+	if s.denyBlocking {
+		return resp.ErrorValue(fmt.Sprintf("ERR %s isn't allowed for a DENY BLOCKING client", strings.ToUpper(c.Type))), false
+	}
+
+	return resp.Value{}, true
+}
+
+// subscribeHandler confirms every channel, messages are then pushed to the
+// connection by the hub as they are published.
+func subscribeHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'subscribe' command"), nil
+	}
+
+	if errValue, ok := subscriptionRequest(c, s); !ok {
+		return errValue, nil
+	}
+
+	s.PubSub.Subscribe(s.Conn, c.Args...)
+
+	return resp.FlatArrayValue(), nil
+}
+
+// pSubscribeHandler subscribes to glob-style patterns, messages published
+// to a matching channel are pushed as pmessage.
+func pSubscribeHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'psubscribe' command"), nil
+	}
+
+	if errValue, ok := subscriptionRequest(c, s); !ok {
+		return errValue, nil
+	}
+
+	s.PubSub.PSubscribe(s.Conn, c.Args...)
+
+	return resp.FlatArrayValue(), nil
+}
+
+// sSubscribeHandler subscribes to shard channels, published to with
+// SPUBLISH.
+func sSubscribeHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'ssubscribe' command"), nil
+	}
+
+	if errValue, ok := subscriptionRequest(c, s); !ok {
+		return errValue, nil
+	}
+
+	s.PubSub.SSubscribe(s.Conn, c.Args...)
+
+	return resp.FlatArrayValue(), nil
+}
+
+func unsubscribeHandler(c Command, s RequestContext) (resp.Value, error) {
+	if errValue, ok := subscriptionRequest(c, s); !ok {
+		return errValue, nil
+	}
+
+	s.PubSub.Unsubscribe(s.Conn, c.Args...)
+
+	return resp.FlatArrayValue(), nil
+}
+
+func pUnsubscribeHandler(c Command, s RequestContext) (resp.Value, error) {
+	if errValue, ok := subscriptionRequest(c, s); !ok {
+		return errValue, nil
+	}
+
+	s.PubSub.PUnsubscribe(s.Conn, c.Args...)
+
+	return resp.FlatArrayValue(), nil
+}
+
+func sUnsubscribeHandler(c Command, s RequestContext) (resp.Value, error) {
+	if errValue, ok := subscriptionRequest(c, s); !ok {
+		return errValue, nil
+	}
+
+	s.PubSub.SUnsubscribe(s.Conn, c.Args...)
+
+	return resp.FlatArrayValue(), nil
+}
+
+func publishHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) != 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'publish' command"), nil
+	}
+
+	n := s.PubSub.Publish(c.Args[0], c.Args[1])
+
+	return resp.IntegerValue(int64(n)), nil
+}
+
+// sPublishHandler publishes to a shard channel. It is replicated, so that
+// the subscribers connected to the replicas receive the message as well.
+func sPublishHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) != 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'spublish' command"), nil
+	}
+
+	n := s.PubSub.SPublish(c.Args[0], c.Args[1])
+
+	return resp.IntegerValue(int64(n)), nil
+}
+
+// pubSubHandler answers PUBSUB CHANNELS [pattern], NUMSUB [channel...],
+// NUMPAT, and SHARDCHANNELS [pattern] and SHARDNUMSUB [channel...] for shard
+// channels.
+func pubSubHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'pubsub' command"), nil
+	}
+
+	sub := strings.ToUpper(c.Args[0])
+	args := c.Args[1:]
+
+	switch {
+	case sub == "CHANNELS" && len(args) <= 1:
+		return channelList(s.PubSub.Channels, args), nil
+	case sub == "SHARDCHANNELS" && len(args) <= 1:
+		return channelList(s.PubSub.ShardChannels, args), nil
+	case sub == "NUMSUB":
+		return subscriberCounts(s.PubSub.NumSub, args), nil
+	case sub == "SHARDNUMSUB":
+		return subscriberCounts(s.PubSub.ShardNumSub, args), nil
+	case sub == "NUMPAT" && len(args) == 0:
+		return resp.IntegerValue(int64(s.PubSub.NumPat())), nil
+	}
+
+	return resp.ErrorValue(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try PUBSUB HELP.", c.Args[0])), nil
+}
+
+// channelList replies with the channels listed by list, filtered by the
+// optional pattern of args.
+func channelList(list func(pattern string) []string, args []string) resp.Value {
+	pattern := ""
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+
+	channels := list(pattern)
+
+	values := make([]resp.Value, 0, len(channels))
+	for _, ch := range channels {
+		values = append(values, resp.BulkStringValue(ch))
+	}
+
+	return resp.ArrayValue(values...)
+}
+
+// subscriberCounts replies with a map of every channel of channels to its
+// number of subscribers.
+func subscriberCounts(count func(channel string) int, channels []string) resp.Value {
+	values := make([]resp.Value, 0, 2*len(channels))
+	for _, ch := range channels {
+		values = append(values, resp.BulkStringValue(ch), resp.IntegerValue(int64(count(ch))))
+	}
+
+	return resp.MapValue(values...)
+}
@@ -0,0 +1,223 @@
+package resp
+
+import "fmt"
+
+// ReadRequest reads the next command sent by a client: a RESP array of bulk
+// strings, or an inline command as typed in telnet, e.g.
+// `SET key "hello world"`, read as an array of bulk strings. Empty lines and
+// empty arrays are skipped.
+func (r *Reader) ReadRequest() (value Value, n int, err error) {
+	start := r.consumed
+
+	for {
+		next, err := r.rd.Peek(1)
+
+		if err != nil {
+			return nullValue, r.consumed - start, err
+		}
+
+		if DataType(next[0]) == Array {
+			value, err = r.readRequestArray()
+
+			if err == nil && len(value.Values) == 0 {
+				continue
+			}
+
+			return value, r.consumed - start, err
+		}
+
+		line, err := r.readLine()
+
+		if err != nil {
+			return nullValue, r.consumed - start, err
+		}
+
+		args, err := splitArgs(line)
+
+		if err != nil {
+			return nullValue, r.consumed - start, err
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		values := make([]Value, 0, len(args))
+		for _, arg := range args {
+			values = append(values, BulkStringValue(arg))
+		}
+
+		return ArrayValue(values...), r.consumed - start, nil
+	}
+}
+
+// readRequestArray reads a request sent as a RESP array, whose elements may
+// only be bulk strings. A null or empty array has no values.
+func (r *Reader) readRequestArray() (Value, error) {
+	r.rd.Discard(1)
+	r.consumed++
+
+	length, err := r.readLength(r.limits.MaxMultiBulkLen, "multibulk")
+
+	if err != nil || length <= 0 {
+		return ArrayValue(), err
+	}
+
+	values := make([]Value, 0, min(length, preallocated))
+
+	for i := 0; i < length; i++ {
+		typ, err := r.rd.ReadByte()
+
+		if err != nil {
+			return nullValue, err
+		}
+
+		r.consumed++
+
+		if DataType(typ) != BulkString {
+			return nullValue, protocolError(fmt.Sprintf("expected '$', got '%c'", typ))
+		}
+
+		value, err := r.readBulkString()
+
+		if err != nil {
+			return nullValue, err
+		}
+
+		if value.Type != BulkString {
+			return nullValue, protocolError("invalid bulk length")
+		}
+
+		values = append(values, value)
+	}
+
+	return ArrayValue(values...), nil
+}
+
+// splitArgs splits an inline command into its arguments, as redis-cli
+// does: arguments are separated by spaces, and may be quoted to contain
+// spaces. Double quoted arguments understand the \n, \r, \t, \b, \a and \xHH
+// escapes, single quoted ones only \'.
+func splitArgs(line []byte) ([]string, error) {
+	var args []string
+
+	i := 0
+
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+
+		if i == len(line) {
+			return args, nil
+		}
+
+		var (
+			arg                      []byte
+			inDouble, inSingle, done bool
+		)
+
+		for !done {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, protocolError("unbalanced quotes in request")
+				}
+
+				break
+			}
+
+			c := line[i]
+
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					arg = append(arg, hexValue(line[i+2])<<4|hexValue(line[i+3]))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					arg = append(arg, unescape(line[i]))
+				case c == '"':
+					// the closing quote must end the argument
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolError("unbalanced quotes in request")
+					}
+
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			case inSingle:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolError("unbalanced quotes in request")
+					}
+
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			default:
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+
+			i++
+		}
+
+		args = append(args, string(arg))
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
@@ -0,0 +1,68 @@
+package resp
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"PING", []string{"PING"}},
+		{"  SET  key   value ", []string{"SET", "key", "value"}},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key "a\"b\n\x41\x4g"`, []string{"SET", "key", "a\"b\nAx4g"}},
+		{`SET key 'it\'s \n'`, []string{"SET", "key", `it's \n`}},
+		{`SET key ""`, []string{"SET", "key", ""}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		args, err := splitArgs([]byte(tt.line))
+		assert.NoError(t, err, tt.line)
+		assert.Equal(t, tt.expected, args, tt.line)
+	}
+
+	for _, line := range []string{`SET key "open`, `SET key 'open`, `SET key "a"b`} {
+		_, err := splitArgs([]byte(line))
+		assert.EqualError(t, err, "Protocol error: unbalanced quotes in request", line)
+	}
+}
+
+func TestReader_ReadRequest(t *testing.T) {
+	reader := NewReader(bytes.NewBufferString("PING\r\n\r\nECHO \"hi there\"\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n"))
+
+	value, n, err := reader.ReadRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, ArrayValue(BulkStringValue("PING")), value)
+	assert.Equal(t, 6, n)
+
+	value, _, err = reader.ReadRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, ArrayValue(BulkStringValue("ECHO"), BulkStringValue("hi there")), value, "empty lines are skipped")
+
+	value, _, err = reader.ReadRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, ArrayValue(BulkStringValue("ECHO"), BulkStringValue("hi")), value)
+}
+
+func TestReader_ReadRequest_BulkStringsOnly(t *testing.T) {
+	reader := NewReader(bytes.NewBufferString("*0\r\n*-1\r\n*1\r\n$4\r\nPING\r\n"))
+
+	value, _, err := reader.ReadRequest()
+	assert.NoError(t, err)
+	assert.Equal(t, ArrayValue(BulkStringValue("PING")), value, "empty arrays are skipped")
+
+	for request, msg := range map[string]string{
+		"*1\r\n*0\r\n":              "Protocol error: expected '$', got '*'",
+		"*2\r\n$3\r\nGET\r\n:5\r\n": "Protocol error: expected '$', got ':'",
+		"*1\r\n$-1\r\n":             "Protocol error: invalid bulk length",
+	} {
+		_, _, err := NewReader(bytes.NewBufferString(request)).ReadRequest()
+		assert.ErrorIs(t, err, ErrProtocol, request)
+		assert.EqualError(t, err, msg, request)
+	}
+}
@@ -5,18 +5,65 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
 )
 
+// Reader parses values as they arrive on a stream. A value split across
+// reads is completed by the following ones, and the bytes following a value
+// stay buffered for the next one.
 type Reader struct {
-	rd   *bufio.Reader
-	size *int
+	rd     *bufio.Reader
+	limits Limits
+
+	// consumed is the number of bytes parsed so far
+	consumed int
+
+	// chunk is where the short bulk payloads are carved from
+	chunk []byte
 }
 
-var InvalidDataType = errors.New("invalid Data typ")
+// Limits bound what a peer may send, so that a single request cannot make
+// the server allocate without bounds.
+type Limits struct {
+	// MaxBulkLen is the greatest length of a bulk string
+	MaxBulkLen int
+	// MaxMultiBulkLen is the greatest number of elements of an aggregate
+	MaxMultiBulkLen int
+}
+
+var DefaultLimits = Limits{
+	MaxBulkLen:      512 * 1024 * 1024,
+	MaxMultiBulkLen: 1024 * 1024,
+}
+
+// maxLineLen bounds the header lines and the simple values.
+const maxLineLen = 64 * 1024
+
+// chunkSize is the size of the chunks the payloads of at most maxCarved
+// bytes share.
+const (
+	chunkSize = 16 * 1024
+	maxCarved = 1024
+)
+
+// preallocated bounds the elements allocated ahead for an aggregate, the
+// others are only allocated as they arrive.
+const preallocated = 1024
+
+// ErrProtocol is wrapped by the errors of malformed input, after which the
+// stream cannot be parsed any further.
+var ErrProtocol = errors.New("Protocol error")
+
+var InvalidDataType = protocolError("invalid data type")
+
+func protocolError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, reason)
+}
 
 func NewReader(input io.Reader) *Reader {
-	c := ByteCounter{size: 0}
+	return NewLimitedReader(input, DefaultLimits)
+}
+
+func NewLimitedReader(input io.Reader, limits Limits) *Reader {
 	var reader *bufio.Reader
 
 	if isBufioReader, ok := input.(*bufio.Reader); ok {
@@ -26,130 +73,385 @@ func NewReader(input io.Reader) *Reader {
 	}
 
 	return &Reader{
-		rd:   reader,
-		size: &c.size,
+		rd:     reader,
+		limits: limits,
 	}
 }
 
+// Buffered returns the number of bytes received but not parsed yet.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// ReadValue reads the next value, waiting for it to be complete, and
+// returns the number of bytes it took.
 func (r *Reader) ReadValue() (value Value, n int, err error) {
+	start := r.consumed
+
+	value, err = r.readValue()
+
+	return value, r.consumed - start, err
+}
+
+func (r *Reader) readValue() (Value, error) {
 	rpType, err := r.rd.ReadByte()
 
 	if err != nil {
-		return nullValue, *r.size, err
+		return nullValue, err
 	}
 
+	r.consumed++
+
 	t := DataType(rpType)
 
 	switch t {
-	case SimpleString, SimpleError, Null, Boolean:
-		return r.ReadSimpleValue(t)
+	case SimpleString, SimpleError, Null, Boolean, Double, BigNumber:
+		return r.readSimpleValue(t)
 	case Integer:
-		return r.ReadInteger()
+		return r.readInteger()
 	case BulkString:
-		return r.ReadBulkString()
+		return r.readBulkString()
+	case VerbatimString, BlobError:
+		return r.readBlob(t)
 	case Array:
-		return r.ReadArrayValue()
+		return r.readArrayValue()
+	case Set, Push:
+		return r.readAggregate(t, 1)
+	case Map:
+		return r.readAggregate(t, 2)
+	case Attribute:
+		return r.readAttributes()
 	}
 
-	return nullValue, *r.size, InvalidDataType
+	return nullValue, InvalidDataType
 }
 
-func (r *Reader) ReadSimpleValue(typ DataType) (Value, int, error) {
-	if typ == Null {
-		return nullValue, 0, nil
+// readLine returns the next line without its line terminator. The line is
+// only valid until the next read.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.rd.ReadSlice('\n')
+
+	// longer than the buffer, only simple values may be
+	if errors.Is(err, bufio.ErrBufferFull) {
+		long := append([]byte(nil), line...)
+
+		for errors.Is(err, bufio.ErrBufferFull) && len(long) <= maxLineLen {
+			line, err = r.rd.ReadSlice('\n')
+			long = append(long, line...)
+		}
+
+		line = long
 	}
 
-	line, _, err := r.rd.ReadLine()
-	size := *r.size
+	if len(line) > maxLineLen {
+		return nil, protocolError("too big line")
+	}
 
 	if err != nil {
-		return nullValue, size, err
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	r.consumed += len(line)
+
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	return line, nil
+}
+
+// parseInt parses the integer of a header line without allocating.
+func parseInt(b []byte) (int, bool) {
+	negative := len(b) > 0 && b[0] == '-'
+	if negative {
+		b = b[1:]
+	}
+
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+
+		n = n*10 + int(c-'0')
+	}
+
+	if negative {
+		n = -n
+	}
+
+	return n, true
+}
+
+// readLength reads the length of a bulk value or of an aggregate, -1 for a
+// null one.
+func (r *Reader) readLength(limit int, kind string) (int, error) {
+	line, err := r.readLine()
+
+	if err != nil {
+		return 0, err
+	}
+
+	length, ok := parseInt(line)
+	if !ok || length < -1 || length > limit {
+		return 0, protocolError("invalid " + kind + " length")
+	}
+
+	return length, nil
+}
+
+func (r *Reader) readSimpleValue(typ DataType) (Value, error) {
+	line, err := r.readLine()
+
+	if err != nil {
+		return nullValue, err
+	}
+
+	if typ == Null {
+		return nullValue, nil
 	}
 
 	return Value{
 		Type:  typ,
-		Raw:   line,
+		Raw:   append([]byte(nil), line...),
 		IsNil: false,
-	}, size, nil
-
+	}, nil
 }
 
-func (r *Reader) ReadInteger() (Value, int, error) {
-	line, _, err := r.rd.ReadLine()
+func (r *Reader) readInteger() (Value, error) {
+	line, err := r.readLine()
 
 	if err != nil {
-		return nullValue, 0, errors.New("invalid integer")
+		return nullValue, err
+	}
+
+	if _, ok := parseInt(line); !ok {
+		return nullValue, protocolError("invalid integer")
 	}
 
 	return Value{
 		Type: Integer,
-		Raw:  line,
-	}, *r.size, nil
+		Raw:  append([]byte(nil), line...),
+	}, nil
+}
+
+// readPayload reads the length bytes of a bulk value followed by its CRLF.
+// The value outlives the read, as it is handed to the command while the
+// next one is parsed, so it is copied once out of the buffer of the reader.
+func (r *Reader) readPayload(length int) ([]byte, error) {
+	framed, err := r.rd.Peek(length + 2)
+
+	// longer than the buffer, read straight into its own slice
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return r.readLongPayload(length)
+	}
+
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if framed[length] != '\r' || framed[length+1] != '\n' {
+		return nil, protocolError("invalid bulk terminator")
+	}
+
+	payload := r.carve(length)
+	copy(payload, framed)
+
+	r.rd.Discard(length + 2)
+	r.consumed += length + 2
+
+	return payload, nil
+}
+
+func (r *Reader) readLongPayload(length int) ([]byte, error) {
+	payload := make([]byte, length)
+
+	if _, err := io.ReadFull(r.rd, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	r.consumed += length
+
+	terminator, err := r.rd.Peek(2)
+
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if terminator[0] != '\r' || terminator[1] != '\n' {
+		return nil, protocolError("invalid bulk terminator")
+	}
+
+	r.rd.Discard(2)
+	r.consumed += 2
+
+	return payload, nil
+}
+
+// carve returns length bytes for a payload. Short payloads are cut out of a
+// shared chunk, so that the arguments of a command do not each cost an
+// allocation. A chunk is never written again once handed out.
+func (r *Reader) carve(length int) []byte {
+	if length > maxCarved {
+		return make([]byte, length)
+	}
+
+	if cap(r.chunk)-len(r.chunk) < length {
+		r.chunk = make([]byte, 0, chunkSize)
+	}
+
+	start := len(r.chunk)
+	r.chunk = r.chunk[:start+length]
+
+	return r.chunk[start : start+length : start+length]
 }
 
-func (r *Reader) ReadBulkString() (Value, int, error) {
-	length, err := r.readInt()
+// unexpectedEOF reports a stream that ends inside a value as truncated.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func (r *Reader) readBulkString() (Value, error) {
+	length, err := r.readLength(r.limits.MaxBulkLen, "bulk")
 
 	if err != nil {
-		return nullValue, 0, errors.New("invalid bulk string")
+		return nullValue, err
 	}
 
-	if length <= 0 {
-		return nullValue, 0, nil
+	if length == -1 {
+		return nullValue, nil
 	}
 
-	content, _, err := r.rd.ReadLine()
+	content, err := r.readPayload(length)
 
 	if err != nil {
-		return nullValue, 0, errors.New("failed to read bulk string")
+		return nullValue, err
 	}
 
 	return Value{
-		Type:  BulkString,
-		Raw:   content,
-		IsNil: len(content) == 0,
-	}, *r.size, nil
+		Type: BulkString,
+		Raw:  content,
+	}, nil
 }
 
-func (r *Reader) readInt() (x int, err error) {
-	line, _, err := r.rd.ReadLine()
+// readBlob reads a length prefixed RESP3 string: a verbatim string or a blob
+// error, which may contain new lines.
+func (r *Reader) readBlob(typ DataType) (Value, error) {
+	length, err := r.readLength(r.limits.MaxBulkLen, "bulk")
 
 	if err != nil {
-		return 0, err
+		return nullValue, err
 	}
 
-	i, err := strconv.ParseInt(string(line), 10, 64)
+	if length == -1 {
+		return nullValue, nil
+	}
+
+	content, err := r.readPayload(length)
 
 	if err != nil {
-		return 0, err
+		return nullValue, err
 	}
 
-	return int(i), nil
+	return Value{
+		Type: typ,
+		Raw:  content,
+	}, nil
 }
 
-func (r *Reader) ReadArrayValue() (Value, int, error) {
-	length, err := r.readInt()
+func (r *Reader) readArrayValue() (Value, error) {
+	length, err := r.readLength(r.limits.MaxMultiBulkLen, "multibulk")
 
 	if err != nil {
-		fmt.Println(err)
-		return nullValue, 0, errors.New("invalid array")
+		return nullValue, err
 	}
 
-	values := make([]Value, 0, length)
+	if length == -1 {
+		return NullArrayValue(), nil
+	}
+
+	values, err := r.readValues(length)
+
+	if err != nil {
+		return nullValue, err
+	}
+
+	return Value{
+		Type:   Array,
+		Values: values,
+	}, nil
+}
+
+func (r *Reader) readValues(n int) ([]Value, error) {
+	values := make([]Value, 0, min(n, preallocated))
 
-	for i := 0; i < length; i++ {
-		value, _, err := r.ReadValue()
+	for i := 0; i < n; i++ {
+		value, err := r.readValue()
 
 		if err != nil {
-			fmt.Println(err)
-			return nullValue, 0, errors.New(err.Error())
+			return nil, err
 		}
 
 		values = append(values, value)
 	}
 
+	return values, nil
+}
+
+// readAggregate reads a set or a push of n values, or a map of n pairs when
+// width is 2.
+func (r *Reader) readAggregate(typ DataType, width int) (Value, error) {
+	length, err := r.readLength(r.limits.MaxMultiBulkLen/width, "multibulk")
+
+	if err != nil {
+		return nullValue, err
+	}
+
+	if length == -1 {
+		return nullValue, nil
+	}
+
+	values, err := r.readValues(width * length)
+
+	if err != nil {
+		return nullValue, err
+	}
+
 	return Value{
-		Type:   Array,
+		Type:   typ,
 		Values: values,
-	}, *r.size, nil
+	}, nil
+}
+
+// readAttributes reads the attributes sent ahead of a reply, and the reply
+// they are attached to.
+func (r *Reader) readAttributes() (Value, error) {
+	attributes, err := r.readAggregate(Attribute, 2)
+
+	if err != nil {
+		return nullValue, err
+	}
+
+	value, err := r.readValue()
+
+	if err != nil {
+		return nullValue, err
+	}
+
+	value.Attributes = attributes.Values
+
+	return value, nil
 }
@@ -2,10 +2,12 @@ package resp
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestRespReader_ReadSimpleValue(t *testing.T) {
@@ -44,7 +46,7 @@ func TestRespReader_ReadSimpleValue(t *testing.T) {
 				Type:  Null,
 				IsNil: true,
 			},
-			size:  0,
+			size:  3,
 			isNil: true,
 		},
 	}
@@ -143,57 +145,43 @@ func TestReader_ReadBulkStringValue(t *testing.T) {
 	}{
 		{
 			name:  "BulkString",
-			input: "$12\r\nHello World\r\n",
+			input: "$11\r\nHello World\r\n",
 			expected: Value{
 				Type:  BulkString,
 				Raw:   []byte("Hello World"),
 				IsNil: false,
 			},
-			size:  15,
+			size:  18,
 			isNil: false,
 		},
 		{
 			name:  "Empty BulkString",
 			input: "$0\r\n\r\n",
 			expected: Value{
-				Type:  Null,
-				Raw:   []byte(""),
-				IsNil: true,
-			},
-			size:  3,
-			isNil: true,
-		},
-		{
-			name:  "BulkString with Missing CRLF",
-			input: "$12\r\nHello World",
-			expected: Value{
-				Type:  BulkString,
-				Raw:   []byte("Hello World"),
-				IsNil: false,
+				Type: BulkString,
+				Raw:  []byte(""),
 			},
-			size:  12,
+			size:  6,
 			isNil: false,
 		},
 		{
-			name:  "BulkString with Invalid Byte Length",
-			input: "$12\r\nHello\r\n",
+			name:  "Null BulkString",
+			input: "$-1\r\n",
 			expected: Value{
-				Type:  BulkString,
-				Raw:   []byte("Hello"),
-				IsNil: false,
+				Type:  Null,
+				IsNil: true,
 			},
-			size:  0,
-			isNil: false,
+			size:  5,
+			isNil: true,
 		},
 		{
-			name:  "BulkString with Incorrect byte length",
-			input: "$5\r\nHelloExtraData\r\n",
+			name:  "BulkString with new lines",
+			input: "$12\r\nHello\r\nWorld\r\n",
 			expected: Value{
-				Type:  BulkString,
-				Raw:   []byte("HelloExtraData"),
-				IsNil: false,
+				Type: BulkString,
+				Raw:  []byte("Hello\r\nWorld"),
 			},
-			size:  7, // Only the valid part is considered
+			size:  19,
 			isNil: false,
 		},
 	}
@@ -201,7 +189,7 @@ func TestReader_ReadBulkStringValue(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			reader := NewReader(bytes.NewBufferString(tt.input))
-			value, _, err := reader.ReadValue()
+			value, n, err := reader.ReadValue()
 
 			if err != nil {
 				t.Fatalf("Unexpected error: %v", err)
@@ -219,41 +207,66 @@ func TestReader_ReadBulkStringValue(t *testing.T) {
 				t.Errorf("Expected IsNil = %v, got %v", tt.isNil, value.IsNil)
 			}
 
-			if tt.size != tt.size {
-				t.Errorf("Expected size = %d, got %d", tt.size, tt.size)
+			if n != tt.size {
+				t.Errorf("Expected size = %d, got %d", tt.size, n)
 			}
 		})
 	}
 }
 
 func TestReader_ReadBulkStringValueInvalid(t *testing.T) {
-	test := struct {
-		name     string
-		input    string
-		size     int
-		expected Value
-		isNil    bool
+	tests := []struct {
+		name  string
+		input string
+		err   error
+		msg   string
 	}{
-		name:     "Invalid string format",
-		input:    "$ABC\\r\\nHello\\r\\n",
-		expected: nullValue,
-		size:     0,
+		{
+			name:  "Invalid string format",
+			input: "$ABC\r\nHello\r\n",
+			err:   ErrProtocol,
+			msg:   "Protocol error: invalid bulk length",
+		},
+		{
+			name:  "BulkString with Missing CRLF",
+			input: "$11\r\nHello World",
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "BulkString with Invalid Byte Length",
+			input: "$12\r\nHello\r\n",
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "BulkString with Incorrect byte length",
+			input: "$5\r\nHelloExtraData\r\n",
+			err:   ErrProtocol,
+			msg:   "Protocol error: invalid bulk terminator",
+		},
+		{
+			name:  "BulkString over the limit",
+			input: "$536870913\r\n",
+			err:   ErrProtocol,
+			msg:   "Protocol error: invalid bulk length",
+		},
 	}
 
-	t.Run(test.name, func(t *testing.T) {
-		reader := NewReader(bytes.NewBufferString(test.input))
-		value, _, err := reader.ReadValue()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewReader(bytes.NewBufferString(tt.input))
+			value, _, err := reader.ReadValue()
 
-		if value.Type != Null {
-			t.Errorf("Expected RespDataType = %v, got %v", Null, value.Type)
-		}
+			if value.Type != Null {
+				t.Errorf("Expected RespDataType = %v, got %v", Null, value.Type)
+			}
 
-		expectedError := errors.New("invalid bulk string")
+			assert.ErrorIs(t, err, tt.err)
 
-		if err.Error() != expectedError.Error() {
-			t.Errorf("Expected error = %v, got %v", expectedError, err)
-		}
-	})
+			if tt.msg != "" {
+				assert.EqualError(t, err, tt.msg)
+			}
+		})
+	}
 }
 
 func TestReader_ReadArrayValue(t *testing.T) {
@@ -266,7 +279,7 @@ func TestReader_ReadArrayValue(t *testing.T) {
 	}{
 		{
 			name:  "Array",
-			input: "*3\r\n$12\r\nHello World\r\n:12345\r\n$-1\r\n",
+			input: "*3\r\n$11\r\nHello World\r\n:12345\r\n$-1\r\n",
 			expected: Value{
 				Type: Array,
 				Values: []Value{
@@ -448,3 +461,109 @@ func TestNestedComplexArrays(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, Array, value.Type)
 }
+
+func TestReader_ReadResp3Values(t *testing.T) {
+	input := "%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n~2\r\n,3.14\r\n(3492890328409238509324850943850943825024385\r\n" +
+		">2\r\n$7\r\nmessage\r\n_\r\n" +
+		"=16\r\ntxt:Some\r\nstring\r\n" +
+		"!11\r\nSYNTAX oops\r\n" +
+		"|1\r\n$3\r\nttl\r\n:3600\r\n#t\r\n"
+	reader := NewReader(bytes.NewBufferString(input))
+
+	value, _, err := reader.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, Map, value.Type)
+	assert.Len(t, value.Values, 4)
+	assert.Equal(t, Set, value.Values[3].Type)
+	assert.Equal(t, Double, value.Values[3].Values[0].Type)
+	assert.Equal(t, "3.14", string(value.Values[3].Values[0].Raw))
+	assert.Equal(t, BigNumber, value.Values[3].Values[1].Type)
+
+	value, _, err = reader.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, Push, value.Type)
+	assert.Equal(t, Null, value.Values[1].Type)
+
+	value, _, err = reader.ReadValue()
+	assert.NoError(t, err)
+	s, _ := value.AsString()
+	assert.Equal(t, "Some\r\nstring", s, "the format is not part of the string")
+
+	value, _, err = reader.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, BlobError, value.Type)
+	assert.Equal(t, "SYNTAX oops", string(value.Raw))
+
+	value, _, err = reader.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, Boolean, value.Type, "attributes come with the reply they precede")
+	assert.Equal(t, []Value{{Type: BulkString, Raw: []byte("ttl")}, {Type: Integer, Raw: []byte("3600")}}, value.Attributes)
+}
+
+func TestReader_PartialFrames(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n*1\r\n$4\r\nPING\r\n"
+
+	// every read returns a single byte, as when a frame spans TCP packets
+	reader := NewReader(iotest.OneByteReader(bytes.NewBufferString(input)))
+
+	value, n, err := reader.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, 33, n)
+	assert.Equal(t, []Value{BulkStringValue("SET"), BulkStringValue("key"), BulkStringValue("value")}, value.Values)
+
+	value, _, err = reader.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, []Value{BulkStringValue("PING")}, value.Values)
+
+	_, _, err = reader.ReadValue()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReader_Limits(t *testing.T) {
+	limits := Limits{MaxBulkLen: 4, MaxMultiBulkLen: 2}
+
+	_, _, err := NewLimitedReader(bytes.NewBufferString("*3\r\n"), limits).ReadValue()
+	assert.EqualError(t, err, "Protocol error: invalid multibulk length")
+
+	_, _, err = NewLimitedReader(bytes.NewBufferString("*1\r\n$5\r\nhello\r\n"), limits).ReadValue()
+	assert.EqualError(t, err, "Protocol error: invalid bulk length")
+
+	value, _, err := NewLimitedReader(bytes.NewBufferString("*2\r\n$4\r\nPING\r\n$4\r\nPONG\r\n"), limits).ReadValue()
+	assert.NoError(t, err)
+	assert.Len(t, value.Values, 2)
+}
+
+func TestReader_Payloads(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+
+	reader := NewReader(iotest.OneByteReader(bytes.NewBufferString(
+		"*2\r\n$5\r\nhello\r\n$10000\r\n" + long + "\r\n$5\r\nworld\r\n")))
+
+	first, _, err := reader.ReadValue()
+	assert.NoError(t, err)
+
+	second, _, err := reader.ReadValue()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []Value{BulkStringValue("hello"), BulkStringValue(long)}, first.Values, "values outlive the reads following them")
+	assert.Equal(t, BulkStringValue("world"), second)
+
+	_, _, err = NewReader(bytes.NewBufferString("$10000\r\n" + long + "!!")).ReadValue()
+	assert.EqualError(t, err, "Protocol error: invalid bulk terminator")
+
+	_, _, err = NewReader(bytes.NewBufferString("$10000\r\n" + long)).ReadValue()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestReader_PayloadAllocations(t *testing.T) {
+	input := strings.Repeat("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", 200)
+	reader := NewReader(strings.NewReader(input))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, _, err := reader.ReadValue(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	assert.LessOrEqual(t, allocs, 1.0, "only the elements of the array are allocated")
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -17,6 +18,9 @@ type Value struct {
 	Values   []Value  // For arrays, a slice of values (allows recursive definition)
 	Flatten  bool
 	BulkLike bool
+
+	// Attributes are the key and value pairs sent ahead of a RESP3 reply
+	Attributes []Value
 }
 
 const (
@@ -27,6 +31,22 @@ const (
 	Array        DataType = '*'
 	Null         DataType = '_'
 	Boolean      DataType = '#'
+
+	// RESP3 only
+	Map            DataType = '%'
+	Set            DataType = '~'
+	Double         DataType = ','
+	BigNumber      DataType = '('
+	VerbatimString DataType = '='
+	Attribute      DataType = '|'
+	Push           DataType = '>'
+	BlobError      DataType = '!'
+)
+
+// Protocol versions, as negotiated with HELLO.
+const (
+	RESP2 = 2
+	RESP3 = 3
 )
 
 const TrueValue = "t"
@@ -81,6 +101,14 @@ func ArrayValue(values ...Value) Value {
 	}
 }
 
+// NullArrayValue is the null reply of commands returning arrays.
+func NullArrayValue() Value {
+	return Value{
+		Type:  Array,
+		IsNil: true,
+	}
+}
+
 func FlatArrayValue(values ...Value) Value {
 	return Value{
 		Type:    Array,
@@ -93,6 +121,86 @@ func NullValue() Value {
 	return nullValue
 }
 
+// MapValue is a RESP3 map of the given key and value pairs, a flat array of
+// them in RESP2.
+func MapValue(pairs ...Value) Value {
+	return Value{
+		Type:   Map,
+		Values: pairs,
+	}
+}
+
+func SetValue(values ...Value) Value {
+	return Value{
+		Type:   Set,
+		Values: values,
+	}
+}
+
+// PushValue is an out of band RESP3 message, e.g. a pub/sub message.
+func PushValue(values ...Value) Value {
+	return Value{
+		Type:   Push,
+		Values: values,
+	}
+}
+
+func BooleanValue(b bool) Value {
+	raw := "f"
+	if b {
+		raw = TrueValue
+	}
+
+	return Value{
+		Type: Boolean,
+		Raw:  []byte(raw),
+	}
+}
+
+func DoubleValue(f float64) Value {
+	var raw string
+
+	switch {
+	case math.IsInf(f, 1):
+		raw = "inf"
+	case math.IsInf(f, -1):
+		raw = "-inf"
+	case math.IsNaN(f):
+		raw = "nan"
+	default:
+		raw = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	return Value{
+		Type: Double,
+		Raw:  []byte(raw),
+	}
+}
+
+// BigNumberValue is an integer too large for a 64 bits integer, in base 10.
+func BigNumberValue(n string) Value {
+	return Value{
+		Type: BigNumber,
+		Raw:  []byte(n),
+	}
+}
+
+// VerbatimStringValue is a string of the three letters format, e.g. "txt"
+// or "mkd", meant to be displayed as is.
+func VerbatimStringValue(format, s string) Value {
+	return Value{
+		Type: VerbatimString,
+		Raw:  []byte(format + ":" + s),
+	}
+}
+
+func BlobErrorValue(s string) Value {
+	return Value{
+		Type: BlobError,
+		Raw:  []byte(s),
+	}
+}
+
 func ErrorValue(s string) Value {
 	return Value{
 		Type: SimpleError,
@@ -116,6 +224,22 @@ func (t DataType) String() string {
 		return "Boolean"
 	case Array:
 		return "Array"
+	case Map:
+		return "Map"
+	case Set:
+		return "Set"
+	case Double:
+		return "Double"
+	case BigNumber:
+		return "BigNumber"
+	case VerbatimString:
+		return "VerbatimString"
+	case Attribute:
+		return "Attribute"
+	case Push:
+		return "Push"
+	case BlobError:
+		return "BlobError"
 	default:
 		return "Unknown"
 	}
@@ -123,7 +247,7 @@ func (t DataType) String() string {
 
 func (v *Value) String() string {
 	switch v.Type {
-	case Array:
+	case Array, Map, Set, Push:
 		return fmt.Sprintf("%v", v.Values)
 	default:
 		return string(v.Raw)
@@ -139,8 +263,10 @@ func (v *Value) AsInt() (int, error) {
 
 func (v *Value) AsString() (string, error) {
 	switch v.Type {
-	case SimpleString, SimpleError, Integer, BulkString:
+	case SimpleString, SimpleError, Integer, BulkString, Double, BigNumber, BlobError:
 		return string(v.Raw), nil
+	case VerbatimString:
+		return string(v.verbatim()), nil
 	default:
 		return "", errors.New("value not a string")
 	}
@@ -155,40 +281,140 @@ func (v *Value) AsBool() (bool, error) {
 }
 
 func (v *Value) AsArray() ([]Value, error) {
-	if v.Type != Array || v.IsNil {
+	if v.Type != Array && v.Type != Set && v.Type != Push || v.IsNil {
 		return nil, errors.New("value not an array or is nil")
 	}
 	return v.Values, nil
 }
 
+// verbatim is the text of a verbatim string, without its format.
+func (v *Value) verbatim() []byte {
+	if len(v.Raw) < 4 || v.Raw[3] != ':' {
+		return v.Raw
+	}
+
+	return v.Raw[4:]
+}
+
+// ForProtocol converts v to what is replied to a client of the given
+// protocol: RESP3 types become their RESP2 counterpart, e.g. a map becomes a
+// flat array and a double a bulk string, while RESP3 replies nulls with its
+// own null type.
+func (v Value) ForProtocol(protocol int) Value {
+	if protocol == RESP3 {
+		if v.IsNil && (v.Type == BulkString || v.Type == Array) {
+			return nullValue
+		}
+
+		v.Values = forProtocol(v.Values, protocol)
+		v.Attributes = forProtocol(v.Attributes, protocol)
+
+		return v
+	}
+
+	v.Attributes = nil
+	v.Values = forProtocol(v.Values, protocol)
+
+	switch v.Type {
+	case Map, Set, Push:
+		v.Type = Array
+	case Double, BigNumber:
+		v.Type = BulkString
+	case VerbatimString:
+		v.Type = BulkString
+		v.Raw = v.verbatim()
+	case BlobError:
+		v.Type = SimpleError
+	case Boolean:
+		b, _ := v.AsBool()
+		if b {
+			return IntegerValue(1)
+		}
+
+		return IntegerValue(0)
+	case Null:
+		return BulkNullStringValue()
+	}
+
+	return v
+}
+
+func forProtocol(values []Value, protocol int) []Value {
+	if values == nil {
+		return nil
+	}
+
+	converted := make([]Value, len(values))
+	for i, v := range values {
+		converted[i] = v.ForProtocol(protocol)
+	}
+
+	return converted
+}
+
 func (v *Value) Marshal() ([]byte, error) {
+	if len(v.Attributes) > 0 {
+		attributes, err := marshalAggregate(Attribute, v.Attributes, len(v.Attributes)/2)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := *v
+		value.Attributes = nil
+
+		reply, err := value.Marshal()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return append(attributes, reply...), nil
+	}
+
 	switch v.Type {
-	case SimpleString, SimpleError, Integer, Null:
+	case SimpleString, SimpleError, Integer, Null, Boolean, Double, BigNumber:
 		return v.format(), nil
 	case BulkString:
 		if v.IsNil {
 			return []byte("$-1\r\n"), nil
 		}
 		return v.format(), nil
+	case VerbatimString, BlobError:
+		return []byte(fmt.Sprintf("%c%d\r\n%s\r\n", v.Type, len(v.Raw), v.Raw)), nil
 	case Array:
-		var b strings.Builder
-		b.Write([]byte(fmt.Sprintf("*%d\r\n", len(v.Values))))
-
-		for _, v := range v.Values {
-			resp, err := v.Marshal()
-
-			if err != nil {
-				return nil, err
-			}
-			b.Write(resp)
+		if v.IsNil {
+			return []byte("*-1\r\n"), nil
 		}
 
-		return []byte(b.String()), nil
+		return marshalAggregate(Array, v.Values, len(v.Values))
+	case Set, Push:
+		return marshalAggregate(v.Type, v.Values, len(v.Values))
+	case Map:
+		return marshalAggregate(Map, v.Values, len(v.Values)/2)
 	}
 
 	return nil, errors.New("invalid data type")
 }
 
+// marshalAggregate writes the header of an aggregate of n elements, n pairs
+// for maps and attributes, followed by its values.
+func marshalAggregate(typ DataType, values []Value, n int) ([]byte, error) {
+	var b strings.Builder
+	b.Write([]byte(fmt.Sprintf("%c%d\r\n", typ, n)))
+
+	for _, v := range values {
+		resp, err := v.Marshal()
+
+		if err != nil {
+			return nil, err
+		}
+		b.Write(resp)
+	}
+
+	return []byte(b.String()), nil
+}
+
 func (v *Value) format() []byte {
 	var b bytes.Buffer
 	b.WriteString(string(v.Type))
@@ -3,6 +3,7 @@ package resp
 import (
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
 )
 
@@ -42,7 +43,7 @@ func TestValue_String(t *testing.T) {
 		{Value{Type: SimpleString, Raw: []byte("OK")}, "OK"},
 		{Value{Type: SimpleError, Raw: []byte("ERROR")}, "ERROR"},
 		{Value{Type: Integer, Raw: []byte("123")}, "123"},
-		{Value{Type: Array, Values: []Value{StringValue("A"), StringValue("B")}}, "[{false [65] SimpleString [] false false} {false [66] SimpleString [] false false}]"}, // fmt-style array output
+		{Value{Type: Array, Values: []Value{StringValue("A"), StringValue("B")}}, "[{false [65] SimpleString [] false false []} {false [66] SimpleString [] false false []}]"}, // fmt-style array output
 	}
 	for _, tt := range tests {
 		assert.Equal(t, tt.expected, tt.value.String(), "String() should return the expected string representation")
@@ -159,3 +160,53 @@ func TestValue_Marshal(t *testing.T) {
 		assert.Equal(t, tt.expected, string(result), "Marshal() should return the correct RESP serialization")
 	}
 }
+
+func TestValue_MarshalResp3(t *testing.T) {
+	tests := []struct {
+		value    Value
+		expected string
+	}{
+		{MapValue(BulkStringValue("proto"), IntegerValue(3)), "%1\r\n$5\r\nproto\r\n:3\r\n"},
+		{SetValue(StringValue("a")), "~1\r\n+a\r\n"},
+		{PushValue(BulkStringValue("message")), ">1\r\n$7\r\nmessage\r\n"},
+		{BooleanValue(true), "#t\r\n"},
+		{DoubleValue(1.5), ",1.5\r\n"},
+		{DoubleValue(math.Inf(-1)), ",-inf\r\n"},
+		{BigNumberValue("12345678901234567890"), "(12345678901234567890\r\n"},
+		{VerbatimStringValue("txt", "a\r\nb"), "=8\r\ntxt:a\r\nb\r\n"},
+		{BlobErrorValue("ERR x"), "!5\r\nERR x\r\n"},
+		{Value{Type: Integer, Raw: []byte("1"), Attributes: []Value{StringValue("k"), StringValue("v")}}, "|1\r\n+k\r\n+v\r\n:1\r\n"},
+	}
+	for _, tt := range tests {
+		result, err := tt.value.Marshal()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, string(result))
+	}
+}
+
+func TestValue_ForProtocol(t *testing.T) {
+	tests := []struct {
+		value Value
+		resp2 string
+		resp3 string
+		name  string
+	}{
+		{MapValue(BulkStringValue("k"), SetValue(DoubleValue(2))), "*2\r\n$1\r\nk\r\n*1\r\n$1\r\n2\r\n", "%1\r\n$1\r\nk\r\n~1\r\n,2\r\n", "nested"},
+		{BooleanValue(false), ":0\r\n", "#f\r\n", "boolean"},
+		{VerbatimStringValue("txt", "info"), "$4\r\ninfo\r\n", "=8\r\ntxt:info\r\n", "verbatim"},
+		{NullValue(), "$-1\r\n", "_\r\n", "null"},
+		{BulkNullStringValue(), "$-1\r\n", "_\r\n", "null bulk string"},
+		{NullArrayValue(), "*-1\r\n", "_\r\n", "null array"},
+		{PushValue(BulkStringValue("message")), "*1\r\n$7\r\nmessage\r\n", ">1\r\n$7\r\nmessage\r\n", "push"},
+		{BlobErrorValue("ERR x"), "-ERR x\r\n", "!5\r\nERR x\r\n", "blob error"},
+	}
+	for _, tt := range tests {
+		resp2 := tt.value.ForProtocol(RESP2)
+		b, _ := resp2.Marshal()
+		assert.Equal(t, tt.resp2, string(b), tt.name)
+
+		resp3 := tt.value.ForProtocol(RESP3)
+		b, _ = resp3.Marshal()
+		assert.Equal(t, tt.resp3, string(b), tt.name)
+	}
+}
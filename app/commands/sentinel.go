@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NewSentinelRouter serves the commands a sentinel answers to, instead of the
+// data commands of a regular server.
+func NewSentinelRouter() commandRouter {
+	return commandRouter{
+		handlers: map[string]commandHandler{
+			"PING":         pingHandler,
+			"HELLO":        helloHandler,
+			"AUTH":         authHandler,
+			"ACL":          aclHandler,
+			"INFO":         sentinelInfoHandler,
+			"SENTINEL":     sentinelHandler,
+			"SUBSCRIBE":    subscribeHandler,
+			"UNSUBSCRIBE":  unsubscribeHandler,
+			"PSUBSCRIBE":   pSubscribeHandler,
+			"PUNSUBSCRIBE": pUnsubscribeHandler,
+		},
+	}
+}
+
+var SentinelHandlers = NewSentinelRouter()
+
+func sentinelInfoHandler(_ Command, s RequestContext) (resp.Value, error) {
+	return resp.VerbatimStringValue("txt", s.Sentinel.Info()), nil
+}
+
+func sentinelHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'sentinel' command"), nil
+	}
+
+	args := c.Args[1:]
+
+	switch strings.ToUpper(c.Args[0]) {
+	case "GET-MASTER-ADDR-BY-NAME":
+		if len(args) != 1 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'sentinel get-master-addr-by-name' command"), nil
+		}
+
+		addr, ok := s.Sentinel.MasterAddr(args[0])
+		if !ok {
+			return resp.BulkNullStringValue(), nil
+		}
+
+		host, port, _ := net.SplitHostPort(addr)
+
+		return resp.ArrayValue(resp.BulkStringValue(host), resp.BulkStringValue(port)), nil
+	case "MASTERS":
+		return fieldLists(s.Sentinel.Masters()), nil
+	case "MASTER":
+		if len(args) != 1 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'sentinel master' command"), nil
+		}
+
+		fields, err := s.Sentinel.Master(args[0])
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		return fieldList(fields), nil
+	case "REPLICAS", "SLAVES":
+		if len(args) != 1 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'sentinel replicas' command"), nil
+		}
+
+		replicas, err := s.Sentinel.Replicas(args[0])
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		return fieldLists(replicas), nil
+	case "SENTINELS":
+		if len(args) != 1 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'sentinel sentinels' command"), nil
+		}
+
+		sentinels, err := s.Sentinel.Sentinels(args[0])
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		return fieldLists(sentinels), nil
+	case "IS-MASTER-DOWN-BY-ADDR":
+		if len(args) != 4 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'sentinel is-master-down-by-addr' command"), nil
+		}
+
+		epoch, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+		}
+
+		down, leader, leaderEpoch := s.Sentinel.IsMasterDownByAddr(net.JoinHostPort(args[0], args[1]), epoch, args[3])
+
+		state := int64(0)
+		if down {
+			state = 1
+		}
+
+		return resp.ArrayValue(
+			resp.IntegerValue(state),
+			resp.BulkStringValue(leader),
+			resp.IntegerValue(leaderEpoch),
+		), nil
+	case "FAILOVER":
+		if len(args) != 1 {
+			return resp.ErrorValue("ERR wrong number of arguments for 'sentinel failover' command"), nil
+		}
+
+		if err := s.Sentinel.ForceFailover(args[0]); err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		return resp.StringValue("OK"), nil
+	case "MYID":
+		return resp.BulkStringValue(s.Sentinel.ID), nil
+	default:
+		return resp.ErrorValue("ERR Unknown sentinel subcommand '" + c.Args[0] + "'"), nil
+	}
+}
+
+func fieldList(fields []string) resp.Value {
+	values := make([]resp.Value, 0, len(fields))
+	for _, f := range fields {
+		values = append(values, resp.BulkStringValue(f))
+	}
+
+	return resp.ArrayValue(values...)
+}
+
+func fieldLists(lists [][]string) resp.Value {
+	values := make([]resp.Value, 0, len(lists))
+	for _, l := range lists {
+		values = append(values, fieldList(l))
+	}
+
+	return resp.ArrayValue(values...)
+}
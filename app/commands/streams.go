@@ -0,0 +1,493 @@
+package commands
+
+import (
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
+	"strconv"
+	"strings"
+)
+
+type addOrTrimArgs struct {
+	trim       stream.TrimOptions
+	noMkStream bool
+
+	// idAt is the index of the entry ID for XADD
+	idAt int
+}
+
+// parseAddOrTrimArgs parses the options following the key of XADD and XTRIM:
+// MAXLEN|MINID [=|~] threshold [LIMIT count], and NOMKSTREAM for XADD whose
+// options end at the entry ID.
+func parseAddOrTrimArgs(args []string, xadd bool) (addOrTrimArgs, resp.Value, bool) {
+	var opts addOrTrimArgs
+
+	limitGiven := false
+
+	for i := 1; i < len(args) && opts.idAt == 0; i++ {
+		opt := strings.ToUpper(args[i])
+
+		switch {
+		case opt == "NOMKSTREAM" && xadd:
+			opts.noMkStream = true
+		case opt == "MAXLEN" || opt == "MINID":
+			strategy := stream.TrimMaxLen
+			if opt == "MINID" {
+				strategy = stream.TrimMinID
+			}
+
+			if opts.trim.Strategy != stream.TrimNone && opts.trim.Strategy != strategy {
+				return opts, resp.ErrorValue("ERR syntax error, MAXLEN and MINID options at the same time are not compatible"), false
+			}
+
+			opts.trim.Strategy = strategy
+
+			if i+1 < len(args) && (args[i+1] == "~" || args[i+1] == "=") {
+				opts.trim.Approx = args[i+1] == "~"
+				i++
+			}
+
+			if i+1 >= len(args) {
+				return opts, resp.ErrorValue("ERR syntax error"), false
+			}
+
+			i++
+
+			if strategy == stream.TrimMinID {
+				id, err := stream.ParseID(args[i])
+				if err != nil {
+					return opts, resp.ErrorValue(err.Error()), false
+				}
+
+				opts.trim.MinID = id
+
+				continue
+			}
+
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opts, resp.ErrorValue("ERR value is not an integer or out of range"), false
+			}
+
+			if n < 0 {
+				return opts, resp.ErrorValue("ERR The MAXLEN argument must be >= 0."), false
+			}
+
+			opts.trim.MaxLen = n
+		case opt == "LIMIT" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, resp.ErrorValue("ERR value is not an integer or out of range"), false
+			}
+
+			if n < 0 {
+				return opts, resp.ErrorValue("ERR The LIMIT argument must be >= 0."), false
+			}
+
+			opts.trim.Limit = n
+			limitGiven = true
+			i++
+		case xadd:
+			opts.idAt = i
+		default:
+			return opts, resp.ErrorValue("ERR syntax error"), false
+		}
+	}
+
+	// XADD needs an ID and at least one field/value pair
+	if xadd && (opts.idAt == 0 || len(args)-opts.idAt-1 == 0 || (len(args)-opts.idAt-1)%2 != 0) {
+		return opts, resp.ErrorValue("ERR wrong number of arguments for 'xadd' command"), false
+	}
+
+	if !xadd && opts.trim.Strategy == stream.TrimNone {
+		return opts, resp.ErrorValue("ERR syntax error, XTRIM must be called with a trimming strategy"), false
+	}
+
+	if limitGiven && !opts.trim.Approx {
+		return opts, resp.ErrorValue("ERR syntax error, LIMIT cannot be used without the special ~ option"), false
+	}
+
+	if opts.trim.Approx && !limitGiven {
+		opts.trim.Limit = stream.DefaultTrimLimit
+	}
+
+	return opts, resp.Value{}, true
+}
+
+// propagatedTrim returns the trimming options to replicate. Approximate
+// trimming depends on how entries are packed, replicas get the resulting
+// length instead.
+func (opts addOrTrimArgs) propagatedTrim(st *stream.Stream) []string {
+	if opts.trim.Strategy == stream.TrimNone {
+		return nil
+	}
+
+	if opts.trim.Approx {
+		return []string{"MAXLEN", "=", strconv.FormatInt(st.Len(), 10)}
+	}
+
+	if opts.trim.Strategy == stream.TrimMinID {
+		return []string{"MINID", "=", opts.trim.MinID.String()}
+	}
+
+	return []string{"MAXLEN", "=", strconv.FormatInt(opts.trim.MaxLen, 10)}
+}
+
+func xLenHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) != 1 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xlen' command"), nil
+	}
+
+	var length int64
+
+	err := s.Store.WithStream(c.Args[0], false, func(st *stream.Stream) {
+		if st != nil {
+			length = st.Len()
+		}
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	return resp.IntegerValue(length), nil
+}
+
+func xDelHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) < 2 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xdel' command"), nil
+	}
+
+	ids := make([]stream.ID, 0, len(c.Args)-1)
+
+	for _, arg := range c.Args[1:] {
+		id, err := stream.ParseID(arg)
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+
+		ids = append(ids, id)
+	}
+
+	var deleted int64
+
+	err := s.Store.WithStream(c.Args[0], false, func(st *stream.Stream) {
+		if st != nil {
+			deleted = st.Delete(ids...)
+		}
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	if deleted == 0 {
+		s.skipPropagation()
+	} else {
+		s.Store.Touch(c.Args[0], "xdel")
+	}
+
+	return resp.IntegerValue(deleted), nil
+}
+
+func xTrimHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) < 3 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xtrim' command"), nil
+	}
+
+	opts, errValue, ok := parseAddOrTrimArgs(c.Args, false)
+	if !ok {
+		return errValue, nil
+	}
+
+	var (
+		removed int64
+		trim    []string
+	)
+
+	err := s.Store.WithStream(c.Args[0], false, func(st *stream.Stream) {
+		if st != nil {
+			removed = st.Trim(opts.trim)
+			trim = opts.propagatedTrim(st)
+		}
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	if removed == 0 {
+		s.skipPropagation()
+	} else {
+		s.Store.Touch(c.Args[0], "xtrim")
+		s.propagateAs(append([]string{c.Type, c.Args[0]}, trim...)...)
+	}
+
+	return resp.IntegerValue(removed), nil
+}
+
+// parseIntervalID parses a bound of XRANGE and XREVRANGE, excluded from the
+// interval when prefixed with "(".
+func parseIntervalID(arg string, end bool) (stream.ID, resp.Value, bool) {
+	bound, exclusive := strings.CutPrefix(arg, "(")
+
+	id, err := stream.ParseRangeID(bound, end)
+	if err != nil {
+		return id, resp.ErrorValue(err.Error()), false
+	}
+
+	if !exclusive {
+		return id, resp.Value{}, true
+	}
+
+	ok := false
+	if end {
+		id, ok = id.Prev()
+	} else {
+		id, ok = id.Next()
+	}
+
+	if !ok {
+		which := "start"
+		if end {
+			which = "end"
+		}
+
+		return id, resp.ErrorValue("ERR invalid " + which + " ID for the interval"), false
+	}
+
+	return id, resp.Value{}, true
+}
+
+func xRangeHandler(c Command, s RequestContext) (resp.Value, error) {
+	return streamRange(c, s, false)
+}
+
+func xRevRangeHandler(c Command, s RequestContext) (resp.Value, error) {
+	return streamRange(c, s, true)
+}
+
+// streamRange replies to XRANGE key start end [COUNT n], and to XREVRANGE
+// whose bounds come end first.
+func streamRange(c Command, s RequestContext, reverse bool) (resp.Value, error) {
+	if len(c.Args) < 3 {
+		return resp.ErrorValue("ERR wrong number of arguments for '" + strings.ToLower(c.Type) + "' command"), nil
+	}
+
+	key, first, second := c.Args[0], c.Args[1], c.Args[2]
+	if reverse {
+		first, second = second, first
+	}
+
+	start, errValue, ok := parseIntervalID(first, false)
+	if !ok {
+		return errValue, nil
+	}
+
+	end, errValue, ok := parseIntervalID(second, true)
+	if !ok {
+		return errValue, nil
+	}
+
+	count := -1
+
+	for i := 3; i < len(c.Args); i++ {
+		if !strings.EqualFold(c.Args[i], "COUNT") || i+1 >= len(c.Args) {
+			return resp.ErrorValue("ERR syntax error"), nil
+		}
+
+		n, err := strconv.Atoi(c.Args[i+1])
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range"), nil
+		}
+
+		count = max(n, 0)
+		i++
+	}
+
+	if count == 0 {
+		return resp.ArrayValue(), nil
+	}
+
+	var entries []*stream.Entry
+
+	err := s.Store.WithStream(key, false, func(st *stream.Stream) {
+		switch {
+		case st == nil:
+		case reverse:
+			entries = st.RevRange(end, start, max(count, 0))
+		default:
+			entries = st.RangeCount(start, end, max(count, 0))
+		}
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	return resp.ArrayValue(formatStreamEntries(entries)...), nil
+}
+
+type xReadArgs struct {
+	group, consumer string
+	count           int
+	block           int64 // -1 when not blocking
+	noAck           bool
+	keys, ids       []string
+}
+
+// parseXReadArgs parses the arguments of XREAD, or of XREADGROUP when group
+// is set.
+func parseXReadArgs(args []string, group bool) (xReadArgs, resp.Value, bool) {
+	opts := xReadArgs{block: -1}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "GROUP":
+			if !group || i+2 >= len(args) {
+				return opts, resp.ErrorValue("ERR syntax error"), false
+			}
+
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			i += 2
+		case "COUNT":
+			if i+1 >= len(args) {
+				return opts, resp.ErrorValue("ERR syntax error"), false
+			}
+
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, resp.ErrorValue("ERR value is not an integer or out of range"), false
+			}
+
+			opts.count = max(n, 0)
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return opts, resp.ErrorValue("ERR syntax error"), false
+			}
+
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, resp.ErrorValue("ERR timeout is not an integer or out of range"), false
+			}
+
+			if ms < 0 {
+				return opts, resp.ErrorValue("ERR timeout is negative"), false
+			}
+
+			opts.block = ms
+			i++
+		case "NOACK":
+			if !group {
+				return opts, resp.ErrorValue("ERR syntax error"), false
+			}
+
+			opts.noAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				if group {
+					return opts, resp.ErrorValue("ERR Unbalanced 'xreadgroup' list of streams: " +
+						"for each stream key an ID or '>' must be specified."), false
+				}
+
+				return opts, resp.ErrorValue("ERR Unbalanced 'xread' list of streams: " +
+					"for each stream key an ID or '$' must be specified."), false
+			}
+
+			opts.keys, opts.ids = splitArray(rest)
+			i = len(args)
+		default:
+			return opts, resp.ErrorValue("ERR syntax error"), false
+		}
+	}
+
+	if group && opts.group == "" {
+		return opts, resp.ErrorValue("ERR Missing GROUP option for XREADGROUP"), false
+	}
+
+	if opts.keys == nil {
+		return opts, resp.ErrorValue("ERR syntax error"), false
+	}
+
+	return opts, resp.Value{}, true
+}
+
+// xReadHandler replies with the entries added after the given IDs, up to
+// COUNT per stream, only for the streams that have some. "$" stands for the
+// last ID of the stream at the time of the call, "+" for its last entry.
+// With BLOCK, the client waits for entries to be added to any of the streams.
+func xReadHandler(c Command, s RequestContext) (resp.Value, error) {
+	opts, errValue, ok := parseXReadArgs(c.Args, false)
+	if !ok {
+		return errValue, nil
+	}
+
+	after := make([]stream.ID, len(opts.keys))
+
+	for i, key := range opts.keys {
+		id := opts.ids[i]
+
+		if id != "$" && id != "+" {
+			parsed, err := stream.ParseID(id)
+			if err != nil {
+				return resp.ErrorValue(err.Error()), nil
+			}
+
+			after[i] = parsed
+
+			continue
+		}
+
+		err := s.Store.WithStream(key, false, func(st *stream.Stream) {
+			if st == nil {
+				return
+			}
+
+			after[i] = st.LastID
+
+			// right before the last entry, so that it is read
+			if id == "+" && st.Len() > 0 {
+				last := st.RevRange(stream.MaxID, stream.MinID, 1)
+				after[i], _ = last[0].ID.Prev()
+			}
+		})
+		if err != nil {
+			return resp.ErrorValue(err.Error()), nil
+		}
+	}
+
+	return s.blockOn(opts.keys, opts.block, func() (resp.Value, bool) {
+		result := make([]resp.Value, 0, len(opts.keys))
+
+		for i, key := range opts.keys {
+			start, ok := after[i].Next()
+			if !ok {
+				continue
+			}
+
+			var entries []*stream.Entry
+
+			err := s.Store.WithStream(key, false, func(st *stream.Stream) {
+				if st != nil {
+					entries = st.RangeCount(start, stream.MaxID, opts.count)
+				}
+			})
+			if err != nil {
+				return resp.ErrorValue(err.Error()), true
+			}
+
+			if len(entries) == 0 {
+				continue
+			}
+
+			result = append(result, resp.ArrayValue(
+				resp.BulkStringValue(key),
+				resp.ArrayValue(formatStreamEntries(entries)...),
+			))
+		}
+
+		if len(result) == 0 {
+			return resp.NullArrayValue(), false
+		}
+
+		return resp.ArrayValue(result...), true
+	}), nil
+}
@@ -1,20 +1,41 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/store"
 	"net"
 	"sync"
 )
 
+// ErrExecAbort discards a transaction in which a command was rejected while
+// being queued.
+var ErrExecAbort = errors.New("EXECABORT Transaction discarded because of previous errors.")
+
+// ErrWatchedKeyChanged aborts a transaction when a key it watched was
+// modified since WATCH.
+var ErrWatchedKeyChanged = errors.New("watched key changed")
+
 type transaction struct {
 	queue      []*Command
 	isExecuted bool
+
+	// failed is set when a command was rejected while being queued
+	failed bool
 }
 
 type TransactionService struct {
 	tmu          sync.Mutex
 	transactions map[net.Conn]*transaction
+
+	// exec is held exclusively while a transaction runs, and shared by the
+	// other commands, so that nothing runs in the middle of a transaction
+	exec sync.RWMutex
+
+	// watches holds the version of each key watched by a connection at the
+	// time it was watched
+	watches map[net.Conn]map[string]uint64
 }
 
 func NewTransactionService() *TransactionService {
@@ -52,35 +73,145 @@ func (t *TransactionService) IsTransaction(conn net.Conn) bool {
 	return exists
 }
 
+// Commit runs the queued commands of the connection, with no other command
+// running meanwhile, and forgets its watched keys. The writes are replicated
+// wrapped in MULTI and EXEC. The transaction is aborted with ErrExecAbort if a command was
+// rejected while queued, and with ErrWatchedKeyChanged if a watched key was
+// modified.
 func (t *TransactionService) Commit(conn net.Conn, req RequestContext) ([]resp.Value, error) {
 	t.tmu.Lock()
-	defer t.tmu.Unlock()
 
-	if transaction, exists := t.transactions[conn]; exists {
-		if transaction.isExecuted {
-			return nil, fmt.Errorf("transaction already committed for this connection")
+	transaction, exists := t.transactions[conn]
+	if !exists {
+		t.tmu.Unlock()
+		return nil, nil
+	}
+
+	if transaction.isExecuted {
+		t.tmu.Unlock()
+		return nil, fmt.Errorf("transaction already committed for this connection")
+	}
+
+	delete(t.transactions, conn)
+
+	watched := t.watches[conn]
+	delete(t.watches, conn)
+
+	// queued commands may use the service themselves, e.g. UNWATCH
+	t.tmu.Unlock()
+
+	// only the writes of a transaction that ran are replicated
+	req.skipPropagation()
+
+	if transaction.failed {
+		return nil, ErrExecAbort
+	}
+
+	t.exec.Lock()
+	defer t.exec.Unlock()
+
+	for key, version := range watched {
+		if req.Store.Version(key) != version {
+			return nil, ErrWatchedKeyChanged
 		}
+	}
 
-		response := make([]resp.Value, 0)
+	response := make([]resp.Value, 0)
+	propagated := [][]string{{"MULTI"}}
 
-		handler := NewCommandRouter()
+	handler := NewCommandRouter()
 
-		for _, cmd := range transaction.queue {
-			resp, err := handler.Handle(*cmd, req)
+	run := req
+	run.denyBlocking = true
 
-			if err != nil {
-				return nil, fmt.Errorf("error executing command %s: %w", cmd.Type, err)
-			}
-			response = append(response, resp)
+	// a failing command does not stop the others, its error is part of the
+	// reply
+	for _, cmd := range transaction.queue {
+		p := &propagation{}
+		run.propagation = p
+
+		// the user may have lost its permissions since the command was queued
+		if denied, ok := run.authorize(*cmd, "multi"); !ok {
+			response = append(response, denied)
+			continue
 		}
 
-		transaction.isExecuted = true
-		delete(t.transactions, conn)
+		value, err := handler.Handle(*cmd, run)
+
+		if err != nil {
+			value = resp.ErrorValue(err.Error())
+		}
+		response = append(response, value)
+
+		propagated = append(propagated, p.propagated(*cmd, value)...)
+	}
+
+	transaction.isExecuted = true
+
+	if len(propagated) > 1 {
+		req.propagateEach(append(propagated, []string{"EXEC"}))
+	}
+
+	return response, nil
+}
 
-		return response, nil
+// Shared holds the execution lock in shared mode until unlock is called,
+// keeping transactions from running meanwhile.
+func (t *TransactionService) Shared() (unlock func()) {
+	if t == nil {
+		return func() {}
 	}
 
-	return nil, nil
+	t.exec.RLock()
+
+	return t.exec.RUnlock
+}
+
+// Fail makes the transaction of the connection abort on EXEC.
+func (t *TransactionService) Fail(conn net.Conn) {
+	t.tmu.Lock()
+	defer t.tmu.Unlock()
+
+	if transaction, exists := t.transactions[conn]; exists {
+		transaction.failed = true
+	}
+}
+
+// Watch makes the next transaction of the connection fail if one of keys is
+// modified in the meantime. A key already watched keeps its first version.
+func (t *TransactionService) Watch(conn net.Conn, s store.DataStore, keys []string) {
+	t.tmu.Lock()
+	defer t.tmu.Unlock()
+
+	if t.watches == nil {
+		t.watches = make(map[net.Conn]map[string]uint64)
+	}
+
+	if t.watches[conn] == nil {
+		t.watches[conn] = make(map[string]uint64)
+	}
+
+	for _, key := range keys {
+		if _, ok := t.watches[conn][key]; !ok {
+			t.watches[conn][key] = s.Version(key)
+		}
+	}
+}
+
+func (t *TransactionService) Unwatch(conn net.Conn) {
+	t.tmu.Lock()
+	defer t.tmu.Unlock()
+
+	delete(t.watches, conn)
+}
+
+// Forget drops the transaction and watched keys of a closed connection.
+func (t *TransactionService) Forget(conn net.Conn) {
+	t.tmu.Lock()
+	defer t.tmu.Unlock()
+
+	delete(t.transactions, conn)
+	delete(t.watches, conn)
 }
 
 func (t *TransactionService) AddCommand(conn net.Conn, c *Command) error {
@@ -101,6 +232,7 @@ func (t *TransactionService) Discard(conn net.Conn) error {
 
 	if _, exists := t.transactions[conn]; exists {
 		delete(t.transactions, conn)
+		delete(t.watches, conn)
 		return nil
 	}
 
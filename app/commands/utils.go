@@ -2,9 +2,7 @@ package commands
 
 import (
 	"errors"
-	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
-	"github.com/codecrafters-io/redis-starter-go/app/store"
 	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
 	"slices"
 	"strconv"
@@ -108,125 +106,34 @@ func splitArray(arr []string) (firstHalf []string, secondHalf []string) {
 	return
 }
 
-func parseXReadArgs(args []string) (keys, ids []string, block int64) {
-	block = -1
-	index := 0
-
-	if len(args) >= 3 && strings.ToLower(args[0]) == "block" {
-		blockVal, err := strconv.ParseInt(args[1], 10, 64)
-		if err == nil {
-			block = blockVal
-		}
-
-		for i, arg := range args {
-			if strings.ToLower(arg) == "streams" {
-				index = i + 1
-				break
-			}
-		}
-	} else {
-		for i, arg := range args {
-			if strings.ToLower(arg) == "streams" {
-				index = i + 1
-				break
-			}
-		}
-	}
-
-	if index > 0 && index < len(args) {
-		keys, ids = splitArray(args[index:])
-	}
-
-	return keys, ids, block
-}
-
-func handleBlockingRead(keys, ids []string, store store.DataStore) (resp.Value, error) {
-	notifyCh := make(chan stream.Notification, len(keys))
-
-	subscriptions := subscribeToStreams(keys, store, notifyCh)
-	defer func() {
-		for _, stream := range subscriptions {
-			stream.Unsubscribe(notifyCh)
-		}
-	}()
-
-	<-notifyCh
-
-	return readStreams(keys, ids, store)
-}
+func formatStreamEntries(entries []*stream.Entry) []resp.Value {
+	entriesValues := make([]resp.Value, 0, len(entries))
 
-func subscribeToStreams(keys []string, store store.DataStore, notifyCh chan stream.Notification) []*stream.Stream {
-	subscriptions := make([]*stream.Stream, 0, len(keys))
+	for _, entry := range entries {
+		// pending entries deleted from the stream have no fields left
+		if entry.Elements == nil {
+			entriesValues = append(
+				entriesValues,
+				resp.ArrayValue(resp.BulkStringValue(entry.ID.String()), resp.NullArrayValue()),
+			)
 
-	for _, streamKey := range keys {
-		streamObj := store.Read(streamKey)
-		if streamObj == nil {
 			continue
 		}
 
-		stream, ok := streamObj.(*stream.Stream)
-		if ok {
-			stream.Subscribe(notifyCh)
-			subscriptions = append(subscriptions, stream)
-		}
-	}
-
-	return subscriptions
-}
-
-func readStreams(keys, ids []string, store store.DataStore) (resp.Value, error) {
-	result := make([]resp.Value, 0, len(keys))
-
-	for i, streamKey := range keys {
-		streamObj := store.Read(streamKey)
-		entryKey := ids[i]
-
-		if streamObj == nil {
-			return resp.BulkNullStringValue(), nil
-		}
-
-		stream, ok := streamObj.(*stream.Stream)
-		if !ok {
-			return resp.BulkNullStringValue(), nil
-		}
-
-		entries := stream.XRead(entryKey)
-		if len(entries) == 0 {
-			return resp.BulkNullStringValue(), nil
-		}
-
-		entriesValues := formatStreamEntries(entries)
-
-		result = append(
-			result,
-			resp.ArrayValue(
-				resp.BulkStringValue(streamKey),
-				resp.ArrayValue(entriesValues...),
-			),
-		)
-	}
-
-	return resp.ArrayValue(result...), nil
-}
-
-func formatStreamEntries(entries []*stream.Entry) []resp.Value {
-	entriesValues := make([]resp.Value, 0, len(entries))
-
-	for _, entry := range entries {
 		entryElements := make([]resp.Value, 0, 2*len(entry.Elements))
 
-		for k, v := range entry.Elements {
+		for _, f := range entry.Elements {
 			entryElements = append(
 				entryElements,
-				resp.BulkStringValue(k),
-				resp.BulkStringValue(fmt.Sprintf("%v", v)),
+				resp.BulkStringValue(f.Name),
+				resp.BulkStringValue(f.Value),
 			)
 		}
 
 		entriesValues = append(
 			entriesValues,
 			resp.ArrayValue(
-				resp.BulkStringValue(entry.Id),
+				resp.BulkStringValue(entry.ID.String()),
 				resp.ArrayValue(entryElements...),
 			),
 		)
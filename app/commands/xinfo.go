@@ -0,0 +1,262 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultInfoCount bounds the entries and pending entries of XINFO STREAM
+// FULL when no COUNT is given.
+const defaultInfoCount = 10
+
+func xInfoHandler(c Command, s RequestContext) (resp.Value, error) {
+	if len(c.Args) == 0 {
+		return resp.ErrorValue("ERR wrong number of arguments for 'xinfo' command"), nil
+	}
+
+	sub := strings.ToUpper(c.Args[0])
+	args := c.Args[1:]
+
+	arity := map[string][2]int{
+		"STREAM":    {1, 4},
+		"GROUPS":    {1, 1},
+		"CONSUMERS": {2, 2},
+	}
+
+	bounds, ok := arity[sub]
+	if !ok {
+		return resp.ErrorValue(fmt.Sprintf("ERR unknown subcommand '%s'. Try XINFO HELP.", c.Args[0])), nil
+	}
+
+	if len(args) < bounds[0] || len(args) > bounds[1] {
+		return resp.ErrorValue(fmt.Sprintf("ERR wrong number of arguments for 'xinfo|%s' command", strings.ToLower(sub))), nil
+	}
+
+	var reply resp.Value
+
+	err := s.Store.WithStream(args[0], false, func(st *stream.Stream) {
+		if st == nil {
+			reply = resp.ErrorValue("ERR no such key")
+			return
+		}
+
+		reply = streamInfoReply(st, sub, args)
+	})
+	if err != nil {
+		return resp.ErrorValue(err.Error()), nil
+	}
+
+	return reply, nil
+}
+
+// streamInfoReply replies to the XINFO subcommand sub about st.
+func streamInfoReply(st *stream.Stream, sub string, args []string) resp.Value {
+	now := time.Now().UnixMilli()
+
+	switch sub {
+	case "GROUPS":
+		groups := make([]resp.Value, 0, len(st.Groups))
+		for _, name := range st.GroupNames() {
+			groups = append(groups, groupInfo(st, st.Group(name)))
+		}
+
+		return resp.ArrayValue(groups...)
+	case "CONSUMERS":
+		g := st.Group(args[1])
+		if g == nil {
+			return noGroupError(args[0], args[1])
+		}
+
+		consumers := make([]resp.Value, 0, len(g.Consumers))
+		for _, name := range g.ConsumerNames() {
+			consumers = append(consumers, consumerInfo(g.Consumers[name], now))
+		}
+
+		return resp.ArrayValue(consumers...)
+	}
+
+	if len(args) == 1 {
+		return streamInfo(st)
+	}
+
+	count := defaultInfoCount
+
+	switch {
+	case !strings.EqualFold(args[1], "FULL"):
+		return resp.ErrorValue("ERR syntax error")
+	case len(args) == 3:
+		return resp.ErrorValue("ERR syntax error")
+	case len(args) == 4:
+		if !strings.EqualFold(args[2], "COUNT") {
+			return resp.ErrorValue("ERR syntax error")
+		}
+
+		n, err := strconv.Atoi(args[3])
+		if err != nil {
+			return resp.ErrorValue("ERR value is not an integer or out of range")
+		}
+
+		count = max(n, 0)
+	}
+
+	return streamInfoFull(st, count)
+}
+
+// streamHeader is the part of XINFO STREAM shared with its FULL form, as
+// the key and value pairs of the reply map.
+func streamHeader(st *stream.Stream) []resp.Value {
+	return []resp.Value{
+		resp.BulkStringValue("length"), resp.IntegerValue(st.Len()),
+		resp.BulkStringValue("radix-tree-keys"), resp.IntegerValue(int64(st.RadixTreeKeys())),
+		resp.BulkStringValue("radix-tree-nodes"), resp.IntegerValue(int64(st.RadixTreeNodes())),
+		resp.BulkStringValue("last-generated-id"), resp.BulkStringValue(st.LastID.String()),
+		resp.BulkStringValue("max-deleted-entry-id"), resp.BulkStringValue(st.MaxDeletedID.String()),
+		resp.BulkStringValue("entries-added"), resp.IntegerValue(st.EntriesAdded),
+		resp.BulkStringValue("recorded-first-entry-id"), resp.BulkStringValue(st.FirstID().String()),
+	}
+}
+
+// entryOrNull replies with the only entry of entries, or a null reply.
+func entryOrNull(entries []*stream.Entry) resp.Value {
+	if len(entries) == 0 {
+		return resp.BulkNullStringValue()
+	}
+
+	return formatStreamEntries(entries)[0]
+}
+
+func streamInfo(st *stream.Stream) resp.Value {
+	values := streamHeader(st)
+
+	values = append(values,
+		resp.BulkStringValue("groups"), resp.IntegerValue(int64(len(st.Groups))),
+		resp.BulkStringValue("first-entry"), entryOrNull(st.RangeCount(stream.MinID, stream.MaxID, 1)),
+		resp.BulkStringValue("last-entry"), entryOrNull(st.RevRange(stream.MaxID, stream.MinID, 1)),
+	)
+
+	return resp.MapValue(values...)
+}
+
+// streamInfoFull replies to XINFO STREAM FULL, with up to count entries and
+// pending entries per group and consumer, all of them when count is 0.
+func streamInfoFull(st *stream.Stream, count int) resp.Value {
+	values := streamHeader(st)
+
+	groups := make([]resp.Value, 0, len(st.Groups))
+
+	for _, name := range st.GroupNames() {
+		g := st.Group(name)
+
+		limit := count
+		if limit == 0 {
+			limit = g.Pending.Len()
+		}
+
+		pending := make([]resp.Value, 0, limit)
+		for _, p := range g.PendingRange(stream.MinID, stream.MaxID, limit, nil, 0, 0) {
+			pending = append(pending, resp.ArrayValue(
+				resp.BulkStringValue(p.ID.String()),
+				resp.BulkStringValue(p.Consumer.Name),
+				resp.IntegerValue(p.DeliveryTime),
+				resp.IntegerValue(p.DeliveryCount),
+			))
+		}
+
+		consumers := make([]resp.Value, 0, len(g.Consumers))
+
+		for _, consumerName := range g.ConsumerNames() {
+			consumer := g.Consumers[consumerName]
+
+			limit := count
+			if limit == 0 {
+				limit = consumer.Pending.Len()
+			}
+
+			consumerPending := make([]resp.Value, 0, limit)
+			for _, p := range g.PendingRange(stream.MinID, stream.MaxID, limit, consumer, 0, 0) {
+				consumerPending = append(consumerPending, resp.ArrayValue(
+					resp.BulkStringValue(p.ID.String()),
+					resp.IntegerValue(p.DeliveryTime),
+					resp.IntegerValue(p.DeliveryCount),
+				))
+			}
+
+			consumers = append(consumers, resp.MapValue(
+				resp.BulkStringValue("name"), resp.BulkStringValue(consumer.Name),
+				resp.BulkStringValue("seen-time"), resp.IntegerValue(consumer.SeenTime),
+				resp.BulkStringValue("active-time"), resp.IntegerValue(consumer.ActiveTime),
+				resp.BulkStringValue("pel-count"), resp.IntegerValue(int64(consumer.Pending.Len())),
+				resp.BulkStringValue("pending"), resp.ArrayValue(consumerPending...),
+			))
+		}
+
+		entriesRead, lag := groupProgress(st, g)
+
+		groups = append(groups, resp.MapValue(
+			resp.BulkStringValue("name"), resp.BulkStringValue(g.Name),
+			resp.BulkStringValue("last-delivered-id"), resp.BulkStringValue(g.LastID.String()),
+			resp.BulkStringValue("entries-read"), entriesRead,
+			resp.BulkStringValue("lag"), lag,
+			resp.BulkStringValue("pel-count"), resp.IntegerValue(int64(g.Pending.Len())),
+			resp.BulkStringValue("pending"), resp.ArrayValue(pending...),
+			resp.BulkStringValue("consumers"), resp.ArrayValue(consumers...),
+		))
+	}
+
+	values = append(values,
+		resp.BulkStringValue("entries"), resp.ArrayValue(formatStreamEntries(st.RangeCount(stream.MinID, stream.MaxID, count))...),
+		resp.BulkStringValue("groups"), resp.ArrayValue(groups...),
+	)
+
+	return resp.MapValue(values...)
+}
+
+// groupProgress replies with the entries read by the group and its lag, null
+// when unknown.
+func groupProgress(st *stream.Stream, g *stream.Group) (entriesRead, lag resp.Value) {
+	entriesRead = resp.BulkNullStringValue()
+	if g.EntriesRead != stream.InvalidEntriesRead {
+		entriesRead = resp.IntegerValue(g.EntriesRead)
+	}
+
+	lag = resp.BulkNullStringValue()
+	if n, ok := st.Lag(g); ok {
+		lag = resp.IntegerValue(n)
+	}
+
+	return entriesRead, lag
+}
+
+func groupInfo(st *stream.Stream, g *stream.Group) resp.Value {
+	entriesRead, lag := groupProgress(st, g)
+
+	return resp.MapValue(
+		resp.BulkStringValue("name"), resp.BulkStringValue(g.Name),
+		resp.BulkStringValue("consumers"), resp.IntegerValue(int64(len(g.Consumers))),
+		resp.BulkStringValue("pending"), resp.IntegerValue(int64(g.Pending.Len())),
+		resp.BulkStringValue("last-delivered-id"), resp.BulkStringValue(g.LastID.String()),
+		resp.BulkStringValue("entries-read"), entriesRead,
+		resp.BulkStringValue("lag"), lag,
+	)
+}
+
+// consumerInfo replies with the pending entries of the consumer, the time
+// since it was last seen and since it was last delivered entries (-1 when
+// never).
+func consumerInfo(c *stream.Consumer, now int64) resp.Value {
+	inactive := int64(-1)
+	if c.ActiveTime != -1 {
+		inactive = now - c.ActiveTime
+	}
+
+	return resp.MapValue(
+		resp.BulkStringValue("name"), resp.BulkStringValue(c.Name),
+		resp.BulkStringValue("pending"), resp.IntegerValue(int64(c.Pending.Len())),
+		resp.BulkStringValue("idle"), resp.IntegerValue(now-c.SeenTime),
+		resp.BulkStringValue("inactive"), resp.IntegerValue(inactive),
+	)
+}
@@ -0,0 +1,258 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Listpacks as serialized by Redis: a 6 bytes header (total bytes, number of
+// elements), the elements and an end byte. Each element is its encoding, its
+// data and its length encoded backwards, so that the list can also be
+// walked from the end.
+const (
+	listpackHeaderSize = 6
+	listpackEnd        = 0xFF
+
+	listpack7BitUint  = 0x00
+	listpack6BitStr   = 0x80
+	listpack13BitInt  = 0xC0
+	listpack12BitStr  = 0xE0
+	listpack16BitInt  = 0xF1
+	listpack24BitInt  = 0xF2
+	listpack32BitInt  = 0xF3
+	listpack64BitInt  = 0xF4
+	listpack32BitStr  = 0xF0
+	listpackMaxIntStr = 20
+)
+
+var InvalidListpack = errors.New("invalid listpack")
+
+type listpackWriter struct {
+	buf   []byte
+	count int
+}
+
+func newListpackWriter() *listpackWriter {
+	return &listpackWriter{buf: make([]byte, listpackHeaderSize)}
+}
+
+// AppendString stores s as an integer when it is the canonical form of one,
+// as Redis does.
+func (lp *listpackWriter) AppendString(s string) {
+	if len(s) <= listpackMaxIntStr {
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(v, 10) == s {
+			lp.AppendInt(v)
+			return
+		}
+	}
+
+	start := len(lp.buf)
+
+	switch l := len(s); {
+	case l < 64:
+		lp.buf = append(lp.buf, listpack6BitStr|byte(l))
+	case l < 4096:
+		lp.buf = append(lp.buf, listpack12BitStr|byte(l>>8), byte(l))
+	default:
+		lp.buf = append(lp.buf, listpack32BitStr)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(l))
+	}
+
+	lp.buf = append(lp.buf, s...)
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+func (lp *listpackWriter) AppendInt(v int64) {
+	start := len(lp.buf)
+
+	switch {
+	case v >= 0 && v <= 127:
+		lp.buf = append(lp.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1FFF
+		lp.buf = append(lp.buf, listpack13BitInt|byte(u>>8), byte(u))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		lp.buf = append(lp.buf, listpack16BitInt)
+		lp.buf = binary.LittleEndian.AppendUint16(lp.buf, uint16(v))
+	case v >= -(1<<23) && v < 1<<23:
+		u := uint32(v)
+		lp.buf = append(lp.buf, listpack24BitInt, byte(u), byte(u>>8), byte(u>>16))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		lp.buf = append(lp.buf, listpack32BitInt)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(v))
+	default:
+		lp.buf = append(lp.buf, listpack64BitInt)
+		lp.buf = binary.LittleEndian.AppendUint64(lp.buf, uint64(v))
+	}
+
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+// appendBacklen stores the length of the element, 7 bits per byte starting
+// with the most significant ones. Every byte but the first has its high bit
+// set, so the length can be read from its last byte backwards.
+func (lp *listpackWriter) appendBacklen(l int) {
+	n := backlenSize(l)
+
+	for i := n - 1; i >= 0; i-- {
+		b := byte(l >> (7 * i) & 127)
+		if i != n-1 {
+			b |= 128
+		}
+
+		lp.buf = append(lp.buf, b)
+	}
+
+	lp.count++
+}
+
+// Bytes terminates the listpack and fills in its header.
+func (lp *listpackWriter) Bytes() []byte {
+	buf := append(lp.buf, listpackEnd)
+
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+
+	count := lp.count
+	if count > math.MaxUint16 {
+		// the number of elements is unknown, it has to be counted
+		count = math.MaxUint16
+	}
+
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(count))
+
+	return buf
+}
+
+// listpackElement is either an integer or a string.
+type listpackElement struct {
+	Int   int64
+	Str   string
+	IsInt bool
+}
+
+func (e listpackElement) String() string {
+	if e.IsInt {
+		return strconv.FormatInt(e.Int, 10)
+	}
+
+	return e.Str
+}
+
+// decodeListpack returns the elements of a serialized listpack.
+func decodeListpack(buf []byte) ([]listpackElement, error) {
+	if len(buf) < listpackHeaderSize+1 || int(binary.LittleEndian.Uint32(buf)) != len(buf) {
+		return nil, InvalidListpack
+	}
+
+	var elements []listpackElement
+
+	p := buf[listpackHeaderSize:]
+
+	for len(p) > 0 && p[0] != listpackEnd {
+		e, size, err := decodeListpackElement(p)
+		if err != nil {
+			return nil, err
+		}
+
+		size += backlenSize(size)
+		if size > len(p) {
+			return nil, InvalidListpack
+		}
+
+		elements = append(elements, e)
+		p = p[size:]
+	}
+
+	if len(p) != 1 {
+		return nil, InvalidListpack
+	}
+
+	return elements, nil
+}
+
+// decodeListpackElement decodes the element at the start of p, returning the
+// size of its encoding and data.
+func decodeListpackElement(p []byte) (listpackElement, int, error) {
+	b := p[0]
+
+	str := func(offset, l int) (listpackElement, int, error) {
+		if offset+l > len(p) {
+			return listpackElement{}, 0, InvalidListpack
+		}
+
+		return listpackElement{Str: string(p[offset : offset+l])}, offset + l, nil
+	}
+
+	integer := func(size int, decode func([]byte) int64) (listpackElement, int, error) {
+		if 1+size > len(p) {
+			return listpackElement{}, 0, InvalidListpack
+		}
+
+		return listpackElement{Int: decode(p[1 : 1+size]), IsInt: true}, 1 + size, nil
+	}
+
+	switch {
+	case b&0x80 == listpack7BitUint:
+		return listpackElement{Int: int64(b & 0x7F), IsInt: true}, 1, nil
+	case b&0xC0 == listpack6BitStr:
+		return str(1, int(b&0x3F))
+	case b&0xE0 == listpack13BitInt:
+		if len(p) < 2 {
+			return listpackElement{}, 0, InvalidListpack
+		}
+
+		u := uint64(b&0x1F)<<8 | uint64(p[1])
+		v := int64(u)
+		if u >= 1<<12 {
+			v -= 1 << 13
+		}
+
+		return listpackElement{Int: v, IsInt: true}, 2, nil
+	case b&0xF0 == listpack12BitStr:
+		if len(p) < 2 {
+			return listpackElement{}, 0, InvalidListpack
+		}
+
+		return str(2, int(b&0x0F)<<8|int(p[1]))
+	}
+
+	switch b {
+	case listpack16BitInt:
+		return integer(2, func(d []byte) int64 { return int64(int16(binary.LittleEndian.Uint16(d))) })
+	case listpack24BitInt:
+		return integer(3, func(d []byte) int64 {
+			u := int32(uint32(d[0])<<8|uint32(d[1])<<16|uint32(d[2])<<24) >> 8
+			return int64(u)
+		})
+	case listpack32BitInt:
+		return integer(4, func(d []byte) int64 { return int64(int32(binary.LittleEndian.Uint32(d))) })
+	case listpack64BitInt:
+		return integer(8, func(d []byte) int64 { return int64(binary.LittleEndian.Uint64(d)) })
+	case listpack32BitStr:
+		if len(p) < 5 {
+			return listpackElement{}, 0, InvalidListpack
+		}
+
+		return str(5, int(binary.LittleEndian.Uint32(p[1:5])))
+	}
+
+	return listpackElement{}, 0, InvalidListpack
+}
+
+// backlenSize returns how many bytes Redis uses for the backwards length.
+func backlenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
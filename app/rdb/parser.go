@@ -76,25 +76,42 @@ func (p *Parser) Parse() (err error) {
 }
 
 func (p *Parser) readLengthWithEncoding(reader *bufio.Reader) (length int, isEncoding bool, err error) {
+	l, isEncoding, err := p.readLength64(reader)
+
+	return int(l), isEncoding, err
+}
+
+// readLength64 reads a length, or the type of a special encoding when
+// isEncoding is set. Lengths above 2^32 only appear in streams metadata.
+func (p *Parser) readLength64(reader *bufio.Reader) (length uint64, isEncoding bool, err error) {
 	l, err := reader.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
 	encodeType := (l & 0xC0) >> 6
 
 	switch encodeType {
 	case REDIS_RDB_ENCVAL:
-		isEncoding = true
-		length = int(l & 0x3F)
+		return uint64(l & 0x3F), true, nil
 	case REDIS_RDB_6BITLEN:
-		length = int(l & 0x3F) // mask - 0b00111111
+		return uint64(l & 0x3F), false, nil // mask - 0b00111111
 	case REDIS_RDB_14BITLEN:
-		additional, _ := reader.ReadByte()
-		length = int(uint16(l&0x3F)<<8 | uint16(additional))
+		additional, err := reader.ReadByte()
+		return uint64(l&0x3F)<<8 | uint64(additional), false, err
+	}
+
+	switch l {
 	case REDIS_RDB_32BITLEN:
 		var d uint32
-		_ = binary.Read(reader, binary.BigEndian, &d)
-		length = int(d)
+		err = binary.Read(reader, binary.BigEndian, &d)
+		return uint64(d), false, err
+	case REDIS_RDB_64BITLEN:
+		err = binary.Read(reader, binary.BigEndian, &length)
+		return length, false, err
 	}
 
-	return
+	return 0, false, InvalidFile
 }
 
 func (p *Parser) readString(reader *bufio.Reader) (string, error) {
@@ -104,6 +121,10 @@ func (p *Parser) readString(reader *bufio.Reader) (string, error) {
 		return "", err
 	}
 
+	if isEncoding && length == REDIS_RDB_ENC_LZF {
+		return p.readCompressedString(reader)
+	}
+
 	if isEncoding {
 		return decodeInteger(reader, length)
 	}
@@ -114,6 +135,27 @@ func (p *Parser) readString(reader *bufio.Reader) (string, error) {
 	return string(buf), err
 }
 
+func (p *Parser) readCompressedString(reader *bufio.Reader) (string, error) {
+	compressedLength, err := p.readLength(reader)
+	if err != nil {
+		return "", err
+	}
+
+	length, err := p.readLength(reader)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, compressedLength)
+	if _, err = io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+
+	out, err := lzfDecompress(buf, length)
+
+	return string(out), err
+}
+
 func (p *Parser) readInt(reader *bufio.Reader) (int, error) {
 	v, err := p.readString(reader)
 
@@ -166,8 +208,6 @@ func decodeInteger(reader *bufio.Reader, length int) (string, error) {
 		var i uint32
 		_ = binary.Read(reader, binary.LittleEndian, &i)
 		return strconv.Itoa(int(i)), nil
-	case REDIS_RDB_ENC_LZF:
-		return "", fmt.Errorf("LZF encoding not implemented")
 	}
 
 	return "", fmt.Errorf("unknown encoding")
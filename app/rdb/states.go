@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"strconv"
 )
 
@@ -52,6 +53,9 @@ type databaseEntry struct {
 	Value  string
 	Type   string
 	Expiry expiry
+
+	// Stream is only set for stream values
+	Stream *Stream
 }
 
 type Database struct {
@@ -160,54 +164,66 @@ func (db *Database) parse(reader *bufio.Reader, parser *Parser) (*ParserState, e
 	}
 
 	for {
-		var expiryType byte
-		var expiryValue int64
-
-		if hasExpiry(reader) {
-			expiryType, expiryValue, err = db.readExpiry(reader)
-
-			if err != nil {
-				return nil, err
-			}
+		next, err := reader.Peek(1)
+		if err != nil {
+			return nil, err
 		}
 
-		valueType, err := reader.ReadByte()
+		switch next[0] {
+		case EOF:
+			parser.Context.Databases[database.ID] = database
+			return nil, nil
+		case SELECTDB:
+			parser.Context.Databases[database.ID] = database
+			var nextState ParserState = &Database{}
+			return &nextState, nil
+		}
 
+		entry, err := db.readEntry(reader, parser)
 		if err != nil {
 			return nil, err
 		}
 
-		kv, err := parser.readKeyValuePair(reader)
+		database.Entries = append(database.Entries, entry)
+	}
+}
+
+func (db *Database) readEntry(reader *bufio.Reader, parser *Parser) (databaseEntry, error) {
+	var (
+		entry databaseEntry
+		err   error
+	)
+
+	if hasExpiry(reader) {
+		entry.Expiry.Type, entry.Expiry.Value, err = db.readExpiry(reader)
 
 		if err != nil {
-			return nil, err
+			return entry, err
 		}
+	}
 
-		database.Entries = append(database.Entries, databaseEntry{
-			Key:   kv.Key,
-			Value: kv.Value,
-			Type:  string(valueType),
-			Expiry: expiry{
-				Type:  expiryType,
-				Value: expiryValue,
-			},
-		})
+	valueType, err := reader.ReadByte()
 
-		eod, _ := reader.Peek(1)
+	if err != nil {
+		return entry, err
+	}
 
-		if eod[0] == EOF {
-			parser.Context.Databases[database.ID] = database
-			break
-		}
+	entry.Type = string(valueType)
 
-		if eod[0] == SELECTDB {
-			parser.Context.Databases[database.ID] = database
-			var nextState ParserState = &database
-			return &nextState, nil
-		}
+	if entry.Key, err = parser.readString(reader); err != nil {
+		return entry, err
+	}
+
+	switch valueType {
+	case TypeString:
+		entry.Value, err = parser.readString(reader)
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		entry.Stream, err = parser.readStream(reader, valueType)
+	default:
+		err = fmt.Errorf("%w: unsupported value type %d", InvalidFile, valueType)
 	}
 
-	return nil, nil
+	return entry, err
 }
 
 func (db *Database) readExpiry(reader *bufio.Reader) (byte, int64, error) {
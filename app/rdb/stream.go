@@ -0,0 +1,464 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Flags of the entries of a stream listpack.
+const (
+	streamItemDeleted    = 1 << 0
+	streamItemSameFields = 1 << 1
+)
+
+// streamNodeMaxEntries caps the entries of a listpack, as the default
+// stream-node-max-entries does.
+const streamNodeMaxEntries = 100
+
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+func (id StreamID) bytes() []byte {
+	b := make([]byte, 16)
+
+	binary.BigEndian.PutUint64(b[:8], id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Seq)
+
+	return b
+}
+
+func streamIDFromBytes(b []byte) StreamID {
+	return StreamID{
+		Ms:  binary.BigEndian.Uint64(b[:8]),
+		Seq: binary.BigEndian.Uint64(b[8:]),
+	}
+}
+
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string // field/value pairs
+}
+
+// StreamNack is a pending entry of a consumer group.
+type StreamNack struct {
+	ID            StreamID
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64
+	ActiveTime int64
+	Pending    []StreamID
+}
+
+type StreamGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	Pending     []StreamNack
+	Consumers   []StreamConsumer
+}
+
+type Stream struct {
+	Entries      []StreamEntry
+	LastID       StreamID
+	FirstID      StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	Groups       []StreamGroup
+}
+
+// streamNodes packs the entries into listpacks keyed by the ID of their first
+// entry, the master entry, laid out as Redis does:
+//
+//	master: count, deleted, number of fields, fields..., 0
+//	entry:  flags, ms delta, seq delta, [number of fields], [fields]..., values..., lp-count
+func streamNodes(entries []StreamEntry) (keys [][]byte, nodes [][]byte) {
+	for len(entries) > 0 {
+		n := min(len(entries), streamNodeMaxEntries)
+		batch := entries[:n]
+		entries = entries[n:]
+
+		master := batch[0]
+		masterFields := fieldNames(master.Fields)
+
+		lp := newListpackWriter()
+		lp.AppendInt(int64(len(batch)))
+		lp.AppendInt(0)
+		lp.AppendInt(int64(len(masterFields)))
+
+		for _, f := range masterFields {
+			lp.AppendString(f)
+		}
+
+		lp.AppendInt(0)
+
+		for _, e := range batch {
+			fields := fieldNames(e.Fields)
+			sameFields := slices.Equal(fields, masterFields)
+
+			flags := int64(0)
+			if sameFields {
+				flags |= streamItemSameFields
+			}
+
+			lp.AppendInt(flags)
+			lp.AppendInt(int64(e.ID.Ms - master.ID.Ms))
+			lp.AppendInt(int64(e.ID.Seq - master.ID.Seq))
+
+			if sameFields {
+				for i := 1; i < len(e.Fields); i += 2 {
+					lp.AppendString(e.Fields[i])
+				}
+
+				lp.AppendInt(int64(len(fields) + 3))
+				continue
+			}
+
+			lp.AppendInt(int64(len(fields)))
+
+			for _, s := range e.Fields {
+				lp.AppendString(s)
+			}
+
+			lp.AppendInt(int64(2*len(fields) + 4))
+		}
+
+		keys = append(keys, master.ID.bytes())
+		nodes = append(nodes, lp.Bytes())
+	}
+
+	return keys, nodes
+}
+
+// streamEntries decodes the entries of a stream listpack, skipping those
+// flagged as deleted.
+func streamEntries(key, node []byte) ([]StreamEntry, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("%w: stream node key of %d bytes", InvalidFile, len(key))
+	}
+
+	elements, err := decodeListpack(node)
+	if err != nil {
+		return nil, err
+	}
+
+	master := streamIDFromBytes(key)
+
+	next := func() (listpackElement, error) {
+		if len(elements) == 0 {
+			return listpackElement{}, InvalidListpack
+		}
+
+		e := elements[0]
+		elements = elements[1:]
+
+		return e, nil
+	}
+
+	nextInt := func() (int64, error) {
+		e, err := next()
+		if err == nil && !e.IsInt {
+			err = InvalidListpack
+		}
+
+		return e.Int, err
+	}
+
+	count, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+
+	numFields, err := nextInt()
+	if err != nil {
+		return nil, err
+	}
+
+	masterFields := make([]string, numFields)
+	for i := range masterFields {
+		e, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		masterFields[i] = e.String()
+	}
+
+	if _, err := nextInt(); err != nil { // master terminator
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, 0, count)
+
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		msDelta, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		seqDelta, err := nextInt()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := StreamEntry{ID: StreamID{
+			Ms:  master.Ms + uint64(msDelta),
+			Seq: master.Seq + uint64(seqDelta),
+		}}
+
+		fields := masterFields
+		if flags&streamItemSameFields == 0 {
+			n, err := nextInt()
+			if err != nil {
+				return nil, err
+			}
+
+			fields = nil
+			entry.Fields = make([]string, 0, 2*n)
+
+			for j := int64(0); j < 2*n; j++ {
+				e, err := next()
+				if err != nil {
+					return nil, err
+				}
+
+				entry.Fields = append(entry.Fields, e.String())
+			}
+		}
+
+		for _, f := range fields {
+			v, err := next()
+			if err != nil {
+				return nil, err
+			}
+
+			entry.Fields = append(entry.Fields, f, v.String())
+		}
+
+		if _, err := nextInt(); err != nil { // lp-count
+			return nil, err
+		}
+
+		if flags&streamItemDeleted == 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func fieldNames(pairs []string) []string {
+	names := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		names = append(names, pairs[i])
+	}
+
+	return names
+}
+
+// readStream reads a stream value of any of the listpack based types, the
+// older ones lacking the metadata added along consumer group lag.
+func (p *Parser) readStream(reader *bufio.Reader, typ byte) (*Stream, error) {
+	nodes, err := p.readLength(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stream{}
+
+	for i := 0; i < nodes; i++ {
+		key, err := p.readString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := p.readString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := streamEntries([]byte(key), []byte(node))
+		if err != nil {
+			return nil, err
+		}
+
+		s.Entries = append(s.Entries, entries...)
+	}
+
+	length, _, err := p.readLength64(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.LastID, err = p.readStreamID(reader); err != nil {
+		return nil, err
+	}
+
+	if typ >= TypeStreamListpacks2 {
+		if s.FirstID, err = p.readStreamID(reader); err != nil {
+			return nil, err
+		}
+
+		if s.MaxDeletedID, err = p.readStreamID(reader); err != nil {
+			return nil, err
+		}
+
+		if s.EntriesAdded, _, err = p.readLength64(reader); err != nil {
+			return nil, err
+		}
+	} else {
+		s.EntriesAdded = length
+
+		if len(s.Entries) > 0 {
+			s.FirstID = s.Entries[0].ID
+		}
+	}
+
+	groups, err := p.readLength(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < groups; i++ {
+		g, err := p.readStreamGroup(reader, typ)
+		if err != nil {
+			return nil, err
+		}
+
+		s.Groups = append(s.Groups, g)
+	}
+
+	return s, nil
+}
+
+func (p *Parser) readStreamGroup(reader *bufio.Reader, typ byte) (StreamGroup, error) {
+	g := StreamGroup{EntriesRead: -1}
+
+	var err error
+
+	if g.Name, err = p.readString(reader); err != nil {
+		return g, err
+	}
+
+	if g.LastID, err = p.readStreamID(reader); err != nil {
+		return g, err
+	}
+
+	if typ >= TypeStreamListpacks2 {
+		entriesRead, _, err := p.readLength64(reader)
+		if err != nil {
+			return g, err
+		}
+
+		g.EntriesRead = int64(entriesRead)
+	}
+
+	pending, err := p.readLength(reader)
+	if err != nil {
+		return g, err
+	}
+
+	for i := 0; i < pending; i++ {
+		var nack StreamNack
+
+		if nack.ID, err = readRawStreamID(reader); err != nil {
+			return g, err
+		}
+
+		if nack.DeliveryTime, err = readMillis(reader); err != nil {
+			return g, err
+		}
+
+		if nack.DeliveryCount, _, err = p.readLength64(reader); err != nil {
+			return g, err
+		}
+
+		g.Pending = append(g.Pending, nack)
+	}
+
+	consumers, err := p.readLength(reader)
+	if err != nil {
+		return g, err
+	}
+
+	for i := 0; i < consumers; i++ {
+		var c StreamConsumer
+
+		if c.Name, err = p.readString(reader); err != nil {
+			return g, err
+		}
+
+		if c.SeenTime, err = readMillis(reader); err != nil {
+			return g, err
+		}
+
+		c.ActiveTime = c.SeenTime
+		if typ >= TypeStreamListpacks3 {
+			if c.ActiveTime, err = readMillis(reader); err != nil {
+				return g, err
+			}
+		}
+
+		n, err := p.readLength(reader)
+		if err != nil {
+			return g, err
+		}
+
+		for j := 0; j < n; j++ {
+			id, err := readRawStreamID(reader)
+			if err != nil {
+				return g, err
+			}
+
+			c.Pending = append(c.Pending, id)
+		}
+
+		g.Consumers = append(g.Consumers, c)
+	}
+
+	return g, nil
+}
+
+func (p *Parser) readStreamID(reader *bufio.Reader) (StreamID, error) {
+	ms, _, err := p.readLength64(reader)
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	seq, _, err := p.readLength64(reader)
+
+	return StreamID{Ms: ms, Seq: seq}, err
+}
+
+func readRawStreamID(reader *bufio.Reader) (StreamID, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(reader, b); err != nil {
+		return StreamID{}, err
+	}
+
+	return streamIDFromBytes(b), nil
+}
+
+func readMillis(reader *bufio.Reader) (int64, error) {
+	var ms int64
+	err := binary.Read(reader, binary.LittleEndian, &ms)
+
+	return ms, err
+}
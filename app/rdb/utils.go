@@ -1 +1,90 @@
 package rdb
+
+import "errors"
+
+// crc64Poly is the reflected polynomial of CRC-64/Jones, the checksum Redis
+// appends to RDB files.
+const crc64Poly = 0x95AC9329AC4BC9B5
+
+var crc64Table = func() (t [256]uint64) {
+	for i := range t {
+		crc := uint64(i)
+
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ crc64Poly
+			} else {
+				crc >>= 1
+			}
+		}
+
+		t[i] = crc
+	}
+
+	return t
+}()
+
+func crc64(crc uint64, b []byte) uint64 {
+	for _, c := range b {
+		crc = crc64Table[byte(crc)^c] ^ crc>>8
+	}
+
+	return crc
+}
+
+var InvalidLZF = errors.New("invalid LZF compressed string")
+
+// lzfDecompress expands the strings Redis compresses with LZF: literal runs
+// and back references into the output produced so far.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			run := ctrl + 1
+			if i+run > len(in) {
+				return nil, InvalidLZF
+			}
+
+			out = append(out, in[i:i+run]...)
+			i += run
+
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, InvalidLZF
+			}
+
+			length += int(in[i])
+			i++
+		}
+
+		if i >= len(in) {
+			return nil, InvalidLZF
+		}
+
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+
+		if ref < 0 {
+			return nil, InvalidLZF
+		}
+
+		// the reference may overlap what it produces
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, InvalidLZF
+	}
+
+	return out, nil
+}
@@ -0,0 +1,177 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Version of the RDB format written, the first one with RDB_TYPE_STREAM_LISTPACKS_3.
+const Version = 11
+
+// Value types of the RDB format.
+const (
+	TypeString           = 0
+	TypeStreamListpacks  = 15
+	TypeStreamListpacks2 = 19
+	TypeStreamListpacks3 = 21
+)
+
+// Writer encodes a snapshot in the RDB format. The first error is kept and
+// returned by Close, further writes are ignored.
+type Writer struct {
+	w   io.Writer
+	crc uint64
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+
+	w.crc = crc64(w.crc, b)
+	_, w.err = w.w.Write(b)
+}
+
+// WriteHeader writes the magic string, the version and the auxiliary fields.
+func (w *Writer) WriteHeader(aux map[AuxiliaryFieldKey]string) {
+	w.write([]byte("REDIS" + leftPad(strconv.Itoa(Version), 4)))
+
+	for _, k := range []AuxiliaryFieldKey{RedisVersion, RedisBits, CreationTime, UsedMemory} {
+		if v, ok := aux[k]; ok {
+			w.write([]byte{AUX})
+			w.writeString(string(k))
+			w.writeString(v)
+		}
+	}
+}
+
+// SelectDB starts the keys of a database, size and expires being the number
+// of keys and of keys with a TTL.
+func (w *Writer) SelectDB(id, size, expires int) {
+	w.write([]byte{SELECTDB})
+	w.writeLength(uint64(id))
+	w.write([]byte{RESIZEDB})
+	w.writeLength(uint64(size))
+	w.writeLength(uint64(expires))
+}
+
+// WriteString writes a string key, expiring at expireAt (unix time in
+// milliseconds) unless it is 0.
+func (w *Writer) WriteString(key, value string, expireAt int64) {
+	w.writeExpiry(expireAt)
+	w.write([]byte{TypeString})
+	w.writeString(key)
+	w.writeString(value)
+}
+
+// WriteStream writes a stream key and its consumer groups.
+func (w *Writer) WriteStream(key string, s *Stream, expireAt int64) {
+	w.writeExpiry(expireAt)
+	w.write([]byte{TypeStreamListpacks3})
+	w.writeString(key)
+
+	keys, nodes := streamNodes(s.Entries)
+
+	w.writeLength(uint64(len(nodes)))
+	for i := range nodes {
+		w.writeString(string(keys[i]))
+		w.writeString(string(nodes[i]))
+	}
+
+	w.writeLength(uint64(len(s.Entries)))
+	w.writeID(s.LastID)
+	w.writeID(s.FirstID)
+	w.writeID(s.MaxDeletedID)
+	w.writeLength(s.EntriesAdded)
+
+	w.writeLength(uint64(len(s.Groups)))
+
+	for _, g := range s.Groups {
+		w.writeString(g.Name)
+		w.writeID(g.LastID)
+		w.writeLength(uint64(g.EntriesRead))
+
+		w.writeLength(uint64(len(g.Pending)))
+		for _, nack := range g.Pending {
+			w.write(nack.ID.bytes())
+			w.writeMillis(nack.DeliveryTime)
+			w.writeLength(nack.DeliveryCount)
+		}
+
+		w.writeLength(uint64(len(g.Consumers)))
+		for _, c := range g.Consumers {
+			w.writeString(c.Name)
+			w.writeMillis(c.SeenTime)
+			w.writeMillis(c.ActiveTime)
+
+			w.writeLength(uint64(len(c.Pending)))
+			for _, id := range c.Pending {
+				w.write(id.bytes())
+			}
+		}
+	}
+}
+
+// Close ends the file with its checksum.
+func (w *Writer) Close() error {
+	w.write([]byte{EOF})
+
+	if w.err != nil {
+		return w.err
+	}
+
+	_, err := w.w.Write(binary.LittleEndian.AppendUint64(nil, w.crc))
+
+	return err
+}
+
+func (w *Writer) writeExpiry(expireAt int64) {
+	if expireAt == 0 {
+		return
+	}
+
+	w.write([]byte{EXPIRETIME_MS})
+	w.writeMillis(expireAt)
+}
+
+func (w *Writer) writeMillis(ms int64) {
+	w.write(binary.LittleEndian.AppendUint64(nil, uint64(ms)))
+}
+
+func (w *Writer) writeID(id StreamID) {
+	w.writeLength(id.Ms)
+	w.writeLength(id.Seq)
+}
+
+func (w *Writer) writeLength(l uint64) {
+	switch {
+	case l < 1<<6:
+		w.write([]byte{REDIS_RDB_6BITLEN<<6 | byte(l)})
+	case l < 1<<14:
+		w.write([]byte{REDIS_RDB_14BITLEN<<6 | byte(l>>8), byte(l)})
+	case l <= math.MaxUint32:
+		w.write(binary.BigEndian.AppendUint32([]byte{REDIS_RDB_32BITLEN}, uint32(l)))
+	default:
+		w.write(binary.BigEndian.AppendUint64([]byte{REDIS_RDB_64BITLEN}, l))
+	}
+}
+
+func (w *Writer) writeString(s string) {
+	w.writeLength(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func leftPad(s string, n int) string {
+	for len(s) < n {
+		s = "0" + s
+	}
+
+	return s
+}
@@ -0,0 +1,140 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	// check value of CRC-64/Jones, as in the Redis sources
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64(0, []byte("123456789")))
+}
+
+func TestLZFDecompress(t *testing.T) {
+	// a literal run of 3 bytes, then a back reference copying 6 bytes from
+	// 3 bytes back
+	in := []byte{0x02, 'a', 'b', 'c', 4 << 5, 0x02}
+
+	out, err := lzfDecompress(in, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcabcabc", string(out))
+
+	_, err = lzfDecompress(in, 10)
+	assert.ErrorIs(t, err, InvalidLZF)
+}
+
+func TestListpack_RoundTrip(t *testing.T) {
+	ints := []int64{0, 127, 128, -1, 4095, -4096, 4096, math.MaxInt16, math.MinInt16, 1<<23 - 1, -(1 << 23),
+		math.MaxInt32, math.MinInt32, math.MaxInt64, math.MinInt64}
+	strs := []string{"", "a", strings.Repeat("x", 63), strings.Repeat("y", 64), strings.Repeat("z", 20000), "01", "-0"}
+
+	lp := newListpackWriter()
+	for _, v := range ints {
+		lp.AppendInt(v)
+	}
+
+	for _, s := range strs {
+		lp.AppendString(s)
+	}
+
+	lp.AppendString("12345")
+
+	elements, err := decodeListpack(lp.Bytes())
+	assert.NoError(t, err)
+	assert.Len(t, elements, len(ints)+len(strs)+1)
+
+	for i, v := range ints {
+		assert.True(t, elements[i].IsInt)
+		assert.Equal(t, v, elements[i].Int)
+	}
+
+	for i, s := range strs {
+		assert.False(t, elements[len(ints)+i].IsInt, "%q is not the canonical form of an integer", s)
+		assert.Equal(t, s, elements[len(ints)+i].String())
+	}
+
+	last := elements[len(elements)-1]
+	assert.True(t, last.IsInt, "integer strings are stored as integers")
+	assert.Equal(t, "12345", last.String())
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	var entries []StreamEntry
+	for i := 1; i <= 250; i++ {
+		fields := []string{"n", fmt.Sprint(i), "name", "entry"}
+		if i%7 == 0 {
+			fields = []string{"other", strings.Repeat("v", 100)}
+		}
+
+		entries = append(entries, StreamEntry{ID: StreamID{Ms: uint64(1000 + i/3), Seq: uint64(i % 3)}, Fields: fields})
+	}
+
+	s := &Stream{
+		Entries:      entries,
+		LastID:       entries[len(entries)-1].ID,
+		FirstID:      entries[0].ID,
+		EntriesAdded: 250,
+		Groups: []StreamGroup{
+			{
+				Name:        "g",
+				LastID:      entries[10].ID,
+				EntriesRead: 11,
+				Pending: []StreamNack{
+					{ID: entries[9].ID, DeliveryTime: 1700000000000, DeliveryCount: 2},
+					{ID: entries[10].ID, DeliveryTime: 1700000000001, DeliveryCount: 1},
+				},
+				Consumers: []StreamConsumer{
+					{Name: "alice", SeenTime: 1700000000002, ActiveTime: 1700000000001, Pending: []StreamID{entries[9].ID, entries[10].ID}},
+					{Name: "bob", SeenTime: 1700000000003, ActiveTime: -1},
+				},
+			},
+			{Name: "unread", EntriesRead: -1},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	w.WriteHeader(map[AuxiliaryFieldKey]string{RedisVersion: "7.2.0", RedisBits: "64"})
+	w.SelectDB(0, 2, 1)
+	w.WriteString("key", "value", 1893456000000)
+	w.WriteStream("stream", s, 0)
+	assert.NoError(t, w.Close())
+
+	file := buf.Bytes()
+	checksum := binary.LittleEndian.Uint64(file[len(file)-8:])
+	assert.Equal(t, crc64(0, file[:len(file)-8]), checksum)
+
+	parser := NewParser(bytes.NewReader(file))
+	assert.NoError(t, parser.Parse())
+
+	assert.Equal(t, Version, parser.Context.Header.Version)
+	assert.Equal(t, "7.2.0", parser.Context.Aux.Fields[RedisVersion])
+
+	db := parser.Context.Databases[0]
+	assert.Len(t, db.Entries, 2)
+
+	assert.Equal(t, "key", db.Entries[0].Key)
+	assert.Equal(t, "value", db.Entries[0].Value)
+	assert.Equal(t, expiry{Type: EXPIRETIME_MS, Value: 1893456000000}, db.Entries[0].Expiry)
+
+	assert.Equal(t, "stream", db.Entries[1].Key)
+	assert.Equal(t, s, db.Entries[1].Stream)
+}
+
+func TestWriter_EmptySnapshot(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	w.WriteHeader(map[AuxiliaryFieldKey]string{RedisVersion: "7.2.0"})
+	assert.NoError(t, w.Close())
+
+	parser := NewParser(&buf)
+	assert.NoError(t, parser.Parse())
+	assert.Empty(t, parser.Context.Databases)
+}
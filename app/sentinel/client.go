@@ -0,0 +1,107 @@
+package sentinel
+
+import (
+	"errors"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const linkTimeout = time.Second
+
+var linkClosed = errors.New("link closed")
+
+// link is a command connection to a monitored instance or another sentinel.
+// It is dialed lazily and dropped on the first error, so the next command
+// reconnects.
+type link struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *resp.Reader
+
+	closed  bool
+	localIP atomic.Value
+}
+
+func newLink(addr string) *link {
+	return &link{addr: addr}
+}
+
+// Do sends a command and waits for its reply.
+func (l *link) Do(args ...string) (resp.Value, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return resp.NullValue(), linkClosed
+	}
+
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.addr, linkTimeout)
+		if err != nil {
+			return resp.NullValue(), err
+		}
+
+		l.conn = conn
+		l.rd = resp.NewReader(conn)
+
+		if host, _, err := net.SplitHostPort(conn.LocalAddr().String()); err == nil {
+			l.localIP.Store(host)
+		}
+	}
+
+	_ = l.conn.SetDeadline(time.Now().Add(linkTimeout))
+
+	if _, err := l.conn.Write(command(args...)); err != nil {
+		l.reset()
+		return resp.NullValue(), err
+	}
+
+	v, _, err := l.rd.ReadValue()
+	if err != nil {
+		l.reset()
+	}
+
+	return v, err
+}
+
+// LocalIP is the address we last reached the instance from, which is the one
+// other sentinels can reach us on.
+func (l *link) LocalIP() string {
+	ip, _ := l.localIP.Load().(string)
+
+	return ip
+}
+
+func (l *link) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	l.reset()
+}
+
+func (l *link) reset() {
+	if l.conn != nil {
+		l.conn.Close()
+	}
+
+	l.conn = nil
+	l.rd = nil
+}
+
+func command(args ...string) []byte {
+	values := make([]resp.Value, 0, len(args))
+	for _, a := range args {
+		values = append(values, resp.BulkStringValue(a))
+	}
+
+	c := resp.ArrayValue(values...)
+	b, _ := c.Marshal()
+
+	return b
+}
@@ -0,0 +1,417 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type FailoverState string
+
+const (
+	NoFailover       FailoverState = "none"
+	WaitStart        FailoverState = "wait-start"
+	SelectSlave      FailoverState = "select-slave"
+	SendSlaveofNoone FailoverState = "send-slaveof-noone"
+	WaitPromotion    FailoverState = "wait-promotion"
+)
+
+// sentinels that detect the failure at the same time would all ask for votes
+// in the same epoch; a random delay lets one of them go first
+const maxDesync = time.Second
+
+var (
+	failoverInProgress = errors.New("INPROG Failover already in progress")
+	noGoodReplica      = errors.New("NOGOODSLAVE No suitable replica to promote")
+)
+
+// checkObjectivelyDown flags the master as down once enough sentinels,
+// counting us, agree it is subjectively down.
+func (s *Sentinel) checkObjectivelyDown(now time.Time) {
+	m := s.master
+
+	odown := false
+	votes := 0
+
+	if m.sdown {
+		votes = 1
+
+		for _, p := range m.sentinels {
+			if p.masterDown && now.Sub(p.masterDownAt) < askValidity {
+				votes++
+			}
+		}
+
+		odown = votes >= m.quorum
+	}
+
+	switch {
+	case odown && m.odownSince.IsZero():
+		m.odownSince = now
+		s.logEvent("+odown", "master", m.masterDescription(), fmt.Sprintf("#quorum %d/%d", votes, m.quorum))
+
+		if m.nextAttempt.Before(now) {
+			m.nextAttempt = now.Add(time.Duration(rand.Int63n(int64(maxDesync))))
+		}
+	case !odown && !m.odownSince.IsZero():
+		m.odownSince = time.Time{}
+		s.logEvent("-odown", "master", m.masterDescription())
+	}
+}
+
+// askMasterState asks the other sentinels whether they see the master down
+// too. While we wait to be elected the same request asks for their vote.
+func (s *Sentinel) askMasterState(now time.Time) {
+	m := s.master
+
+	if !m.sdown {
+		for _, p := range m.sentinels {
+			p.masterDown = false
+		}
+		return
+	}
+
+	runID := "*"
+	if m.failoverState == WaitStart {
+		runID = s.ID
+	}
+
+	host, port, _ := net.SplitHostPort(m.addr)
+
+	for _, p := range m.sentinels {
+		if p.askPending || now.Sub(p.lastAsk) < askPeriod {
+			continue
+		}
+
+		p.askPending = true
+		p.lastAsk = now
+
+		go s.askSentinel(p, host, port, s.currentEpoch, runID)
+	}
+}
+
+func (s *Sentinel) askSentinel(p *instance, host, port string, epoch int64, runID string) {
+	v, err := p.link.Do("SENTINEL", "is-master-down-by-addr", host, port, strconv.FormatInt(epoch, 10), runID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.askPending = false
+
+	if err != nil {
+		return
+	}
+
+	values, err := v.AsArray()
+	if err != nil || len(values) != 3 {
+		return
+	}
+
+	down, _ := values[0].AsString()
+	leader, _ := values[1].AsString()
+	leaderEpoch, _ := values[2].AsString()
+
+	p.masterDown = down == "1"
+	p.masterDownAt = time.Now()
+
+	if leader != "*" {
+		p.leader = leader
+		p.leaderEpoch, _ = strconv.ParseInt(leaderEpoch, 10, 64)
+	}
+}
+
+// IsMasterDownByAddr answers another sentinel asking about the master at
+// addr. A run id other than "*" also asks for our vote in epoch.
+func (s *Sentinel) IsMasterDownByAddr(addr string, epoch int64, runID string) (bool, string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.master
+	down := m.addr == addr && m.sdown
+
+	leader, leaderEpoch := "*", int64(0)
+
+	if m.addr == addr && runID != "*" {
+		leader, leaderEpoch = s.voteLeader(epoch, runID, time.Now())
+	}
+
+	return down, leader, leaderEpoch
+}
+
+// voteLeader gives our vote for epoch to the first sentinel asking for it.
+func (s *Sentinel) voteLeader(epoch int64, runID string, now time.Time) (string, int64) {
+	m := s.master
+
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.logEvent("+new-epoch", strconv.FormatInt(epoch, 10))
+	}
+
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader = runID
+		m.leaderEpoch = s.currentEpoch
+		s.logEvent("+vote-for-leader", runID, strconv.FormatInt(m.leaderEpoch, 10))
+
+		// leave the other sentinel time to complete its failover
+		if runID != s.ID {
+			m.nextAttempt = now.Add(2 * s.config.FailoverTimeout)
+		}
+	}
+
+	return m.leader, m.leaderEpoch
+}
+
+// electedLeader returns the sentinel that got a majority of the votes for
+// epoch, counting the votes of every sentinel we know of, or "".
+func (s *Sentinel) electedLeader(epoch int64) string {
+	m := s.master
+
+	votes := make(map[string]int)
+
+	if m.leaderEpoch == epoch && m.leader != "" {
+		votes[m.leader]++
+	}
+
+	for _, p := range m.sentinels {
+		if p.leaderEpoch == epoch && p.leader != "" {
+			votes[p.leader]++
+		}
+	}
+
+	var (
+		winner   string
+		maxVotes int
+	)
+
+	for id, n := range votes {
+		if n > maxVotes || (n == maxVotes && id < winner) {
+			winner, maxVotes = id, n
+		}
+	}
+
+	needed := max(m.quorum, (len(m.sentinels)+1)/2+1)
+	if maxVotes < needed {
+		return ""
+	}
+
+	return winner
+}
+
+func (s *Sentinel) failoverTick(now time.Time) {
+	m := s.master
+
+	switch m.failoverState {
+	case NoFailover:
+		if m.odownSince.IsZero() || now.Before(m.nextAttempt) {
+			return
+		}
+
+		s.startFailover(now)
+	case WaitStart:
+		if s.electedLeader(m.failoverEpoch) == s.ID {
+			s.logEvent("+elected-leader", "master", m.masterDescription())
+			m.failoverState = SelectSlave
+			return
+		}
+
+		if now.Sub(m.failoverStart) > s.config.FailoverTimeout {
+			s.abortFailover("-failover-abort-not-elected")
+		}
+	case SelectSlave:
+		r := s.selectReplica(now)
+		if r == nil {
+			s.abortFailover("-failover-abort-no-good-slave")
+			return
+		}
+
+		s.event("+selected-slave", "slave", r)
+
+		m.promoted = r
+		m.failoverState = SendSlaveofNoone
+		go s.promote(r)
+	case SendSlaveofNoone, WaitPromotion:
+		if now.Sub(m.failoverStart) > s.config.FailoverTimeout {
+			s.abortFailover("-failover-abort-slave-timeout")
+		}
+	}
+}
+
+func (s *Sentinel) startFailover(now time.Time) {
+	m := s.master
+
+	s.currentEpoch++
+	s.logEvent("+new-epoch", strconv.FormatInt(s.currentEpoch, 10))
+
+	m.failoverState = WaitStart
+	m.failoverEpoch = s.currentEpoch
+	m.failoverStart = now
+	m.nextAttempt = now.Add(2 * s.config.FailoverTimeout)
+
+	s.logEvent("+try-failover", "master", m.masterDescription())
+	s.voteLeader(m.failoverEpoch, s.ID, now)
+
+	// ask for votes right away
+	for _, p := range m.sentinels {
+		p.lastAsk = time.Time{}
+	}
+}
+
+func (s *Sentinel) abortFailover(reason string) {
+	m := s.master
+
+	s.logEvent(reason, "master", m.masterDescription())
+
+	m.failoverState = NoFailover
+	m.promoted = nil
+}
+
+// selectReplica picks the reachable replica with the most data, breaking ties
+// by address.
+func (s *Sentinel) selectReplica(now time.Time) *instance {
+	var candidates []*instance
+
+	for _, r := range s.master.replicas {
+		switch {
+		case r.sdown,
+			r.role != "slave",
+			now.Sub(r.lastPong) > 5*pingPeriod,
+			now.Sub(r.lastInfo) > 5*failoverInfoPeriod:
+			continue
+		}
+
+		candidates = append(candidates, r)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].replOffset != candidates[j].replOffset {
+			return candidates[i].replOffset > candidates[j].replOffset
+		}
+
+		return candidates[i].addr < candidates[j].addr
+	})
+
+	return candidates[0]
+}
+
+func (s *Sentinel) promote(r *instance) {
+	_, err := r.link.Do("REPLICAOF", "NO", "ONE")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.master
+
+	if m.promoted != r || m.failoverState != SendSlaveofNoone {
+		return
+	}
+
+	if err != nil {
+		fmt.Println("Sentinel: REPLICAOF NO ONE failed: ", err)
+		return
+	}
+
+	s.event("+promoted-slave", "slave", r)
+	m.failoverState = WaitPromotion
+
+	// check the new role right away
+	r.lastInfo = time.Time{}
+}
+
+// finishFailover runs once the promoted replica reports itself as master: the
+// other replicas follow it and the new config is published with our epoch.
+func (s *Sentinel) finishFailover() {
+	m := s.master
+	promoted := m.promoted
+
+	for _, r := range m.replicas {
+		if r == promoted || r.sdown {
+			continue
+		}
+
+		s.event("+slave-reconf-sent", "slave", r)
+		go s.replicaOf(r, promoted.addr)
+	}
+
+	s.logEvent("+failover-end", "master", m.masterDescription())
+
+	m.configEpoch = m.failoverEpoch
+	s.switchMaster(promoted.addr)
+}
+
+// switchMaster starts monitoring addr as the master. The old master becomes
+// one of its replicas, to be reconfigured once it is back.
+func (s *Sentinel) switchMaster(addr string) {
+	m := s.master
+	old := m.instance
+
+	oldHost, oldPort, _ := net.SplitHostPort(old.addr)
+	newHost, newPort, _ := net.SplitHostPort(addr)
+	s.logEvent("+switch-master", m.name, oldHost, oldPort, newHost, newPort)
+
+	replicas := make(map[string]*instance)
+
+	for a := range m.replicas {
+		if a != addr {
+			replicas[a] = newInstance(a)
+		}
+	}
+
+	replicas[old.addr] = newInstance(old.addr)
+
+	s.removeInstance(old)
+	for _, r := range m.replicas {
+		s.removeInstance(r)
+	}
+
+	m.instance = newInstance(addr)
+	m.replicas = replicas
+	m.odownSince = time.Time{}
+	m.failoverState = NoFailover
+	m.promoted = nil
+
+	for _, p := range m.sentinels {
+		p.masterDown = false
+	}
+}
+
+// ForceFailover promotes a replica without asking the other sentinels, as
+// with SENTINEL FAILOVER.
+func (s *Sentinel) ForceFailover(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.master
+
+	if name != m.name {
+		return unknownMaster
+	}
+
+	if m.failoverState != NoFailover {
+		return failoverInProgress
+	}
+
+	if s.selectReplica(time.Now()) == nil {
+		return noGoodReplica
+	}
+
+	now := time.Now()
+
+	s.currentEpoch++
+	s.logEvent("+new-epoch", strconv.FormatInt(s.currentEpoch, 10))
+	s.voteLeader(s.currentEpoch, s.ID, now)
+
+	m.failoverState = SelectSlave
+	m.failoverEpoch = s.currentEpoch
+	m.failoverStart = now
+	m.nextAttempt = now.Add(2 * s.config.FailoverTimeout)
+
+	return nil
+}
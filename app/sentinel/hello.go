@@ -0,0 +1,68 @@
+package sentinel
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const helloChannel = "__sentinel__:hello"
+
+var invalidHello = errors.New("invalid hello message")
+
+// hello is what sentinels publish on the hello channel of the instances they
+// monitor, to discover each other and to spread the latest master config.
+type hello struct {
+	addr         string
+	runID        string
+	currentEpoch int64
+
+	masterName        string
+	masterAddr        string
+	masterConfigEpoch int64
+}
+
+// String formats the message as
+// ip,port,runid,current-epoch,master-name,master-ip,master-port,master-config-epoch
+func (h hello) String() string {
+	ip, port, _ := net.SplitHostPort(h.addr)
+	masterIP, masterPort, _ := net.SplitHostPort(h.masterAddr)
+
+	return strings.Join([]string{
+		ip,
+		port,
+		h.runID,
+		strconv.FormatInt(h.currentEpoch, 10),
+		h.masterName,
+		masterIP,
+		masterPort,
+		strconv.FormatInt(h.masterConfigEpoch, 10),
+	}, ",")
+}
+
+func parseHello(msg string) (hello, error) {
+	fields := strings.Split(msg, ",")
+	if len(fields) != 8 {
+		return hello{}, invalidHello
+	}
+
+	currentEpoch, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return hello{}, invalidHello
+	}
+
+	configEpoch, err := strconv.ParseInt(fields[7], 10, 64)
+	if err != nil {
+		return hello{}, invalidHello
+	}
+
+	return hello{
+		addr:              net.JoinHostPort(fields[0], fields[1]),
+		runID:             fields[2],
+		currentEpoch:      currentEpoch,
+		masterName:        fields[4],
+		masterAddr:        net.JoinHostPort(fields[5], fields[6]),
+		masterConfigEpoch: configEpoch,
+	}, nil
+}
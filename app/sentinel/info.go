@@ -0,0 +1,191 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MasterAddr returns the address of the current master for name.
+func (s *Sentinel) MasterAddr(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name != s.master.name {
+		return "", false
+	}
+
+	return s.master.addr, true
+}
+
+// Masters describes the monitored masters as field/value pairs.
+func (s *Sentinel) Masters() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return [][]string{s.describeMaster(time.Now())}
+}
+
+// Master describes the master monitored as name.
+func (s *Sentinel) Master(name string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name != s.master.name {
+		return nil, unknownMaster
+	}
+
+	return s.describeMaster(time.Now()), nil
+}
+
+// Replicas describes the known replicas of the master monitored as name.
+func (s *Sentinel) Replicas(name string) ([][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name != s.master.name {
+		return nil, unknownMaster
+	}
+
+	now := time.Now()
+	replicas := make([][]string, 0, len(s.master.replicas))
+
+	for _, addr := range sortedKeys(s.master.replicas) {
+		r := s.master.replicas[addr]
+		fields := s.describe(r, addr, "slave", now)
+
+		masterHost, masterPort, _ := net.SplitHostPort(r.masterAddr)
+		linkStatus := "err"
+		if r.masterLinkUp {
+			linkStatus = "ok"
+		}
+
+		replicas = append(replicas, append(fields,
+			"master-link-status", linkStatus,
+			"master-host", masterHost,
+			"master-port", masterPort,
+			"slave-repl-offset", strconv.FormatInt(r.replOffset, 10),
+		))
+	}
+
+	return replicas, nil
+}
+
+// Sentinels describes the other sentinels monitoring the master named name.
+func (s *Sentinel) Sentinels(name string) ([][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name != s.master.name {
+		return nil, unknownMaster
+	}
+
+	now := time.Now()
+	sentinels := make([][]string, 0, len(s.master.sentinels))
+
+	for _, id := range sortedKeys(s.master.sentinels) {
+		p := s.master.sentinels[id]
+		fields := s.describe(p, p.addr, "sentinel", now)
+
+		sentinels = append(sentinels, append(fields,
+			"runid", p.runID,
+			"last-hello-message", strconv.FormatInt(now.Sub(p.lastHello).Milliseconds(), 10),
+			"voted-leader", p.leader,
+			"voted-leader-epoch", strconv.FormatInt(p.leaderEpoch, 10),
+		))
+	}
+
+	return sentinels, nil
+}
+
+// Info is the sentinel section of INFO.
+func (s *Sentinel) Info() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.master
+
+	status := "ok"
+	if m.sdown {
+		status = "sdown"
+	}
+	if !m.odownSince.IsZero() {
+		status = "odown"
+	}
+
+	var b strings.Builder
+	b.WriteString("# Sentinel\r\n")
+	b.WriteString("sentinel_masters:1\r\n")
+	b.WriteString(fmt.Sprintf("sentinel_current_epoch:%d\r\n", s.currentEpoch))
+	b.WriteString(fmt.Sprintf("master0:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+		m.name, status, m.addr, len(m.replicas), len(m.sentinels)+1))
+
+	return b.String()
+}
+
+func (s *Sentinel) describeMaster(now time.Time) []string {
+	m := s.master
+	fields := s.describe(m.instance, m.name, "master", now)
+
+	return append(fields,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"failover-state", string(m.failoverState),
+		"down-after-milliseconds", strconv.FormatInt(s.config.DownAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(s.config.FailoverTimeout.Milliseconds(), 10),
+	)
+}
+
+func (s *Sentinel) describe(inst *instance, name, kind string, now time.Time) []string {
+	host, port, _ := net.SplitHostPort(inst.addr)
+
+	flags := []string{kind}
+	if inst.sdown {
+		flags = append(flags, "s_down")
+	}
+	if inst == s.master.instance {
+		if !s.master.odownSince.IsZero() {
+			flags = append(flags, "o_down")
+		}
+		if s.master.failoverState != NoFailover {
+			flags = append(flags, "failover_in_progress")
+		}
+	}
+	if inst == s.master.promoted {
+		flags = append(flags, "promoted")
+	}
+
+	return []string{
+		"name", name,
+		"ip", host,
+		"port", port,
+		"flags", strings.Join(flags, ","),
+		"last-ping-sent", strconv.FormatInt(sinceMillis(inst.unansweredPing, now), 10),
+		"last-ok-ping-reply", strconv.FormatInt(sinceMillis(inst.lastPong, now), 10),
+		"role-reported", inst.role,
+	}
+}
+
+func sinceMillis(t, now time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return now.Sub(t).Milliseconds()
+}
+
+func sortedKeys(m map[string]*instance) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
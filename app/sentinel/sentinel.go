@@ -0,0 +1,643 @@
+package sentinel
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/services"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tickPeriod         = 100 * time.Millisecond
+	pingPeriod         = time.Second
+	infoPeriod         = 10 * time.Second
+	failoverInfoPeriod = time.Second
+	helloPeriod        = 2 * time.Second
+	askPeriod          = time.Second
+
+	// replies to is-master-down-by-addr are only trusted for a few periods
+	askValidity = 5 * askPeriod
+
+	// how long a wrong role or master must be reported before we fix it,
+	// which leaves time for hello messages to carry a newer config
+	reconfigureDelay = 4 * helloPeriod
+)
+
+var unknownMaster = errors.New("ERR No such master with that name")
+
+// Config describes the master a sentinel monitors.
+type Config struct {
+	MasterName      string
+	MasterAddr      string
+	Quorum          int
+	DownAfter       time.Duration
+	FailoverTimeout time.Duration
+
+	// Port is the port this sentinel listens on, announced in hello messages.
+	Port int
+}
+
+// ParseMonitor parses "<master-name> <ip> <port> <quorum>".
+func ParseMonitor(s string) (name, addr string, quorum int, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return "", "", 0, errors.New("sentinel monitor expects <master-name> <ip> <port> <quorum>")
+	}
+
+	if _, err := strconv.Atoi(fields[2]); err != nil {
+		return "", "", 0, fmt.Errorf("invalid master port: %s", fields[2])
+	}
+
+	quorum, err = strconv.Atoi(fields[3])
+	if err != nil || quorum <= 0 {
+		return "", "", 0, fmt.Errorf("invalid quorum: %s", fields[3])
+	}
+
+	return fields[0], net.JoinHostPort(fields[1], fields[2]), quorum, nil
+}
+
+// instance is a master, replica or sentinel as seen by this sentinel.
+type instance struct {
+	addr  string
+	runID string // sentinels only
+	link  *link
+
+	lastPingSent time.Time
+	lastPong     time.Time // last valid PING reply
+	pingPending  bool
+	sdown        bool
+
+	// unansweredPing is when the oldest PING not answered yet was sent, zero
+	// once every PING was answered
+	unansweredPing time.Time
+
+	// INFO replication, masters and replicas only
+	lastInfo         time.Time
+	infoPending      bool
+	role             string
+	roleReportedAt   time.Time
+	masterAddr       string
+	masterLinkUp     bool
+	replOffset       int64
+	lastHelloSent    time.Time
+	subscribed       bool
+	subscription     net.Conn
+	removed          bool
+	masterMismatchAt time.Time
+
+	// sentinels only: their last answer to is-master-down-by-addr
+	lastHello    time.Time
+	lastAsk      time.Time
+	askPending   bool
+	masterDown   bool
+	masterDownAt time.Time
+	leader       string
+	leaderEpoch  int64
+}
+
+func newInstance(addr string) *instance {
+	return &instance{
+		addr: addr,
+		link: newLink(addr),
+	}
+}
+
+// master is the monitored master along with what we learned about its
+// replicas and the other sentinels watching it.
+type master struct {
+	*instance
+
+	name        string
+	quorum      int
+	configEpoch int64
+
+	replicas  map[string]*instance // by address
+	sentinels map[string]*instance // by run id
+
+	odownSince time.Time
+
+	// our vote for the failover leader
+	leader      string
+	leaderEpoch int64
+
+	failoverState FailoverState
+	failoverEpoch int64
+	failoverStart time.Time
+	nextAttempt   time.Time
+	promoted      *instance
+}
+
+// Sentinel monitors a master and its replicas, agrees with other sentinels
+// when it is down and promotes one of its replicas.
+type Sentinel struct {
+	mu sync.Mutex
+
+	ID           string
+	config       Config
+	currentEpoch int64
+	master       *master
+	announceIP   string
+
+	// events such as +sdown or +switch-master are published to our own clients
+	events *services.PubSub
+}
+
+func New(config Config, events *services.PubSub) *Sentinel {
+	return &Sentinel{
+		ID:     generateRunID(),
+		config: config,
+		events: events,
+		master: &master{
+			instance:      newInstance(config.MasterAddr),
+			failoverState: NoFailover,
+			name:          config.MasterName,
+			quorum:        config.Quorum,
+			replicas:      make(map[string]*instance),
+			sentinels:     make(map[string]*instance),
+		},
+	}
+}
+
+func generateRunID() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Run drives the monitoring until shutdown is closed.
+func (s *Sentinel) Run(shutdown <-chan struct{}) {
+	ticker := time.NewTicker(tickPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			s.close()
+			return
+		case <-ticker.C:
+			s.tick(time.Now())
+		}
+	}
+}
+
+func (s *Sentinel) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.master
+
+	s.sendPeriodic(m.instance, now, true)
+	for _, r := range m.replicas {
+		s.sendPeriodic(r, now, true)
+	}
+	for _, p := range m.sentinels {
+		s.sendPeriodic(p, now, false)
+	}
+
+	s.checkSubjectivelyDown(m.instance, "master", now)
+	for _, r := range m.replicas {
+		s.checkSubjectivelyDown(r, "slave", now)
+	}
+	for _, p := range m.sentinels {
+		s.checkSubjectivelyDown(p, "sentinel", now)
+	}
+
+	s.checkObjectivelyDown(now)
+	s.askMasterState(now)
+	s.failoverTick(now)
+}
+
+func (s *Sentinel) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeInstance(s.master.instance)
+	for _, r := range s.master.replicas {
+		s.removeInstance(r)
+	}
+	for _, p := range s.master.sentinels {
+		s.removeInstance(p)
+	}
+}
+
+// sendPeriodic pings every instance, and for data instances also refreshes
+// INFO, publishes our hello and listens to the hello of other sentinels.
+func (s *Sentinel) sendPeriodic(inst *instance, now time.Time, dataInstance bool) {
+	if !inst.pingPending && now.Sub(inst.lastPingSent) >= s.pingInterval() {
+		inst.pingPending = true
+		inst.lastPingSent = now
+
+		if inst.unansweredPing.IsZero() {
+			inst.unansweredPing = now
+		}
+
+		go s.ping(inst)
+	}
+
+	if !dataInstance {
+		return
+	}
+
+	period := infoPeriod
+	if !s.master.odownSince.IsZero() || s.master.failoverState != NoFailover {
+		period = failoverInfoPeriod
+	}
+
+	if !inst.infoPending && (inst.lastInfo.IsZero() || now.Sub(inst.lastInfo) >= period) {
+		inst.infoPending = true
+		go s.refreshInfo(inst)
+	}
+
+	if now.Sub(inst.lastHelloSent) >= helloPeriod {
+		inst.lastHelloSent = now
+		go s.publishHello(inst, s.helloLocked())
+	}
+
+	if !inst.subscribed {
+		inst.subscribed = true
+		go s.subscribe(inst)
+	}
+}
+
+// pingInterval is the time between two PINGs to an instance, short enough
+// for an instance to be pinged several times before it is considered down.
+func (s *Sentinel) pingInterval() time.Duration {
+	return min(s.config.DownAfter, pingPeriod)
+}
+
+func (s *Sentinel) ping(inst *instance) {
+	v, err := inst.link.Do("PING")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inst.pingPending = false
+
+	if err != nil {
+		return
+	}
+
+	// a loading or masterdown instance is still alive
+	reply, _ := v.AsString()
+	if reply == "PONG" || strings.HasPrefix(reply, "LOADING") || strings.HasPrefix(reply, "MASTERDOWN") {
+		inst.lastPong = time.Now()
+		inst.unansweredPing = time.Time{}
+	}
+}
+
+// checkSubjectivelyDown considers inst down once a PING went unanswered for
+// longer than down-after-milliseconds. The time since the last reply would
+// also count the time until the next PING is sent.
+func (s *Sentinel) checkSubjectivelyDown(inst *instance, kind string, now time.Time) {
+	down := !inst.unansweredPing.IsZero() && now.Sub(inst.unansweredPing) > s.config.DownAfter
+
+	switch {
+	case down && !inst.sdown:
+		inst.sdown = true
+		s.event("+sdown", kind, inst)
+	case !down && inst.sdown:
+		inst.sdown = false
+		s.event("-sdown", kind, inst)
+	}
+}
+
+func (s *Sentinel) refreshInfo(inst *instance) {
+	v, err := inst.link.Do("INFO", "replication")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inst.infoPending = false
+
+	if err != nil || inst.removed {
+		return
+	}
+
+	info, err := v.AsString()
+	if err != nil {
+		return
+	}
+
+	s.applyInfo(inst, parseInfo(info), time.Now())
+}
+
+// parseInfo turns the key:value lines of INFO into a map.
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+
+	return fields
+}
+
+// parseReplicaLine extracts the address of a replica from a
+// slaveN:ip=...,port=...,state=... line of the master INFO.
+func parseReplicaLine(line string) (string, bool) {
+	var ip, port string
+
+	for _, kv := range strings.Split(line, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+
+		switch k {
+		case "ip":
+			ip = v
+		case "port":
+			port = v
+		}
+	}
+
+	if ip == "" || port == "" || port == "0" {
+		return "", false
+	}
+
+	return net.JoinHostPort(ip, port), true
+}
+
+func (s *Sentinel) applyInfo(inst *instance, info map[string]string, now time.Time) {
+	m := s.master
+
+	inst.lastInfo = now
+
+	if role := info["role"]; role != inst.role {
+		inst.role = role
+		inst.roleReportedAt = now
+	}
+
+	inst.masterAddr = ""
+	if host, port := info["master_host"], info["master_port"]; host != "" && port != "" {
+		inst.masterAddr = net.JoinHostPort(host, port)
+	}
+
+	inst.masterLinkUp = info["master_link_status"] == "up"
+	inst.replOffset, _ = strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+
+	// the master tells us about its replicas
+	if inst == m.instance && inst.role == "master" {
+		for k, v := range info {
+			if !strings.HasPrefix(k, "slave") || strings.HasPrefix(k, "slave_") {
+				continue
+			}
+
+			if addr, ok := parseReplicaLine(v); ok && m.replicas[addr] == nil && addr != m.addr {
+				m.replicas[addr] = newInstance(addr)
+				s.event("+slave", "slave", m.replicas[addr])
+			}
+		}
+	}
+
+	if inst == m.instance {
+		return
+	}
+
+	if m.failoverState == WaitPromotion && inst == m.promoted && inst.role == "master" {
+		s.finishFailover()
+		return
+	}
+
+	if m.failoverState != NoFailover {
+		return
+	}
+
+	s.reconfigureReplica(inst, now)
+}
+
+// reconfigureReplica points replicas that follow the wrong master, or came
+// back as masters after a failover, at the current master.
+func (s *Sentinel) reconfigureReplica(inst *instance, now time.Time) {
+	m := s.master
+
+	if inst.sdown || m.sdown {
+		return
+	}
+
+	switch {
+	case inst.role == "master":
+		if now.Sub(inst.roleReportedAt) < reconfigureDelay {
+			return
+		}
+
+		s.event("+convert-to-slave", "slave", inst)
+	case inst.role == "slave" && inst.masterAddr != "" && inst.masterAddr != m.addr:
+		if inst.masterMismatchAt.IsZero() {
+			inst.masterMismatchAt = now
+		}
+
+		if now.Sub(inst.masterMismatchAt) < reconfigureDelay {
+			return
+		}
+
+		s.event("+fix-slave-config", "slave", inst)
+	default:
+		inst.masterMismatchAt = time.Time{}
+		return
+	}
+
+	inst.masterMismatchAt = time.Time{}
+	inst.roleReportedAt = now
+
+	go s.replicaOf(inst, m.addr)
+}
+
+func (s *Sentinel) replicaOf(inst *instance, addr string) {
+	host, port, _ := net.SplitHostPort(addr)
+
+	if _, err := inst.link.Do("REPLICAOF", host, port); err != nil {
+		fmt.Println("Sentinel: REPLICAOF failed: ", err)
+	}
+}
+
+// helloLocked builds our hello message for the current master config.
+func (s *Sentinel) helloLocked() hello {
+	if ip := s.master.link.LocalIP(); ip != "" {
+		s.announceIP = ip
+	}
+
+	ip := s.announceIP
+	if ip == "" {
+		ip = "127.0.0.1"
+	}
+
+	return hello{
+		addr:              net.JoinHostPort(ip, strconv.Itoa(s.config.Port)),
+		runID:             s.ID,
+		currentEpoch:      s.currentEpoch,
+		masterName:        s.master.name,
+		masterAddr:        s.master.addr,
+		masterConfigEpoch: s.master.configEpoch,
+	}
+}
+
+func (s *Sentinel) publishHello(inst *instance, h hello) {
+	if _, err := inst.link.Do("PUBLISH", helloChannel, h.String()); err != nil {
+		return
+	}
+}
+
+// subscribe listens to the hello channel of inst until it is removed.
+func (s *Sentinel) subscribe(inst *instance) {
+	for {
+		s.mu.Lock()
+		removed := inst.removed
+		s.mu.Unlock()
+
+		if removed {
+			return
+		}
+
+		if err := s.listenHello(inst); err != nil {
+			time.Sleep(pingPeriod)
+		}
+	}
+}
+
+func (s *Sentinel) listenHello(inst *instance) error {
+	conn, err := net.DialTimeout("tcp", inst.addr, linkTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	if inst.removed {
+		s.mu.Unlock()
+		return nil
+	}
+	inst.subscription = conn
+	s.mu.Unlock()
+
+	if _, err := conn.Write(command("SUBSCRIBE", helloChannel)); err != nil {
+		return err
+	}
+
+	rd := resp.NewReader(conn)
+
+	for {
+		v, _, err := rd.ReadValue()
+		if err != nil {
+			return err
+		}
+
+		values, err := v.AsArray()
+		if err != nil || len(values) != 3 {
+			continue
+		}
+
+		if kind, _ := values[0].AsString(); kind != "message" {
+			continue
+		}
+
+		msg, _ := values[2].AsString()
+
+		h, err := parseHello(msg)
+		if err != nil {
+			continue
+		}
+
+		s.processHello(h, time.Now())
+	}
+}
+
+// processHello learns about other sentinels and adopts a master config with
+// a newer config epoch.
+func (s *Sentinel) processHello(h hello, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.master
+
+	if h.runID == s.ID || h.masterName != m.name {
+		return
+	}
+
+	peer := m.sentinels[h.runID]
+	if peer == nil {
+		// a sentinel restarted under a new run id replaces the old entry
+		for id, p := range m.sentinels {
+			if p.addr == h.addr {
+				s.removeInstance(p)
+				delete(m.sentinels, id)
+			}
+		}
+
+		peer = newInstance(h.addr)
+		peer.runID = h.runID
+		m.sentinels[h.runID] = peer
+		s.event("+sentinel", "sentinel", peer)
+	} else if peer.addr != h.addr {
+		go peer.link.Close()
+		peer.addr = h.addr
+		peer.link = newLink(h.addr)
+	}
+
+	peer.lastHello = now
+
+	if h.currentEpoch > s.currentEpoch {
+		s.currentEpoch = h.currentEpoch
+		s.logEvent("+new-epoch", strconv.FormatInt(s.currentEpoch, 10))
+	}
+
+	if h.masterConfigEpoch > m.configEpoch {
+		if h.masterAddr != m.addr {
+			s.switchMaster(h.masterAddr)
+		}
+
+		m.configEpoch = h.masterConfigEpoch
+	}
+}
+
+// removeInstance stops all the background work for inst.
+func (s *Sentinel) removeInstance(inst *instance) {
+	inst.removed = true
+
+	// the link may be busy with a command, don't hold up the caller
+	go inst.link.Close()
+
+	if inst.subscription != nil {
+		inst.subscription.Close()
+	}
+}
+
+func (s *Sentinel) event(typ, kind string, inst *instance) {
+	host, port, _ := net.SplitHostPort(inst.addr)
+
+	switch kind {
+	case "master":
+		s.logEvent(typ, kind, s.master.name, host, port)
+	case "sentinel":
+		s.logEvent(typ, kind, inst.runID, host, port, "@", s.master.masterDescription())
+	default:
+		s.logEvent(typ, kind, inst.addr, host, port, "@", s.master.masterDescription())
+	}
+}
+
+func (s *Sentinel) logEvent(typ string, fields ...string) {
+	msg := strings.Join(fields, " ")
+
+	fmt.Printf("[SENTINEL] %s %s\n", typ, msg)
+	s.events.Publish(typ, msg)
+}
+
+func (m *master) masterDescription() string {
+	host, port, _ := net.SplitHostPort(m.addr)
+
+	return strings.Join([]string{m.name, host, port}, " ")
+}
@@ -0,0 +1,246 @@
+package sentinel
+
+import (
+	"bufio"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/services"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestSentinel(quorum int) *Sentinel {
+	return New(Config{
+		MasterName:      "mymaster",
+		MasterAddr:      "127.0.0.1:6379",
+		Quorum:          quorum,
+		DownAfter:       time.Second,
+		FailoverTimeout: 10 * time.Second,
+		Port:            26379,
+	}, services.NewPubSub(nil))
+}
+
+func addPeer(s *Sentinel, runID, addr string) *instance {
+	p := newInstance(addr)
+	p.runID = runID
+	s.master.sentinels[runID] = p
+
+	return p
+}
+
+func TestParseMonitor(t *testing.T) {
+	name, addr, quorum, err := ParseMonitor("mymaster 127.0.0.1 6379 2")
+	assert.NoError(t, err)
+	assert.Equal(t, "mymaster", name)
+	assert.Equal(t, "127.0.0.1:6379", addr)
+	assert.Equal(t, 2, quorum)
+
+	_, _, _, err = ParseMonitor("mymaster 127.0.0.1 6379")
+	assert.Error(t, err)
+
+	_, _, _, err = ParseMonitor("mymaster 127.0.0.1 6379 0")
+	assert.Error(t, err, "quorum must be positive")
+}
+
+func TestHello_RoundTrip(t *testing.T) {
+	h := hello{
+		addr:              "127.0.0.1:26379",
+		runID:             "abc",
+		currentEpoch:      3,
+		masterName:        "mymaster",
+		masterAddr:        "127.0.0.1:6380",
+		masterConfigEpoch: 2,
+	}
+
+	assert.Equal(t, "127.0.0.1,26379,abc,3,mymaster,127.0.0.1,6380,2", h.String())
+
+	parsed, err := parseHello(h.String())
+	assert.NoError(t, err)
+	assert.Equal(t, h, parsed)
+
+	_, err = parseHello("127.0.0.1,26379,abc")
+	assert.ErrorIs(t, err, invalidHello)
+}
+
+func TestParseInfo_Replicas(t *testing.T) {
+	info := parseInfo("# Replication\r\nrole:master\r\nconnected_slaves:1\r\nslave0:ip=127.0.0.1,port=6380,state=online,offset=10,lag=0\r\n")
+
+	assert.Equal(t, "master", info["role"])
+
+	addr, ok := parseReplicaLine(info["slave0"])
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1:6380", addr)
+
+	_, ok = parseReplicaLine("ip=127.0.0.1,port=0,state=online")
+	assert.False(t, ok, "replicas that did not announce a port cannot be monitored")
+}
+
+func TestProcessHello_AdoptsNewerConfig(t *testing.T) {
+	s := newTestSentinel(2)
+	s.master.replicas["127.0.0.1:6380"] = newInstance("127.0.0.1:6380")
+
+	s.processHello(hello{
+		addr:              "127.0.0.1:26380",
+		runID:             "other",
+		currentEpoch:      5,
+		masterName:        "mymaster",
+		masterAddr:        "127.0.0.1:6380",
+		masterConfigEpoch: 5,
+	}, time.Now())
+
+	assert.Contains(t, s.master.sentinels, "other")
+	assert.Equal(t, int64(5), s.currentEpoch)
+	assert.Equal(t, "127.0.0.1:6380", s.master.addr)
+	assert.Equal(t, int64(5), s.master.configEpoch)
+	assert.Contains(t, s.master.replicas, "127.0.0.1:6379", "the old master should be monitored as a replica")
+	assert.NotContains(t, s.master.replicas, "127.0.0.1:6380")
+}
+
+func TestVoteLeader_FirstRequestWins(t *testing.T) {
+	s := newTestSentinel(2)
+	now := time.Now()
+
+	leader, epoch := s.voteLeader(1, "a", now)
+	assert.Equal(t, "a", leader)
+	assert.Equal(t, int64(1), epoch)
+
+	leader, epoch = s.voteLeader(1, "b", now)
+	assert.Equal(t, "a", leader, "only one vote per epoch")
+	assert.Equal(t, int64(1), epoch)
+
+	leader, epoch = s.voteLeader(2, "b", now)
+	assert.Equal(t, "b", leader)
+	assert.Equal(t, int64(2), epoch)
+}
+
+func TestElectedLeader_NeedsMajority(t *testing.T) {
+	s := newTestSentinel(2)
+	a := addPeer(s, "a", "127.0.0.1:26380")
+	b := addPeer(s, "b", "127.0.0.1:26381")
+
+	s.voteLeader(1, s.ID, time.Now())
+	assert.Empty(t, s.electedLeader(1), "our own vote is not a majority")
+
+	a.leader, a.leaderEpoch = "b", 1
+	b.leader, b.leaderEpoch = s.ID, 1
+	assert.Equal(t, s.ID, s.electedLeader(1))
+
+	b.leaderEpoch = 0
+	assert.Empty(t, s.electedLeader(1), "votes from another epoch do not count")
+}
+
+func TestCheckSubjectivelyDown(t *testing.T) {
+	s := newTestSentinel(2)
+	m := s.master.instance
+	now := time.Now()
+
+	// pinged every second, the last reply of a healthy master is often older
+	// than down-after-milliseconds
+	m.lastPong = now.Add(-1500 * time.Millisecond)
+	s.checkSubjectivelyDown(m, "master", now)
+	assert.False(t, m.sdown, "every PING was answered")
+
+	m.unansweredPing = now.Add(-900 * time.Millisecond)
+	s.checkSubjectivelyDown(m, "master", now)
+	assert.False(t, m.sdown)
+
+	m.unansweredPing = now.Add(-1100 * time.Millisecond)
+	s.checkSubjectivelyDown(m, "master", now)
+	assert.True(t, m.sdown)
+
+	m.unansweredPing = time.Time{}
+	s.checkSubjectivelyDown(m, "master", now)
+	assert.False(t, m.sdown, "back once it replied")
+
+	assert.Equal(t, time.Second, s.pingInterval())
+
+	s.config.DownAfter = 300 * time.Millisecond
+	assert.Equal(t, 300*time.Millisecond, s.pingInterval(), "pinged at least once per down-after-milliseconds")
+}
+
+func TestCheckObjectivelyDown(t *testing.T) {
+	s := newTestSentinel(2)
+	p := addPeer(s, "a", "127.0.0.1:26380")
+	now := time.Now()
+
+	s.master.sdown = true
+	s.checkObjectivelyDown(now)
+	assert.True(t, s.master.odownSince.IsZero())
+
+	p.masterDown, p.masterDownAt = true, now
+	s.checkObjectivelyDown(now)
+	assert.False(t, s.master.odownSince.IsZero())
+
+	s.checkObjectivelyDown(now.Add(askValidity))
+	assert.True(t, s.master.odownSince.IsZero(), "stale replies should not count")
+}
+
+func TestSelectReplica(t *testing.T) {
+	s := newTestSentinel(1)
+	now := time.Now()
+
+	replica := func(addr string, offset int64) *instance {
+		r := newInstance(addr)
+		r.role = "slave"
+		r.lastPong, r.lastInfo = now, now
+		r.replOffset = offset
+		s.master.replicas[addr] = r
+
+		return r
+	}
+
+	replica("127.0.0.1:6380", 10)
+	best := replica("127.0.0.1:6381", 20)
+	down := replica("127.0.0.1:6382", 30)
+	down.sdown = true
+
+	assert.Same(t, best, s.selectReplica(now))
+
+	best.lastPong = now.Add(-time.Minute)
+	assert.Equal(t, "127.0.0.1:6380", s.selectReplica(now).addr)
+}
+
+func TestLink_Do(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// an instance replying to INFO with a verbatim string, then to ROLE
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rd := resp.NewReader(bufio.NewReader(conn))
+		for _, reply := range []string{
+			"=32\r\ntxt:# Replication\r\nrole:master\r\n\r\n",
+			"*3\r\n$6\r\nmaster\r\n:42\r\n*0\r\n",
+		} {
+			if _, _, err := rd.ReadValue(); err != nil {
+				return
+			}
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	l := newLink(ln.Addr().String())
+	defer l.Close()
+
+	v, err := l.Do("INFO", "replication")
+	assert.NoError(t, err)
+	info, _ := v.AsString()
+	assert.Equal(t, "master", parseInfo(info)["role"], "the reply spans several lines")
+
+	v, err = l.Do("ROLE")
+	assert.NoError(t, err)
+	values, _ := v.AsArray()
+	assert.Len(t, values, 3)
+	offset, _ := values[1].AsInt()
+	assert.Equal(t, 42, offset)
+	assert.Equal(t, "127.0.0.1", l.LocalIP())
+}
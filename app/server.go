@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/commands"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/sentinel"
 	"github.com/codecrafters-io/redis-starter-go/app/services"
 	"github.com/codecrafters-io/redis-starter-go/app/store"
 	"github.com/codecrafters-io/redis-starter-go/app/tcp"
@@ -13,6 +16,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -20,6 +24,10 @@ func main() {
 	fmt.Println("Starting server...")
 	flag.Parse()
 
+	if *services.Config.Sentinel && !isFlagSet("port") {
+		*services.Config.Port = sentinelPort
+	}
+
 	addr := fmt.Sprintf("%s:%v", *services.Config.Host, *services.Config.Port)
 	server, err := NewTcpServer(addr)
 
@@ -40,13 +48,70 @@ func main() {
 
 }
 
+// sentinels listen on their own default port
+const sentinelPort = 26379
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// activeExpireInterval is how often keys that expired without being read are
+// collected.
+const activeExpireInterval = 100 * time.Millisecond
+
+// activeExpire collects expired keys on a master, between transactions, and
+// replicates their deletion: replicas only lose keys their master deleted.
+func activeExpire(s *store.Memory, replication *services.ReplicationService, transactions *commands.TransactionService, shutdown <-chan struct{}) {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			if !replication.IsMaster() {
+				continue
+			}
+
+			unlock := transactions.Shared()
+
+			for _, key := range s.ExpireCycle() {
+				del := resp.ArrayValue(resp.BulkStringValue("DEL"), resp.BulkStringValue(key))
+				b, _ := del.Marshal()
+
+				replication.Propagate(b)
+			}
+
+			unlock()
+		}
+	}
+}
+
 func NewTcpServer(listAddr string) (tcp.Server, error) {
-	ln, err := net.Listen("tcp", listAddr)
-	replication := services.NewReplicationService(services.Config)
+	var ln net.Listener
 
+	// port 0 accepts TLS connections only
+	if *services.Config.Port != 0 || *services.Config.TlsPort == 0 {
+		var err error
+		if ln, err = net.Listen("tcp", listAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsLn, replicationTLS, err := listenTLS()
 	if err != nil {
 		return nil, err
 	}
+
+	replication := services.NewReplicationService(services.Config)
 	s := store.NewMemory()
 
 	var (
@@ -69,22 +134,76 @@ func NewTcpServer(listAddr string) (tcp.Server, error) {
 		}
 	}
 
+	clients := services.NewClients()
+
+	limits, err := services.ParseOutputLimits(*services.Config.ClientOutputBufferLimit, clients.OutputLimits())
+	if err != nil {
+		return nil, fmt.Errorf("client-output-buffer-limit: %w", err)
+	}
+
+	clients.SetOutputLimits(limits)
+
+	acl := commands.NewACLService(*services.Config.AclFile)
+	if *services.Config.AclFile != "" {
+		if err := acl.Load(); err != nil {
+			return nil, fmt.Errorf("aclfile: %w", err)
+		}
+	}
+
+	if *services.Config.RequirePass != "" {
+		acl.SetRequirePass(*services.Config.RequirePass)
+	}
+
+	pubSub := services.NewPubSub(clients)
+
+	keyspace := services.NewKeyspaceEvents(pubSub)
+	if err := keyspace.SetFlags(*services.Config.NotifyKeyspaceEvents); err != nil {
+		return nil, fmt.Errorf("notify-keyspace-events: %w", err)
+	}
+
+	s.SetNotifier(keyspace)
+
 	baseServer := &tcp.BaseServer{
 		ListAddr:    listAddr,
 		Listener:    ln,
+		TLSListener: tlsLn,
 		Connections: make(chan net.Conn),
 		Shutdown:    make(chan struct{}),
 		Datastore:   s,
 		Replication: replication,
+		PubSub:      pubSub,
+		Blocking:    services.NewBlocking(),
+		Keyspace:    keyspace,
+		Clients:     clients,
 
-		Transactions: commands.NewTransactionService(),
+		Transactions:   commands.NewTransactionService(),
+		ACL:            acl,
+		ReplicationTLS: replicationTLS,
 	}
 
-	if replication.IsMaster() {
-		baseServer.CommandsChannel = make(chan []byte, 100)
+	if *services.Config.Sentinel {
+		name, masterAddr, quorum, err := sentinel.ParseMonitor(*services.Config.SentinelMonitor)
+		if err != nil {
+			return nil, err
+		}
+
+		baseServer.Sentinel = sentinel.New(sentinel.Config{
+			MasterName:      name,
+			MasterAddr:      masterAddr,
+			Quorum:          quorum,
+			DownAfter:       time.Duration(*services.Config.SentinelDownAfter) * time.Millisecond,
+			FailoverTimeout: time.Duration(*services.Config.SentinelFailoverTimeout) * time.Millisecond,
+			Port:            *services.Config.Port,
+		}, baseServer.PubSub)
+
+		return &tcp.SentinelServer{
+			BaseServer: baseServer,
+		}, nil
 	}
 
-	switch replication.Role {
+	go activeExpire(s, replication, baseServer.Transactions, baseServer.Shutdown)
+
+	switch replication.GetRole() {
 	case services.Master:
 		return &tcp.MasterServer{
 			BaseServer: baseServer,
@@ -94,6 +213,49 @@ func NewTcpServer(listAddr string) (tcp.Server, error) {
 			BaseServer: baseServer,
 		}, nil
 	default:
-		return nil, fmt.Errorf("unknown role: %s", replication.Role)
+		return nil, fmt.Errorf("unknown role: %s", replication.GetRole())
+	}
+}
+
+// listenTLS listens for TLS connections on tls-port, and returns the
+// configuration of the link with the master when tls-replication is set.
+func listenTLS() (net.Listener, *tls.Config, error) {
+	options := tcp.TLSOptions{
+		CertFile:    *services.Config.TlsCertFile,
+		KeyFile:     *services.Config.TlsKeyFile,
+		CACertFile:  *services.Config.TlsCaCertFile,
+		AuthClients: *services.Config.TlsAuthClients,
 	}
+
+	var (
+		ln             net.Listener
+		replicationTLS *tls.Config
+	)
+
+	if *services.Config.TlsPort != 0 {
+		config, err := tcp.ServerTLSConfig(options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		addr := fmt.Sprintf("%s:%v", *services.Config.Host, *services.Config.TlsPort)
+		if ln, err = tls.Listen("tcp", addr, config); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if *services.Config.TlsReplication {
+		config, err := tcp.ClientTLSConfig(options)
+		if err != nil {
+			if ln != nil {
+				ln.Close()
+			}
+
+			return nil, nil, err
+		}
+
+		replicationTLS = config
+	}
+
+	return ln, replicationTLS, nil
 }
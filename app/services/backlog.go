@@ -0,0 +1,53 @@
+package services
+
+// backlog keeps the tail of the replication stream so a replica that lost its
+// link can continue from its own offset instead of doing a full resync.
+type backlog struct {
+	size  int
+	start int64 // replication offset of the first byte in buf
+	buf   []byte
+}
+
+const defaultBacklogSize = 1024 * 1024
+
+func newBacklog(size int, offset int64) *backlog {
+	return &backlog{
+		size:  size,
+		start: offset,
+		buf:   make([]byte, 0, size),
+	}
+}
+
+func (b *backlog) Write(p []byte) {
+	b.buf = append(b.buf, p...)
+
+	if overflow := len(b.buf) - b.size; overflow > 0 {
+		b.buf = append(b.buf[:0], b.buf[overflow:]...)
+		b.start += int64(overflow)
+	}
+}
+
+// End is the replication offset right after the last byte in the backlog.
+func (b *backlog) End() int64 {
+	return b.start + int64(len(b.buf))
+}
+
+// Since returns a copy of the stream after offset, and false when offset is
+// no longer (or not yet) covered by the backlog.
+func (b *backlog) Since(offset int64) ([]byte, bool) {
+	if offset < b.start || offset > b.End() {
+		return nil, false
+	}
+
+	tail := b.buf[offset-b.start:]
+	out := make([]byte, len(tail))
+	copy(out, tail)
+
+	return out, true
+}
+
+// Reset drops the history and restarts the backlog at offset.
+func (b *backlog) Reset(offset int64) {
+	b.buf = b.buf[:0]
+	b.start = offset
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBacklog_Since(t *testing.T) {
+	b := newBacklog(8, 0)
+	b.Write([]byte("abcd"))
+
+	tail, ok := b.Since(2)
+	assert.True(t, ok)
+	assert.Equal(t, "cd", string(tail))
+
+	tail, ok = b.Since(4)
+	assert.True(t, ok, "the current end offset should be a valid continuation point")
+	assert.Empty(t, tail)
+
+	_, ok = b.Since(5)
+	assert.False(t, ok, "offsets past the end cannot be served")
+}
+
+func TestBacklog_Overflow(t *testing.T) {
+	b := newBacklog(4, 10)
+	b.Write([]byte("abc"))
+	b.Write([]byte("def"))
+
+	assert.Equal(t, int64(12), b.start)
+	assert.Equal(t, int64(16), b.End())
+
+	_, ok := b.Since(11)
+	assert.False(t, ok, "trimmed offsets should not be served")
+
+	tail, ok := b.Since(13)
+	assert.True(t, ok)
+	assert.Equal(t, "def", string(tail))
+}
+
+func TestBacklog_Reset(t *testing.T) {
+	b := newBacklog(4, 0)
+	b.Write([]byte("abc"))
+	b.Reset(100)
+
+	_, ok := b.Since(1)
+	assert.False(t, ok)
+
+	tail, ok := b.Since(100)
+	assert.True(t, ok)
+	assert.Empty(t, tail)
+}
@@ -0,0 +1,85 @@
+package services
+
+import "sync"
+
+// Blocking keeps track of the clients blocked on keys, e.g. by XREAD BLOCK,
+// and wakes those waiting on a key once it is signaled as ready. Clients wait
+// on key names, so a key created after the client blocked still wakes it.
+type Blocking struct {
+	mu      sync.Mutex
+	waiters map[string]map[*Waiter]struct{}
+}
+
+// Waiter is a client blocked on a set of keys.
+type Waiter struct {
+	keys []string
+
+	// ready holds at most one pending wake up, signals received while one
+	// is pending are merged into it
+	ready chan struct{}
+}
+
+func NewBlocking() *Blocking {
+	return &Blocking{
+		waiters: make(map[string]map[*Waiter]struct{}),
+	}
+}
+
+// Block registers a client waiting on keys until Unblock is called. It is
+// called before looking for data, so that a change made in between is not
+// missed.
+func (b *Blocking) Block(keys []string) *Waiter {
+	w := &Waiter{keys: keys, ready: make(chan struct{}, 1)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		if b.waiters[key] == nil {
+			b.waiters[key] = make(map[*Waiter]struct{})
+		}
+
+		b.waiters[key][w] = struct{}{}
+	}
+
+	return w
+}
+
+func (b *Blocking) Unblock(w *Waiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range w.keys {
+		delete(b.waiters[key], w)
+
+		if len(b.waiters[key]) == 0 {
+			delete(b.waiters, key)
+		}
+	}
+}
+
+// SignalKeyAsReady wakes the clients blocked on key.
+func (b *Blocking) SignalKeyAsReady(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for w := range b.waiters[key] {
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Blocked returns the number of clients blocked on key.
+func (b *Blocking) Blocked(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.waiters[key])
+}
+
+// Ready is signaled when one of the keys of the waiter may have changed.
+func (w *Waiter) Ready() <-chan struct{} {
+	return w.ready
+}
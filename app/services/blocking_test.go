@@ -0,0 +1,37 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBlocking(t *testing.T) {
+	b := NewBlocking()
+
+	w1 := b.Block([]string{"a", "b"})
+	w2 := b.Block([]string{"b"})
+
+	assert.Equal(t, 1, b.Blocked("a"))
+	assert.Equal(t, 2, b.Blocked("b"))
+
+	b.SignalKeyAsReady("a")
+	b.SignalKeyAsReady("a")
+
+	assert.Len(t, w1.Ready(), 1, "wake ups are merged")
+	assert.Len(t, w2.Ready(), 0, "only the clients blocked on the key are woken")
+
+	<-w1.Ready()
+
+	b.SignalKeyAsReady("b")
+	assert.Len(t, w1.Ready(), 1)
+	assert.Len(t, w2.Ready(), 1)
+
+	b.Unblock(w1)
+	assert.Equal(t, 0, b.Blocked("a"))
+	assert.Equal(t, 1, b.Blocked("b"))
+
+	b.Unblock(w2)
+	assert.Empty(t, b.waiters)
+
+	b.SignalKeyAsReady("c")
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"net"
+	"slices"
+	"sync"
+)
+
+// Clients keeps the state of the client connections: what they negotiated
+// with HELLO, and the output buffer their replies go through.
+type Clients struct {
+	mu      sync.RWMutex
+	clients map[net.Conn]*Client
+	nextID  int64
+
+	limitsMu sync.RWMutex
+	limits   OutputLimits
+}
+
+type Client struct {
+	ID       int64
+	Name     string
+	Protocol int
+
+	// User is the user the client authenticated as, none until it did
+	User string
+
+	output *Output
+
+	// gone is closed once the client closed the connection
+	gone   chan struct{}
+	hungUp bool
+}
+
+func NewClients() *Clients {
+	limits, _ := ParseOutputLimits(DefaultOutputLimits, OutputLimits{})
+
+	return &Clients{
+		clients: make(map[net.Conn]*Client),
+		limits:  limits,
+	}
+}
+
+// Add registers a new connection, speaking RESP2 until it says otherwise.
+func (c *Clients) Add(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.client(conn)
+}
+
+// client returns the state of conn, created on first use. It must be called
+// with c.mu held.
+func (c *Clients) client(conn net.Conn) *Client {
+	client, ok := c.clients[conn]
+	if !ok {
+		c.nextID++
+		client = &Client{
+			ID:       c.nextID,
+			Protocol: resp.RESP2,
+			output:   NewOutput(conn, conn, c.OutputLimits),
+			gone:     make(chan struct{}),
+		}
+		c.clients[conn] = client
+	}
+
+	return client
+}
+
+// Get returns a copy of the state of conn.
+func (c *Clients) Get(conn net.Conn) Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return *c.client(conn)
+}
+
+// Protocol returns the protocol version replies to conn are encoded with.
+func (c *Clients) Protocol(conn net.Conn) int {
+	if c == nil || conn == nil {
+		return resp.RESP2
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if client, ok := c.clients[conn]; ok {
+		return client.Protocol
+	}
+
+	return resp.RESP2
+}
+
+// SetProtocol switches conn to the given protocol version.
+func (c *Clients) SetProtocol(conn net.Conn, protocol int) {
+	c.update(conn, func(client *Client) {
+		client.Protocol = protocol
+	})
+}
+
+func (c *Clients) SetName(conn net.Conn, name string) {
+	c.update(conn, func(client *Client) {
+		client.Name = name
+	})
+}
+
+// SetUser records that conn authenticated as user.
+func (c *Clients) SetUser(conn net.Conn, user string) {
+	c.update(conn, func(client *Client) {
+		client.User = user
+	})
+}
+
+// DisconnectUsers closes the connections authenticated as one of users,
+// e.g. once removed.
+func (c *Clients) DisconnectUsers(users ...string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for conn, client := range c.clients {
+		if slices.Contains(users, client.User) {
+			conn.Close()
+		}
+	}
+}
+
+func (c *Clients) update(conn net.Conn, change func(client *Client)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	change(c.client(conn))
+}
+
+// Output returns the output buffer of conn.
+func (c *Clients) Output(conn net.Conn) *Output {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.client(conn).output
+}
+
+// Gone returns a channel closed once the client closed conn, which wakes up
+// a command blocked on its behalf.
+func (c *Clients) Gone(conn net.Conn) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.client(conn).gone
+}
+
+// Hangup records that the client closed conn.
+func (c *Clients) Hangup(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[conn]; ok {
+		client.hangup()
+	}
+}
+
+func (client *Client) hangup() {
+	if !client.hungUp {
+		client.hungUp = true
+		close(client.gone)
+	}
+}
+
+// OutputLimits returns the limits of the output buffers, per client class.
+func (c *Clients) OutputLimits() OutputLimits {
+	c.limitsMu.RLock()
+	defer c.limitsMu.RUnlock()
+
+	return c.limits
+}
+
+// SetOutputLimits changes the limits of the output buffers, including the
+// ones of the connected clients.
+func (c *Clients) SetOutputLimits(limits OutputLimits) {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+
+	c.limits = limits
+}
+
+// Forget drops the state of a connection done with, which is closed once
+// the replies left are written.
+func (c *Clients) Forget(conn net.Conn) {
+	c.mu.Lock()
+	client, ok := c.clients[conn]
+	delete(c.clients, conn)
+
+	if ok {
+		client.hangup()
+	}
+	c.mu.Unlock()
+
+	if ok {
+		client.output.Close()
+	}
+}
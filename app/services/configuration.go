@@ -8,6 +8,31 @@ type Configuration struct {
 	Port       *int
 	Host       *string
 	ReplicaOf  *string
+	MasterAuth *string
+	MasterUser *string
+
+	MinReplicasToWrite *int
+	MinReplicasMaxLag  *int
+
+	NotifyKeyspaceEvents *string
+
+	ProtoMaxBulkLen         *int
+	ClientOutputBufferLimit *string
+
+	RequirePass *string
+	AclFile     *string
+
+	TlsPort        *int
+	TlsCertFile    *string
+	TlsKeyFile     *string
+	TlsCaCertFile  *string
+	TlsAuthClients *string
+	TlsReplication *bool
+
+	Sentinel                *bool
+	SentinelMonitor         *string
+	SentinelDownAfter       *int
+	SentinelFailoverTimeout *int
 }
 
 // Config not the brightest idea 💡
@@ -17,4 +42,29 @@ var Config = Configuration{
 	Port:       flag.Int("port", 6379, "Port to listen on"),
 	Host:       flag.String("host", "0.0.0.0", "Host to listen on"),
 	ReplicaOf:  flag.String("replicaof", "", "ReplicaOf mode"),
+	MasterAuth: flag.String("masterauth", "", "Password authenticating with the master"),
+	MasterUser: flag.String("masteruser", "", "User authenticating with the master, the default user when empty"),
+
+	MinReplicasToWrite: flag.Int("min-replicas-to-write", 0, "Minimum number of good replicas required to accept writes"),
+	MinReplicasMaxLag:  flag.Int("min-replicas-max-lag", 10, "Maximum lag in seconds for a replica to be considered good"),
+
+	NotifyKeyspaceEvents: flag.String("notify-keyspace-events", "", "Keyspace events published to subscribers, e.g. KEA"),
+
+	ProtoMaxBulkLen:         flag.Int("proto-max-bulk-len", 512*1024*1024, "Maximum length in bytes of a bulk string sent by a client"),
+	ClientOutputBufferLimit: flag.String("client-output-buffer-limit", DefaultOutputLimits, "Output buffer limits per client class, as \"<class> <hard> <soft> <soft seconds>\"..."),
+
+	RequirePass: flag.String("requirepass", "", "Password of the default user, which needs none when empty"),
+	AclFile:     flag.String("aclfile", "", "File the users are loaded from, and saved to by ACL SAVE"),
+
+	TlsPort:        flag.Int("tls-port", 0, "Port to accept TLS connections on, none when 0"),
+	TlsCertFile:    flag.String("tls-cert-file", "", "Certificate presented to clients, and to the master with tls-replication"),
+	TlsKeyFile:     flag.String("tls-key-file", "", "Private key of tls-cert-file"),
+	TlsCaCertFile:  flag.String("tls-ca-cert-file", "", "CA certificates client and master certificates are verified with"),
+	TlsAuthClients: flag.String("tls-auth-clients", "yes", "Whether TLS clients must present a certificate: yes, optional or no"),
+	TlsReplication: flag.Bool("tls-replication", false, "Connect to the master over TLS"),
+
+	Sentinel:                flag.Bool("sentinel", false, "Run as a sentinel monitoring --sentinel-monitor"),
+	SentinelMonitor:         flag.String("sentinel-monitor", "", "Master to monitor as \"<master-name> <ip> <port> <quorum>\""),
+	SentinelDownAfter:       flag.Int("sentinel-down-after-milliseconds", 30000, "Time without a valid PING reply before an instance is considered down"),
+	SentinelFailoverTimeout: flag.Int("sentinel-failover-timeout", 180000, "Time in milliseconds a failover may take before it is aborted"),
 }
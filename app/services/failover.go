@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+type FailoverState string
+
+const (
+	NoFailover         FailoverState = "no-failover"
+	WaitingForSync     FailoverState = "waiting-for-sync"
+	FailoverInProgress FailoverState = "failover-in-progress"
+)
+
+// FailoverOptions mirrors FAILOVER [TO host port [FORCE]] [TIMEOUT ms].
+type FailoverOptions struct {
+	Host    string
+	Port    string
+	Force   bool
+	Timeout time.Duration
+}
+
+type failover struct {
+	mu     sync.Mutex
+	state  FailoverState
+	cancel context.CancelFunc
+}
+
+func (i *ReplicationService) FailoverState() FailoverState {
+	i.failover.mu.Lock()
+	defer i.failover.mu.Unlock()
+
+	return i.failover.state
+}
+
+func (i *ReplicationService) setFailoverState(state FailoverState) {
+	i.failover.mu.Lock()
+	defer i.failover.mu.Unlock()
+
+	i.failover.state = state
+}
+
+// Failover starts a coordinated failover to a replica: client writes are
+// paused, the target is given time to catch up with our offset, and we then
+// become its replica asking it to take over through PSYNC FAILOVER.
+func (i *ReplicationService) Failover(opts FailoverOptions) error {
+	if !i.IsMaster() {
+		return errors.New("ERR FAILOVER is not valid when server is a replica.")
+	}
+
+	i.failover.mu.Lock()
+	defer i.failover.mu.Unlock()
+
+	if i.failover.state != NoFailover {
+		return errors.New("ERR FAILOVER already in progress.")
+	}
+
+	target, err := i.failoverTarget(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+	}
+
+	abortCtx, abort := context.WithCancel(context.Background())
+
+	i.failover.state = WaitingForSync
+	i.failover.cancel = abort
+	i.PauseWrites()
+
+	go func() {
+		defer cancel()
+
+		synced := i.waitForReplicaSync(ctx, abortCtx, target)
+
+		if abortCtx.Err() != nil {
+			return
+		}
+
+		if !synced && !opts.Force {
+			fmt.Println("FAILOVER target did not catch up in time, aborting")
+			i.endFailover()
+			return
+		}
+
+		fmt.Println("FAILOVER handing over to", target.Addr())
+		i.setFailoverState(FailoverInProgress)
+		i.BecomeReplicaOf(target.Addr())
+	}()
+
+	return nil
+}
+
+// failoverTarget returns the replica matching opts, or the one with the
+// highest acknowledged offset when no target was given.
+func (i *ReplicationService) failoverTarget(opts FailoverOptions) (*Replica, error) {
+	i.ReplicaMutex.RLock()
+	defer i.ReplicaMutex.RUnlock()
+
+	if len(i.Replicas) == 0 {
+		return nil, errors.New("ERR FAILOVER requires connected replicas.")
+	}
+
+	var target *Replica
+
+	for _, r := range i.Replicas {
+		if opts.Host == "" {
+			if target == nil || r.AckOffset.Load() > target.AckOffset.Load() {
+				target = r
+			}
+			continue
+		}
+
+		if sameAddr(r.Addr(), net.JoinHostPort(opts.Host, opts.Port)) {
+			target = r
+		}
+	}
+
+	if target == nil {
+		return nil, errors.New("ERR FAILOVER target HOST and PORT is not a replica.")
+	}
+
+	return target, nil
+}
+
+func sameAddr(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	ra, errA := net.ResolveTCPAddr("tcp", a)
+	rb, errB := net.ResolveTCPAddr("tcp", b)
+
+	return errA == nil && errB == nil && ra.IP.Equal(rb.IP) && ra.Port == rb.Port
+}
+
+// waitForReplicaSync waits until target acknowledged everything we sent.
+func (i *ReplicationService) waitForReplicaSync(ctx, abort context.Context, target *Replica) bool {
+	offset := i.GetReplOffset()
+	i.RequestAcks()
+
+	for {
+		i.ackMu.Lock()
+		acked := i.acked
+		i.ackMu.Unlock()
+
+		if target.AckOffset.Load() >= offset {
+			return true
+		}
+
+		select {
+		case <-acked:
+		case <-ctx.Done():
+			return false
+		case <-abort.Done():
+			return false
+		}
+	}
+}
+
+// AbortFailover implements FAILOVER ABORT. Once we handed over to the target
+// it is up to the replication link to complete or fail the failover.
+func (i *ReplicationService) AbortFailover() error {
+	i.failover.mu.Lock()
+	state, cancel := i.failover.state, i.failover.cancel
+	i.failover.mu.Unlock()
+
+	if state == NoFailover {
+		return errors.New("ERR No failover in progress.")
+	}
+
+	if state == FailoverInProgress {
+		i.revertFailover()
+		return nil
+	}
+
+	cancel()
+	i.endFailover()
+
+	return nil
+}
+
+// FailoverLinkFailed is called when the handshake with our new master fails.
+// A failover in progress is reverted so we keep acting as the master.
+func (i *ReplicationService) FailoverLinkFailed() {
+	if i.FailoverState() == FailoverInProgress {
+		fmt.Println("FAILOVER target refused the handover, reverting to master")
+		i.revertFailover()
+	}
+}
+
+func (i *ReplicationService) revertFailover() {
+	i.roleMu.Lock()
+	i.Role = Master
+	i.MasterAddr = ""
+	close(i.roleChanged)
+	i.roleChanged = make(chan struct{})
+	i.roleMu.Unlock()
+
+	i.dropMasterLink()
+	i.endFailover()
+}
+
+// completeFailover is called once our new master accepted us as its replica.
+func (i *ReplicationService) completeFailover() {
+	if i.FailoverState() == FailoverInProgress {
+		fmt.Println("FAILOVER completed")
+		i.endFailover()
+	}
+}
+
+func (i *ReplicationService) endFailover() {
+	i.setFailoverState(NoFailover)
+	i.ResumeWrites()
+}
+
+// PauseWrites blocks client writes until ResumeWrites is called.
+func (i *ReplicationService) PauseWrites() {
+	i.pauseMu.Lock()
+	defer i.pauseMu.Unlock()
+
+	if i.paused == nil {
+		i.paused = make(chan struct{})
+	}
+}
+
+func (i *ReplicationService) ResumeWrites() {
+	i.pauseMu.Lock()
+	defer i.pauseMu.Unlock()
+
+	if i.paused != nil {
+		close(i.paused)
+		i.paused = nil
+	}
+}
+
+// WaitWritesAllowed blocks while client writes are paused.
+func (i *ReplicationService) WaitWritesAllowed() {
+	i.pauseMu.Lock()
+	paused := i.paused
+	i.pauseMu.Unlock()
+
+	if paused != nil {
+		<-paused
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// allEventClasses are the event classes "A" stands for: generic, string,
+// list, set, hash, sorted set, expired, evicted and stream events. Key miss
+// (m) and new key (n) events have to be enabled on their own.
+const allEventClasses = "g$lshzxet"
+
+// KeyspaceEvents publishes the changes made to the keys as selected by
+// notify-keyspace-events: "__keyspace@0__:<key>" receives the event name
+// with K, "__keyevent@0__:<event>" the key name with E.
+type KeyspaceEvents struct {
+	pubsub *PubSub
+
+	mu    sync.RWMutex
+	flags string
+}
+
+func NewKeyspaceEvents(pubsub *PubSub) *KeyspaceEvents {
+	return &KeyspaceEvents{pubsub: pubsub}
+}
+
+// SetFlags replaces the enabled events with the classes of flags, e.g.
+// "KEA" or "Ex".
+func (k *KeyspaceEvents) SetFlags(flags string) error {
+	var enabled strings.Builder
+
+	for _, c := range flags {
+		switch {
+		case c == 'A':
+			enabled.WriteString(allEventClasses)
+		case strings.ContainsRune(allEventClasses+"KEmn", c):
+			enabled.WriteRune(c)
+		default:
+			return errors.New("Invalid event class character. Use 'Ag$lshzxeKEtmn'.")
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.flags = enabled.String()
+
+	return nil
+}
+
+// Flags returns the enabled events in their canonical form, e.g. "AKE".
+func (k *KeyspaceEvents) Flags() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var flags strings.Builder
+
+	all := true
+	for _, c := range allEventClasses {
+		all = all && strings.ContainsRune(k.flags, c)
+	}
+
+	if all {
+		flags.WriteByte('A')
+	}
+
+	for _, c := range allEventClasses + "KEmn" {
+		isClass := strings.ContainsRune(allEventClasses, c)
+
+		if strings.ContainsRune(k.flags, c) && !(all && isClass) {
+			flags.WriteRune(c)
+		}
+	}
+
+	return flags.String()
+}
+
+func (k *KeyspaceEvents) enabled(flag byte) bool {
+	return strings.IndexByte(k.flags, flag) >= 0
+}
+
+// Notify publishes event on key if its class is enabled.
+func (k *KeyspaceEvents) Notify(class byte, event, key string) {
+	if k == nil {
+		return
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if !k.enabled(class) {
+		return
+	}
+
+	if k.enabled('K') {
+		k.pubsub.Publish("__keyspace@0__:"+key, event)
+	}
+
+	if k.enabled('E') {
+		k.pubsub.Publish("__keyevent@0__:"+event, key)
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestKeyspaceEvents_Flags(t *testing.T) {
+	k := NewKeyspaceEvents(NewPubSub(nil))
+
+	assert.Equal(t, "", k.Flags())
+
+	assert.NoError(t, k.SetFlags("EKA"))
+	assert.Equal(t, "AKE", k.Flags())
+
+	assert.NoError(t, k.SetFlags("xE$g"))
+	assert.Equal(t, "g$xE", k.Flags())
+
+	assert.Error(t, k.SetFlags("KX"))
+	assert.Equal(t, "g$xE", k.Flags(), "invalid flags change nothing")
+}
+
+func TestKeyspaceEvents_Notify(t *testing.T) {
+	p := NewPubSub(NewClients())
+	k := NewKeyspaceEvents(p)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	p.PSubscribe(server, "__key*__:*")
+	frames(t, client, 1)
+
+	// disabled, nothing is published
+	k.Notify('$', "ignored", "k")
+
+	assert.NoError(t, k.SetFlags("K$"))
+	k.Notify('g', "expire", "k")
+	k.Notify('$', "set", "k")
+	assert.Equal(t, [][]string{
+		{"pmessage", "__key*__:*", "__keyspace@0__:k", "set"},
+	}, frames(t, client, 1))
+
+	assert.NoError(t, k.SetFlags("KEA"))
+	k.Notify('x', "expired", "k")
+	assert.Equal(t, [][]string{
+		{"pmessage", "__key*__:*", "__keyspace@0__:k", "expired"},
+		{"pmessage", "__key*__:*", "__keyevent@0__:expired", "k"},
+	}, frames(t, client, 2))
+
+	var disabled *KeyspaceEvents
+	disabled.Notify('$', "set", "k")
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientClass selects the output buffer limits applying to a client.
+type ClientClass int32
+
+const (
+	NormalClient ClientClass = iota
+	ReplicaClient
+	PubSubClient
+)
+
+// clientClassNames are the names of the classes in client-output-buffer-limit,
+// in the order of the classes.
+var clientClassNames = [...]string{"normal", "slave", "pubsub"}
+
+// OutputLimit disconnects a client whose pending replies reach Hard bytes,
+// or stay above Soft bytes for more than SoftSeconds. Zero disables a limit.
+type OutputLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int64
+}
+
+// OutputLimits holds the limit of every client class.
+type OutputLimits [len(clientClassNames)]OutputLimit
+
+// DefaultOutputLimits leave normal clients unbounded.
+const DefaultOutputLimits = "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60"
+
+// ParseOutputLimits reads client-output-buffer-limit, a list of
+// "<class> <hard> <soft> <soft seconds>" where sizes accept the b, k, kb, m,
+// mb, g and gb units. The classes not listed keep their limit in current.
+func ParseOutputLimits(s string, current OutputLimits) (OutputLimits, error) {
+	fields := strings.Fields(s)
+
+	if len(fields)%4 != 0 {
+		return current, errors.New("Wrong number of arguments in buffer limit configuration.")
+	}
+
+	limits := current
+
+	for i := 0; i < len(fields); i += 4 {
+		class, ok := parseClientClass(fields[i])
+		if !ok {
+			return current, errors.New("Invalid client class specified in buffer limit configuration.")
+		}
+
+		hard, errHard := parseMemory(fields[i+1])
+		soft, errSoft := parseMemory(fields[i+2])
+		seconds, errSeconds := strconv.ParseInt(fields[i+3], 10, 64)
+
+		if errHard != nil || errSoft != nil || errSeconds != nil || seconds < 0 {
+			return current, errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+
+		limits[class] = OutputLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+	}
+
+	return limits, nil
+}
+
+func (l OutputLimits) String() string {
+	parts := make([]string, 0, len(l))
+
+	for class, limit := range l {
+		parts = append(parts, fmt.Sprintf("%s %d %d %d", clientClassNames[class], limit.Hard, limit.Soft, limit.SoftSeconds))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func parseClientClass(name string) (ClientClass, bool) {
+	switch strings.ToLower(name) {
+	case "normal":
+		return NormalClient, true
+	case "replica", "slave":
+		return ReplicaClient, true
+	case "pubsub":
+		return PubSubClient, true
+	}
+
+	return 0, false
+}
+
+// parseMemory parses a size such as 64mb: k, m and g are powers of 1000,
+// kb, mb and gb powers of 1024.
+func parseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+		{"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000}, {"b", 1},
+	}
+
+	lower := strings.ToLower(s)
+	factor := int64(1)
+
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, factor = strings.TrimSuffix(lower, unit.suffix), unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size: %s", s)
+	}
+
+	return n * factor, nil
+}
+
+// ErrOutputClosed is returned when writing to a client that was
+// disconnected.
+var ErrOutputClosed = errors.New("client output closed")
+
+// Output is the output buffer of a client. Replies are appended to it in the
+// order they are produced, and written to the connection in one go by its
+// own writer once flushed, so that a client that reads slowly holds up
+// nobody. A client whose pending replies overcome the limits of its class is
+// disconnected.
+type Output struct {
+	w      io.Writer
+	closer io.Closer
+	limits func() OutputLimits
+	class  atomic.Int32
+
+	mu sync.Mutex
+	// pending are the replies written since the last flush, flushed the ones
+	// handed to the writer, and writing the size of what it is writing
+	pending []byte
+	flushed []byte
+	writing int
+
+	// softSince is when the pending replies went over the soft limit
+	softSince time.Time
+
+	closing, closed bool
+
+	// wake holds at most one pending wake up of the writer
+	wake chan struct{}
+}
+
+// NewOutput creates the output buffer writing to w. closer is closed to
+// disconnect the client, limits returns the limits applying, none when nil.
+func NewOutput(w io.Writer, closer io.Closer, limits func() OutputLimits) *Output {
+	o := &Output{
+		w:      w,
+		closer: closer,
+		limits: limits,
+		wake:   make(chan struct{}, 1),
+	}
+
+	go o.writeLoop()
+
+	return o
+}
+
+// Class returns the class of the client, whose limits apply.
+func (o *Output) Class() ClientClass {
+	return ClientClass(o.class.Load())
+}
+
+func (o *Output) SetClass(class ClientClass) {
+	o.class.Store(int32(class))
+}
+
+// Write appends p to the replies waiting for the next flush.
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed || o.closing {
+		return 0, ErrOutputClosed
+	}
+
+	o.pending = append(o.pending, p...)
+
+	if reason := o.overLimit(); reason != "" {
+		fmt.Printf("Client scheduled to be closed ASAP for overcoming of output buffer limits (%s)\n", reason)
+		o.disconnect()
+
+		return 0, ErrOutputClosed
+	}
+
+	return len(p), nil
+}
+
+// Flush hands the pending replies to the writer.
+func (o *Output) Flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed || len(o.pending) == 0 {
+		return
+	}
+
+	o.flushed = append(o.flushed, o.pending...)
+	o.pending = o.pending[:0]
+
+	o.signal()
+}
+
+// Close writes what is left and then closes the connection.
+func (o *Output) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed || o.closing {
+		return
+	}
+
+	o.flushed = append(o.flushed, o.pending...)
+	o.pending = nil
+	o.closing = true
+
+	o.signal()
+}
+
+// Size returns the number of bytes not written yet.
+func (o *Output) Size() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.size()
+}
+
+func (o *Output) size() int {
+	return len(o.pending) + len(o.flushed) + o.writing
+}
+
+// overLimit tells which limit of its class the client overcame, if any. It
+// must be called with o.mu held.
+func (o *Output) overLimit() string {
+	if o.limits == nil {
+		return ""
+	}
+
+	limit := o.limits()[o.Class()]
+	size := int64(o.size())
+
+	if limit.Hard > 0 && size >= limit.Hard {
+		return "hard limit"
+	}
+
+	if limit.Soft == 0 || size < limit.Soft {
+		o.softSince = time.Time{}
+		return ""
+	}
+
+	if o.softSince.IsZero() {
+		o.softSince = time.Now()
+		return ""
+	}
+
+	if time.Since(o.softSince) > time.Duration(limit.SoftSeconds)*time.Second {
+		return "soft limit"
+	}
+
+	return ""
+}
+
+// disconnect drops the replies and closes the connection right away. It
+// must be called with o.mu held.
+func (o *Output) disconnect() {
+	o.closed = true
+	o.pending, o.flushed = nil, nil
+
+	if o.closer != nil {
+		o.closer.Close()
+	}
+
+	o.signal()
+}
+
+func (o *Output) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes what is flushed until the client is disconnected. Once
+// closing, what was flushed last is all there is left to write.
+func (o *Output) writeLoop() {
+	for range o.wake {
+		o.mu.Lock()
+		out := o.flushed
+		o.flushed = nil
+		o.writing = len(out)
+		closed, closing := o.closed, o.closing
+		o.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		if len(out) > 0 {
+			_, err := o.w.Write(out)
+
+			o.mu.Lock()
+			o.writing = 0
+			if err != nil {
+				fmt.Println("Error writing to client: ", err)
+				o.disconnect()
+			}
+			o.mu.Unlock()
+
+			if err != nil {
+				return
+			}
+		}
+
+		if closing {
+			o.mu.Lock()
+			o.closed = true
+			o.mu.Unlock()
+
+			if o.closer != nil {
+				o.closer.Close()
+			}
+
+			return
+		}
+	}
+}
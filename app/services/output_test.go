@@ -0,0 +1,93 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// writes records every write as a separate frame.
+type writes chan string
+
+func (w writes) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestOutput(t *testing.T) {
+	w := make(writes, 10)
+	o := NewOutput(w, nil, nil)
+
+	o.Write([]byte("+OK\r\n"))
+	o.Write([]byte(":1\r\n"))
+	assert.Len(t, w, 0, "nothing is written before a flush")
+
+	o.Flush()
+	assert.Equal(t, "+OK\r\n:1\r\n", <-w, "replies are written at once, in order")
+
+	o.Write([]byte("+PONG\r\n"))
+	o.Close()
+	assert.Equal(t, "+PONG\r\n", <-w, "what is left is written on close")
+
+	_, err := o.Write([]byte("+late\r\n"))
+	assert.ErrorIs(t, err, ErrOutputClosed)
+}
+
+func TestOutput_HardLimit(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	limits, err := ParseOutputLimits("pubsub 10 0 0", OutputLimits{})
+	assert.NoError(t, err)
+
+	o := NewOutput(server, server, func() OutputLimits { return limits })
+
+	_, err = o.Write([]byte("0123456789"))
+	assert.NoError(t, err, "normal clients are not limited")
+
+	o.SetClass(PubSubClient)
+
+	// the client does not read what is flushed
+	o.Flush()
+	_, err = o.Write([]byte("0123456789"))
+	assert.ErrorIs(t, err, ErrOutputClosed)
+
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "the client is disconnected")
+}
+
+func TestOutput_SoftLimit(t *testing.T) {
+	limits, err := ParseOutputLimits("replica 0 4 0", OutputLimits{})
+	assert.NoError(t, err)
+
+	o := NewOutput(make(writes, 10), nil, func() OutputLimits { return limits })
+	o.SetClass(ReplicaClient)
+
+	_, err = o.Write([]byte("12345"))
+	assert.NoError(t, err, "a client may stay over the soft limit for a while")
+
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = o.Write([]byte("6"))
+	assert.ErrorIs(t, err, ErrOutputClosed, "but not longer than allowed")
+}
+
+func TestParseOutputLimits(t *testing.T) {
+	limits, err := ParseOutputLimits(DefaultOutputLimits, OutputLimits{})
+	assert.NoError(t, err)
+	assert.Equal(t, OutputLimit{Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60}, limits[ReplicaClient])
+	assert.Equal(t, "normal 0 0 0 slave 268435456 67108864 60 pubsub 33554432 8388608 60", limits.String())
+
+	limits, err = ParseOutputLimits("SLAVE 1k 2KB 3", limits)
+	assert.NoError(t, err)
+	assert.Equal(t, OutputLimit{Hard: 1000, Soft: 2048, SoftSeconds: 3}, limits[ReplicaClient])
+	assert.Equal(t, int64(32<<20), limits[PubSubClient].Hard, "the classes not given are kept")
+
+	for _, invalid := range []string{"normal 0 0", "master 0 0 0", "pubsub 1x 0 0", "pubsub 0 0 -1"} {
+		unchanged, err := ParseOutputLimits(invalid, limits)
+		assert.Error(t, err, invalid)
+		assert.Equal(t, limits, unchanged)
+	}
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+	"net"
+	"slices"
+	"sync"
+)
+
+// PubSub fans published messages out to the connections subscribed to a
+// channel, or to a pattern matching it. Shard channels are a namespace of
+// their own, published to with SPUBLISH.
+type PubSub struct {
+	mu            sync.RWMutex
+	channels      map[string]map[net.Conn]struct{}
+	patterns      map[string]map[net.Conn]struct{}
+	shardChannels map[string]map[net.Conn]struct{}
+	subscribers   map[net.Conn]*subscriber
+
+	// clients tells how messages are encoded for each connection, and holds
+	// the output buffers they are pushed to
+	clients *Clients
+}
+
+// subscriber is a connection that used pub/sub. Messages are appended to
+// its output buffer, ordered with its replies, and written by the writer of
+// the buffer so that a client that reads slowly does not hold up the
+// publishers.
+type subscriber struct {
+	conn          net.Conn
+	clients       *Clients
+	output        *Output
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+}
+
+// NewPubSub creates the hub, pushing messages as RESP3 push values to the
+// clients that negotiated RESP3 with HELLO, as arrays otherwise.
+func NewPubSub(clients *Clients) *PubSub {
+	return &PubSub{
+		channels:      make(map[string]map[net.Conn]struct{}),
+		patterns:      make(map[string]map[net.Conn]struct{}),
+		shardChannels: make(map[string]map[net.Conn]struct{}),
+		subscribers:   make(map[net.Conn]*subscriber),
+		clients:       clients,
+	}
+}
+
+func newSubscriber(conn net.Conn, clients *Clients) *subscriber {
+	return &subscriber{
+		conn:          conn,
+		clients:       clients,
+		output:        clients.Output(conn),
+		channels:      make(map[string]struct{}),
+		patterns:      make(map[string]struct{}),
+		shardChannels: make(map[string]struct{}),
+	}
+}
+
+// write pushes frame to the connection.
+func (s *subscriber) write(frame []byte) {
+	if _, err := s.output.Write(frame); err != nil {
+		fmt.Println("Error writing to subscriber: ", err)
+		return
+	}
+
+	s.output.Flush()
+}
+
+func (s *subscriber) push(v resp.Value) {
+	v = v.ForProtocol(s.protocol())
+	frame, _ := v.Marshal()
+	s.write(frame)
+}
+
+func (s *subscriber) protocol() int {
+	return s.clients.Protocol(s.conn)
+}
+
+// message is a value pushed to many subscribers, marshalled at most once per
+// protocol version.
+type message struct {
+	value  resp.Value
+	frames map[int][]byte
+}
+
+func newMessage(values ...resp.Value) *message {
+	return &message{
+		value:  resp.PushValue(values...),
+		frames: make(map[int][]byte),
+	}
+}
+
+func (m *message) pushTo(sub *subscriber) {
+	protocol := sub.protocol()
+
+	frame, ok := m.frames[protocol]
+	if !ok {
+		v := m.value.ForProtocol(protocol)
+		frame, _ = v.Marshal()
+		m.frames[protocol] = frame
+	}
+
+	sub.write(frame)
+}
+
+// updateClass puts a connection with subscriptions under the output limits
+// of the pubsub clients, and back under the normal ones without.
+func (s *subscriber) updateClass() {
+	subscribed := s.count()+s.shardCount() > 0
+
+	switch class := s.output.Class(); {
+	case subscribed && class == NormalClient:
+		s.output.SetClass(PubSubClient)
+	case !subscribed && class == PubSubClient:
+		s.output.SetClass(NormalClient)
+	}
+}
+
+// count is the number of subscriptions to channels and patterns, as replied
+// to their (un)subscriptions.
+func (s *subscriber) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// shardCount is the number of subscriptions to shard channels, counted apart.
+func (s *subscriber) shardCount() int {
+	return len(s.shardChannels)
+}
+
+// client returns the subscriber of conn, created on first use. It must be
+// called with p.mu held.
+func (p *PubSub) client(conn net.Conn) *subscriber {
+	sub, ok := p.subscribers[conn]
+	if !ok {
+		sub = newSubscriber(conn, p.clients)
+		p.subscribers[conn] = sub
+	}
+
+	return sub
+}
+
+// Subscribe adds channels to the subscriptions of conn. One confirmation per
+// channel is pushed to conn, with the number of subscriptions of conn.
+func (p *PubSub) Subscribe(conn net.Conn, channels ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub := p.client(conn)
+	p.add(sub, "subscribe", p.channels, sub.channels, channels, sub.count)
+}
+
+// PSubscribe adds glob-style patterns to the subscriptions of conn.
+func (p *PubSub) PSubscribe(conn net.Conn, patterns ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub := p.client(conn)
+	p.add(sub, "psubscribe", p.patterns, sub.patterns, patterns, sub.count)
+}
+
+// SSubscribe adds shard channels to the subscriptions of conn. Confirmations
+// carry the number of shard channels conn is subscribed to.
+func (p *PubSub) SSubscribe(conn net.Conn, channels ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub := p.client(conn)
+	p.add(sub, "ssubscribe", p.shardChannels, sub.shardChannels, channels, sub.shardCount)
+}
+
+// Unsubscribe removes channels from the subscriptions of conn, all of them
+// when none is given.
+func (p *PubSub) Unsubscribe(conn net.Conn, channels ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub := p.client(conn)
+	p.remove(sub, "unsubscribe", p.channels, sub.channels, channels, sub.count)
+}
+
+// PUnsubscribe removes patterns from the subscriptions of conn, all of them
+// when none is given.
+func (p *PubSub) PUnsubscribe(conn net.Conn, patterns ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub := p.client(conn)
+	p.remove(sub, "punsubscribe", p.patterns, sub.patterns, patterns, sub.count)
+}
+
+// SUnsubscribe removes shard channels from the subscriptions of conn, all of
+// them when none is given.
+func (p *PubSub) SUnsubscribe(conn net.Conn, channels ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub := p.client(conn)
+	p.remove(sub, "sunsubscribe", p.shardChannels, sub.shardChannels, channels, sub.shardCount)
+}
+
+func (p *PubSub) add(sub *subscriber, kind string, index map[string]map[net.Conn]struct{}, subscribed map[string]struct{}, names []string, count func() int) {
+	for _, name := range names {
+		if index[name] == nil {
+			index[name] = make(map[net.Conn]struct{})
+		}
+
+		index[name][sub.conn] = struct{}{}
+		subscribed[name] = struct{}{}
+
+		sub.push(confirmation(kind, resp.BulkStringValue(name), count()))
+	}
+
+	sub.updateClass()
+}
+
+func (p *PubSub) remove(sub *subscriber, kind string, index map[string]map[net.Conn]struct{}, subscribed map[string]struct{}, names []string, count func() int) {
+	if len(names) == 0 {
+		for name := range subscribed {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+	}
+
+	// unsubscribing from nothing is still confirmed
+	if len(names) == 0 {
+		sub.push(confirmation(kind, resp.BulkNullStringValue(), count()))
+		return
+	}
+
+	for _, name := range names {
+		delete(index[name], sub.conn)
+
+		if len(index[name]) == 0 {
+			delete(index, name)
+		}
+
+		delete(subscribed, name)
+
+		sub.push(confirmation(kind, resp.BulkStringValue(name), count()))
+	}
+
+	sub.updateClass()
+}
+
+func confirmation(kind string, name resp.Value, count int) resp.Value {
+	return resp.PushValue(
+		resp.BulkStringValue(kind),
+		name,
+		resp.IntegerValue(int64(count)),
+	)
+}
+
+// Subscribed tells whether conn has subscriptions, which restricts the
+// commands it may send.
+func (p *PubSub) Subscribed(conn net.Conn) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	sub, ok := p.subscribers[conn]
+
+	return ok && sub.count()+sub.shardCount() > 0
+}
+
+// UnsubscribeAll drops every subscription of conn, e.g. when it disconnects.
+func (p *PubSub) UnsubscribeAll(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sub, ok := p.subscribers[conn]
+	if !ok {
+		return
+	}
+
+	unindex := func(index map[string]map[net.Conn]struct{}, subscribed map[string]struct{}) {
+		for name := range subscribed {
+			delete(index[name], conn)
+
+			if len(index[name]) == 0 {
+				delete(index, name)
+			}
+		}
+	}
+
+	unindex(p.channels, sub.channels)
+	unindex(p.patterns, sub.patterns)
+	unindex(p.shardChannels, sub.shardChannels)
+
+	if sub.output.Class() == PubSubClient {
+		sub.output.SetClass(NormalClient)
+	}
+
+	delete(p.subscribers, conn)
+}
+
+// Publish pushes message to every subscriber of channel and of a pattern
+// matching it, and returns the number of messages pushed: a client matching
+// through several subscriptions receives the message once per subscription.
+func (p *PubSub) Publish(channel, message string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	receivers := 0
+
+	if subscribers := p.channels[channel]; len(subscribers) > 0 {
+		m := newMessage(
+			resp.BulkStringValue("message"),
+			resp.BulkStringValue(channel),
+			resp.BulkStringValue(message),
+		)
+
+		for conn := range subscribers {
+			m.pushTo(p.subscribers[conn])
+			receivers++
+		}
+	}
+
+	for pattern, subscribers := range p.patterns {
+		if !utils.Match(pattern, channel) {
+			continue
+		}
+
+		m := newMessage(
+			resp.BulkStringValue("pmessage"),
+			resp.BulkStringValue(pattern),
+			resp.BulkStringValue(channel),
+			resp.BulkStringValue(message),
+		)
+
+		for conn := range subscribers {
+			m.pushTo(p.subscribers[conn])
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+// SPublish pushes message to the subscribers of the shard channel and
+// returns how many received it. Patterns do not apply to shard channels.
+func (p *PubSub) SPublish(channel, message string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	m := newMessage(
+		resp.BulkStringValue("smessage"),
+		resp.BulkStringValue(channel),
+		resp.BulkStringValue(message),
+	)
+
+	for conn := range p.shardChannels[channel] {
+		m.pushTo(p.subscribers[conn])
+	}
+
+	return len(p.shardChannels[channel])
+}
+
+// Channels returns the channels with subscribers matching pattern, all of
+// them when pattern is empty.
+func (p *PubSub) Channels(pattern string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return matching(p.channels, pattern)
+}
+
+// ShardChannels returns the shard channels with subscribers matching
+// pattern, all of them when pattern is empty.
+func (p *PubSub) ShardChannels(pattern string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return matching(p.shardChannels, pattern)
+}
+
+func matching(index map[string]map[net.Conn]struct{}, pattern string) []string {
+	channels := make([]string, 0, len(index))
+	for ch := range index {
+		if pattern == "" || utils.Match(pattern, ch) {
+			channels = append(channels, ch)
+		}
+	}
+
+	slices.Sort(channels)
+
+	return channels
+}
+
+// NumSub returns the number of subscribers of channel, patterns excluded.
+func (p *PubSub) NumSub(channel string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.channels[channel])
+}
+
+// ShardNumSub returns the number of subscribers of the shard channel.
+func (p *PubSub) ShardNumSub(channel string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.shardChannels[channel])
+}
+
+// NumPat returns the number of distinct patterns subscribed to.
+func (p *PubSub) NumPat() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.patterns)
+}
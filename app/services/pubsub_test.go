@@ -0,0 +1,162 @@
+package services
+
+import (
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+// frames reads the values pushed to a subscriber as slices of strings.
+func frames(t *testing.T, conn net.Conn, n int) [][]string {
+	reader := resp.NewReader(conn)
+
+	var out [][]string
+
+	for range n {
+		v, _, err := reader.ReadValue()
+		assert.NoError(t, err)
+
+		var frame []string
+		for _, e := range v.Values {
+			s, _ := e.AsString()
+			frame = append(frame, s)
+		}
+
+		out = append(out, frame)
+	}
+
+	return out
+}
+
+func TestPubSub(t *testing.T) {
+	p := NewPubSub(NewClients())
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	p.Subscribe(server, "news", "cache")
+	p.PSubscribe(server, "news.*", "*")
+
+	assert.True(t, p.Subscribed(server))
+	assert.Equal(t, [][]string{
+		{"subscribe", "news", "1"},
+		{"subscribe", "cache", "2"},
+		{"psubscribe", "news.*", "3"},
+		{"psubscribe", "*", "4"},
+	}, frames(t, client, 4))
+
+	assert.Equal(t, 2, p.Publish("news.tech", "hello"), "once per matching pattern")
+	assert.ElementsMatch(t, [][]string{
+		{"pmessage", "news.*", "news.tech", "hello"},
+		{"pmessage", "*", "news.tech", "hello"},
+	}, frames(t, client, 2))
+
+	assert.Equal(t, 2, p.Publish("cache", "flush"))
+	assert.ElementsMatch(t, [][]string{
+		{"message", "cache", "flush"},
+		{"pmessage", "*", "cache", "flush"},
+	}, frames(t, client, 2))
+
+	assert.Equal(t, []string{"cache", "news"}, p.Channels(""))
+	assert.Equal(t, []string{"news"}, p.Channels("n*"))
+	assert.Equal(t, 1, p.NumSub("news"))
+	assert.Equal(t, 0, p.NumSub("news.tech"), "patterns are not counted")
+	assert.Equal(t, 2, p.NumPat())
+
+	p.Unsubscribe(server)
+	p.PUnsubscribe(server, "*")
+	p.PUnsubscribe(server, "news.*")
+	p.PUnsubscribe(server)
+	assert.Equal(t, [][]string{
+		{"unsubscribe", "cache", "3"},
+		{"unsubscribe", "news", "2"},
+		{"punsubscribe", "*", "1"},
+		{"punsubscribe", "news.*", "0"},
+		{"punsubscribe", "", "0"},
+	}, frames(t, client, 5))
+
+	assert.False(t, p.Subscribed(server))
+	assert.Equal(t, 0, p.Publish("news", "nobody listens"))
+	assert.Empty(t, p.Channels(""))
+
+	p.Subscribe(server, "news")
+	frames(t, client, 1)
+
+	assert.Equal(t, PubSubClient, p.clients.Output(server).Class())
+
+	p.UnsubscribeAll(server)
+	assert.Equal(t, 0, p.Publish("news", "gone"))
+	assert.Equal(t, NormalClient, p.clients.Output(server).Class())
+}
+
+func TestPubSub_Shard(t *testing.T) {
+	p := NewPubSub(NewClients())
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	p.Subscribe(server, "orders")
+	p.PSubscribe(server, "*")
+	p.SSubscribe(server, "orders", "users")
+
+	assert.Equal(t, [][]string{
+		{"subscribe", "orders", "1"},
+		{"psubscribe", "*", "2"},
+		{"ssubscribe", "orders", "1"},
+		{"ssubscribe", "users", "2"},
+	}, frames(t, client, 4), "shard channels are counted apart")
+
+	assert.Equal(t, 1, p.SPublish("orders", "created"), "patterns do not apply")
+	assert.Equal(t, [][]string{{"smessage", "orders", "created"}}, frames(t, client, 1))
+
+	assert.Equal(t, []string{"orders", "users"}, p.ShardChannels(""))
+	assert.Equal(t, []string{"users"}, p.ShardChannels("u*"))
+	assert.Equal(t, 1, p.ShardNumSub("users"))
+
+	p.Unsubscribe(server)
+	p.PUnsubscribe(server)
+	frames(t, client, 2)
+	assert.True(t, p.Subscribed(server), "still subscribed to shard channels")
+
+	p.SUnsubscribe(server)
+	assert.Equal(t, [][]string{
+		{"sunsubscribe", "orders", "1"},
+		{"sunsubscribe", "users", "0"},
+	}, frames(t, client, 2))
+	assert.False(t, p.Subscribed(server))
+	assert.Equal(t, 0, p.SPublish("orders", "lost"))
+}
+
+func TestPubSub_Protocols(t *testing.T) {
+	clients := NewClients()
+	p := NewPubSub(clients)
+
+	server2, client2 := net.Pipe()
+	defer client2.Close()
+
+	server3, client3 := net.Pipe()
+	defer client3.Close()
+
+	clients.SetProtocol(server3, resp.RESP3)
+
+	p.Subscribe(server2, "news")
+	p.Subscribe(server3, "news")
+
+	reader2, reader3 := resp.NewReader(client2), resp.NewReader(client3)
+	for _, r := range []*resp.Reader{reader2, reader3} {
+		_, _, err := r.ReadValue()
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, p.Publish("news", "hello"))
+
+	v, _, err := reader2.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Array, v.Type)
+
+	v, _, err = reader3.ReadValue()
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Push, v.Type, "RESP3 clients get push messages")
+	assert.Len(t, v.Values, 3)
+}
@@ -1,18 +1,18 @@
 package services
 
 import (
-	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
-	"github.com/codecrafters-io/redis-starter-go/app/utils"
-	"io"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Role string
@@ -23,10 +23,39 @@ const (
 )
 
 type Replica struct {
-	Mu    sync.Mutex
-	Conn  net.Conn
-	Queue chan []byte
-	Ack   chan bool
+	Mu   sync.Mutex
+	Conn net.Conn
+
+	// Output is the output buffer of the replica link, the replication stream
+	// is written to
+	Output *Output
+
+	// AckOffset is the last replication offset acknowledged by the replica
+	// through REPLCONF ACK, AofAckOffset the last one it reported as fsynced.
+	AckOffset    atomic.Int64
+	AofAckOffset atomic.Int64
+
+	// LastAckTime is the unix time in milliseconds of the last REPLCONF ACK.
+	LastAckTime atomic.Int64
+
+	// ListeningPort is the port announced through REPLCONF listening-port.
+	ListeningPort int
+}
+
+// Addr returns the address the replica accepts connections on.
+func (r *Replica) Addr() string {
+	host, port, _ := net.SplitHostPort(r.Conn.RemoteAddr().String())
+
+	if r.ListeningPort != 0 {
+		port = strconv.Itoa(r.ListeningPort)
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// Lag returns the number of seconds since the replica last acknowledged.
+func (r *Replica) Lag() int64 {
+	return (time.Now().UnixMilli() - r.LastAckTime.Load()) / 1000
 }
 
 type ReplicationService struct {
@@ -34,17 +63,43 @@ type ReplicationService struct {
 	MasterReplid     string
 	MasterReplOffset atomic.Int64
 
+	// replid2 is the replication id we had before the last promotion or
+	// id change, valid for partial resyncs up to secondReplOffset.
+	replid2          string
+	secondReplOffset int64
+
+	roleMu      sync.RWMutex
+	roleChanged chan struct{} // closed and replaced on every role change
+
 	ReplicaMutex sync.RWMutex
 	Replicas     map[string]*Replica
 
-	ReplicaAck chan bool
+	// MasterAddr and masterConn describe the link to our master when running
+	// as a replica, masterConn is nil while the link is down. Both are
+	// guarded by roleMu.
+	MasterAddr string
+	masterConn net.Conn
+
+	propagateMu sync.Mutex
+	backlog     *backlog
+
+	ackMu sync.Mutex
+	acked chan struct{} // closed and replaced every time a replica acknowledges
+
+	clientsMu      sync.Mutex
+	clientOffsets  map[net.Conn]int64
+	listeningPorts map[net.Conn]int
+
+	failover failover
+
+	pauseMu sync.Mutex
+	paused  chan struct{} // non nil while client writes are paused
 }
 
 func NewReplicationService(config Configuration) *ReplicationService {
 	var (
-		role                = Slave
-		masterReplicaId     = ""
-		masterReplicaOffset atomic.Int64
+		role            = Slave
+		masterReplicaId = ""
 	)
 
 	if *config.ReplicaOf == "" {
@@ -52,18 +107,25 @@ func NewReplicationService(config Configuration) *ReplicationService {
 		masterReplicaId, _ = generateReplicationId()
 	}
 
-	replication := ReplicationService{
-		Role:             role,
-		MasterReplid:     masterReplicaId,
-		MasterReplOffset: masterReplicaOffset,
+	replication := &ReplicationService{
+		Role:          role,
+		MasterReplid:  masterReplicaId,
+		Replicas:      make(map[string]*Replica),
+		backlog:       newBacklog(defaultBacklogSize, 0),
+		acked:         make(chan struct{}),
+		clientOffsets: make(map[net.Conn]int64),
+
+		listeningPorts:   make(map[net.Conn]int),
+		roleChanged:      make(chan struct{}),
+		secondReplOffset: -1,
+		failover:         failover{state: NoFailover},
 	}
 
-	if role == Master {
-		replication.Replicas = make(map[string]*Replica)
-		replication.ReplicaAck = make(chan bool, 100)
+	if role == Slave {
+		replication.MasterAddr = strings.Replace(strings.TrimSpace(*config.ReplicaOf), " ", ":", 1)
 	}
 
-	return &replication
+	return replication
 }
 
 func generateReplicationId() (string, error) {
@@ -79,60 +141,298 @@ func generateReplicationId() (string, error) {
 
 func (i *ReplicationService) String() string {
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("role:%s\r\n", i.Role))
+	sb.WriteString(fmt.Sprintf("role:%s\r\n", i.GetRole()))
+
+	if i.IsSlave() {
+		host, port, _ := net.SplitHostPort(i.GetMasterAddr())
+		linkStatus := "down"
+		if i.MasterConn() != nil {
+			linkStatus = "up"
+		}
+
+		sb.WriteString(fmt.Sprintf("master_host:%s\r\n", host))
+		sb.WriteString(fmt.Sprintf("master_port:%s\r\n", port))
+		sb.WriteString(fmt.Sprintf("master_link_status:%s\r\n", linkStatus))
+		sb.WriteString(fmt.Sprintf("slave_repl_offset:%d\r\n", i.MasterReplOffset.Load()))
+	}
+
+	i.ReplicaMutex.RLock()
+	sb.WriteString(fmt.Sprintf("connected_slaves:%d\r\n", len(i.Replicas)))
+
+	n := 0
+	for _, r := range i.Replicas {
+		host, port, _ := net.SplitHostPort(r.Addr())
+		sb.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=%d,lag=%d\r\n", n, host, port, r.AckOffset.Load(), r.Lag()))
+		n++
+	}
+	i.ReplicaMutex.RUnlock()
+
+	if i.IsMaster() {
+		if *Config.MinReplicasToWrite > 0 {
+			sb.WriteString(fmt.Sprintf("min_slaves_good_slaves:%d\r\n", i.GoodReplicas(int64(*Config.MinReplicasMaxLag))))
+		}
+	}
+
+	sb.WriteString(fmt.Sprintf("master_failover_state:%s\r\n", i.FailoverState()))
+
+	i.propagateMu.Lock()
+	replid2 := i.replid2
+	if replid2 == "" {
+		replid2 = strings.Repeat("0", 40)
+	}
+
 	sb.WriteString(fmt.Sprintf("master_replid:%s\r\n", i.MasterReplid))
+	sb.WriteString(fmt.Sprintf("master_replid2:%s\r\n", replid2))
 	sb.WriteString(fmt.Sprintf("master_repl_offset:%s\r\n", strconv.FormatInt(i.MasterReplOffset.Load(), 10)))
+	sb.WriteString(fmt.Sprintf("second_repl_offset:%d\r\n", i.secondReplOffset))
+	sb.WriteString("repl_backlog_active:1\r\n")
+	sb.WriteString(fmt.Sprintf("repl_backlog_size:%d\r\n", i.backlog.size))
+	sb.WriteString(fmt.Sprintf("repl_backlog_first_byte_offset:%d\r\n", i.backlog.start+1))
+	sb.WriteString(fmt.Sprintf("repl_backlog_histlen:%d\r\n", len(i.backlog.buf)))
+	i.propagateMu.Unlock()
 
 	return sb.String()
 }
 
+func (i *ReplicationService) GetRole() Role {
+	i.roleMu.RLock()
+	defer i.roleMu.RUnlock()
+
+	return i.Role
+}
+
 func (i *ReplicationService) IsMaster() bool {
-	return i.Role == Master
+	return i.GetRole() == Master
 }
 
 func (i *ReplicationService) IsSlave() bool {
-	return i.Role == Slave
+	return i.GetRole() == Slave
 }
 
-func (i *ReplicationService) AddReplica(conn net.Conn) {
-	if !i.IsMaster() {
-		fmt.Println("Not a master, cannot add replica")
+func (i *ReplicationService) GetMasterAddr() string {
+	i.roleMu.RLock()
+	defer i.roleMu.RUnlock()
+
+	return i.MasterAddr
+}
+
+// MasterConn returns the link to our master, nil while it is down.
+func (i *ReplicationService) MasterConn() net.Conn {
+	i.roleMu.RLock()
+	defer i.roleMu.RUnlock()
+
+	return i.masterConn
+}
+
+// SetMasterConn records the link to our master, nil once it is closed.
+func (i *ReplicationService) SetMasterConn(conn net.Conn) {
+	i.roleMu.Lock()
+	defer i.roleMu.Unlock()
+
+	i.masterConn = conn
+}
+
+// RoleChanged returns a channel closed on the next role or master change.
+func (i *ReplicationService) RoleChanged() <-chan struct{} {
+	i.roleMu.RLock()
+	defer i.roleMu.RUnlock()
+
+	return i.roleChanged
+}
+
+// BecomeReplicaOf turns us into a replica of addr. The replication id and
+// offset are kept so the new link can start with a partial resync.
+func (i *ReplicationService) BecomeReplicaOf(addr string) {
+	i.roleMu.Lock()
+	i.Role = Slave
+	i.MasterAddr = addr
+	close(i.roleChanged)
+	i.roleChanged = make(chan struct{})
+	i.roleMu.Unlock()
+
+	i.dropMasterLink()
+}
+
+// BecomeMaster promotes a replica to master under a new replication id. The
+// previous id is kept as replid2 so former peers can still continue from it.
+// Our own replicas are disconnected so that they learn the new id when they
+// continue from us.
+func (i *ReplicationService) BecomeMaster() {
+	i.roleMu.Lock()
+	if i.Role == Master {
+		i.roleMu.Unlock()
 		return
 	}
 
+	i.Role = Master
+	i.MasterAddr = ""
+	close(i.roleChanged)
+	i.roleChanged = make(chan struct{})
+	i.roleMu.Unlock()
+
+	i.dropMasterLink()
+
+	replid, _ := generateReplicationId()
+
+	i.propagateMu.Lock()
+	i.shiftReplid(replid)
+	i.disconnectReplicas()
+	i.propagateMu.Unlock()
+}
+
+// shiftReplid switches to replid, remembering the current id as replid2.
+func (i *ReplicationService) shiftReplid(replid string) {
+	if i.MasterReplid != "" {
+		i.replid2 = i.MasterReplid
+		i.secondReplOffset = i.MasterReplOffset.Load() + 1
+	}
+
+	i.MasterReplid = replid
+}
+
+func (i *ReplicationService) dropMasterLink() {
+	if conn := i.MasterConn(); conn != nil {
+		conn.Close()
+	}
+}
+
+// SetListeningPort records the port a future replica announced on conn.
+func (i *ReplicationService) SetListeningPort(conn net.Conn, port int) {
+	i.clientsMu.Lock()
+	defer i.clientsMu.Unlock()
+
+	i.listeningPorts[conn] = port
+}
+
+// IsMasterLink reports whether conn is the replication link to our master.
+func (i *ReplicationService) IsMasterLink(conn net.Conn) bool {
+	if conn == nil {
+		return false
+	}
+
+	i.roleMu.RLock()
+	defer i.roleMu.RUnlock()
+
+	return i.Role == Slave && conn == i.masterConn
+}
+
+func (i *ReplicationService) AddReplica(conn net.Conn, output *Output) *Replica {
 	fmt.Println("Adding replica:", (conn).RemoteAddr())
 
 	i.ReplicaMutex.Lock()
 	defer i.ReplicaMutex.Unlock()
 
-	// i.keepAlive(conn) test if this is required
+	i.clientsMu.Lock()
+	port := i.listeningPorts[conn]
+	i.clientsMu.Unlock()
+
+	output.SetClass(ReplicaClient)
 
 	key := conn.RemoteAddr().String()
-	replica := &Replica{Conn: conn, Queue: make(chan []byte, 100), Ack: make(chan bool)}
+	replica := &Replica{Conn: conn, Output: output, ListeningPort: port}
+	replica.LastAckTime.Store(time.Now().UnixMilli())
 
 	i.Replicas[key] = replica
 
-	go func(r *Replica) {
-		defer i.RemoveReplica(key)
-
-		tcpConn := r.Conn
-		writer := bufio.NewWriter(tcpConn)
-
-		for cmd := range r.Queue {
-			if _, err := writer.Write(cmd); err != nil {
-				if err == io.EOF {
-					fmt.Println("Replica disconnected:", tcpConn.RemoteAddr())
-					break
-				}
-				fmt.Println("Error writing to replica:", err)
-				continue
-			}
-
-			if err := writer.Flush(); err != nil {
-				fmt.Println("Failed to flush data to replica:", tcpConn.RemoteAddr())
-			}
+	return replica
+}
+
+// Sync registers conn as a replica asking to continue replid from offset, the
+// first byte it is missing, whose stream is written to output. The missing
+// part of the stream is sent when the
+// backlog still covers it, otherwise a full snapshot produced by dump.
+// Both happen under the propagation lock so nothing is sent out of order.
+func (i *ReplicationService) Sync(conn net.Conn, output *Output, replid string, offset int64, dump func() []byte) error {
+	i.propagateMu.Lock()
+	defer i.propagateMu.Unlock()
+
+	if i.IsSlave() && i.MasterReplid == "" {
+		return errors.New("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+
+	var payload []byte
+
+	canContinue := replid == i.MasterReplid || (replid == i.replid2 && offset <= i.secondReplOffset)
+
+	if canContinue {
+		if tail, ok := i.backlog.Since(offset - 1); ok {
+			fmt.Printf("Partial resync of %s from offset %d\n", conn.RemoteAddr(), offset)
+			payload = append([]byte(fmt.Sprintf("+CONTINUE %s\r\n", i.MasterReplid)), tail...)
 		}
-	}(replica)
+	}
+
+	if payload == nil {
+		header := resp.StringValue(fmt.Sprintf("FULLRESYNC %s %d", i.MasterReplid, i.MasterReplOffset.Load()))
+		rdb := resp.BulkLikeStringValue(dump())
+
+		h, _ := header.Marshal()
+		r, _ := rdb.Marshal()
+		payload = append(h, r...)
+	}
+
+	replica := i.AddReplica(conn, output)
+	replica.send(payload)
+
+	return nil
+}
+
+// SetMaster records the replication id and offset announced by our master
+// after a full resync. Our own replicas are dropped since their history is
+// no longer valid; they reconnect and resync from us.
+func (i *ReplicationService) SetMaster(replid string, offset int64) {
+	i.propagateMu.Lock()
+	defer i.propagateMu.Unlock()
+
+	i.MasterReplid = replid
+	i.MasterReplOffset.Store(offset)
+	i.replid2 = ""
+	i.secondReplOffset = -1
+	i.backlog.Reset(offset)
+
+	i.disconnectReplicas()
+	i.completeFailover()
+}
+
+// ContinueMaster handles +CONTINUE. A master that changed its replication id
+// keeps the offsets, so only the id needs updating.
+func (i *ReplicationService) ContinueMaster(replid string) {
+	i.propagateMu.Lock()
+	defer i.propagateMu.Unlock()
+
+	if replid != "" && replid != i.MasterReplid {
+		i.shiftReplid(replid)
+		i.disconnectReplicas()
+	}
+
+	i.completeFailover()
+}
+
+// PsyncArgs returns the PSYNC arguments to send to our master. A master
+// demoted by FAILOVER asks its target to take over with a trailing FAILOVER.
+func (i *ReplicationService) PsyncArgs() []string {
+	i.propagateMu.Lock()
+	defer i.propagateMu.Unlock()
+
+	if i.MasterReplid == "" {
+		return []string{"?", "-1"}
+	}
+
+	args := []string{i.MasterReplid, strconv.FormatInt(i.MasterReplOffset.Load()+1, 10)}
+
+	if i.FailoverState() == FailoverInProgress {
+		args = append(args, "FAILOVER")
+	}
+
+	return args
+}
+
+func (i *ReplicationService) disconnectReplicas() {
+	i.ReplicaMutex.RLock()
+	defer i.ReplicaMutex.RUnlock()
+
+	for _, r := range i.Replicas {
+		r.Conn.Close()
+	}
 }
 
 func (i *ReplicationService) RemoveReplica(k string) {
@@ -140,7 +440,6 @@ func (i *ReplicationService) RemoveReplica(k string) {
 	defer i.ReplicaMutex.Unlock()
 
 	if _, ok := i.Replicas[k]; !ok {
-		fmt.Println("Replica not found:", k)
 		return
 	}
 
@@ -148,21 +447,52 @@ func (i *ReplicationService) RemoveReplica(k string) {
 	fmt.Println("Removing replica:", (replica.Conn).RemoteAddr())
 
 	replica.Conn.Close()
-	close(replica.Queue)
-	close(replica.Ack)
 
 	delete(i.Replicas, k)
 }
 
-func (i *ReplicationService) IncrementReplOffset(delta int) {
-	i.MasterReplOffset.Add(int64(delta))
+func (i *ReplicationService) IncrementReplOffset(delta int) int64 {
+	return i.MasterReplOffset.Add(int64(delta))
 }
 
 func (i *ReplicationService) GetReplOffset() int64 {
 	return i.MasterReplOffset.Load()
 }
 
-func (i *ReplicationService) GetAck(conn net.Conn) error {
+// Propagate appends command to the replication stream and writes it to every
+// connected replica. It returns the master offset right after the command.
+func (i *ReplicationService) Propagate(command []byte) int64 {
+	i.propagateMu.Lock()
+	defer i.propagateMu.Unlock()
+
+	offset := i.IncrementReplOffset(len(command))
+	i.backlog.Write(command)
+
+	i.ReplicaMutex.RLock()
+	defer i.ReplicaMutex.RUnlock()
+
+	for _, r := range i.Replicas {
+		r.send(command)
+	}
+
+	return offset
+}
+
+// send writes part of the replication stream to the replica. A replica that
+// does not keep up is disconnected by the limits of its output buffer, and
+// resyncs once reconnected.
+func (r *Replica) send(stream []byte) {
+	if _, err := r.Output.Write(stream); err != nil {
+		fmt.Println("Error writing to replica:", r.Conn.RemoteAddr(), err)
+		return
+	}
+
+	r.Output.Flush()
+}
+
+// RequestAcks asks every replica for its current offset through the
+// replication stream so the answer reflects everything queued before it.
+func (i *ReplicationService) RequestAcks() {
 	v := resp.ArrayValue(
 		resp.BulkStringValue("REPLCONF"),
 		resp.BulkStringValue("GETACK"),
@@ -170,22 +500,132 @@ func (i *ReplicationService) GetAck(conn net.Conn) error {
 	)
 
 	ack, _ := v.Marshal()
+	i.Propagate(ack)
+}
 
-	fmt.Printf("Requesting ack -> %s \n", conn.RemoteAddr().String())
+// AckValue is the REPLCONF ACK a replica sends its master. Without an
+// append only file, everything processed counts as fsynced, so the FACK
+// offset is the replication offset.
+func (i *ReplicationService) AckValue() resp.Value {
+	offset := strconv.FormatInt(i.GetReplOffset(), 10)
 
-	if _, err := conn.Write(ack); err != nil {
-		fmt.Println("Error writing ack response: ", err)
-		return err
+	return resp.ArrayValue(
+		resp.BulkStringValue("REPLCONF"),
+		resp.BulkStringValue("ACK"),
+		resp.BulkStringValue(offset),
+		resp.BulkStringValue("FACK"),
+		resp.BulkStringValue(offset),
+	)
+}
+
+// Ack records the offsets reported by a replica through REPLCONF ACK and
+// wakes up any client blocked in WAIT or WAITAOF.
+func (i *ReplicationService) Ack(replica *Replica, offset int64, aofOffset int64) {
+	replica.AckOffset.Store(offset)
+	replica.LastAckTime.Store(time.Now().UnixMilli())
+
+	if aofOffset >= 0 {
+		replica.AofAckOffset.Store(aofOffset)
 	}
 
-	if err := utils.Flush(conn); err != nil {
-		fmt.Println("Error flushing ack response: ", err)
-		return err
+	i.ackMu.Lock()
+	close(i.acked)
+	i.acked = make(chan struct{})
+	i.ackMu.Unlock()
+}
+
+// CountAcked returns the number of replicas that acknowledged at least offset.
+func (i *ReplicationService) CountAcked(offset int64, aof bool) int {
+	i.ReplicaMutex.RLock()
+	defer i.ReplicaMutex.RUnlock()
+
+	count := 0
+	for _, r := range i.Replicas {
+		acked := r.AckOffset.Load()
+		if aof {
+			acked = r.AofAckOffset.Load()
+		}
+
+		if acked >= offset {
+			count++
+		}
 	}
 
-	return nil
+	return count
+}
+
+// WaitForAcks blocks until numReplicas replicas acknowledged offset or ctx is
+// done, and returns the number of replicas that did.
+func (i *ReplicationService) WaitForAcks(ctx context.Context, offset int64, numReplicas int, aof bool) int {
+	for {
+		i.ackMu.Lock()
+		acked := i.acked
+		i.ackMu.Unlock()
+
+		count := i.CountAcked(offset, aof)
+		if count >= numReplicas {
+			return count
+		}
+
+		select {
+		case <-acked:
+		case <-ctx.Done():
+			return i.CountAcked(offset, aof)
+		}
+	}
+}
+
+// GoodReplicas returns the number of replicas that acknowledged within maxLag seconds.
+func (i *ReplicationService) GoodReplicas(maxLag int64) int {
+	i.ReplicaMutex.RLock()
+	defer i.ReplicaMutex.RUnlock()
+
+	count := 0
+	for _, r := range i.Replicas {
+		if r.Lag() <= maxLag {
+			count++
+		}
+	}
+
+	return count
+}
+
+// CanWrite reports whether enough good replicas are connected to satisfy
+// min-replicas-to-write. It is always true on replicas and when the option is off.
+func (i *ReplicationService) CanWrite() bool {
+	if !i.IsMaster() || *Config.MinReplicasToWrite <= 0 {
+		return true
+	}
+
+	return i.GoodReplicas(int64(*Config.MinReplicasMaxLag)) >= *Config.MinReplicasToWrite
+}
+
+// SetClientOffset remembers the replication offset of the last write issued by conn.
+func (i *ReplicationService) SetClientOffset(conn net.Conn, offset int64) {
+	i.clientsMu.Lock()
+	defer i.clientsMu.Unlock()
+
+	i.clientOffsets[conn] = offset
+}
+
+func (i *ReplicationService) GetClientOffset(conn net.Conn) int64 {
+	i.clientsMu.Lock()
+	defer i.clientsMu.Unlock()
+
+	return i.clientOffsets[conn]
+}
+
+func (i *ReplicationService) ForgetClient(conn net.Conn) {
+	i.clientsMu.Lock()
+	defer i.clientsMu.Unlock()
+
+	delete(i.clientOffsets, conn)
+	delete(i.listeningPorts, conn)
 }
 
 func (i *ReplicationService) GetReplica(conn net.Conn) *Replica {
+	i.ReplicaMutex.RLock()
+	defer i.ReplicaMutex.RUnlock()
+
 	return i.Replicas[conn.RemoteAddr().String()]
 }
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBecomeMaster_DisconnectsReplicas(t *testing.T) {
+	i := NewReplicationService(Config)
+	i.BecomeReplicaOf("127.0.0.1:6379")
+	i.SetMaster("8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb", 100)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	i.AddReplica(server, NewOutput(server, server, nil))
+
+	i.BecomeMaster()
+
+	assert.NotEqual(t, "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb", i.MasterReplid)
+	assert.Equal(t, "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb", i.replid2)
+	assert.Equal(t, int64(101), i.secondReplOffset)
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "the replica reconnects to learn the new replication id")
+}
@@ -1,6 +1,12 @@
 package store
 
-import "io"
+import (
+	"errors"
+	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
+	"io"
+)
+
+var WrongTypeError = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 
 type Options struct {
 	TTL int64
@@ -9,9 +15,26 @@ type Options struct {
 type DataStore interface {
 	Read(key string) Recordable
 	Write(key string, value string, params ...Options) error
+	// Delete removes the keys, and returns how many existed.
+	Delete(keys ...string) int
 	Keys() []string
 	Dump() []byte
 	Hydrate(r io.Reader) error
 	XAdd(name, id string, entries [][]string) (string, error)
+	// WithStream runs fn on the stream stored at key, nil when there is none
+	// unless create is set, while no other command can read or change it.
+	// fn must not call the store.
+	WithStream(key string, create bool, fn func(st *stream.Stream)) error
 	Increment(key string) (int64, error)
+	// Touch records a modification made in place to the stream at key, and
+	// notifies event unless empty.
+	Touch(key, event string)
+	// Version changes every time key is modified, expires or is flushed.
+	Version(key string) uint64
+}
+
+// Notifier receives the keyspace events of a store. class is the
+// notify-keyspace-events flag of the event, e.g. '$' for string events.
+type Notifier interface {
+	Notify(class byte, event, key string)
 }
@@ -1,11 +1,12 @@
 package store
 
 import (
-	"encoding/hex"
+	"bytes"
 	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/rdb"
 	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
 	"io"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -14,16 +15,52 @@ import (
 type Memory struct {
 	mu    *sync.RWMutex
 	Store map[string]Recordable
+
+	// versions holds the value of clock at the last modification of a key,
+	// WATCH compares them to tell whether a key changed
+	versions map[string]uint64
+	clock    uint64
+
+	notifier Notifier
 }
 
 func NewMemory() *Memory {
 	return &Memory{
-		mu:    &sync.RWMutex{},
-		Store: make(map[string]Recordable),
+		mu:       &sync.RWMutex{},
+		Store:    make(map[string]Recordable),
+		versions: make(map[string]uint64),
 	}
 }
 
+// SetNotifier sends the keyspace events of the store to n.
+func (m *Memory) SetNotifier(n Notifier) {
+	m.notifier = n
+}
+
+func (m *Memory) notify(class byte, event, key string) {
+	if m.notifier != nil {
+		m.notifier.Notify(class, event, key)
+	}
+}
+
+// Read looks key up on behalf of a command, a missing key is notified as a
+// key miss.
 func (m *Memory) Read(key string) Recordable {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := m.lookup(key)
+
+	if v == nil {
+		m.notify('m', "keymiss", key)
+	}
+
+	return v
+}
+
+// lookup returns the value of key, deleting it when it expired. It must be
+// called with m.mu held.
+func (m *Memory) lookup(key string) Recordable {
 	v, ok := m.Store[key]
 
 	if !ok {
@@ -31,13 +68,90 @@ func (m *Memory) Read(key string) Recordable {
 	}
 
 	if v.IsExpired() {
-		delete(m.Store, key)
+		m.expire(key)
 		return nil
 	}
 
 	return v
 }
 
+func (m *Memory) expire(key string) {
+	delete(m.Store, key)
+	m.touch(key)
+
+	m.notify('x', "expired", key)
+}
+
+// activeExpireSamples is the number of keys with a time to live looked at per
+// round of ExpireCycle.
+const activeExpireSamples = 20
+
+// ExpireCycle deletes expired keys that were not read since they expired. It
+// samples keys with a time to live, and goes on while more than a quarter of
+// a sample expired. It returns the keys deleted.
+func (m *Memory) ExpireCycle() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted []string
+
+	for {
+		sampled, expired := 0, 0
+
+		// map iteration starts at a random key
+		for k, v := range m.Store {
+			if r, ok := v.(*SimpleRecord); !ok || r.TTL == 0 {
+				continue
+			}
+
+			if v.IsExpired() {
+				m.expire(k)
+				deleted = append(deleted, k)
+				expired++
+			}
+
+			if sampled++; sampled == activeExpireSamples {
+				break
+			}
+		}
+
+		if expired <= activeExpireSamples/4 {
+			return deleted
+		}
+	}
+}
+
+// touch records a modification of key.
+func (m *Memory) touch(key string) {
+	m.clock++
+	m.versions[key] = m.clock
+}
+
+// Touch records a modification made in place to a stream by a WithStream
+// function, and notifies event unless empty.
+func (m *Memory) Touch(key, event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.touch(key)
+
+	if event != "" {
+		m.notify('t', event, key)
+	}
+}
+
+// Version returns a number that changes every time key is modified, expires
+// or is flushed.
+func (m *Memory) Version(key string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// an expired key may not have been deleted yet
+	m.lookup(key)
+
+	return m.versions[key]
+}
+
 func (m *Memory) Write(key string, value string, params ...Options) error {
 	var ttl int64
 
@@ -47,11 +161,47 @@ func (m *Memory) Write(key string, value string, params ...Options) error {
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+
+	existed := m.lookup(key) != nil
+
 	m.Store[key] = NewRecord(value, ttl, "string") // other data types not implemented yet, this will always be a string
+	m.touch(key)
+
+	if !existed {
+		m.notify('n', "new", key)
+	}
+
+	m.notify('$', "set", key)
+
+	if ttl != 0 {
+		m.notify('g', "expire", key)
+	}
 
 	return nil
 }
 
+// Delete removes the keys, and returns how many existed.
+func (m *Memory) Delete(keys ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+
+	for _, key := range keys {
+		if m.lookup(key) == nil {
+			continue
+		}
+
+		delete(m.Store, key)
+		m.touch(key)
+		m.notify('g', "del", key)
+
+		deleted++
+	}
+
+	return deleted
+}
+
 func (m *Memory) Keys() []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -64,6 +214,7 @@ func (m *Memory) Keys() []string {
 	return keys
 }
 
+// Hydrate replaces the dataset with the first database of an RDB file.
 func (m *Memory) Hydrate(r io.Reader) error {
 	parser := rdb.NewParser(r)
 	err := parser.Parse()
@@ -73,56 +224,191 @@ func (m *Memory) Hydrate(r io.Reader) error {
 		return err
 	}
 
+	records := make(map[string]Recordable)
+
 	if len(parser.Context.Databases) == 0 {
 		fmt.Println("No databases found in dumpFile file")
-		return nil
 	}
 
 	for _, record := range parser.Context.Databases[0].Entries {
+		if record.Stream != nil {
+			st, err := streamFromRDB(record.Key, record.Stream)
+			if err != nil {
+				return err
+			}
+
+			records[record.Key] = st
+			continue
+		}
+
 		ttl := record.Expiry.Value
 
 		if record.Expiry.Type == rdb.EXPIRETIME_SECONDS {
 			ttl = time.Unix(record.Expiry.Value, 0).UnixMilli()
 		}
 
-		m.Store[record.Key] = NewRecord(record.Value, ttl, "string")
+		records[record.Key] = NewRecord(record.Value, ttl, "string")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// replacing the dataset modifies the keys of both
+	for k := range m.Store {
+		m.touch(k)
+	}
+
+	for k := range records {
+		m.touch(k)
 	}
 
+	m.Store = records
+
 	return nil
 }
 
+// Dump snapshots the dataset in the RDB format.
 func (m *Memory) Dump() []byte {
-	// @todo create dump from memory store - currently just a hex encoded empty redis dump file
-	file, _ := hex.DecodeString("524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2")
-	return file
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.Store))
+	expires := 0
+
+	for k, v := range m.Store {
+		if v.IsExpired() {
+			continue
+		}
+
+		keys = append(keys, k)
+
+		if r, ok := v.(*SimpleRecord); ok && r.TTL != 0 {
+			expires++
+		}
+	}
+
+	slices.Sort(keys)
+
+	var buf bytes.Buffer
+
+	w := rdb.NewWriter(&buf)
+	w.WriteHeader(map[rdb.AuxiliaryFieldKey]string{
+		rdb.RedisVersion: "7.2.0",
+		rdb.RedisBits:    "64",
+		rdb.CreationTime: strconv.FormatInt(time.Now().Unix(), 10),
+	})
+
+	if len(keys) > 0 {
+		w.SelectDB(0, len(keys), expires)
+	}
+
+	for _, k := range keys {
+		switch v := m.Store[k].(type) {
+		case *stream.Stream:
+			w.WriteStream(k, streamToRDB(v), 0)
+		case *SimpleRecord:
+			w.WriteString(k, v.Value, v.TTL)
+		}
+	}
+
+	// writing to a buffer does not fail
+	_ = w.Close()
+
+	return buf.Bytes()
 }
 
 func (m *Memory) XAdd(name, id string, e [][]string) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	trieNode, ok := m.Store[name].(*stream.Stream)
+	v := m.lookup(name)
+
+	trieNode, ok := v.(*stream.Stream)
+	if v != nil && !ok {
+		return "", WrongTypeError
+	}
+
 	if !ok {
-		fmt.Println("Stream not found, creating new entry")
 		trieNode = stream.NewTrieStream(name)
-		m.Store[name] = trieNode
 	}
 
-	entries := make(map[string]interface{})
+	entries := make([]stream.Field, 0, len(e))
 	for _, v := range e {
-		entries[v[0]] = v[1]
+		entries = append(entries, stream.Field{Name: v[0], Value: v[1]})
+	}
+
+	entryID, err := trieNode.Add(id, entries)
+
+	if err != nil {
+		return "", err
+	}
+
+	// the stream only exists once an entry made it in
+	if !ok {
+		m.Store[name] = trieNode
+		m.notify('n', "new", name)
+	}
+
+	m.touch(name)
+	m.notify('t', "xadd", name)
+
+	return entryID.String(), nil
+}
+
+// WithStream runs fn on the stream stored at key while holding the lock of
+// the store, so that nothing else reads or changes the stream meanwhile.
+func (m *Memory) WithStream(key string, create bool, fn func(st *stream.Stream)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.stream(key, create)
+	if err != nil {
+		return err
 	}
 
-	return trieNode.Add(id, entries)
+	fn(st)
+
+	return nil
+}
+
+// stream returns the stream stored at key, creating it when create is set.
+// It must be called with m.mu held.
+func (m *Memory) stream(key string, create bool) (*stream.Stream, error) {
+	v := m.lookup(key)
+
+	if v == nil {
+		if !create {
+			m.notify('m', "keymiss", key)
+			return nil, nil
+		}
+
+		st := stream.NewTrieStream(key)
+		m.Store[key] = st
+		m.touch(key)
+		m.notify('n', "new", key)
+
+		return st, nil
+	}
+
+	st, ok := v.(*stream.Stream)
+	if !ok {
+		return nil, WrongTypeError
+	}
+
+	return st, nil
 }
 
 func (m *Memory) Increment(key string) (int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	v, ok := m.Store[key]
-	if !ok || v == nil || v.IsExpired() {
+	v := m.lookup(key)
+	if v == nil {
 		m.Store[key] = NewRecord(strconv.FormatInt(1, 10), 0, "string")
+		m.touch(key)
+		m.notify('n', "new", key)
+		m.notify('$', "incrby", key)
+
 		return 1, nil
 	}
 
@@ -133,6 +419,8 @@ func (m *Memory) Increment(key string) (int64, error) {
 
 	res := i + 1
 	m.Store[key] = NewRecord(strconv.FormatInt(res, 10), 0, "string")
+	m.touch(key)
+	m.notify('$', "incrby", key)
 
 	return res, nil
 }
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVersion(t *testing.T) {
+	m := NewMemory()
+
+	assert.Equal(t, uint64(0), m.Version("k"), "never modified")
+
+	assert.NoError(t, m.Write("k", "1"))
+	v := m.Version("k")
+	assert.NotZero(t, v)
+
+	m.Read("k")
+	assert.Equal(t, v, m.Version("k"), "reading is not a modification")
+
+	_, err := m.Increment("k")
+	assert.NoError(t, err)
+	assert.Greater(t, m.Version("k"), v)
+
+	assert.NoError(t, m.Write("text", "abc"))
+	v = m.Version("text")
+	_, err = m.Increment("text")
+	assert.Error(t, err)
+	assert.Equal(t, v, m.Version("text"), "a failed increment is not a modification")
+
+	v = m.Version("s")
+	_, err = m.XAdd("s", "1-1", [][]string{{"f", "v"}})
+	assert.NoError(t, err)
+	assert.Greater(t, m.Version("s"), v)
+
+	v = m.Version("s")
+	m.Touch("s", "")
+	assert.Greater(t, m.Version("s"), v)
+
+	expireAt := time.Now().Add(20 * time.Millisecond).UnixMilli()
+	assert.NoError(t, m.Write("expiring", "v", Options{TTL: expireAt}))
+	v = m.Version("expiring")
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Greater(t, m.Version("expiring"), v, "expiring is a modification")
+
+	v = m.Version("k")
+	assert.NoError(t, m.Hydrate(bytes.NewReader(NewMemory().Dump())))
+	assert.Greater(t, m.Version("k"), v, "replacing the dataset modifies every key")
+}
+
+type recordedEvents []string
+
+func (r *recordedEvents) Notify(class byte, event, key string) {
+	*r = append(*r, string(class)+" "+event+" "+key)
+}
+
+func TestNotifications(t *testing.T) {
+	m := NewMemory()
+
+	events := &recordedEvents{}
+	m.SetNotifier(events)
+
+	assert.NoError(t, m.Write("k", "1"))
+	assert.NoError(t, m.Write("k", "2"))
+	_, err := m.Increment("k")
+	assert.NoError(t, err)
+	m.Read("missing")
+
+	assert.Equal(t, recordedEvents{
+		"n new k",
+		"$ set k",
+		"$ set k",
+		"$ incrby k",
+		"m keymiss missing",
+	}, *events)
+
+	*events = nil
+
+	expireAt := time.Now().Add(10 * time.Millisecond).UnixMilli()
+	assert.NoError(t, m.Write("a", "v", Options{TTL: expireAt}))
+	assert.NoError(t, m.Write("b", "v", Options{TTL: expireAt}))
+
+	time.Sleep(20 * time.Millisecond)
+	assert.ElementsMatch(t, []string{"a", "b"}, m.ExpireCycle(), "keys expire without being read")
+	assert.Empty(t, m.ExpireCycle())
+
+	assert.Equal(t, recordedEvents{
+		"n new a", "$ set a", "g expire a",
+		"n new b", "$ set b", "g expire b",
+	}, (*events)[:6])
+	assert.ElementsMatch(t, []string{"x expired a", "x expired b"}, (*events)[6:])
+}
+
+func TestDelete(t *testing.T) {
+	m := NewMemory()
+	assert.NoError(t, m.Write("a", "1"))
+	assert.NoError(t, m.Write("b", "1", Options{TTL: time.Now().Add(-time.Second).UnixMilli()}))
+
+	v := m.Version("a")
+
+	assert.Equal(t, 1, m.Delete("a", "b", "c"), "expired keys are not counted")
+	assert.Nil(t, m.Read("a"))
+	assert.Greater(t, m.Version("a"), v)
+}
+
+func TestExpireCycle_ConcurrentReads(t *testing.T) {
+	m := NewMemory()
+	expireAt := time.Now().Add(5 * time.Millisecond).UnixMilli()
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, m.Write(strconv.Itoa(i), "v", Options{TTL: expireAt}))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			m.Read(strconv.Itoa(i))
+		}
+	}()
+
+	for len(m.ExpireCycle()) > 0 {
+	}
+
+	<-done
+	assert.Empty(t, m.Keys())
+}
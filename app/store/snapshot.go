@@ -0,0 +1,125 @@
+package store
+
+import (
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
+)
+
+func toRDBID(id stream.ID) rdb.StreamID {
+	return rdb.StreamID{Ms: id.Ms, Seq: id.Seq}
+}
+
+func fromRDBID(id rdb.StreamID) stream.ID {
+	return stream.ID{Ms: id.Ms, Seq: id.Seq}
+}
+
+// streamToRDB flattens a stream, its consumer groups and their pending
+// entries for the RDB writer.
+func streamToRDB(st *stream.Stream) *rdb.Stream {
+	entries := st.Range(stream.MinID, stream.MaxID)
+
+	s := &rdb.Stream{
+		Entries:      make([]rdb.StreamEntry, 0, len(entries)),
+		LastID:       toRDBID(st.LastID),
+		FirstID:      toRDBID(st.FirstID()),
+		MaxDeletedID: toRDBID(st.MaxDeletedID),
+		EntriesAdded: uint64(st.EntriesAdded),
+	}
+
+	for _, e := range entries {
+		fields := make([]string, 0, 2*len(e.Elements))
+		for _, f := range e.Elements {
+			fields = append(fields, f.Name, f.Value)
+		}
+
+		s.Entries = append(s.Entries, rdb.StreamEntry{ID: toRDBID(e.ID), Fields: fields})
+	}
+
+	for _, name := range st.GroupNames() {
+		g := st.Group(name)
+
+		group := rdb.StreamGroup{
+			Name:        g.Name,
+			LastID:      toRDBID(g.LastID),
+			EntriesRead: g.EntriesRead,
+		}
+
+		for _, p := range g.PendingRange(stream.MinID, stream.MaxID, g.Pending.Len(), nil, 0, 0) {
+			group.Pending = append(group.Pending, rdb.StreamNack{
+				ID:            toRDBID(p.ID),
+				DeliveryTime:  p.DeliveryTime,
+				DeliveryCount: uint64(p.DeliveryCount),
+			})
+		}
+
+		for _, consumerName := range g.ConsumerNames() {
+			c := g.Consumers[consumerName]
+
+			consumer := rdb.StreamConsumer{
+				Name:       c.Name,
+				SeenTime:   c.SeenTime,
+				ActiveTime: c.ActiveTime,
+			}
+
+			for _, p := range g.PendingRange(stream.MinID, stream.MaxID, c.Pending.Len(), c, 0, 0) {
+				consumer.Pending = append(consumer.Pending, toRDBID(p.ID))
+			}
+
+			group.Consumers = append(group.Consumers, consumer)
+		}
+
+		s.Groups = append(s.Groups, group)
+	}
+
+	return s
+}
+
+// streamFromRDB rebuilds a stream read by the RDB parser.
+func streamFromRDB(key string, s *rdb.Stream) (*stream.Stream, error) {
+	st := stream.NewTrieStream(key)
+
+	for _, e := range s.Entries {
+		fields := make([]stream.Field, 0, len(e.Fields)/2)
+		for i := 0; i+1 < len(e.Fields); i += 2 {
+			fields = append(fields, stream.Field{Name: e.Fields[i], Value: e.Fields[i+1]})
+		}
+
+		if err := st.Load(fromRDBID(e.ID), fields); err != nil {
+			return nil, fmt.Errorf("load stream %s: %w", key, err)
+		}
+	}
+
+	st.LastID = fromRDBID(s.LastID)
+	st.MaxDeletedID = fromRDBID(s.MaxDeletedID)
+	st.EntriesAdded = int64(s.EntriesAdded)
+
+	for _, g := range s.Groups {
+		group, created := st.CreateGroup(g.Name, fromRDBID(g.LastID), g.EntriesRead)
+		if !created {
+			return nil, fmt.Errorf("load stream %s: duplicate consumer group %s", key, g.Name)
+		}
+
+		nacks := make(map[rdb.StreamID]rdb.StreamNack, len(g.Pending))
+		for _, nack := range g.Pending {
+			nacks[nack.ID] = nack
+		}
+
+		for _, c := range g.Consumers {
+			consumer, _ := group.CreateConsumer(c.Name, c.SeenTime)
+			consumer.ActiveTime = c.ActiveTime
+
+			for _, id := range c.Pending {
+				nack, ok := nacks[id]
+				if !ok {
+					return nil, fmt.Errorf("load stream %s: consumer %s has entry %d-%d pending outside of the group",
+						key, c.Name, id.Ms, id.Seq)
+				}
+
+				group.AddPending(fromRDBID(id), consumer, nack.DeliveryTime, int64(nack.DeliveryCount))
+			}
+		}
+	}
+
+	return st, nil
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"bytes"
+	"github.com/codecrafters-io/redis-starter-go/app/store/stream"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDumpAndHydrate(t *testing.T) {
+	m := NewMemory()
+
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	assert.NoError(t, m.Write("plain", "value"))
+	assert.NoError(t, m.Write("expiring", "soon", Options{TTL: expireAt}))
+
+	for _, id := range []string{"1-1", "1-2", "2-0"} {
+		_, err := m.XAdd("events", id, [][]string{{"type", "click"}, {"at", id}})
+		assert.NoError(t, err)
+	}
+
+	st, err := m.stream("events", false)
+	assert.NoError(t, err)
+
+	g, _ := st.CreateGroup("workers", stream.MinID, 0)
+	alice := g.Consumer("alice", 1000)
+	g.Consumer("idle", 1500)
+	st.ReadGroup(g, alice, 2, false, 2000)
+
+	replica := NewMemory()
+	assert.NoError(t, replica.Write("stale", "dropped by the full load"))
+	assert.NoError(t, replica.Hydrate(bytes.NewReader(m.Dump())))
+
+	assert.Nil(t, replica.Read("stale"))
+	assert.Equal(t, "value", replica.Read("plain").GetValue())
+	assert.Equal(t, expireAt, replica.Read("expiring").(*SimpleRecord).TTL)
+
+	loaded, err := replica.stream("events", false)
+	assert.NoError(t, err)
+	assert.Equal(t, st.Range(stream.MinID, stream.MaxID), loaded.Range(stream.MinID, stream.MaxID))
+	assert.Equal(t, st.LastID, loaded.LastID)
+	assert.Equal(t, int64(3), loaded.EntriesAdded)
+
+	lg := loaded.Group("workers")
+	assert.NotNil(t, lg)
+	assert.Equal(t, stream.ID{Ms: 1, Seq: 2}, lg.LastID)
+	assert.Equal(t, int64(2), lg.EntriesRead)
+	assert.Equal(t, []string{"alice", "idle"}, lg.ConsumerNames())
+
+	pending := lg.PendingRange(stream.MinID, stream.MaxID, 10, nil, 0, 0)
+	assert.Len(t, pending, 2)
+	assert.Same(t, lg.Consumers["alice"], pending[0].Consumer)
+	assert.Equal(t, int64(2000), pending[0].DeliveryTime)
+	assert.Equal(t, int64(1), pending[0].DeliveryCount)
+	assert.Equal(t, int64(2000), lg.Consumers["alice"].ActiveTime)
+	assert.Equal(t, int64(-1), lg.Consumers["idle"].ActiveTime)
+}
@@ -0,0 +1,501 @@
+package stream
+
+import (
+	"slices"
+)
+
+// InvalidEntriesRead marks a consumer group whose count of read entries is
+// unknown, it is estimated again on the next delivery.
+const InvalidEntriesRead int64 = -1
+
+// PendingEntry is an entry delivered to a consumer and not acknowledged yet.
+// It is shared by the pending entries list of the group and of its consumer.
+type PendingEntry struct {
+	ID            ID
+	Consumer      *Consumer
+	DeliveryTime  int64 // unix time in milliseconds
+	DeliveryCount int64
+}
+
+type Consumer struct {
+	Name string
+
+	// SeenTime is the last time the consumer interacted with the group,
+	// ActiveTime the last time it was delivered entries, or -1.
+	SeenTime   int64
+	ActiveTime int64
+
+	Pending *radixTree[*PendingEntry]
+}
+
+// Group tracks the progress of a consumer group: the last ID delivered, and
+// the entries delivered but not acknowledged yet.
+type Group struct {
+	Name        string
+	LastID      ID
+	EntriesRead int64
+
+	Pending   *radixTree[*PendingEntry]
+	Consumers map[string]*Consumer
+}
+
+func newGroup(name string, lastID ID, entriesRead int64) *Group {
+	return &Group{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		Pending:     newRadixTree[*PendingEntry](),
+		Consumers:   make(map[string]*Consumer),
+	}
+}
+
+// CreateGroup adds a consumer group starting after lastID, returning false
+// when a group with the same name exists.
+func (s *Stream) CreateGroup(name string, lastID ID, entriesRead int64) (*Group, bool) {
+	if _, exists := s.Groups[name]; exists {
+		return nil, false
+	}
+
+	if s.Groups == nil {
+		s.Groups = make(map[string]*Group)
+	}
+
+	g := newGroup(name, lastID, entriesRead)
+	s.Groups[name] = g
+
+	return g, true
+}
+
+func (s *Stream) Group(name string) *Group {
+	return s.Groups[name]
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, exists := s.Groups[name]; !exists {
+		return false
+	}
+
+	delete(s.Groups, name)
+
+	return true
+}
+
+// GroupNames returns the names of the consumer groups in lexicographic order.
+func (s *Stream) GroupNames() []string {
+	names := make([]string, 0, len(s.Groups))
+	for name := range s.Groups {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// SetID moves the last delivered ID of the group, as XGROUP SETID does.
+func (g *Group) SetID(lastID ID, entriesRead int64) {
+	g.LastID = lastID
+	g.EntriesRead = entriesRead
+}
+
+// CreateConsumer adds a consumer, returning false when it already exists.
+func (g *Group) CreateConsumer(name string, now int64) (*Consumer, bool) {
+	if c, exists := g.Consumers[name]; exists {
+		return c, false
+	}
+
+	c := &Consumer{
+		Name:       name,
+		SeenTime:   now,
+		ActiveTime: -1,
+		Pending:    newRadixTree[*PendingEntry](),
+	}
+	g.Consumers[name] = c
+
+	return c, true
+}
+
+// Consumer returns the named consumer, creating it the first time it is
+// seen, and records the interaction.
+func (g *Group) Consumer(name string, now int64) *Consumer {
+	c, _ := g.CreateConsumer(name, now)
+	c.SeenTime = now
+
+	return c
+}
+
+// DeleteConsumer removes a consumer and its pending entries, returning how
+// many entries were pending.
+func (g *Group) DeleteConsumer(name string) (int, bool) {
+	c, exists := g.Consumers[name]
+	if !exists {
+		return 0, false
+	}
+
+	pending := c.Pending.Len()
+
+	c.Pending.Ascend("", func(k string, _ *PendingEntry) bool {
+		g.Pending.Delete(k)
+		return true
+	})
+
+	delete(g.Consumers, name)
+
+	return pending, true
+}
+
+// ConsumerNames returns the names of the consumers in lexicographic order.
+func (g *Group) ConsumerNames() []string {
+	names := make([]string, 0, len(g.Consumers))
+	for name := range g.Consumers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// deliver records the entry as pending for the consumer. An entry already
+// pending, e.g. after the group ID moved backwards, is handed over to the
+// consumer.
+func (g *Group) deliver(id ID, c *Consumer, now int64) {
+	k := id.key()
+
+	if p, ok := g.Pending.Get(k); ok {
+		p.Consumer.Pending.Delete(k)
+
+		p.Consumer = c
+		p.DeliveryTime = now
+		p.DeliveryCount = 1
+		c.Pending.Insert(k, p)
+
+		return
+	}
+
+	g.AddPending(id, c, now, 1)
+}
+
+// AddPending records an entry pending for the consumer, as read from a
+// snapshot or claimed with FORCE.
+func (g *Group) AddPending(id ID, c *Consumer, deliveryTime, deliveryCount int64) *PendingEntry {
+	p := &PendingEntry{ID: id, Consumer: c, DeliveryTime: deliveryTime, DeliveryCount: deliveryCount}
+
+	g.Pending.Insert(id.key(), p)
+	c.Pending.Insert(id.key(), p)
+
+	return p
+}
+
+// Ack removes the entry from the pending entries, returning false when it was
+// not pending.
+func (g *Group) Ack(id ID) bool {
+	p, ok := g.Pending.Get(id.key())
+	if !ok {
+		return false
+	}
+
+	g.drop(p)
+
+	return true
+}
+
+// PendingBounds returns the pending entries with the smallest and greatest
+// IDs, nil when nothing is pending.
+func (g *Group) PendingBounds() (first, last *PendingEntry) {
+	_, first, _ = g.Pending.First()
+	_, last, _ = g.Pending.Last()
+
+	return first, last
+}
+
+// PendingRange returns up to count pending entries with IDs between start and
+// end, only those of consumer when it is not nil and only those idle for at
+// least minIdle milliseconds.
+func (g *Group) PendingRange(start, end ID, count int, consumer *Consumer, minIdle, now int64) []*PendingEntry {
+	pel := g.Pending
+	if consumer != nil {
+		pel = consumer.Pending
+	}
+
+	var result []*PendingEntry
+
+	if count <= 0 || end.Less(start) {
+		return result
+	}
+
+	pel.Ascend(start.key(), func(_ string, p *PendingEntry) bool {
+		if end.Less(p.ID) {
+			return false
+		}
+
+		if minIdle <= 0 || now-p.DeliveryTime >= minIdle {
+			result = append(result, p)
+		}
+
+		return len(result) < count
+	})
+
+	return result
+}
+
+// ClaimOptions tune how XCLAIM and XAUTOCLAIM hand pending entries over.
+type ClaimOptions struct {
+	// MinIdle skips the entries delivered less than MinIdle milliseconds ago.
+	MinIdle int64
+
+	// DeliveryTime is recorded as the delivery time of the claimed entries.
+	DeliveryTime int64
+
+	// RetryCount replaces the delivery count when it is not negative,
+	// otherwise the count is incremented unless JustID is set.
+	RetryCount int64
+
+	// Force creates pending entries for IDs in the stream but pending for no
+	// consumer.
+	Force bool
+
+	JustID bool
+}
+
+// claim hands the pending entry over to the consumer.
+func (g *Group) claim(p *PendingEntry, c *Consumer, opts ClaimOptions) {
+	k := p.ID.key()
+
+	if p.Consumer != c {
+		p.Consumer.Pending.Delete(k)
+		p.Consumer = c
+		c.Pending.Insert(k, p)
+	}
+
+	p.DeliveryTime = opts.DeliveryTime
+
+	if opts.RetryCount >= 0 {
+		p.DeliveryCount = opts.RetryCount
+	} else if !opts.JustID {
+		p.DeliveryCount++
+	}
+}
+
+// drop removes the entry from the pending entries of the group and of its
+// consumer.
+func (g *Group) drop(p *PendingEntry) {
+	k := p.ID.key()
+
+	g.Pending.Delete(k)
+	p.Consumer.Pending.Delete(k)
+}
+
+// Claim hands the entries with the given IDs over to the consumer, as XCLAIM
+// does. It returns the claimed entries and the IDs of those pending but
+// deleted from the stream, which are dropped from the pending entries.
+func (s *Stream) Claim(g *Group, c *Consumer, ids []ID, opts ClaimOptions, now int64) (claimed []*PendingEntry, deleted []ID) {
+	for _, id := range ids {
+		p, pending := g.Pending.Get(id.key())
+
+		if !pending {
+			if !opts.Force || s.Get(id) == nil {
+				continue
+			}
+
+			// a forced entry was never delivered, the idle time does not apply
+			p = g.AddPending(id, c, opts.DeliveryTime, 0)
+		} else {
+			if opts.MinIdle > 0 && now-p.DeliveryTime < opts.MinIdle {
+				continue
+			}
+
+			if s.Get(id) == nil {
+				g.drop(p)
+				deleted = append(deleted, id)
+
+				continue
+			}
+		}
+
+		g.claim(p, c, opts)
+		claimed = append(claimed, p)
+	}
+
+	if len(claimed) > 0 {
+		c.ActiveTime = now
+	}
+
+	return claimed, deleted
+}
+
+// AutoClaim scans the pending entries from start and hands over to the
+// consumer up to count entries idle for long enough, as XAUTOCLAIM does. At
+// most 10 times count entries are looked at. It also returns the IDs dropped
+// because their entries were deleted, and the ID to resume the scan from,
+// MinID once the scan is complete.
+func (s *Stream) AutoClaim(g *Group, c *Consumer, start ID, count int, opts ClaimOptions, now int64) (claimed []*PendingEntry, deleted []ID, next ID) {
+	attempts := count * 10
+	var candidates []*PendingEntry
+
+	// the entries are collected first, dropping them while walking the tree
+	// would change it under the walk
+	g.Pending.Ascend(start.key(), func(_ string, p *PendingEntry) bool {
+		if len(candidates) == attempts {
+			next = p.ID
+			return false
+		}
+
+		candidates = append(candidates, p)
+
+		return true
+	})
+
+	for _, p := range candidates {
+		if len(claimed) == count {
+			next = p.ID
+			break
+		}
+
+		if now-p.DeliveryTime < opts.MinIdle {
+			continue
+		}
+
+		if s.Get(p.ID) == nil {
+			g.drop(p)
+			deleted = append(deleted, p.ID)
+
+			continue
+		}
+
+		g.claim(p, c, opts)
+		claimed = append(claimed, p)
+	}
+
+	if len(claimed) > 0 {
+		c.ActiveTime = now
+	}
+
+	return claimed, deleted, next
+}
+
+// ReadGroup delivers to the consumer up to count entries (all of them when
+// count is 0) never delivered to the group. Unless noAck is set, the entries
+// are added to the pending entries until acknowledged.
+func (s *Stream) ReadGroup(g *Group, c *Consumer, count int, noAck bool, now int64) []*Entry {
+	entries := s.XRead(g.LastID)
+
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+
+	for _, e := range entries {
+		if g.EntriesRead != InvalidEntriesRead && !s.rangeHasTombstones(e.ID, MaxID) {
+			// a valid counter and no tombstone ahead, the count is exact
+			g.EntriesRead++
+		} else if s.EntriesAdded > 0 {
+			g.EntriesRead = s.estimateEntriesRead(e.ID)
+		}
+
+		g.LastID = e.ID
+
+		if !noAck {
+			g.deliver(e.ID, c, now)
+		}
+	}
+
+	if len(entries) > 0 {
+		c.ActiveTime = now
+	}
+
+	return entries
+}
+
+// ReadHistory delivers again up to count entries pending for the consumer with
+// IDs greater than after. Entries deleted from the stream meanwhile are
+// returned without fields.
+func (s *Stream) ReadHistory(c *Consumer, after ID, count int, now int64) []*Entry {
+	var entries []*Entry
+
+	start, ok := after.Next()
+	if !ok {
+		return entries
+	}
+
+	c.Pending.Ascend(start.key(), func(_ string, p *PendingEntry) bool {
+		entry := s.Get(p.ID)
+		if entry == nil {
+			entry = &Entry{ID: p.ID}
+		}
+
+		p.DeliveryTime = now
+		p.DeliveryCount++
+
+		entries = append(entries, entry)
+
+		return count <= 0 || len(entries) < count
+	})
+
+	return entries
+}
+
+// Lag returns how many entries of the stream the group has yet to read, or
+// false when deletions make it impossible to tell.
+func (s *Stream) Lag(g *Group) (int64, bool) {
+	if s.EntriesAdded == 0 {
+		return 0, true
+	}
+
+	if g.EntriesRead != InvalidEntriesRead && !s.rangeHasTombstones(g.LastID, MaxID) {
+		return s.EntriesAdded - g.EntriesRead, true
+	}
+
+	entriesRead := s.estimateEntriesRead(g.LastID)
+	if entriesRead == InvalidEntriesRead {
+		return 0, false
+	}
+
+	return s.EntriesAdded - entriesRead, true
+}
+
+// rangeHasTombstones tells whether entries between start and end may have
+// been deleted.
+func (s *Stream) rangeHasTombstones(start, end ID) bool {
+	if s.length == 0 || s.MaxDeletedID.IsZero() {
+		return false
+	}
+
+	if s.MaxDeletedID.Less(s.FirstID()) {
+		return false
+	}
+
+	return !s.MaxDeletedID.Less(start) && !end.Less(s.MaxDeletedID)
+}
+
+// estimateEntriesRead returns how many entries were added up to id, or
+// InvalidEntriesRead when deletions make it impossible to tell.
+func (s *Stream) estimateEntriesRead(id ID) int64 {
+	if s.EntriesAdded == 0 {
+		return 0
+	}
+
+	if s.length == 0 && !s.MaxDeletedID.Less(id) {
+		return s.EntriesAdded
+	}
+
+	switch id.Compare(s.LastID) {
+	case 0:
+		return s.EntriesAdded
+	case 1:
+		return InvalidEntriesRead
+	}
+
+	first := s.FirstID()
+
+	// without deletions after the first entry there is no gap to account for
+	if s.MaxDeletedID.IsZero() || s.MaxDeletedID.Less(first) {
+		switch id.Compare(first) {
+		case -1:
+			return s.EntriesAdded - s.length
+		case 0:
+			return s.EntriesAdded - s.length + 1
+		}
+	}
+
+	return InvalidEntriesRead
+}
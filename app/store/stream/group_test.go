@@ -0,0 +1,274 @@
+package stream
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestStream(t *testing.T, n int) *Stream {
+	s := NewTrieStream("test-stream")
+
+	for i := 1; i <= n; i++ {
+		_, err := s.Add(fmt.Sprintf("%d-0", i), []Field{{"n", fmt.Sprint(i)}})
+		assert.NoError(t, err)
+	}
+
+	return s
+}
+
+func TestReadGroup_DeliversNewEntriesOnce(t *testing.T) {
+	s := newTestStream(t, 5)
+
+	g, created := s.CreateGroup("g", MinID, 0)
+	assert.True(t, created)
+
+	_, created = s.CreateGroup("g", MinID, 0)
+	assert.False(t, created, "group names are unique")
+
+	alice := g.Consumer("alice", 100)
+	bob := g.Consumer("bob", 100)
+
+	entries := s.ReadGroup(g, alice, 2, false, 1000)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, ID{Ms: 2}, g.LastID)
+	assert.Equal(t, int64(2), g.EntriesRead)
+	assert.Equal(t, int64(1000), alice.ActiveTime)
+
+	entries = s.ReadGroup(g, bob, 0, false, 2000)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, ID{Ms: 3}, entries[0].ID)
+	assert.Equal(t, int64(5), g.EntriesRead)
+
+	assert.Empty(t, s.ReadGroup(g, alice, 0, false, 3000))
+	assert.Equal(t, int64(2000), bob.ActiveTime)
+
+	assert.Equal(t, 5, g.Pending.Len())
+	assert.Equal(t, 2, alice.Pending.Len())
+	assert.Equal(t, 3, bob.Pending.Len())
+
+	first, last := g.PendingBounds()
+	assert.Equal(t, ID{Ms: 1}, first.ID)
+	assert.Equal(t, ID{Ms: 5}, last.ID)
+	assert.Same(t, bob, last.Consumer)
+}
+
+func TestReadGroup_NoAck(t *testing.T) {
+	s := newTestStream(t, 3)
+
+	g, _ := s.CreateGroup("g", MinID, InvalidEntriesRead)
+	c := g.Consumer("c", 0)
+
+	assert.Len(t, s.ReadGroup(g, c, 0, true, 0), 3)
+	assert.Equal(t, 0, g.Pending.Len())
+	assert.Equal(t, ID{Ms: 3}, g.LastID)
+	assert.Equal(t, int64(3), g.EntriesRead, "an unknown counter is estimated on delivery")
+}
+
+func TestReadGroup_RedeliveryMovesPendingEntry(t *testing.T) {
+	s := newTestStream(t, 2)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	alice := g.Consumer("alice", 0)
+	bob := g.Consumer("bob", 0)
+
+	s.ReadGroup(g, alice, 0, false, 10)
+	s.ReadHistory(alice, MinID, 0, 20)
+
+	p, _ := g.Pending.Get(ID{Ms: 1}.key())
+	assert.Equal(t, int64(2), p.DeliveryCount)
+
+	g.SetID(MinID, 0)
+	s.ReadGroup(g, bob, 1, false, 30)
+
+	assert.Same(t, bob, p.Consumer)
+	assert.Equal(t, int64(1), p.DeliveryCount)
+	assert.Equal(t, int64(30), p.DeliveryTime)
+	assert.Equal(t, 1, alice.Pending.Len())
+	assert.Equal(t, 1, bob.Pending.Len())
+	assert.Equal(t, 2, g.Pending.Len())
+}
+
+func TestReadHistory(t *testing.T) {
+	s := newTestStream(t, 4)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	c := g.Consumer("c", 0)
+	s.ReadGroup(g, c, 0, false, 0)
+
+	history := s.ReadHistory(c, ID{Ms: 1}, 2, 50)
+	assert.Len(t, history, 2)
+	assert.Equal(t, ID{Ms: 2}, history[0].ID)
+	assert.Equal(t, []Field{{"n", "2"}}, history[0].Elements)
+
+	p, _ := g.Pending.Get(ID{Ms: 2}.key())
+	assert.Equal(t, int64(2), p.DeliveryCount)
+	assert.Equal(t, int64(50), p.DeliveryTime)
+
+	assert.Empty(t, s.ReadHistory(c, MaxID, 0, 0))
+}
+
+func TestAckAndDeleteConsumer(t *testing.T) {
+	s := newTestStream(t, 3)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	alice := g.Consumer("alice", 0)
+	bob := g.Consumer("bob", 0)
+
+	s.ReadGroup(g, alice, 2, false, 0)
+	s.ReadGroup(g, bob, 1, false, 0)
+
+	assert.True(t, g.Ack(ID{Ms: 1}))
+	assert.False(t, g.Ack(ID{Ms: 1}), "already acknowledged")
+	assert.Equal(t, 2, g.Pending.Len())
+	assert.Equal(t, 1, alice.Pending.Len())
+
+	pending, deleted := g.DeleteConsumer("alice")
+	assert.True(t, deleted)
+	assert.Equal(t, 1, pending)
+	assert.Equal(t, 1, g.Pending.Len(), "the pending entries of the consumer are dropped")
+	assert.Equal(t, []string{"bob"}, g.ConsumerNames())
+
+	_, deleted = g.DeleteConsumer("alice")
+	assert.False(t, deleted)
+}
+
+func TestPendingRange(t *testing.T) {
+	s := newTestStream(t, 5)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	alice := g.Consumer("alice", 0)
+	bob := g.Consumer("bob", 0)
+
+	s.ReadGroup(g, alice, 3, false, 1000)
+	s.ReadGroup(g, bob, 0, false, 2000)
+
+	all := g.PendingRange(MinID, MaxID, 10, nil, 0, 2500)
+	assert.Len(t, all, 5)
+
+	limited := g.PendingRange(ID{Ms: 2}, MaxID, 2, nil, 0, 2500)
+	assert.Equal(t, ID{Ms: 2}, limited[0].ID)
+	assert.Equal(t, ID{Ms: 3}, limited[1].ID)
+
+	idle := g.PendingRange(MinID, MaxID, 10, nil, 1000, 2500)
+	assert.Len(t, idle, 3, "only the entries delivered to alice are idle long enough")
+
+	ofBob := g.PendingRange(MinID, MaxID, 10, bob, 0, 2500)
+	assert.Len(t, ofBob, 2)
+	assert.Equal(t, ID{Ms: 4}, ofBob[0].ID)
+
+	assert.Empty(t, g.PendingRange(ID{Ms: 4}, ID{Ms: 3}, 10, nil, 0, 2500))
+	assert.Empty(t, g.PendingRange(MinID, MaxID, 0, nil, 0, 2500))
+}
+
+func TestEstimateEntriesRead(t *testing.T) {
+	empty := NewTrieStream("empty")
+	assert.Equal(t, int64(0), empty.estimateEntriesRead(MinID))
+
+	s := newTestStream(t, 3)
+
+	assert.Equal(t, int64(3), s.estimateEntriesRead(ID{Ms: 3}), "the last ID read all entries")
+	assert.Equal(t, int64(1), s.estimateEntriesRead(ID{Ms: 1}))
+	assert.Equal(t, int64(0), s.estimateEntriesRead(MinID))
+	assert.Equal(t, InvalidEntriesRead, s.estimateEntriesRead(ID{Ms: 2}), "an ID in the middle can't be told")
+	assert.Equal(t, InvalidEntriesRead, s.estimateEntriesRead(ID{Ms: 9}))
+}
+
+func TestClaim(t *testing.T) {
+	s := newTestStream(t, 4)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	alice := g.Consumer("alice", 0)
+	bob := g.Consumer("bob", 0)
+
+	s.ReadGroup(g, alice, 3, false, 1000)
+
+	opts := ClaimOptions{MinIdle: 500, DeliveryTime: 1200, RetryCount: -1}
+
+	claimed, deleted := s.Claim(g, bob, []ID{{Ms: 1}, {Ms: 2}, {Ms: 4}}, opts, 1200)
+	assert.Empty(t, claimed, "the entries are not idle for long enough")
+	assert.Empty(t, deleted)
+
+	opts.DeliveryTime = 1600
+	claimed, _ = s.Claim(g, bob, []ID{{Ms: 1}, {Ms: 2}, {Ms: 4}}, opts, 1600)
+	assert.Len(t, claimed, 2, "4-0 was never delivered")
+	assert.Same(t, bob, claimed[0].Consumer)
+	assert.Equal(t, int64(2), claimed[0].DeliveryCount)
+	assert.Equal(t, int64(1600), claimed[0].DeliveryTime)
+	assert.Equal(t, 1, alice.Pending.Len())
+	assert.Equal(t, 2, bob.Pending.Len())
+	assert.Equal(t, int64(1600), bob.ActiveTime)
+
+	forced := ClaimOptions{MinIdle: 10000, DeliveryTime: 1700, RetryCount: 7, Force: true}
+	claimed, _ = s.Claim(g, bob, []ID{{Ms: 4}, {Ms: 9}}, forced, 1700)
+	assert.Len(t, claimed, 1, "only entries of the stream are forced")
+	assert.Equal(t, int64(7), claimed[0].DeliveryCount)
+	assert.Equal(t, 4, g.Pending.Len())
+
+	justID := ClaimOptions{DeliveryTime: 1800, RetryCount: -1, JustID: true}
+	claimed, _ = s.Claim(g, alice, []ID{{Ms: 1}}, justID, 1800)
+	assert.Equal(t, int64(2), claimed[0].DeliveryCount, "JUSTID does not count as a delivery")
+}
+
+func TestAutoClaim(t *testing.T) {
+	s := newTestStream(t, 5)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	alice := g.Consumer("alice", 0)
+	bob := g.Consumer("bob", 0)
+
+	s.ReadGroup(g, alice, 0, false, 1000)
+
+	// an entry deleted from the stream after its delivery
+	g.AddPending(ID{Ms: 6}, alice, 1000, 1)
+
+	opts := ClaimOptions{MinIdle: 100, DeliveryTime: 2000, RetryCount: -1}
+
+	claimed, deleted, next := s.AutoClaim(g, bob, MinID, 2, opts, 2000)
+	assert.Len(t, claimed, 2)
+	assert.Empty(t, deleted)
+	assert.Equal(t, ID{Ms: 3}, next)
+
+	claimed, deleted, next = s.AutoClaim(g, bob, next, 4, opts, 2000)
+	assert.Len(t, claimed, 3)
+	assert.Equal(t, ID{Ms: 5}, claimed[2].ID)
+	assert.Equal(t, []ID{{Ms: 6}}, deleted, "6-0 is not in the stream")
+	assert.Equal(t, MinID, next, "the scan is complete")
+
+	assert.Equal(t, 0, alice.Pending.Len())
+	assert.Equal(t, 5, bob.Pending.Len())
+	assert.Equal(t, 5, g.Pending.Len())
+
+	claimed, _, _ = s.AutoClaim(g, alice, MinID, 10, opts, 2050)
+	assert.Empty(t, claimed, "the entries were just claimed")
+}
+
+func TestLag(t *testing.T) {
+	s := newTestStream(t, 5)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	c := g.Consumer("c", 0)
+
+	lag, ok := s.Lag(g)
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), lag)
+
+	s.ReadGroup(g, c, 2, false, 0)
+	lag, _ = s.Lag(g)
+	assert.Equal(t, int64(3), lag)
+
+	s.Delete(ID{Ms: 4})
+	_, ok = s.Lag(g)
+	assert.False(t, ok, "an entry deleted ahead of the group")
+
+	s.ReadGroup(g, c, 0, false, 0)
+	lag, ok = s.Lag(g)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), lag)
+
+	empty := NewTrieStream("empty")
+	unread, _ := empty.CreateGroup("g", MinID, InvalidEntriesRead)
+	lag, ok = empty.Lag(unread)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), lag)
+}
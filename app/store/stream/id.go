@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ID identifies a stream entry: a millisecond timestamp and a sequence number
+// for entries added within the same millisecond.
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinID = ID{}
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+var InvalidID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// ParseID parses "<ms>-<seq>". A bare "<ms>" gets a zero sequence number.
+func ParseID(s string) (ID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, InvalidID
+	}
+
+	if !hasSeq {
+		return ID{Ms: ms}, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, InvalidID
+	}
+
+	return ID{Ms: ms, Seq: seq}, nil
+}
+
+// ParseRangeID parses a range bound, where "-" and "+" stand for the smallest
+// and greatest IDs. A bare "<ms>" covers the whole millisecond, so it starts
+// at sequence 0 and ends at the greatest sequence number.
+func ParseRangeID(s string, end bool) (ID, error) {
+	switch s {
+	case "-":
+		return MinID, nil
+	case "+":
+		return MaxID, nil
+	}
+
+	id, err := ParseID(s)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if end && !strings.Contains(s, "-") {
+		id.Seq = math.MaxUint64
+	}
+
+	return id, nil
+}
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id ID) Compare(other ID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (id ID) Less(other ID) bool {
+	return id.Compare(other) < 0
+}
+
+// Next returns the smallest ID greater than id, or false for MaxID.
+func (id ID) Next() (ID, bool) {
+	switch {
+	case id == MaxID:
+		return MaxID, false
+	case id.Seq == math.MaxUint64:
+		return ID{Ms: id.Ms + 1}, true
+	default:
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+}
+
+// Prev returns the greatest ID smaller than id, or false for MinID.
+func (id ID) Prev() (ID, bool) {
+	switch {
+	case id == MinID:
+		return MinID, false
+	case id.Seq == 0:
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	default:
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+}
+
+func (id ID) IsZero() bool {
+	return id == MinID
+}
+
+// key encodes the ID big-endian, so the byte order of keys in the radix tree
+// is the numeric order of the IDs.
+func (id ID) key() string {
+	var b [16]byte
+
+	binary.BigEndian.PutUint64(b[:8], id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Seq)
+
+	return string(b[:])
+}
+
+func idFromKey(k string) ID {
+	return ID{
+		Ms:  binary.BigEndian.Uint64([]byte(k[:8])),
+		Seq: binary.BigEndian.Uint64([]byte(k[8:])),
+	}
+}
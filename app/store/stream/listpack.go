@@ -0,0 +1,205 @@
+package stream
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// Limits of a single listpack, as stream-node-max-entries and
+// stream-node-max-bytes do in Redis.
+const (
+	maxListpackEntries = 100
+	maxListpackBytes   = 4096
+)
+
+const (
+	// the entry has the same field names as the master entry and only
+	// stores its values
+	flagSameFields byte = 1 << iota
+
+	// the entry was deleted, it stays in the buffer until the whole
+	// listpack goes
+	flagDeleted
+)
+
+// listpack packs a batch of consecutive entries into a single buffer. Entry
+// IDs are stored as deltas from the master ID, the ID of the first entry and
+// the radix tree key of the batch, and field names are only kept once when
+// entries share those of the master entry.
+type listpack struct {
+	master       ID
+	masterFields []string
+	last         ID
+	count        int // entries in the buffer, deleted ones included
+	deleted      int
+	buf          []byte
+}
+
+func newListpack(master ID, fields []string) *listpack {
+	return &listpack{
+		master:       master,
+		masterFields: fields,
+	}
+}
+
+func (lp *listpack) full() bool {
+	return lp.count >= maxListpackEntries || len(lp.buf) >= maxListpackBytes
+}
+
+// live returns the number of entries not deleted.
+func (lp *listpack) live() int64 {
+	return int64(lp.count - lp.deleted)
+}
+
+func fieldNames(elements []Field) []string {
+	fields := make([]string, 0, len(elements))
+	for _, f := range elements {
+		fields = append(fields, f.Name)
+	}
+
+	return fields
+}
+
+// Append adds an entry whose ID is greater than any in the listpack.
+func (lp *listpack) Append(id ID, elements []Field) {
+	fields := fieldNames(elements)
+
+	flags := byte(0)
+	if slices.Equal(fields, lp.masterFields) {
+		flags |= flagSameFields
+	}
+
+	lp.buf = append(lp.buf, flags)
+	lp.buf = binary.AppendUvarint(lp.buf, id.Ms-lp.master.Ms)
+
+	// the sequence only restarts once the millisecond changes
+	if id.Ms == lp.master.Ms {
+		lp.buf = binary.AppendUvarint(lp.buf, id.Seq-lp.master.Seq)
+	} else {
+		lp.buf = binary.AppendUvarint(lp.buf, id.Seq)
+	}
+
+	lp.buf = binary.AppendUvarint(lp.buf, uint64(len(fields)))
+
+	for _, f := range elements {
+		if flags&flagSameFields == 0 {
+			lp.buf = appendString(lp.buf, f.Name)
+		}
+
+		lp.buf = appendString(lp.buf, f.Value)
+	}
+
+	lp.last = id
+	lp.count++
+}
+
+// Entries decodes the entries of the listpack in ID order, starting at the
+// first entry with an ID greater than or equal to from.
+func (lp *listpack) Entries(from ID) []*Entry {
+	entries := make([]*Entry, 0, lp.count)
+
+	lp.walk(func(e *Entry) bool {
+		if !e.ID.Less(from) {
+			entries = append(entries, e)
+		}
+
+		return true
+	})
+
+	return entries
+}
+
+// Delete marks the entry as deleted, returning false when it is not in the
+// listpack or already deleted.
+func (lp *listpack) Delete(id ID) bool {
+	deleted := false
+
+	lp.scan(func(offset int, flags byte, e *Entry) bool {
+		if e.ID.Less(id) {
+			return true
+		}
+
+		if e.ID == id && flags&flagDeleted == 0 {
+			lp.markDeleted(offset)
+			deleted = true
+		}
+
+		return false
+	})
+
+	return deleted
+}
+
+func (lp *listpack) markDeleted(offset int) {
+	lp.buf[offset] |= flagDeleted
+	lp.deleted++
+}
+
+// walk calls fn for the entries not deleted, in ID order, until it returns
+// false.
+func (lp *listpack) walk(fn func(e *Entry) bool) {
+	lp.scan(func(_ int, flags byte, e *Entry) bool {
+		if flags&flagDeleted != 0 {
+			return true
+		}
+
+		return fn(e)
+	})
+}
+
+// scan decodes every entry of the buffer, deleted ones included, along with
+// the offset of its flags.
+func (lp *listpack) scan(fn func(offset int, flags byte, e *Entry) bool) {
+	buf := lp.buf
+
+	for len(buf) > 0 {
+		offset := len(lp.buf) - len(buf)
+		flags := buf[0]
+		buf = buf[1:]
+
+		msDelta, n := binary.Uvarint(buf)
+		buf = buf[n:]
+
+		seq, n := binary.Uvarint(buf)
+		buf = buf[n:]
+
+		id := ID{Ms: lp.master.Ms + msDelta, Seq: seq}
+		if msDelta == 0 {
+			id.Seq += lp.master.Seq
+		}
+
+		count, n := binary.Uvarint(buf)
+		buf = buf[n:]
+
+		elements := make([]Field, 0, count)
+
+		for i := 0; i < int(count); i++ {
+			var field, value string
+
+			if flags&flagSameFields != 0 {
+				field = lp.masterFields[i]
+			} else {
+				field, buf = readString(buf)
+			}
+
+			value, buf = readString(buf)
+			elements = append(elements, Field{Name: field, Value: value})
+		}
+
+		if !fn(offset, flags, &Entry{ID: id, Elements: elements}) {
+			return
+		}
+	}
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(buf []byte) (string, []byte) {
+	l, n := binary.Uvarint(buf)
+	buf = buf[n:]
+
+	return string(buf[:l]), buf[l:]
+}
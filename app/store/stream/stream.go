@@ -0,0 +1,307 @@
+package stream
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field is a field/value pair of an entry. Entries keep their fields in the
+// order they were added, duplicates included.
+type Field struct {
+	Name  string
+	Value string
+}
+
+type Entry struct {
+	ID       ID
+	Elements []Field
+}
+
+// Stream keeps its entries in listpacks indexed by a radix tree on their
+// master ID.
+type Stream struct {
+	Name   string
+	Value  *radixTree[*listpack]
+	LastID ID
+	length int64
+
+	// EntriesAdded counts every entry ever added, and MaxDeletedID is the
+	// greatest ID deleted so far. Together they tell how far a consumer group
+	// is from the end of the stream.
+	EntriesAdded int64
+	MaxDeletedID ID
+
+	Groups map[string]*Group
+}
+
+var (
+	zeroIDError      = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	smallerIDError   = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	exhaustedIDError = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+func (s *Stream) GetType() string {
+	return "stream"
+}
+
+func (s *Stream) GetValue() string {
+	return ""
+}
+
+func (s *Stream) IsExpired() bool {
+	return false
+}
+
+func NewTrieStream(name string) *Stream {
+	return &Stream{
+		Name:  name,
+		Value: newRadixTree[*listpack](),
+	}
+}
+
+func (s *Stream) Len() int64 {
+	return s.length
+}
+
+// RadixTreeKeys returns the number of listpacks holding the entries.
+func (s *Stream) RadixTreeKeys() int {
+	return s.Value.Len()
+}
+
+// RadixTreeNodes returns the number of nodes of the radix tree indexing the
+// listpacks.
+func (s *Stream) RadixTreeNodes() int {
+	return s.Value.Nodes()
+}
+
+// Add appends an entry. The ID may be explicit ("1-1"), have an
+// auto-generated sequence ("1-*") or be fully auto-generated ("*").
+func (s *Stream) Add(id string, elements []Field) (ID, error) {
+	entryID, err := s.nextID(id)
+
+	if err != nil {
+		return ID{}, err
+	}
+
+	s.append(entryID, elements)
+
+	return entryID, nil
+}
+
+// Load appends an entry read from a snapshot, its ID has to come after the
+// last one.
+func (s *Stream) Load(id ID, elements []Field) error {
+	if !s.LastID.Less(id) {
+		return smallerIDError
+	}
+
+	s.append(id, elements)
+
+	return nil
+}
+
+func (s *Stream) append(id ID, elements []Field) {
+	_, lp, ok := s.Value.Last()
+
+	if !ok || lp.full() {
+		lp = newListpack(id, fieldNames(elements))
+		s.Value.Insert(id.key(), lp)
+	}
+
+	lp.Append(id, elements)
+
+	s.length++
+	s.EntriesAdded++
+	s.LastID = id
+}
+
+// nextID resolves the ID requested by XADD and checks it comes after the
+// last ID, even when the entries were deleted since.
+func (s *Stream) nextID(id string) (ID, error) {
+	entryID, err := s.resolveID(id)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if !s.LastID.Less(entryID) {
+		return ID{}, smallerIDError
+	}
+
+	return entryID, nil
+}
+
+func (s *Stream) resolveID(id string) (ID, error) {
+	if id == "*" {
+		ms := uint64(time.Now().UnixMilli())
+
+		// the clock may go backwards, IDs may not
+		if ms <= s.LastID.Ms {
+			if s.LastID.Seq == math.MaxUint64 {
+				return ID{}, exhaustedIDError
+			}
+
+			return ID{Ms: s.LastID.Ms, Seq: s.LastID.Seq + 1}, nil
+		}
+
+		return ID{Ms: ms}, nil
+	}
+
+	if msPart, ok := strings.CutSuffix(id, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return ID{}, InvalidID
+		}
+
+		switch {
+		case ms < s.LastID.Ms:
+			return ID{}, smallerIDError
+		case ms == s.LastID.Ms && !s.LastID.IsZero():
+			if s.LastID.Seq == math.MaxUint64 {
+				return ID{}, smallerIDError
+			}
+
+			return ID{Ms: ms, Seq: s.LastID.Seq + 1}, nil
+		case ms == 0:
+			return ID{Seq: 1}, nil
+		default:
+			return ID{Ms: ms}, nil
+		}
+	}
+
+	entryID, err := ParseID(id)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if entryID.IsZero() {
+		return ID{}, zeroIDError
+	}
+
+	return entryID, nil
+}
+
+// FirstID returns the ID of the first entry, or a zero ID for an empty
+// stream.
+func (s *Stream) FirstID() ID {
+	_, lp, ok := s.Value.First()
+	if !ok {
+		return MinID
+	}
+
+	var first ID
+	lp.walk(func(e *Entry) bool {
+		first = e.ID
+		return false
+	})
+
+	return first
+}
+
+// Get returns the entry with the given ID.
+func (s *Stream) Get(id ID) *Entry {
+	entries := s.Range(id, id)
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return entries[0]
+}
+
+// Range returns the entries with IDs between start and end, both included.
+func (s *Stream) Range(start, end ID) []*Entry {
+	return s.RangeCount(start, end, 0)
+}
+
+// RangeCount returns up to count entries (all of them when count is 0) with
+// IDs between start and end, both included. The seek to the listpack holding
+// start is logarithmic, only the listpacks overlapping the range are
+// decoded.
+func (s *Stream) RangeCount(start, end ID, count int) []*Entry {
+	if end.Less(start) {
+		return nil
+	}
+
+	from := start.key()
+
+	// the entry may live in the listpack keyed right before it
+	if k, _, ok := s.Value.Floor(from); ok {
+		from = k
+	}
+
+	var result []*Entry
+
+	s.Value.Ascend(from, func(k string, lp *listpack) bool {
+		if end.Less(idFromKey(k)) {
+			return false
+		}
+
+		for _, e := range lp.Entries(start) {
+			if end.Less(e.ID) {
+				return false
+			}
+
+			result = append(result, e)
+
+			if count > 0 && len(result) == count {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	return result
+}
+
+// RevRange returns up to count entries (all of them when count is 0) with
+// IDs between start and end, both included, from the greatest ID down.
+func (s *Stream) RevRange(end, start ID, count int) []*Entry {
+	if end.Less(start) {
+		return nil
+	}
+
+	var result []*Entry
+
+	k, lp, ok := s.Value.Floor(end.key())
+
+	for ok {
+		entries := lp.Entries(start)
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			if end.Less(entries[i].ID) {
+				continue
+			}
+
+			result = append(result, entries[i])
+
+			if count > 0 && len(result) == count {
+				return result
+			}
+		}
+
+		// the listpacks before this one only hold entries lower than start
+		master := idFromKey(k)
+		if !start.Less(master) {
+			break
+		}
+
+		prev, _ := master.Prev()
+		k, lp, ok = s.Value.Floor(prev.key())
+	}
+
+	return result
+}
+
+// XRead returns the entries with IDs greater than after.
+func (s *Stream) XRead(after ID) []*Entry {
+	start, ok := after.Next()
+	if !ok {
+		return nil
+	}
+
+	return s.Range(start, MaxID)
+}
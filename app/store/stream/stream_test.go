@@ -0,0 +1,246 @@
+package stream
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAddSingleEntry(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	entries := []Field{{"field1", "value1"}, {"field2", "value2"}}
+
+	id, err := trie.Add("1-1", entries)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, ID{Ms: 1, Seq: 1}, id)
+	assert.Equal(t, int64(1), trie.Len())
+
+	entry := trie.Get(id)
+	assert.NotNil(t, entry)
+	assert.Equal(t, entries, entry.Elements, "Entry fields mismatch")
+}
+
+func TestAdd_KeepsFieldOrderAndDuplicates(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	fields := []Field{{"z", "1"}, {"a", "2"}, {"z", "3"}}
+	id, err := trie.Add("1-0", fields)
+	assert.NoError(t, err)
+
+	// same field names as the master entry are only stored once
+	other := []Field{{"z", "4"}, {"a", "5"}, {"z", "6"}}
+	_, err = trie.Add("1-1", other)
+	assert.NoError(t, err)
+
+	entries := trie.Range(id, MaxID)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, fields, entries[0].Elements)
+	assert.Equal(t, other, entries[1].Elements)
+}
+
+func TestAdd_NumericOrdering(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	_, err := trie.Add("9-0", []Field{{"f", "v"}})
+	assert.NoError(t, err)
+
+	_, err = trie.Add("10-0", []Field{{"f", "v"}})
+	assert.NoError(t, err, "10-0 comes after 9-0")
+
+	_, err = trie.Add("10-0", []Field{{"f", "v"}})
+	assert.ErrorIs(t, err, smallerIDError)
+
+	_, err = trie.Add("0-0", []Field{{"f", "v"}})
+	assert.ErrorIs(t, err, zeroIDError)
+
+	_, err = trie.Add("abc", []Field{{"f", "v"}})
+	assert.ErrorIs(t, err, InvalidID)
+}
+
+func TestAdd_GeneratedIDs(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	id, err := trie.Add("0-*", []Field{{"f", "v"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ID{Ms: 0, Seq: 1}, id)
+
+	id, err = trie.Add("5-*", []Field{{"f", "v"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ID{Ms: 5, Seq: 0}, id)
+
+	id, err = trie.Add("5-*", []Field{{"f", "v"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ID{Ms: 5, Seq: 1}, id)
+
+	id, err = trie.Add("*", []Field{{"f", "v"}})
+	assert.NoError(t, err)
+	assert.True(t, ID{Ms: 5, Seq: 1}.Less(id))
+}
+
+func TestAdd_GeneratedIDsAfterDeletion(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	for _, id := range []string{"5-1", "5-2", "5-3"} {
+		_, err := trie.Add(id, []Field{{"f", "v"}})
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, int64(3), trie.Delete(ID{Ms: 5, Seq: 1}, ID{Ms: 5, Seq: 2}, ID{Ms: 5, Seq: 3}))
+
+	id, err := trie.Add("5-*", []Field{{"f", "v"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ID{Ms: 5, Seq: 4}, id, "IDs never go backwards, the stream being empty or not")
+
+	_, err = trie.Add("5-4", []Field{{"f", "v"}})
+	assert.Equal(t, smallerIDError, err)
+}
+
+func TestGet(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	// Add multiple entries
+	entries := []struct {
+		id       string
+		elements []Field
+	}{
+		{"1-0", []Field{{"field1", "value1"}}},
+		{"1-1", []Field{{"field2", "value2"}}},
+		{"2-0", []Field{{"field3", "value3"}}},
+	}
+
+	for _, entry := range entries {
+		_, err := trie.Add(entry.id, entry.elements)
+		assert.NoError(t, err)
+	}
+
+	// Retrieve existing entry
+	foundEntry := trie.Get(ID{Ms: 1})
+	assert.NotNil(t, foundEntry, "Expected to find entry with ID '1-0'")
+	assert.Equal(t, []Field{{"field1", "value1"}}, foundEntry.Elements, "Fields do not match for '1-0'")
+
+	foundEntry = trie.Get(ID{Ms: 2})
+	assert.NotNil(t, foundEntry, "Expected to find entry with ID '2-0'")
+	assert.Equal(t, []Field{{"field3", "value3"}}, foundEntry.Elements, "Fields do not match for '2-0'")
+
+	// Try to retrieve a non-existing entry
+	notFoundEntry := trie.Get(ID{Ms: 3})
+	assert.Nil(t, notFoundEntry, "Expected nil for non-existing entry")
+}
+
+func TestStreamRange(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	_, _ = trie.Add("0-1", []Field{{"field", "value-a"}})
+	_, _ = trie.Add("0-2", []Field{{"field", "value-a"}})
+	_, _ = trie.Add("0-3", []Field{{"field", "value-a"}})
+
+	// Retrieve range
+	entries := trie.Range(ID{Seq: 1}, ID{Seq: 3})
+
+	for _, entry := range entries {
+		t.Logf("Entry: %v", entry)
+	}
+
+	assert.Len(t, entries, 3, "Expected 3 entries in range")
+}
+
+func TestStreamRange_AcrossListpacks(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	n := 3*maxListpackEntries + 7
+	for i := 1; i <= n; i++ {
+		_, err := trie.Add(fmt.Sprintf("%d-0", i), []Field{{"n", fmt.Sprint(i)}})
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 4, trie.Value.size, "entries should be batched in listpacks")
+
+	entries := trie.Range(ID{Ms: 95}, ID{Ms: 205})
+	assert.Len(t, entries, 111)
+	assert.Equal(t, ID{Ms: 95}, entries[0].ID)
+	assert.Equal(t, ID{Ms: 205}, entries[len(entries)-1].ID)
+
+	for i := 1; i < len(entries); i++ {
+		assert.True(t, entries[i-1].ID.Less(entries[i].ID))
+	}
+
+	assert.Empty(t, trie.Range(ID{Ms: 10}, ID{Ms: 9}))
+}
+
+func TestStreamRange_CountAndReverse(t *testing.T) {
+	s := newTestStream(t, 3*maxListpackEntries)
+
+	entries := s.RangeCount(ID{Ms: 95}, MaxID, 10)
+	assert.Len(t, entries, 10)
+	assert.Equal(t, ID{Ms: 104}, entries[9].ID)
+
+	reversed := s.RevRange(ID{Ms: 205}, ID{Ms: 95}, 0)
+	assert.Len(t, reversed, 111)
+	assert.Equal(t, ID{Ms: 205}, reversed[0].ID)
+	assert.Equal(t, ID{Ms: 95}, reversed[len(reversed)-1].ID)
+
+	for i := 1; i < len(reversed); i++ {
+		assert.True(t, reversed[i].ID.Less(reversed[i-1].ID))
+	}
+
+	last := s.RevRange(MaxID, MinID, 2)
+	assert.Equal(t, []ID{{Ms: 300}, {Ms: 299}}, []ID{last[0].ID, last[1].ID})
+
+	s.Delete(ID{Ms: 101})
+	assert.Equal(t, ID{Ms: 100}, s.RevRange(ID{Ms: 101}, MinID, 1)[0].ID)
+
+	assert.Empty(t, s.RevRange(ID{Ms: 9}, ID{Ms: 10}, 0))
+	assert.Empty(t, NewTrieStream("empty").RevRange(MaxID, MinID, 0))
+}
+
+func TestID_NextAndPrev(t *testing.T) {
+	next, ok := ID{Ms: 1, Seq: MaxID.Seq}.Next()
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 2}, next)
+
+	prev, ok := ID{Ms: 2}.Prev()
+	assert.True(t, ok)
+	assert.Equal(t, ID{Ms: 1, Seq: MaxID.Seq}, prev)
+
+	_, ok = MinID.Prev()
+	assert.False(t, ok)
+
+	_, ok = MaxID.Next()
+	assert.False(t, ok)
+}
+
+func TestXRead(t *testing.T) {
+	trie := NewTrieStream("test-stream")
+
+	_, _ = trie.Add("1-1", []Field{{"field", "a"}})
+	_, _ = trie.Add("1-2", []Field{{"field", "b"}})
+	_, _ = trie.Add("2-0", []Field{{"other", "c"}})
+
+	entries := trie.XRead(ID{Ms: 1, Seq: 1})
+	assert.Len(t, entries, 2)
+	assert.Equal(t, ID{Ms: 1, Seq: 2}, entries[0].ID)
+	assert.Equal(t, []Field{{"other", "c"}}, entries[1].Elements)
+
+	assert.Empty(t, trie.XRead(ID{Ms: 2}))
+}
+
+func TestParseRangeID(t *testing.T) {
+	start, err := ParseRangeID("5", false)
+	assert.NoError(t, err)
+	assert.Equal(t, ID{Ms: 5}, start)
+
+	end, err := ParseRangeID("5", true)
+	assert.NoError(t, err)
+	assert.Equal(t, ID{Ms: 5, Seq: MaxID.Seq}, end)
+
+	first, _ := ParseRangeID("-", false)
+	last, _ := ParseRangeID("+", true)
+	assert.Equal(t, MinID, first)
+	assert.Equal(t, MaxID, last)
+
+	_, err = ParseRangeID("5-x", false)
+	assert.ErrorIs(t, err, InvalidID)
+}
@@ -1,339 +1,274 @@
 package stream
 
-import (
-	"errors"
-	"fmt"
-	"sort"
-	"strconv"
-	"strings"
-	"sync"
-	"time"
-)
-
-type Entry struct {
-	Id       string
-	Elements map[string]interface{}
-}
-
-type Node struct {
+// Node of a compressed prefix tree (radix tree). Stream keys all have the
+// same length, so values are only stored on leaves.
+type Node[V any] struct {
 	Prefix   string
-	Entries  []*Entry
-	Children map[byte]*Node
+	Value    V
+	Children []*Node[V] // sorted by the first byte of their prefix
 }
 
-type Notification struct {
-	key string
-}
-
-// Stream  Compressed prefix tree.
-type Stream struct {
-	Name       string
-	Value      *Node
-	TailPrefix string
-	length     int64
-
-	subscribers   map[chan<- Notification]struct{}
-	subscribersMu sync.RWMutex
+// radixTree maps 16 bytes IDs to listpacks for the entries, and to pending
+// entries for the consumer groups.
+type radixTree[V any] struct {
+	root *Node[V]
+	size int
 }
 
-func (s *Stream) GetType() string {
-	return "stream"
+func newRadixTree[V any]() *radixTree[V] {
+	return &radixTree[V]{root: &Node[V]{}}
 }
 
-func (s *Stream) GetValue() string {
-	return ""
+func (t *radixTree[V]) Len() int {
+	return t.size
 }
 
-func (s *Stream) IsExpired() bool {
-	return false
+// Nodes returns the number of nodes of the tree, the root included.
+func (t *radixTree[V]) Nodes() int {
+	return countNodes(t.root)
 }
 
-func NewTrieStream(name string) *Stream {
-	return &Stream{
-		Name:       name,
-		Value:      nil,
-		TailPrefix: "0-0",
-		length:     0,
+func countNodes[V any](n *Node[V]) int {
+	count := 1
+	for _, child := range n.Children {
+		count += countNodes(child)
 	}
+
+	return count
 }
 
-func (s *Stream) Add(id string, entries map[string]interface{}) (string, error) {
-	current := s.Value
-	entryId := id
+// childIndex returns the position of the child starting with b, or where it
+// would be inserted.
+func (n *Node[V]) childIndex(b byte) (int, bool) {
+	lo, hi := 0, len(n.Children)
 
-	if strings.Contains(id, "*") {
-		id = s.formatPrefix(id)
-	}
+	for lo < hi {
+		mid := (lo + hi) / 2
 
-	err := s.validatePrefix(id)
-
-	if err != nil {
-		return id, err
+		if n.Children[mid].Prefix[0] < b {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
 	}
 
-	s.length++
-	s.TailPrefix = id
-
-	defer s.notifySubscribers(id)
+	return lo, lo < len(n.Children) && n.Children[lo].Prefix[0] == b
+}
 
-	for {
-		if current == nil {
-			s.Value = &Node{
-				Prefix:   id,
-				Entries:  []*Entry{{Id: id, Elements: entries}},
-				Children: make(map[byte]*Node),
-			}
-			return id, nil
-		}
+func (n *Node[V]) insertChild(i int, child *Node[V]) {
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = child
+}
 
-		commonPrefix := longestCommonPrefix(id, current.Prefix)
-		// prefix at node : computing
-		// inserting id : computer
-		// longest common prefix will be comput
-		// comput != computing. Split computing into comput and ing
-		// root
-		if commonPrefix != current.Prefix {
-			child := &Node{
-				Prefix:   current.Prefix[len(commonPrefix):],
-				Entries:  current.Entries,
-				Children: current.Children,
-			}
+// Insert stores v under key, replacing any previous value.
+func (t *radixTree[V]) Insert(key string, v V) {
+	current := t.root
 
-			current.Prefix = commonPrefix
-			current.Entries = nil
-			current.Children = map[byte]*Node{
-				child.Prefix[0]: child,
-			}
+	for {
+		i, exists := current.childIndex(key[0])
 
-			if len(entryId) > len(commonPrefix) {
-				current.Children[entryId[len(commonPrefix)]] = &Node{
-					Prefix: entryId[len(commonPrefix):],
-					Entries: []*Entry{
-						{Id: entryId, Elements: entries},
-					},
-				}
-			} else {
-				current.Entries = append(current.Entries, &Entry{Id: entryId, Elements: entries})
-			}
-			return id, nil
+		if !exists {
+			current.insertChild(i, &Node[V]{Prefix: key, Value: v})
+			t.size++
+			return
 		}
 
-		entryId = entryId[len(commonPrefix):]
-		child, exists := current.Children[entryId[0]]
+		child := current.Children[i]
+		commonPrefix := longestCommonPrefix(key, child.Prefix)
 
-		if !exists {
-			if current.Children == nil {
-				current.Children = make(map[byte]*Node)
-			}
+		if commonPrefix == child.Prefix {
+			key = key[len(commonPrefix):]
 
-			current.Children[entryId[0]] = &Node{
-				Prefix: entryId,
-				Entries: []*Entry{
-					{Id: id, Elements: entries},
-				},
+			if len(key) == 0 {
+				child.Value = v
+				return
 			}
-			return id, nil
+
+			current = child
+			continue
 		}
 
-		current = child
-	}
-}
+		// the key diverges inside the child prefix: split the child
+		split := &Node[V]{Prefix: commonPrefix}
+		child.Prefix = child.Prefix[len(commonPrefix):]
+		leaf := &Node[V]{Prefix: key[len(commonPrefix):], Value: v}
 
-func (s *Stream) Subscribe(ch chan<- Notification) {
-	s.subscribersMu.Lock()
-	defer s.subscribersMu.Unlock()
+		if leaf.Prefix[0] < child.Prefix[0] {
+			split.Children = []*Node[V]{leaf, child}
+		} else {
+			split.Children = []*Node[V]{child, leaf}
+		}
 
-	if s.subscribers == nil {
-		s.subscribers = make(map[chan<- Notification]struct{})
+		current.Children[i] = split
+		t.size++
+		return
 	}
-
-	s.subscribers[ch] = struct{}{}
 }
 
-func (s *Stream) Unsubscribe(ch chan<- Notification) {
-	s.subscribersMu.Lock()
-	defer s.subscribersMu.Unlock()
+// Get returns the value stored under key.
+func (t *radixTree[V]) Get(key string) (V, bool) {
+	current := t.root
 
-	if s.subscribers != nil {
-		delete(s.subscribers, ch)
-	}
-}
+	for len(key) > 0 {
+		i, exists := current.childIndex(key[0])
+		if !exists {
+			var zero V
+			return zero, false
+		}
 
-func (s *Stream) notifySubscribers(key string) {
-	s.subscribersMu.RLock()
-	defer s.subscribersMu.RUnlock()
+		child := current.Children[i]
+		if len(key) < len(child.Prefix) || key[:len(child.Prefix)] != child.Prefix {
+			var zero V
+			return zero, false
+		}
 
-	notification := Notification{
-		key: key,
+		key = key[len(child.Prefix):]
+		current = child
 	}
 
-	fmt.Println("Notifying subscribers: ", s.subscribers)
-
-	for ch := range s.subscribers {
-		select {
-		case ch <- notification:
-		default:
-		}
-	}
+	return current.Value, current != t.root
 }
 
-func (s *Stream) Get(id string) *Entry {
-	entryId := id
-	current := s.Value
-	/*
-		Say we need to find the entry for with id computer gets the longest common prefix
-		compare the lcp to the current node lcp
-		if they do not match. id is not in the trie
-
-		id = id - lcp
-		if the current
-		check the children[id[0]] range the map to find the id
+// Delete removes key, merging back the node left with a single child.
+func (t *radixTree[V]) Delete(key string) bool {
+	current := t.root
 
-	*/
-	for {
-		if current == nil {
-			return nil
+	for len(key) > 0 {
+		i, exists := current.childIndex(key[0])
+		if !exists {
+			return false
 		}
 
-		commonPrefix := longestCommonPrefix(entryId, current.Prefix)
-		entryId = entryId[len(commonPrefix):]
-
-		if len(entryId) == 0 {
-			for _, entry := range current.Entries {
-				if entry.Id == id {
-					return entry
-				}
-			}
-			return nil
+		child := current.Children[i]
+		if len(key) < len(child.Prefix) || key[:len(child.Prefix)] != child.Prefix {
+			return false
 		}
 
-		child, exists := current.Children[entryId[0]]
-
-		if !exists {
-			return nil
-		}
+		if len(key) == len(child.Prefix) {
+			current.Children = append(current.Children[:i], current.Children[i+1:]...)
+			t.size--
 
-		for _, entry := range child.Entries {
-			if entry.Id == entryId {
-				return entry
+			if current != t.root && len(current.Children) == 1 {
+				only := current.Children[0]
+				current.Prefix += only.Prefix
+				current.Value = only.Value
+				current.Children = only.Children
 			}
+
+			return true
 		}
 
+		key = key[len(child.Prefix):]
 		current = child
 	}
-}
-
-func (s *Stream) Range(start, end string) []*Entry {
-	if s.Value == nil {
-		return nil
-	}
-
-	if start == "-" {
-		start = s.Value.Prefix
-	}
-
-	if start == "$" {
-		start = s.TailPrefix
-	}
 
-	if end == "+" {
-		end = s.TailPrefix
-	}
+	return false
+}
 
-	var result []*Entry
+// Floor returns the greatest key lower than or equal to key.
+func (t *radixTree[V]) Floor(key string) (string, V, bool) {
+	return floor(t.root, "", key)
+}
 
-	stack := []*Node{s.Value}
+func floor[V any](n *Node[V], path, key string) (string, V, bool) {
+	for i := len(n.Children) - 1; i >= 0; i-- {
+		child := n.Children[i]
+		p := child.Prefix
 
-	for len(stack) > 0 {
-		current := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
+		if len(key) < len(p) {
+			p = p[:len(key)]
+		}
 
-		for _, entry := range current.Entries {
-			if entry.Id >= start && entry.Id <= end {
-				result = append(result, entry)
+		switch {
+		case p > key[:len(p)]:
+			continue
+		case p < key[:len(p)]:
+			k, v := last(child, path+child.Prefix)
+			return k, v, true
+		case len(child.Children) == 0:
+			return path + child.Prefix, child.Value, true
+		default:
+			if k, v, ok := floor(child, path+child.Prefix, key[len(p):]); ok {
+				return k, v, true
 			}
 		}
+	}
 
-		var keys []byte
-		for b := range current.Children {
-			keys = append(keys, b)
-		}
+	var zero V
 
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i] > keys[j]
-		})
+	return "", zero, false
+}
 
-		for _, b := range keys {
-			stack = append(stack, current.Children[b])
-		}
+// First returns the smallest key in the tree.
+func (t *radixTree[V]) First() (string, V, bool) {
+	if t.size == 0 {
+		var zero V
+		return "", zero, false
 	}
 
-	return result
-}
-
-func (s *Stream) XRead(start string) []*Entry {
-	if s.Value == nil {
-		return nil
+	n, path := t.root, ""
+	for len(n.Children) > 0 {
+		n = n.Children[0]
+		path += n.Prefix
 	}
 
-	results := s.Range(start, "+")
+	return path, n.Value, true
+}
 
-	var filteredResults []*Entry
-	for _, entry := range results {
-		if entry.Id > start {
-			filteredResults = append(filteredResults, entry)
-		}
+// Last returns the greatest key in the tree.
+func (t *radixTree[V]) Last() (string, V, bool) {
+	if t.size == 0 {
+		var zero V
+		return "", zero, false
 	}
 
-	return filteredResults
-}
+	k, v := last(t.root, "")
 
-func (s *Stream) validatePrefix(id string) error {
-	fmt.Println("Validating prefix: ", id, " length: ", s.length, " tailPrefix: ", s.TailPrefix)
+	return k, v, true
+}
 
-	if id <= "0-0" {
-		return errors.New("ERR The ID specified in XADD must be greater than 0-0")
+func last[V any](n *Node[V], path string) (string, V) {
+	for len(n.Children) > 0 {
+		n = n.Children[len(n.Children)-1]
+		path += n.Prefix
 	}
 
-	if id <= s.TailPrefix {
-		return errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
-	}
+	return path, n.Value
+}
 
-	return nil
+// Ascend calls fn in key order for every key greater than or equal to start,
+// until fn returns false. Subtrees entirely before start are skipped.
+func (t *radixTree[V]) Ascend(start string, fn func(key string, v V) bool) {
+	ascend(t.root, "", start, fn)
 }
 
-func (s *Stream) nextSeq(p string) string {
-	tailPrefix := strings.Split(s.TailPrefix, "-")
-	prefix := strings.Split(p, "-")
+func ascend[V any](n *Node[V], path, start string, fn func(key string, v V) bool) bool {
+	for _, child := range n.Children {
+		p := path + child.Prefix
 
-	if tailPrefix[0] == prefix[0] {
-		sq, err := strconv.ParseInt(tailPrefix[1], 10, 64)
-		if err != nil {
-			return ""
+		if len(p) <= len(start) && p < start[:len(p)] {
+			continue
 		}
-		sq++
-		return strconv.FormatInt(sq, 10)
-	}
 
-	return "0"
-}
+		if len(child.Children) == 0 {
+			if p < start {
+				continue
+			}
 
-func (s *Stream) formatPrefix(prefix string) string {
-	if prefix == "*" {
-		return fmt.Sprintf("%d-%s", time.Now().UnixMilli(), "0")
-	}
+			if !fn(p, child.Value) {
+				return false
+			}
 
-	segments := strings.Split(prefix, "-")
-	var (
-		id = segments[0]
-		sq = segments[1]
-	)
+			continue
+		}
 
-	if sq == "*" {
-		sq = s.nextSeq(prefix)
+		if !ascend(child, p, start, fn) {
+			return false
+		}
 	}
 
-	return fmt.Sprintf("%s-%s", id, sq)
+	return true
 }
 
 func longestCommonPrefix(a, b string) string {
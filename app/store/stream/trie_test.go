@@ -11,104 +11,114 @@ func TestNewTrieStream(t *testing.T) {
 
 	// Assertions
 	assert.Equal(t, name, trie.Name, "TrieStream name mismatch")
-	assert.Nil(t, trie.Value, "TrieStream.Value should be nil on initialization")
+	assert.Empty(t, trie.Value.root.Children, "TrieStream should be empty on initialization")
+	assert.Equal(t, ID{}, trie.LastID)
 }
 
-func TestAddSingleEntry(t *testing.T) {
-	trie := NewTrieStream("test-stream")
+func TestRadixTree_InsertWithCommonPrefix(t *testing.T) {
+	tree := newRadixTree[*listpack]()
 
-	id := "key1"
-	entries := map[string]interface{}{
-		"field1": "value1",
-		"field2": "value2",
-	}
+	a := newListpack(ID{Ms: 1}, nil)
+	b := newListpack(ID{Ms: 2}, nil)
+	c := newListpack(ID{Ms: 1 << 40}, nil)
 
-	trie.Add(id, entries)
+	tree.Insert(ID{Ms: 1}.key(), a)
+	tree.Insert(ID{Ms: 2}.key(), b)
+	tree.Insert(ID{Ms: 1 << 40}.key(), c)
 
-	// Assertions
-	assert.NotNil(t, trie.Value, "Root node should be initialized")
-	assert.Equal(t, id, trie.Value.Prefix, "Root node prefix mismatch")
-	assert.Len(t, trie.Value.Entries, 1, "Root node should have exactly 1 entry")
-	assert.Equal(t, id, trie.Value.Entries[0].Id, "Entry ID mismatch")
-	assert.Equal(t, "value1", trie.Value.Entries[0].Elements["field1"], "Entry field1 value mismatch")
-	assert.Equal(t, "value2", trie.Value.Entries[0].Elements["field2"], "Entry field2 value mismatch")
+	assert.Equal(t, 3, tree.size)
+	assert.Len(t, tree.root.Children, 1, "all keys share their leading zero bytes")
+
+	for _, want := range []*listpack{a, b, c} {
+		got, ok := tree.Get(want.master.key())
+		assert.True(t, ok)
+		assert.Same(t, want, got)
+	}
+
+	_, ok := tree.Get(ID{Ms: 3}.key())
+	assert.False(t, ok)
 }
 
-func TestAddWithCommonPrefix(t *testing.T) {
-	trie := NewTrieStream("test-stream")
+func TestRadixTree_Delete(t *testing.T) {
+	tree := newRadixTree[int]()
 
-	// Add first entry
-	id1 := "common-key1"
-	entries1 := map[string]interface{}{
-		"field1": "value1",
+	for i, ms := range []uint64{1, 2, 1 << 40, 300} {
+		tree.Insert(ID{Ms: ms}.key(), i)
 	}
-	trie.Add(id1, entries1)
 
-	// Add second entry with common prefix
-	id2 := "common-key2"
-	entries2 := map[string]interface{}{
-		"field2": "value2",
-	}
-	trie.Add(id2, entries2)
+	assert.True(t, tree.Delete(ID{Ms: 2}.key()))
+	assert.False(t, tree.Delete(ID{Ms: 2}.key()))
+	assert.False(t, tree.Delete(ID{Ms: 7}.key()))
+	assert.Equal(t, 3, tree.Len())
 
-	id3 := "common-key3"
-	entries3 := map[string]interface{}{"field3": "value3"}
+	var keys []uint64
+	tree.Ascend("", func(k string, _ int) bool {
+		keys = append(keys, idFromKey(k).Ms)
+		return true
+	})
+	assert.Equal(t, []uint64{1, 300, 1 << 40}, keys)
 
-	trie.Add(id3, entries3)
+	v, ok := tree.Get(ID{Ms: 1 << 40}.key())
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
 
-	// Assertions
-	assert.NotNil(t, trie.Value, "Root node should be initialized")
-	assert.Equal(t, "common-key", trie.Value.Prefix, "Root node prefix mismatch with common prefix")
-
-	// Validate children nodes
-	child1, exists1 := trie.Value.Children['1']
-	assert.True(t, exists1, "Child node '1' should exist")
-	assert.Equal(t, child1.Prefix, "1", "Child node '1' prefix mismatch")
-	assert.Len(t, child1.Entries, 1, "Child node '1' should have exactly 1 entry")
-	assert.Equal(t, child1.Entries[0].Id, id1, "Child node '1' entry ID mismatch")
-
-	child2, exists2 := trie.Value.Children['2']
-	assert.True(t, exists2, "Child node '2' should exist")
-	assert.Equal(t, child2.Prefix, "2", "Child node '2' prefix mismatch")
-	assert.Len(t, child2.Entries, 1, "Child node '2' should have exactly 1 entry")
-	assert.Equal(t, child2.Entries[0].Id, id2, "Child node '2' entry ID mismatch")
-
-	child3, exists3 := trie.Value.Children['3']
-	assert.True(t, exists3, "Child node '3' should exist")
-	assert.Equal(t, child3.Prefix, "3", "Child node '3' prefix mismatch")
-	assert.Len(t, child3.Entries, 1, "Child node '3' should have exactly 1 entry")
-	assert.Equal(t, child3.Entries[0].Id, id3, "Child node '3' entry ID mismatch")
+	for _, ms := range []uint64{1, 300, 1 << 40} {
+		assert.True(t, tree.Delete(ID{Ms: ms}.key()))
+	}
+
+	assert.Equal(t, 0, tree.Len())
+	assert.Empty(t, tree.root.Children)
 }
 
-func TestGet(t *testing.T) {
-	trie := NewTrieStream("test-stream")
+func TestRadixTree_Floor(t *testing.T) {
+	tree := newRadixTree[*listpack]()
+
+	for _, ms := range []uint64{10, 20, 300, 1 << 20} {
+		tree.Insert(ID{Ms: ms}.key(), newListpack(ID{Ms: ms}, nil))
+	}
 
-	// Add multiple entries
-	entries := []struct {
-		id       string
-		elements map[string]interface{}
+	tests := []struct {
+		key   ID
+		floor ID
+		found bool
 	}{
-		{"key1", map[string]interface{}{"field1": "value1"}},
-		{"key2", map[string]interface{}{"field2": "value2"}},
-		{"common-key", map[string]interface{}{"field3": "value3"}},
+		{ID{Ms: 5}, ID{}, false},
+		{ID{Ms: 10}, ID{Ms: 10}, true},
+		{ID{Ms: 10, Seq: 7}, ID{Ms: 10}, true},
+		{ID{Ms: 299}, ID{Ms: 20}, true},
+		{ID{Ms: 1 << 30}, ID{Ms: 1 << 20}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key.String(), func(t *testing.T) {
+			k, _, ok := tree.Floor(tt.key.key())
+
+			assert.Equal(t, tt.found, ok)
+			if tt.found {
+				assert.Equal(t, tt.floor, idFromKey(k))
+			}
+		})
 	}
+}
 
-	for _, entry := range entries {
-		trie.Add(entry.id, entry.elements)
+func TestRadixTree_AscendInNumericOrder(t *testing.T) {
+	tree := newRadixTree[*listpack]()
+
+	for _, ms := range []uint64{10, 9, 100, 256, 255} {
+		tree.Insert(ID{Ms: ms}.key(), newListpack(ID{Ms: ms}, nil))
 	}
 
-	// Retrieve existing entry
-	foundEntry := trie.Get("key1")
-	assert.NotNil(t, foundEntry, "Expected to find entry with ID 'key1'")
-	assert.Equal(t, "value1", foundEntry.Elements["field1"], "Field1 value does not match for 'key1'")
+	var keys []uint64
+	tree.Ascend(ID{Ms: 10}.key(), func(k string, _ *listpack) bool {
+		keys = append(keys, idFromKey(k).Ms)
+		return true
+	})
 
-	foundEntry = trie.Get("common-key")
-	assert.NotNil(t, foundEntry, "Expected to find entry with ID 'common-key'")
-	assert.Equal(t, "value3", foundEntry.Elements["field3"], "Field3 value does not match for 'common-key'")
+	assert.Equal(t, []uint64{10, 100, 255, 256}, keys)
 
-	// Try to retrieve a non-existing entry
-	notFoundEntry := trie.Get("non-existent")
-	assert.Nil(t, notFoundEntry, "Expected nil for non-existing entry")
+	last, _, ok := tree.Last()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(256), idFromKey(last).Ms)
 }
 
 func TestLongestCommonPrefix(t *testing.T) {
@@ -130,41 +140,14 @@ func TestLongestCommonPrefix(t *testing.T) {
 	}
 }
 
-func TestAddAndRetrieveComplex(t *testing.T) {
-	trie := NewTrieStream("test-stream")
-
-	// Add entries with nested common prefixes
-	_, _ = trie.Add("alpha", map[string]interface{}{"field": "value-a"})
-	_, _ = trie.Add("alphabet", map[string]interface{}{"field": "value-ab"})
-	_, _ = trie.Add("alphabets", map[string]interface{}{"field": "value-abs"})
-
-	// Retrieve each specific item
-	entry := trie.Get("alpha")
-	assert.NotNil(t, entry, "Expected to find 'alpha'")
-	assert.Equal(t, "value-a", entry.Elements["field"], "Field value mismatch for 'alpha'")
-
-	entry = trie.Get("alphabet")
-	assert.NotNil(t, entry, "Expected to find 'alphabet'")
-	assert.Equal(t, "value-ab", entry.Elements["field"], "Field value mismatch for 'alphabet'")
-
-	entry = trie.Get("alphabets")
-	assert.NotNil(t, entry, "Expected to find 'alphabets'")
-	assert.Equal(t, "value-abs", entry.Elements["field"], "Field value mismatch for 'alphabets'")
-}
-
-func TestStreamRange(t *testing.T) {
-	trie := NewTrieStream("test-stream")
+func TestRadixTree_Nodes(t *testing.T) {
+	tree := newRadixTree[int]()
+	assert.Equal(t, 1, tree.Nodes(), "the root")
 
-	_, _ = trie.Add("0-1", map[string]interface{}{"field": "value-a"})
-	_, _ = trie.Add("0-2", map[string]interface{}{"field": "value-a"})
-	_, _ = trie.Add("0-3", map[string]interface{}{"field": "value-a"})
-
-	// Retrieve range
-	entries := trie.Range("0-1", "0-3")
-
-	for _, entry := range entries {
-		t.Logf("Entry: %v", entry)
-	}
+	tree.Insert("abc", 1)
+	tree.Insert("abd", 2)
+	assert.Equal(t, 4, tree.Nodes(), "the root, the shared prefix and two leaves")
 
-	assert.Len(t, entries, 3, "Expected 3 entries in range")
+	tree.Delete("abd")
+	assert.Equal(t, 2, tree.Nodes())
 }
@@ -0,0 +1,124 @@
+package stream
+
+// TrimStrategy tells which entries trimming removes.
+type TrimStrategy int
+
+const (
+	TrimNone TrimStrategy = iota
+
+	// TrimMaxLen removes the oldest entries beyond MaxLen entries.
+	TrimMaxLen
+
+	// TrimMinID removes the entries with IDs lower than MinID.
+	TrimMinID
+)
+
+// DefaultTrimLimit bounds the entries removed by approximate trimming when
+// no LIMIT is given, as 100 times stream-node-max-entries does in Redis.
+const DefaultTrimLimit = 100 * maxListpackEntries
+
+type TrimOptions struct {
+	Strategy TrimStrategy
+	MaxLen   int64
+	MinID    ID
+
+	// Approx only removes whole listpacks, so the stream may keep a few more
+	// entries than asked, and at most Limit entries (no limit when 0).
+	Approx bool
+	Limit  int64
+}
+
+// Delete removes the entries with the given IDs, returning how many were
+// in the stream.
+func (s *Stream) Delete(ids ...ID) int64 {
+	var deleted int64
+
+	for _, id := range ids {
+		k, lp, ok := s.Value.Floor(id.key())
+		if !ok || !lp.Delete(id) {
+			continue
+		}
+
+		if lp.live() == 0 {
+			s.Value.Delete(k)
+		}
+
+		s.length--
+		deleted++
+
+		if s.MaxDeletedID.Less(id) {
+			s.MaxDeletedID = id
+		}
+	}
+
+	return deleted
+}
+
+// Trim removes the oldest entries as opts tell, returning how many were
+// removed. Unlike Delete, trimming leaves MaxDeletedID alone: the entries
+// removed are older than any left, which the first ID already tells.
+func (s *Stream) Trim(opts TrimOptions) int64 {
+	var removed int64
+
+	for opts.Strategy != TrimNone {
+		k, lp, ok := s.Value.First()
+		if !ok {
+			break
+		}
+
+		live := lp.live()
+
+		var whole bool
+		switch opts.Strategy {
+		case TrimMaxLen:
+			whole = s.length-live >= opts.MaxLen
+		case TrimMinID:
+			whole = lp.last.Less(opts.MinID)
+		}
+
+		if whole {
+			if opts.Approx && opts.Limit > 0 && removed+live > opts.Limit {
+				break
+			}
+
+			s.Value.Delete(k)
+			s.length -= live
+			removed += live
+
+			continue
+		}
+
+		if opts.Approx {
+			break
+		}
+
+		// the threshold falls within this listpack, its oldest entries are
+		// deleted one by one
+		lp.scan(func(offset int, flags byte, e *Entry) bool {
+			if flags&flagDeleted != 0 {
+				return true
+			}
+
+			switch opts.Strategy {
+			case TrimMaxLen:
+				if s.length <= opts.MaxLen {
+					return false
+				}
+			case TrimMinID:
+				if !e.ID.Less(opts.MinID) {
+					return false
+				}
+			}
+
+			lp.markDeleted(offset)
+			s.length--
+			removed++
+
+			return true
+		})
+
+		break
+	}
+
+	return removed
+}
@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	s := newTestStream(t, 3)
+
+	assert.Equal(t, int64(2), s.Delete(ID{Ms: 1}, ID{Ms: 3}, ID{Ms: 9}))
+	assert.Equal(t, int64(0), s.Delete(ID{Ms: 1}), "already deleted")
+
+	assert.Equal(t, int64(1), s.Len())
+	assert.Equal(t, ID{Ms: 3}, s.MaxDeletedID)
+	assert.Equal(t, ID{Ms: 3}, s.LastID, "deleting the last entry keeps the last ID")
+	assert.Equal(t, ID{Ms: 2}, s.FirstID())
+	assert.Nil(t, s.Get(ID{Ms: 1}))
+	assert.Len(t, s.Range(MinID, MaxID), 1)
+
+	s.Delete(ID{Ms: 2})
+	assert.Equal(t, 0, s.Value.Len(), "the empty listpack is dropped")
+
+	_, err := s.Add("3-0", []Field{{"n", "3"}})
+	assert.ErrorIs(t, err, smallerIDError)
+
+	_, err = s.Add("4-0", []Field{{"n", "4"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), s.EntriesAdded)
+}
+
+func TestTrim_Exact(t *testing.T) {
+	s := newTestStream(t, 250)
+
+	assert.Equal(t, int64(100), s.Trim(TrimOptions{Strategy: TrimMaxLen, MaxLen: 150}))
+	assert.Equal(t, int64(150), s.Len())
+	assert.Equal(t, ID{Ms: 101}, s.FirstID())
+
+	assert.Equal(t, int64(20), s.Trim(TrimOptions{Strategy: TrimMinID, MinID: ID{Ms: 121}}))
+	assert.Equal(t, ID{Ms: 121}, s.FirstID())
+
+	assert.Equal(t, int64(0), s.Trim(TrimOptions{Strategy: TrimMinID, MinID: ID{Ms: 50}}))
+	assert.True(t, s.MaxDeletedID.IsZero(), "trimming is not a deletion")
+
+	assert.Equal(t, int64(130), s.Trim(TrimOptions{Strategy: TrimMaxLen}))
+	assert.Equal(t, int64(0), s.Len())
+	assert.Equal(t, int64(250), s.EntriesAdded)
+}
+
+func TestTrim_Approx(t *testing.T) {
+	s := newTestStream(t, 250)
+
+	approx := TrimOptions{Strategy: TrimMaxLen, MaxLen: 120, Approx: true}
+	assert.Equal(t, int64(100), s.Trim(approx), "only whole listpacks are removed")
+	assert.Equal(t, int64(150), s.Len())
+
+	assert.Equal(t, int64(0), s.Trim(TrimOptions{Strategy: TrimMinID, MinID: ID{Ms: 150}, Approx: true}))
+
+	limited := TrimOptions{Strategy: TrimMaxLen, Approx: true, Limit: 120}
+	assert.Equal(t, int64(100), s.Trim(limited), "the second listpack would go beyond the limit")
+	assert.Equal(t, int64(50), s.Len())
+}
+
+func TestReadGroup_LagAfterDeletion(t *testing.T) {
+	s := newTestStream(t, 4)
+
+	g, _ := s.CreateGroup("g", MinID, 0)
+	c := g.Consumer("c", 0)
+
+	s.ReadGroup(g, c, 1, false, 0)
+	s.Delete(ID{Ms: 3})
+
+	s.ReadGroup(g, c, 1, false, 0)
+	assert.Equal(t, InvalidEntriesRead, g.EntriesRead, "a deletion ahead makes the count unknown")
+
+	s.ReadGroup(g, c, 0, false, 0)
+	assert.Equal(t, int64(4), g.EntriesRead, "reading up to the last entry makes it known again")
+}
@@ -1,9 +1,12 @@
 package tcp
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/codecrafters-io/redis-starter-go/app/commands"
 	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/sentinel"
 	"github.com/codecrafters-io/redis-starter-go/app/services"
 	"github.com/codecrafters-io/redis-starter-go/app/store"
 	"github.com/codecrafters-io/redis-starter-go/app/utils"
@@ -31,24 +34,44 @@ type BaseServer struct {
 	Shutdown  chan struct{}
 	Datastore store.DataStore
 
+	// TLSListener accepts the TLS connections, when tls-port is set. Listener
+	// is nil when only TLS connections are accepted.
+	TLSListener net.Listener
+	// ReplicationTLS is set when the link with our master is a TLS one
+	ReplicationTLS *tls.Config
+
 	wg          sync.WaitGroup
 	Connections chan net.Conn
 
 	Replication *services.ReplicationService
+	PubSub      *services.PubSub
+	Blocking    *services.Blocking
+	Keyspace    *services.KeyspaceEvents
+	Clients     *services.Clients
+
+	Transactions *commands.TransactionService
+	ACL          *commands.ACLService
 
-	CommandsChannel chan []byte
-	Transactions    *commands.TransactionService
+	// Sentinel is set when the server runs in sentinel mode
+	Sentinel *sentinel.Sentinel
 }
 
 func (s *BaseServer) StartListener(handleConnection func(conn io.ReadWriter)) {
-	s.wg.Add(2)
-	go s.acceptConnections()
+	for _, ln := range s.listeners() {
+		s.wg.Add(1)
+		go s.acceptConnections(ln)
+	}
+
+	s.wg.Add(1)
 	go s.handleConnections(handleConnection)
 }
 
 func (s *BaseServer) StopListener() {
 	close(s.Shutdown)
-	s.Listener.Close()
+
+	for _, ln := range s.listeners() {
+		ln.Close()
+	}
 
 	done := make(chan struct{})
 	go func() {
@@ -65,14 +88,26 @@ func (s *BaseServer) StopListener() {
 	}
 }
 
-func (s *BaseServer) acceptConnections() {
+func (s *BaseServer) listeners() []net.Listener {
+	var listeners []net.Listener
+
+	for _, ln := range []net.Listener{s.Listener, s.TLSListener} {
+		if ln != nil {
+			listeners = append(listeners, ln)
+		}
+	}
+
+	return listeners
+}
+
+func (s *BaseServer) acceptConnections(ln net.Listener) {
 	defer s.wg.Done()
 	for {
 		select {
 		case <-s.Shutdown:
 			return
 		default:
-			conn, err := s.Listener.Accept()
+			conn, err := ln.Accept()
 			if err != nil {
 				continue
 			}
@@ -96,56 +131,219 @@ func (s *BaseServer) handleConnections(handleConnection func(conn io.ReadWriter)
 	}
 }
 
-func (s *BaseServer) ExecuteCommands(r io.Reader, conn net.Conn) ([]ExecutionResult, error) {
-	var (
-		results []ExecutionResult
-	)
+// HandleConnection serves a client, replica or master connection until it is closed.
+func (s *BaseServer) HandleConnection(rw io.ReadWriter) {
+	var conn net.Conn
+
+	// a *net.TCPConn, or a *tls.Conn accepted on the TLS port
+	if connection, ok := rw.(net.Conn); ok {
+		fmt.Printf("[%s] New connection from: %s \n", strings.ToUpper(string(s.Replication.GetRole())), connection.RemoteAddr())
+		conn = connection
+	}
+
+	if conn != nil {
+		s.Clients.Add(conn)
+	}
+
+	defer func() {
+		if conn != nil {
+			s.PubSub.UnsubscribeAll(conn)
+			s.Transactions.Forget(conn)
+			s.Replication.ForgetClient(conn)
+			s.Replication.RemoveReplica(conn.RemoteAddr().String())
+			// closes conn once the replies left are written
+			s.Clients.Forget(conn)
+		}
+	}()
+
+	s.serve(rw, conn)
+}
+
+// request is a command parsed by readRequests. buffered tells whether more
+// was received after it.
+type request struct {
+	value    resp.Value
+	buffered bool
+	err      error
+}
+
+// readRequests parses the commands of the connection as they arrive, a
+// command split across reads waits for the rest of it, until it fails to
+// read. A client that closed the connection is hung up on right away, so
+// that a command blocked on its behalf gives up.
+func (s *BaseServer) readRequests(rw io.Reader, conn net.Conn, requests chan<- request, stop <-chan struct{}) {
+	reader := resp.NewLimitedReader(rw, resp.Limits{
+		MaxBulkLen:      *services.Config.ProtoMaxBulkLen,
+		MaxMultiBulkLen: resp.DefaultLimits.MaxMultiBulkLen,
+	})
 
-	reader := resp.NewReader(r)
 	for {
-		value, _, err := reader.ReadValue()
+		value, _, err := reader.ReadRequest()
 
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			fmt.Println("Failed to read value: ", err)
-			return nil, err
+		if err != nil && !errors.Is(err, resp.ErrProtocol) && conn != nil {
+			s.Clients.Hangup(conn)
 		}
 
-		com, err := commands.NewCommand(value)
+		select {
+		case requests <- request{value: value, buffered: reader.Buffered() > 0, err: err}:
+		case <-stop:
+			return
+		}
 
 		if err != nil {
-			fmt.Println("Failed to create command: ", err)
-			return nil, err
+			return
 		}
+	}
+}
+
+// serve runs the commands of the connection in order. Replies are appended
+// to the output buffer of the client, which is flushed once everything
+// received was served, so a pipeline is answered with a single write.
+func (s *BaseServer) serve(rw io.ReadWriter, conn net.Conn) {
+	var out *services.Output
+
+	if conn != nil {
+		out = s.Clients.Output(conn)
+	} else {
+		out = services.NewOutput(rw, nil, nil)
+		defer out.Close()
+	}
+
+	requests := make(chan request)
+	stop := make(chan struct{})
+	defer close(stop)
 
-		fmt.Printf("[%s] Received command: - %s \n", strings.ToUpper(string(s.Replication.Role)), com.String())
+	go s.readRequests(rw, conn, requests, stop)
+
+	for {
+		req := <-requests
+		value, err := req.value, req.err
+
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			fmt.Println("HandleConnection - Client disconnected")
+			return
+		case errors.Is(err, resp.ErrProtocol):
+			// the rest of the stream cannot be parsed, the connection is closed
+			// once told why
+			fmt.Println("Protocol error: ", err)
 
-		rs, err := com.Execute(commands.DefaultHandlers, commands.RequestContext{
-			Store:       s.Datastore,
-			Replication: s.Replication,
-			Conn:        conn,
+			if _, err := out.Write(marshalError(err)); err != nil {
+				fmt.Println("Error writing results: ", err)
+			}
 
-			Transaction: s.Transactions,
-		})
+			out.Flush()
+			return
+		default:
+			fmt.Println("Read error:", err)
+			return
+		}
 
-		fmt.Printf("[%s] Processed - %s \n", strings.ToUpper(string(s.Replication.Role)), com.String())
+		exec, err := s.ExecuteCommand(value, conn)
 
 		if err != nil {
+			// the client still gets a reply for every request
 			fmt.Println("Failed to execute command: ", err)
-			return nil, err
+
+			exec = ExecutionResult{Results: [][]byte{marshalError(err)}}
+		}
+
+		if s.shouldRespondToCommand(conn, exec.Command) {
+			if err := s.appendResults(out, exec.Results); err != nil {
+				// the client was disconnected
+				fmt.Println("Error writing results: ", err)
+				return
+			}
+		}
+
+		if !req.buffered {
+			out.Flush()
+		}
+	}
+}
+
+// appendResults appends the replies of a command to the output buffer.
+func (s *BaseServer) appendResults(out *services.Output, results [][]byte) error {
+	for _, r := range results {
+		fmt.Println("Sending result: ", strconv.Quote(string(r)))
+
+		if _, err := out.Write(r); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		s.Replication.IncrementReplOffset(len(com.Raw))
+func marshalError(err error) []byte {
+	reply := resp.ErrorValue("ERR " + err.Error())
+	b, _ := reply.Marshal()
+
+	return b
+}
+
+// shouldRespondToCommand keeps a replica silent towards its master, except for
+// the offset acknowledgements it explicitly asks for.
+func (s *BaseServer) shouldRespondToCommand(conn net.Conn, c *commands.Command) bool {
+	if !s.Replication.IsMasterLink(conn) {
+		return true
+	}
+
+	return c != nil && strings.EqualFold(c.Type, "REPLCONF") && len(c.Args) > 0 && strings.EqualFold(c.Args[0], "GETACK")
+}
+
+// ExecuteCommand runs the command sent as value by the connection.
+func (s *BaseServer) ExecuteCommand(value resp.Value, conn net.Conn) (ExecutionResult, error) {
+	handlers := commands.DefaultHandlers
+	if s.Sentinel != nil {
+		handlers = commands.SentinelHandlers
+	}
+
+	com, err := commands.NewCommand(value)
+
+	if err != nil {
+		return ExecutionResult{}, fmt.Errorf("create command: %w", err)
+	}
+
+	fmt.Printf("[%s] Received command: - %s \n", strings.ToUpper(string(s.Replication.GetRole())), com.String())
+
+	rs, err := com.Execute(handlers, commands.RequestContext{
+		Store:       s.Datastore,
+		Replication: s.Replication,
+		Conn:        conn,
+		PubSub:      s.PubSub,
+		Blocking:    s.Blocking,
+		Keyspace:    s.Keyspace,
+		Clients:     s.Clients,
+
+		Transaction: s.Transactions,
+		ACL:         s.ACL,
+		Sentinel:    s.Sentinel,
+	})
+
+	fmt.Printf("[%s] Processed - %s \n", strings.ToUpper(string(s.Replication.GetRole())), com.String())
+
+	if err != nil {
+		return ExecutionResult{}, err
+	}
+
+	// A replica's offset only tracks the bytes it processed from its master,
+	// which it forwards as is to its own replicas. A master's offset tracks
+	// the bytes it propagated.
+	if s.Replication.IsMasterLink(conn) {
+		s.Replication.Propagate(com.Raw)
+	}
 
-		results = append(results, ExecutionResult{
-			Results: rs,
-			Command: &com,
-		})
+	if com.Propagate && s.Replication.IsMaster() {
+		offset := s.Replication.Propagate(com.Raw)
+		s.Replication.SetClientOffset(conn, offset)
 	}
 
-	return results, nil
+	return ExecutionResult{
+		Results: rs,
+		Command: &com,
+	}, nil
 }
 
 func (s *BaseServer) WriteResults(w io.Writer, results [][]byte) error {
@@ -0,0 +1,128 @@
+package tcp
+
+import (
+	"bufio"
+	"github.com/codecrafters-io/redis-starter-go/app/commands"
+	"github.com/codecrafters-io/redis-starter-go/app/services"
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestServer serves the connections accepted by the listeners of s
+// until the test ends.
+func startTestServer(t *testing.T, s *BaseServer) *BaseServer {
+	t.Helper()
+
+	clients := services.NewClients()
+	pubSub := services.NewPubSub(clients)
+
+	s.Shutdown = make(chan struct{})
+	s.Connections = make(chan net.Conn)
+	s.Datastore = store.NewMemory()
+	s.Replication = services.NewReplicationService(services.Config)
+	s.PubSub = pubSub
+	s.Blocking = services.NewBlocking()
+	s.Keyspace = services.NewKeyspaceEvents(pubSub)
+	s.Clients = clients
+	s.Transactions = commands.NewTransactionService()
+
+	s.StartListener(s.HandleConnection)
+	t.Cleanup(s.StopListener)
+
+	return s
+}
+
+func TestServe_RepliesToEveryRequest(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := startTestServer(t, &BaseServer{Listener: ln})
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("FOO bar\r\nGET\r\nPING\r\n"))
+	assert.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	for _, expected := range []string{
+		"-ERR unknown command 'FOO', with args beginning with: 'bar' \r\n",
+		"-ERR wrong number of arguments for 'get' command\r\n",
+		"+PONG\r\n",
+	} {
+		reply, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, expected, reply)
+	}
+}
+
+func TestServe_UnblocksClientsThatDisconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := startTestServer(t, &BaseServer{Listener: ln})
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Write([]byte("XREAD BLOCK 0 STREAMS s $\r\n"))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return s.Blocking.Blocked("s") == 1 }, time.Second, time.Millisecond)
+	conn.Close()
+
+	assert.Eventually(t, func() bool { return s.Blocking.Blocked("s") == 0 }, time.Second, time.Millisecond,
+		"the client that disconnected is unblocked")
+}
+
+func TestReplication_WaitAof(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := startTestServer(t, &BaseServer{Listener: ln})
+
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica := startTestServer(t, &BaseServer{Listener: ln})
+	replica.Replication.BecomeReplicaOf(master.Listener.Addr().String())
+	go replica.replicate()
+
+	assert.Eventually(t, func() bool { return replica.Replication.MasterConn() != nil }, 2*time.Second, time.Millisecond)
+
+	conn, err := net.Dial("tcp", master.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("SET k v\r\nWAITAOF 0 1 2000\r\n"))
+	assert.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	for _, expected := range []string{"+OK\r\n", "*2\r\n", ":0\r\n", ":1\r\n"} {
+		reply, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, expected, reply, "the replica acknowledges the write as fsynced")
+	}
+}
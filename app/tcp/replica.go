@@ -0,0 +1,329 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/codecrafters-io/redis-starter-go/app/commands/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/services"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var connectionError = errors.New("error connecting to master")
+
+const (
+	replicaAckPeriod      = time.Second
+	replicaReconnectDelay = time.Second
+)
+
+// replicate keeps a link with our master whenever we are a replica,
+// reconnecting with a partial resync when the link drops and following
+// role changes made by REPLICAOF or FAILOVER.
+func (s *BaseServer) replicate() {
+	for {
+		changed := s.Replication.RoleChanged()
+
+		if addr := s.Replication.GetMasterAddr(); s.Replication.IsSlave() && addr != "" {
+			if err := s.connectToMaster(addr); err != nil {
+				fmt.Println("Replication link error: ", err)
+				s.Replication.FailoverLinkFailed()
+			}
+		}
+
+		select {
+		case <-s.Shutdown:
+			return
+		case <-changed:
+		case <-time.After(replicaReconnectDelay):
+		}
+	}
+}
+
+// connectToMaster performs the handshake and then serves the replication
+// stream until the link drops.
+func (s *BaseServer) connectToMaster(addr string) error {
+	conn, err := s.dialMaster(addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", connectionError, err)
+	}
+	defer conn.Close()
+
+	fmt.Println("Initializing HandShake: ", conn.RemoteAddr())
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	if err := s.Ping(*rw); err != nil {
+		return err
+	}
+
+	if *services.Config.MasterAuth != "" {
+		if err := s.Auth(*rw, *services.Config.MasterUser, *services.Config.MasterAuth); err != nil {
+			return err
+		}
+	}
+
+	if err := s.ReplConf(*rw, "listening-port", strconv.Itoa(s.listeningPort())); err != nil {
+		return err
+	}
+
+	if err := s.ReplConf(*rw, "capa", "eof", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	if err := s.Psync(*rw); err != nil {
+		return err
+	}
+
+	s.Replication.SetMasterConn(conn)
+	defer func() {
+		s.Replication.SetMasterConn(nil)
+		s.Clients.Forget(conn)
+	}()
+
+	go s.heartbeat(s.Clients.Output(conn))
+	s.serve(rw, conn)
+
+	return nil
+}
+
+// dialMaster opens the link with our master, a TLS one with tls-replication.
+// The handshake is completed right away so that a certificate refused fails
+// the connection attempt.
+func (s *BaseServer) dialMaster(addr string) (net.Conn, error) {
+	if s.ReplicationTLS == nil {
+		return net.Dial("tcp", addr)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := s.ReplicationTLS.Clone()
+	config.ServerName = host
+
+	return tls.Dial("tcp", addr, config)
+}
+
+// listeningPort is the port our master reaches us on, the TLS one when our
+// link with it is a TLS one or when we only accept TLS connections.
+func (s *BaseServer) listeningPort() int {
+	if (s.ReplicationTLS != nil || s.Listener == nil) && *services.Config.TlsPort != 0 {
+		return *services.Config.TlsPort
+	}
+
+	return *services.Config.Port
+}
+
+// heartbeat reports our replication offset to the master every second so it
+// can tell how far behind we are, as required by min-replicas-to-write.
+// Acks go through the output buffer of the link, ordered with the ones
+// replied to GETACK, and stop once the link is closed.
+func (s *BaseServer) heartbeat(out *services.Output) {
+	ticker := time.NewTicker(replicaAckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Shutdown:
+			return
+		case <-ticker.C:
+			ack := s.Replication.AckValue()
+			b, _ := ack.Marshal()
+
+			if _, err := out.Write(b); err != nil {
+				fmt.Println("Error sending ack to master: ", err)
+				return
+			}
+
+			out.Flush()
+		}
+	}
+}
+
+func (s *BaseServer) ReplConf(rw bufio.ReadWriter, params ...string) error {
+	args := make([]resp.Value, 0, len(params)+1)
+	args = append(args, resp.BulkStringValue("REPLCONF"))
+
+	for _, p := range params {
+		args = append(args, resp.BulkStringValue(p))
+	}
+
+	c := resp.ArrayValue(
+		args...,
+	)
+
+	response, _ := c.Marshal()
+
+	if err := s.WriteResults(rw.Writer, [][]byte{response}); err != nil {
+		return fmt.Errorf("write REPLCONF: %w", err)
+	}
+
+	r, err := rw.ReadString('\n')
+
+	if err != nil {
+		return fmt.Errorf("read REPLCONF response: %w", err)
+	}
+
+	fmt.Println("REPLCONF response: ", r)
+
+	if strings.TrimSpace(r) != "+OK" {
+		fmt.Println("repl conf failed - invalid response")
+	}
+
+	return nil
+}
+
+// Psync asks the master to continue from our offset, falling back to a full
+// resync which replaces our replication id, offset and dataset.
+func (s *BaseServer) Psync(conn bufio.ReadWriter) error {
+	args := []resp.Value{resp.BulkStringValue("PSYNC")}
+
+	for _, a := range s.Replication.PsyncArgs() {
+		args = append(args, resp.BulkStringValue(a))
+	}
+
+	p := resp.ArrayValue(args...)
+	m, _ := p.Marshal()
+
+	if err := s.WriteResults(conn.Writer, [][]byte{m}); err != nil {
+		return fmt.Errorf("write PSYNC: %w", err)
+	}
+
+	r, err := conn.ReadString('\n')
+
+	if err != nil {
+		return fmt.Errorf("read PSYNC response: %w", err)
+	}
+
+	fmt.Println("PSYNC response: ", r)
+
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(r), "+"))
+
+	switch {
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		newReplid := ""
+		if len(fields) > 1 {
+			newReplid = fields[1]
+		}
+
+		s.Replication.ContinueMaster(newReplid)
+		return nil
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FULLRESYNC offset: %w", err)
+		}
+
+		file, err := getRDBContent(conn)
+
+		if err != nil {
+			return fmt.Errorf("read RDB file: %w", err)
+		}
+
+		if err = s.Datastore.Hydrate(bytes.NewReader(file)); err != nil {
+			fmt.Println("Error hydrating datastore: ", err)
+		}
+
+		s.Replication.SetMaster(fields[1], masterOffset)
+		return nil
+	default:
+		return fmt.Errorf("PSYNC failed - invalid response: %s", strings.TrimSpace(r))
+	}
+}
+
+func (s *BaseServer) Ping(rw bufio.ReadWriter) error {
+	ping := resp.ArrayValue(
+		resp.BulkStringValue("PING"),
+	)
+	p, _ := ping.Marshal()
+
+	if err := s.WriteResults(rw.Writer, [][]byte{p}); err != nil {
+		return fmt.Errorf("write PING: %w", err)
+	}
+
+	r, err := rw.ReadString('\n')
+
+	if err != nil {
+		return fmt.Errorf("read PING response: %w", err)
+	}
+
+	fmt.Println("Ping response: ", r)
+
+	if strings.TrimSpace(r) != "+PONG" {
+		fmt.Println("Ping failed - invalid response")
+	}
+
+	return nil
+}
+
+// Auth authenticates with the master as set by masterauth and masteruser,
+// the default user when no user is set.
+func (s *BaseServer) Auth(rw bufio.ReadWriter, user, password string) error {
+	args := []resp.Value{resp.BulkStringValue("AUTH")}
+
+	if user != "" {
+		args = append(args, resp.BulkStringValue(user))
+	}
+
+	c := resp.ArrayValue(append(args, resp.BulkStringValue(password))...)
+	auth, _ := c.Marshal()
+
+	if err := s.WriteResults(rw.Writer, [][]byte{auth}); err != nil {
+		return fmt.Errorf("write AUTH: %w", err)
+	}
+
+	r, err := rw.ReadString('\n')
+
+	if err != nil {
+		return fmt.Errorf("read AUTH response: %w", err)
+	}
+
+	if strings.TrimSpace(r) != "+OK" {
+		return fmt.Errorf("AUTH failed: %s", strings.TrimSpace(r))
+	}
+
+	return nil
+}
+
+func getRDBContent(rw bufio.ReadWriter) ([]byte, error) {
+	fmt.Println("Reading RDB file")
+
+	prefix, err := rw.ReadByte()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to peek RDB length: %v", err)
+	}
+
+	if string(prefix) != "$" {
+		return nil, fmt.Errorf("expected $ prefix, got %c", prefix)
+	}
+
+	l, err := rw.ReadString('\n')
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RDB length: %v", err)
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(l))
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid RDB length: %v", err)
+	}
+
+	fmt.Println("RDB file length: ", length)
+	buf := make([]byte, length)
+	_, err = io.ReadFull(rw, buf)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to skip RDB file: %v", err)
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,14 @@
+package tcp
+
+type SentinelServer struct {
+	*BaseServer
+}
+
+func (ss *SentinelServer) Start() {
+	ss.StartListener(ss.HandleConnection)
+	go ss.Sentinel.Run(ss.Shutdown)
+}
+
+func (ss *SentinelServer) Stop() {
+	ss.StopListener()
+}
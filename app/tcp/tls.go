@@ -0,0 +1,95 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions are the certificates used by TLS connections, as set by the
+// tls-* options.
+type TLSOptions struct {
+	CertFile   string
+	KeyFile    string
+	CACertFile string
+
+	// AuthClients is yes when clients must present a certificate signed by
+	// the CA, optional when the ones they present must be and no when they
+	// are not asked for any.
+	AuthClients string
+}
+
+var errNoCACert = errors.New("tls-ca-cert-file must be specified when tls-replication or tls-auth-clients are enabled!")
+
+// ServerTLSConfig is the configuration of the TLS listener.
+func ServerTLSConfig(o TLSOptions) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls-cert-file and tls-key-file: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch strings.ToLower(o.AuthClients) {
+	case "no":
+		config.ClientAuth = tls.NoClientCert
+		return config, nil
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "yes", "":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients: %s", o.AuthClients)
+	}
+
+	if config.ClientCAs, err = loadCACerts(o.CACertFile); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ClientTLSConfig is the configuration of the links we open, a replica's
+// link with its master. Our certificate is presented to the master, whose
+// certificate must be signed by the CA.
+func ClientTLSConfig(o TLSOptions) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls-cert-file and tls-key-file: %w", err)
+	}
+
+	roots, err := loadCACerts(o.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func loadCACerts(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, errNoCACert
+	}
+
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("load tls-ca-cert-file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("load tls-ca-cert-file: no certificate found in %s", file)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,229 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// certificates are self-signed TLS files written to a test directory: a CA,
+// and a certificate it signed for localhost used by both ends.
+type certificates struct {
+	CA, Cert, Key string
+}
+
+func generateCertificates(t *testing.T) certificates {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := certificates{
+		CA:   filepath.Join(dir, "ca.crt"),
+		Cert: filepath.Join(dir, "redis.crt"),
+		Key:  filepath.Join(dir, "redis.key"),
+	}
+
+	writePEM := func(path, typ string, der []byte) {
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePEM(files.CA, "CERTIFICATE", caDER)
+	writePEM(files.Cert, "CERTIFICATE", certDER)
+	writePEM(files.Key, "EC PRIVATE KEY", keyDER)
+
+	return files
+}
+
+func (c certificates) options(authClients string) TLSOptions {
+	return TLSOptions{CertFile: c.Cert, KeyFile: c.Key, CACertFile: c.CA, AuthClients: authClients}
+}
+
+// newTLSServer serves PING over TLS with the given tls-auth-clients.
+func newTLSServer(t *testing.T, certs certificates, authClients string) *BaseServer {
+	t.Helper()
+
+	config, err := ServerTLSConfig(certs.options(authClients))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return startTestServer(t, &BaseServer{TLSListener: ln})
+}
+
+func ping(conn net.Conn) (string, error) {
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestTLS_AuthClients(t *testing.T) {
+	certs := generateCertificates(t)
+
+	client, err := ClientTLSConfig(certs.options(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ServerName = "localhost"
+
+	anonymous := &tls.Config{RootCAs: client.RootCAs, ServerName: "localhost"}
+
+	tests := []struct {
+		authClients  string
+		anonymousErr bool
+	}{
+		{"yes", true},
+		{"optional", false},
+		{"no", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.authClients, func(t *testing.T) {
+			s := newTLSServer(t, certs, tt.authClients)
+			addr := s.TLSListener.Addr().String()
+
+			conn, err := tls.Dial("tcp", addr, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			reply, err := ping(conn)
+			assert.NoError(t, err)
+			assert.Equal(t, "+PONG\r\n", reply)
+
+			conn, err = tls.Dial("tcp", addr, anonymous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// the server tells a missing certificate once it verified it
+			reply, err = ping(conn)
+			if tt.anonymousErr {
+				assert.Error(t, err, "a certificate is required")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "+PONG\r\n", reply)
+			}
+		})
+	}
+}
+
+func TestTLS_Options(t *testing.T) {
+	certs := generateCertificates(t)
+
+	_, err := ServerTLSConfig(TLSOptions{CertFile: certs.Cert, KeyFile: certs.Key})
+	assert.ErrorIs(t, err, errNoCACert, "client certificates are verified with the CA")
+
+	_, err = ServerTLSConfig(TLSOptions{CertFile: certs.Cert, KeyFile: certs.Key, AuthClients: "no"})
+	assert.NoError(t, err)
+
+	_, err = ServerTLSConfig(certs.options("maybe"))
+	assert.Error(t, err)
+
+	_, err = ClientTLSConfig(TLSOptions{CertFile: certs.Cert, KeyFile: certs.Key})
+	assert.ErrorIs(t, err, errNoCACert, "the master's certificate is verified with the CA")
+
+	_, err = ServerTLSConfig(TLSOptions{CertFile: certs.Cert, KeyFile: certs.CA, CACertFile: certs.CA})
+	assert.Error(t, err, "the key must match the certificate")
+}
+
+func TestTLS_DialMaster(t *testing.T) {
+	certs := generateCertificates(t)
+	master := newTLSServer(t, certs, "yes")
+
+	config, err := ClientTLSConfig(certs.options(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica := &BaseServer{ReplicationTLS: config}
+
+	conn, err := replica.dialMaster(master.TLSListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reply, err := ping(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "+PONG\r\n", reply)
+
+	other := generateCertificates(t)
+	untrusted, err := ClientTLSConfig(TLSOptions{CertFile: certs.Cert, KeyFile: certs.Key, CACertFile: other.CA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = (&BaseServer{ReplicationTLS: untrusted}).dialMaster(master.TLSListener.Addr().String())
+	assert.Error(t, err, "the master's certificate is signed by another CA")
+}
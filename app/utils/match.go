@@ -0,0 +1,103 @@
+package utils
+
+// Match reports whether s matches the glob-style pattern, as Redis matches
+// keys and channels: "*" matches any sequence, "?" any character, "[...]" a
+// character of the set ("^" negates it, "a-z" is a range) and "\" escapes the
+// next character.
+func Match(pattern, s string) bool {
+	// on a mismatch, the last "*" seen absorbs one more character of s
+	star, retry := -1, 0
+
+	p, i := 0, 0
+
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, retry = p, i
+				p++
+
+				continue
+			case '?':
+				p++
+				i++
+
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, s[i]); ok {
+					p = next
+					i++
+
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == s[i] {
+					p += 2
+					i++
+
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+
+					continue
+				}
+			}
+		}
+
+		if star == -1 {
+			return false
+		}
+
+		retry++
+		p, i = star+1, retry
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchClass matches c against the set starting at pattern[start], a "[",
+// and returns the position right after the set.
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	matched := false
+
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			matched = matched || pattern[p] == c
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+
+			matched = matched || (lo <= c && c <= hi)
+			p += 2
+		default:
+			matched = matched || pattern[p] == c
+		}
+
+		p++
+	}
+
+	// an unterminated set runs to the end of the pattern
+	if p < len(pattern) {
+		p++
+	}
+
+	return p, matched != negate
+}